@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"hash/fnv"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
@@ -14,10 +18,50 @@ import (
 )
 
 const (
-	authorityKey = ":authority"
-	pathKey      = ":path"
+	authorityKey     = ":authority"
+	pathKey          = ":path"
+	authorizationKey = "authorization"
+	contentDigestKey = "content-digest"
+	amzContentSha256 = "x-amz-content-sha256"
+	dpopNonceKey     = "dpop-nonce"
+	etagKey          = "etag"
+	// interval to ask the auth-provider for the versions of the versioned auth types
+	versionCheckPeriod = 30 * time.Second
 )
 
+/**
+* Auth types whose headers depend on the individual request. They are never shared through the cache, the auth-provider receives the
+* method and uri of the request and the returned headers replace the ones of the request instead of being added.
+ */
+var requestBoundAuthTypes = map[string]requestBinding{
+	"TOKEN_EXCHANGE": {requiresAuthorization: true},
+	"HTTP_SIGNATURE": {digestHeader: contentDigestKey},
+	"AWS_SIGV4":      {digestHeader: amzContentSha256, unsignedDigest: "UNSIGNED-PAYLOAD"},
+	"DPOP":           {nonceHeader: dpopNonceKey},
+}
+
+/**
+* Auth types whose headers are cached for a long time, but depend on state the auth-provider versions, f.e. the static secrets.
+* The filter regularly asks for the current version and re-fetches cached headers of an older one.
+ */
+var versionedAuthTypes = map[string]bool{
+	"STATIC": true,
+}
+
+/**
+* What a request bound auth type needs from the request.
+ */
+type requestBinding struct {
+	// acts on behalf of the user, thus requires the incoming authorization
+	requiresAuthorization bool
+	// header to cover the body with, the auth-provider can only be called once the body is complete
+	digestHeader string
+	// value of the digest header to sign without covering the body, if unsigned payloads are enabled
+	unsignedDigest string
+	// response header the endpoint challenges with a nonce, the latest one is passed to the auth-provider
+	nonceHeader string
+}
+
 /**
 * Plugin configurations
  */
@@ -34,7 +78,7 @@ var endpointAuthConfig EndpointAuthConfiguration
 * Default plugin configuration.
 * The defaults targeting a plain envoy sidecar "ishare"-usecase and WILL NOT work in a mesh setup(istio, ossm)
  */
-var defaultPluginConfig PluginConfiguration = PluginConfiguration{AuthProviderName: "ext-authz", AuthRequestTimeout: 5000, EnableEndpointMatching: false, AuthType: "ISHARE"}
+var defaultPluginConfig PluginConfiguration = PluginConfiguration{AuthProviderName: "ext-authz", AuthRequestTimeout: 5000, EnableEndpointMatching: false, AuthType: "ISHARE", UnsignedPayload: false}
 
 /**
 * Json parser for reading cache and config
@@ -49,6 +93,10 @@ type PluginConfiguration struct {
 	AuthRequestTimeout     uint32
 	EnableEndpointMatching bool
 	AuthType               string
+	// sign requests without buffering and digesting their body, if the auth type supports it
+	UnsignedPayload bool
+	// the sidecar forwards the requests via https, used if endpoint matching is disabled
+	UseHttps bool
 }
 
 /**
@@ -60,6 +108,8 @@ type EndpointAuthEntry struct {
 	AuthType string
 	Domain   string
 	Path     string
+	// the sidecar forwards requests to the endpoint via https, while the filter only sees the plain http request of the application
+	UseHttps bool
 }
 
 /**
@@ -73,6 +123,8 @@ type EndpointAuthConfiguration map[string]map[string]EndpointAuthEntry
 type cachedAuthInformation struct {
 	expirationTime int64
 	cachedHeaders  headersList
+	// version of the auth-providers state the headers were created from, empty for unversioned auth types
+	version string
 }
 
 /**
@@ -104,6 +156,10 @@ type (
 		// Embed the default http context here,
 		// so that we don't need to reimplement all the methods.
 		types.DefaultHttpContext
+		// entry of a request bound auth type, waiting for the body to be complete
+		pendingAuthEntry *EndpointAuthEntry
+		// entry of a request bound auth type, expecting a nonce with the response
+		nonceAuthEntry *EndpointAuthEntry
 	}
 )
 
@@ -117,6 +173,11 @@ func (*vmContext) OnVMStart(vmConfigurationSize int) types.OnVMStartStatus {
 // Handle the plugin start and read the config
 func (ctx pluginContext) OnPluginStart(pluginConfigurationSize int) types.OnPluginStartStatus {
 	readConfiguration()
+	if len(getVersionedAuthTypes()) > 0 {
+		if err := proxywasm.SetTickPeriodMilliSeconds(uint32(versionCheckPeriod.Milliseconds())); err != nil {
+			proxywasm.LogCriticalf("Was not able to start the version checks: %v", err)
+		}
+	}
 	proxywasm.LogInfo("Successfully started plugin.")
 	return types.OnPluginStartStatusOK
 }
@@ -127,6 +188,13 @@ func (*vmContext) NewPluginContext(contextID uint32) types.PluginContext {
 	return &pluginContext{}
 }
 
+// Ask the auth-provider for the current versions of the configured auth types and override types.DefaultPluginContext.
+func (*pluginContext) OnTick() {
+	for _, authType := range getVersionedAuthTypes() {
+		requestVersion(authType)
+	}
+}
+
 // Override types.DefaultPluginContext.
 func (*pluginContext) NewHttpContext(contextID uint32) types.HttpContext {
 	return &httpContext{}
@@ -178,10 +246,10 @@ func (ctx *httpContext) OnHttpRequestHeaders(numHeaders int, endOfStream bool) t
 			// early exit, nothing to handle for the filter
 			return types.ActionContinue
 		}
-		return setHeader(authType)
+		return ctx.setHeader(authType, endOfStream)
 	} else {
 		// in case of 'handle all', we only have to maintain one cache entry
-		return setHeader(EndpointAuthEntry{1, config.AuthType, requestDomain, requestPath})
+		return ctx.setHeader(EndpointAuthEntry{1, config.AuthType, requestDomain, requestPath, config.UseHttps}, endOfStream)
 	}
 
 }
@@ -239,7 +307,11 @@ func matchEndpoint(domainString, pathString string) (authEntry EndpointAuthEntry
 /**
 * Apply the auth headers from either the cache or the auth provider
  */
-func setHeader(authEntry EndpointAuthEntry) types.Action {
+func (ctx *httpContext) setHeader(authEntry EndpointAuthEntry, endOfStream bool) types.Action {
+	if binding, ok := requestBoundAuthTypes[authEntry.AuthType]; ok {
+		return ctx.setRequestBoundHeader(authEntry, binding, endOfStream)
+	}
+
 	data, currentCas, err := proxywasm.GetSharedData(fmt.Sprint(authEntry.CacheId))
 
 	if err != nil || data == nil {
@@ -257,6 +329,9 @@ func setHeader(authEntry EndpointAuthEntry) types.Action {
 	if cachedAuthInfo.expirationTime <= time.Now().Unix() {
 		proxywasm.LogDebugf("Cache expired. Request new auth.")
 		return requestAuthProvider(authEntry, currentCas)
+	} else if currentVersion := getCurrentVersion(authEntry.AuthType); cachedAuthInfo.version != "" && currentVersion != "" && cachedAuthInfo.version != currentVersion {
+		proxywasm.LogDebugf("Cache is of version %s, current is %s. Request new auth.", cachedAuthInfo.version, currentVersion)
+		return requestAuthProvider(authEntry, currentCas)
 	} else {
 		proxywasm.LogDebugf("Cache still valid.")
 		addCachedHeadersToRequest(cachedAuthInfo.cachedHeaders)
@@ -265,6 +340,105 @@ func setHeader(authEntry EndpointAuthEntry) types.Action {
 
 }
 
+/**
+* Request the headers of a request bound auth type, without using the cache. If the body needs to be digested, the auth-provider
+* is called once it is complete.
+ */
+func (ctx *httpContext) setRequestBoundHeader(authEntry EndpointAuthEntry, binding requestBinding, endOfStream bool) types.Action {
+	if binding.requiresAuthorization {
+		// the authorization is forwarded to the auth-provider with the other request headers
+		authorization, err := proxywasm.GetHttpRequestHeader(authorizationKey)
+		if err != nil || authorization == "" {
+			proxywasm.LogWarnf("No authorization to act on behalf of for %s - %s.", authEntry.Domain, authEntry.Path)
+			return types.ActionContinue
+		}
+	}
+	if binding.nonceHeader != "" {
+		ctx.nonceAuthEntry = &authEntry
+	}
+	if binding.digestHeader != "" && !endOfStream {
+		if digest, err := proxywasm.GetHttpRequestHeader(binding.digestHeader); err == nil && digest != "" {
+			// the body is already covered by the digest of the client, no need to buffer it
+			return requestAuthProvider(authEntry, 0)
+		}
+		if config.UnsignedPayload && binding.unsignedDigest != "" {
+			proxywasm.ReplaceHttpRequestHeader(binding.digestHeader, binding.unsignedDigest)
+			return requestAuthProvider(authEntry, 0)
+		}
+		ctx.pendingAuthEntry = &authEntry
+		return types.ActionPause
+	}
+	return requestAuthProvider(authEntry, 0)
+}
+
+/**
+* Keep the nonce the endpoint responded with, to be included in the headers of the next request to its domain.
+ */
+func (ctx *httpContext) OnHttpResponseHeaders(numHeaders int, endOfStream bool) types.Action {
+	if ctx.nonceAuthEntry == nil {
+		return types.ActionContinue
+	}
+	authEntry := *ctx.nonceAuthEntry
+	ctx.nonceAuthEntry = nil
+
+	binding := requestBoundAuthTypes[authEntry.AuthType]
+	nonce, err := proxywasm.GetHttpResponseHeader(binding.nonceHeader)
+	if err != nil || nonce == "" {
+		return types.ActionContinue
+	}
+	nonceKey := getNonceKey(binding, authEntry)
+	_, cas, _ := proxywasm.GetSharedData(nonceKey)
+	if err := proxywasm.SetSharedData(nonceKey, []byte(nonce), cas); err != nil {
+		proxywasm.LogWarnf("Was not able to store the nonce for %s: %v", authEntry.Domain, err)
+	}
+	return types.ActionContinue
+}
+
+/**
+* Nonces are issued per server, thus they are shared by all paths of the domain.
+ */
+func getNonceKey(binding requestBinding, authEntry EndpointAuthEntry) string {
+	return binding.nonceHeader + "/" + authEntry.Domain
+}
+
+/**
+* Handle the body of requests waiting for their digest. The body is buffered until it is complete, its digest is added as
+* header of the request before the auth-provider is called.
+ */
+func (ctx *httpContext) OnHttpRequestBody(bodySize int, endOfStream bool) types.Action {
+	if ctx.pendingAuthEntry == nil {
+		return types.ActionContinue
+	}
+	if !endOfStream {
+		return types.ActionPause
+	}
+	authEntry := *ctx.pendingAuthEntry
+	ctx.pendingAuthEntry = nil
+
+	digestHeader := requestBoundAuthTypes[authEntry.AuthType].digestHeader
+	if digest, err := proxywasm.GetHttpRequestHeader(digestHeader); err != nil || digest == "" {
+		body, err := proxywasm.GetHttpRequestBody(0, bodySize)
+		if err != nil {
+			proxywasm.LogCriticalf("Failed to read the body to digest: %v", err)
+			rejectRequest(authEntry)
+			return types.ActionPause
+		}
+		proxywasm.ReplaceHttpRequestHeader(digestHeader, getBodyDigest(digestHeader, body))
+	}
+	return requestAuthProvider(authEntry, 0)
+}
+
+/**
+* Create the digest of the body in the format of the header, either the content-digest of RFC 9530 or the hex encoded hash of aws.
+ */
+func getBodyDigest(digestHeader string, body []byte) string {
+	hash := sha256.Sum256(body)
+	if digestHeader == amzContentSha256 {
+		return hex.EncodeToString(hash[:])
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+}
+
 /**
 * Apply the headers from the list to the current request
  */
@@ -275,6 +449,16 @@ func addCachedHeadersToRequest(cachedHeaders headersList) {
 	}
 }
 
+/**
+* Replace the headers of the current request with the ones from the list. Header names are case-insensitive, envoy keeps them lower-case.
+ */
+func replaceHeadersOfRequest(headers headersList) {
+	for _, header := range headers {
+		proxywasm.LogDebugf("Replace header %s", header.Name)
+		proxywasm.ReplaceHttpRequestHeader(strings.ToLower(header.Name), header.Value)
+	}
+}
+
 /**
 * Request auth info at the provider. Since the call is executed asynchronous, it needs to pause the actual request handling.
  */
@@ -293,8 +477,18 @@ func requestAuthProvider(authEntry EndpointAuthEntry, cas uint32) types.Action {
 			pathIndex = i
 		}
 	}
+	// without endpoint matching, the path is the one of the request and might contain a query on its own
+	authPath := "/" + authEntry.AuthType + "/auth?domain=" + url.QueryEscape(authEntry.Domain) + "&path=" + url.QueryEscape(authEntry.Path)
+	if binding, ok := requestBoundAuthTypes[authEntry.AuthType]; ok {
+		authPath = authPath + "&method=" + url.QueryEscape(hs[methodIndex][1]) + "&uri=" + url.QueryEscape(getTargetUri(authEntry, hs[pathIndex][1]))
+		if binding.nonceHeader != "" {
+			if nonce, _, err := proxywasm.GetSharedData(getNonceKey(binding, authEntry)); err == nil && len(nonce) > 0 {
+				authPath = authPath + "&nonce=" + url.QueryEscape(string(nonce))
+			}
+		}
+	}
 	hs[methodIndex] = [2]string{":method", "GET"}
-	hs[pathIndex] = [2]string{":path", "/" + authEntry.AuthType + "/auth?domain=" + authEntry.Domain + "&path=" + authEntry.Path}
+	hs[pathIndex] = [2]string{":path", authPath}
 
 	if _, err := proxywasm.DispatchHttpCall(config.AuthProviderName, hs, nil, nil, config.AuthRequestTimeout,
 		func(numHeaders, bodySize, numTrailers int) {
@@ -303,11 +497,102 @@ func requestAuthProvider(authEntry EndpointAuthEntry, cas uint32) types.Action {
 		}); err != nil {
 		proxywasm.LogCriticalf("Domain " + authEntry.Domain + " , path: " + authEntry.Path + " , authType: " + authEntry.AuthType)
 		proxywasm.LogCriticalf("Call to auth-provider failed: %v", err)
+		if _, ok := requestBoundAuthTypes[authEntry.AuthType]; ok {
+			rejectRequest(authEntry)
+			return types.ActionPause
+		}
 		return types.ActionContinue
 	}
 	return types.ActionPause
 }
 
+/**
+* Ask the auth-provider for the current version of the auth type. The call is not bound to any request, the result is only
+* stored for the following ones to check their cached headers against.
+ */
+func requestVersion(authType string) {
+	hs := [][2]string{{":method", "GET"}, {":path", "/" + authType + "/version"}, {authorityKey, config.AuthProviderName}}
+	if _, err := proxywasm.DispatchHttpCall(config.AuthProviderName, hs, nil, nil, config.AuthRequestTimeout,
+		func(numHeaders, bodySize, numTrailers int) {
+			headers, err := proxywasm.GetHttpCallResponseHeaders()
+			if err != nil {
+				proxywasm.LogWarnf("Was not able to read the version of %s: %v", authType, err)
+				return
+			}
+			for _, h := range headers {
+				if h[0] == ":status" && h[1] != "204" {
+					proxywasm.LogWarnf("Was not able to get the version of %s, status was %s.", authType, h[1])
+					return
+				}
+			}
+			for _, h := range headers {
+				if h[0] == etagKey {
+					storeCurrentVersion(authType, h[1])
+				}
+			}
+		}); err != nil {
+		proxywasm.LogWarnf("Call for the version of %s failed: %v", authType, err)
+	}
+}
+
+/**
+* Versioned auth types in use by the plugin. Without endpoint matching, only the default auth type is used.
+ */
+func getVersionedAuthTypes() (authTypes []string) {
+	if !config.EnableEndpointMatching {
+		if versionedAuthTypes[config.AuthType] {
+			authTypes = append(authTypes, config.AuthType)
+		}
+		return
+	}
+	found := map[string]bool{}
+	for _, domainEntries := range endpointAuthConfig {
+		for _, authEntry := range domainEntries {
+			if versionedAuthTypes[authEntry.AuthType] && !found[authEntry.AuthType] {
+				found[authEntry.AuthType] = true
+				authTypes = append(authTypes, authEntry.AuthType)
+			}
+		}
+	}
+	return
+}
+
+/**
+* Latest version of the auth type, as reported by the auth-provider. Empty if it is not known yet.
+ */
+func getCurrentVersion(authType string) string {
+	version, _, err := proxywasm.GetSharedData(getVersionKey(authType))
+	if err != nil {
+		return ""
+	}
+	return string(version)
+}
+
+func storeCurrentVersion(authType, version string) {
+	versionKey := getVersionKey(authType)
+	_, cas, _ := proxywasm.GetSharedData(versionKey)
+	if err := proxywasm.SetSharedData(versionKey, []byte(version), cas); err != nil {
+		proxywasm.LogWarnf("Was not able to store the version of %s: %v", authType, err)
+	}
+}
+
+func getVersionKey(authType string) string {
+	return "version/" + authType
+}
+
+/**
+* Build the uri of the current request from the authority and the given path. The scheme is the one the sidecar uses towards the
+* endpoint, the :scheme of the request is the one of the application, f.e. plain http to the sidecar.
+ */
+func getTargetUri(authEntry EndpointAuthEntry, requestPath string) string {
+	scheme := "http"
+	if authEntry.UseHttps {
+		scheme = "https"
+	}
+	authority, _ := proxywasm.GetHttpRequestHeader(authorityKey)
+	return scheme + "://" + authority + requestPath
+}
+
 /**
 * Callback method to handle the authprovider response.
 * It will resume the request handling before taking care of updating the cache, to reduce the latency of the request. This can lead to
@@ -321,13 +606,13 @@ func authCallback(authEntry EndpointAuthEntry, cas uint32, bodySize int) {
 	body, err := proxywasm.GetHttpCallResponseBody(0, bodySize)
 	if err != nil {
 		proxywasm.LogCriticalf("Failed to get response body for auth-request: %v", err)
-		proxywasm.ResumeHttpRequest()
+		resumeWithoutHeaders(authEntry)
 		return
 	}
 
 	if body == nil {
 		proxywasm.LogCriticalf("Failed to get response body for auth-request, body was nil.")
-		proxywasm.ResumeHttpRequest()
+		resumeWithoutHeaders(authEntry)
 		return
 	}
 
@@ -336,6 +621,16 @@ func authCallback(authEntry EndpointAuthEntry, cas uint32, bodySize int) {
 
 	if err != nil {
 		proxywasm.LogCriticalf("Was not able to decode header list.")
+		resumeWithoutHeaders(authEntry)
+		return
+	}
+	if binding, ok := requestBoundAuthTypes[authEntry.AuthType]; ok {
+		if binding.requiresAuthorization {
+			// the authorization of the user must not reach the endpoint, even if no replacement was returned
+			proxywasm.RemoveHttpRequestHeader(authorizationKey)
+		}
+		// headers of a single request must never be shared with other requests
+		replaceHeadersOfRequest(headersList)
 		proxywasm.ResumeHttpRequest()
 		return
 	}
@@ -346,6 +641,18 @@ func authCallback(authEntry EndpointAuthEntry, cas uint32, bodySize int) {
 
 	proxywasm.LogDebugf("Handle caching.")
 
+	// the version is needed before the entry is written, the headers are not ordered
+	version := ""
+	if versionedAuthTypes[authEntry.AuthType] {
+		for _, h := range headers {
+			if h[0] == etagKey {
+				version = h[1]
+				// the headers are fresher than the last version check
+				storeCurrentVersion(authEntry.AuthType, version)
+			}
+		}
+	}
+
 	// handle cachecontrol
 	for _, h := range headers {
 		proxywasm.LogDebugf("Parse headers: %s", fmt.Sprint(h))
@@ -360,7 +667,7 @@ func authCallback(authEntry EndpointAuthEntry, cas uint32, bodySize int) {
 
 			if expiry > 0 {
 				proxywasm.LogDebugf("Expiry was set to: %v", expiry)
-				parsedInfo, err := parser.Parse(cachedAuthInfoToJson(expiry, headersList))
+				parsedInfo, err := parser.Parse(cachedAuthInfoToJson(expiry, headersList, version))
 				if err != nil {
 					proxywasm.LogCriticalf("Was not able to parse auth info: %v", err)
 					return
@@ -378,10 +685,32 @@ func authCallback(authEntry EndpointAuthEntry, cas uint32, bodySize int) {
 
 }
 
+/**
+* Continue a request the auth-provider did not return headers for. Requests of request bound auth types are rejected instead, they
+* would reach the endpoint with their own authorization, f.e. the token of the user for a token exchange.
+ */
+func resumeWithoutHeaders(authEntry EndpointAuthEntry) {
+	if _, ok := requestBoundAuthTypes[authEntry.AuthType]; ok {
+		rejectRequest(authEntry)
+		return
+	}
+	proxywasm.ResumeHttpRequest()
+}
+
+/**
+* Answer the request with a bad gateway, without sending it to the endpoint.
+ */
+func rejectRequest(authEntry EndpointAuthEntry) {
+	proxywasm.LogWarnf("Reject the request to %s, no %s headers are available.", authEntry.Domain, authEntry.AuthType)
+	if err := proxywasm.SendHttpResponse(502, [][2]string{{"content-type", "text/plain"}}, []byte("Was not able to authenticate the request."), -1); err != nil {
+		proxywasm.LogCriticalf("Was not able to reject the request: %v", err)
+	}
+}
+
 /**
 * Create a json string to store in cache from the headers list
  */
-func cachedAuthInfoToJson(expirationTime int64, cachedHeaders headersList) (jsonString string) {
+func cachedAuthInfoToJson(expirationTime int64, cachedHeaders headersList, version string) (jsonString string) {
 
 	headerArray := `[`
 	for i, header := range cachedHeaders {
@@ -392,7 +721,7 @@ func cachedAuthInfoToJson(expirationTime int64, cachedHeaders headersList) (json
 	}
 	headerArray = headerArray + `]`
 
-	jsonString = fmt.Sprintf(`{"expiration":%d, "cachedHeaders":%s}`, expirationTime, headerArray)
+	jsonString = fmt.Sprintf(`{"expiration":%d, "cachedHeaders":%s, "version":%s}`, expirationTime, headerArray, strconv.Quote(version))
 
 	proxywasm.LogDebugf("Json string to store: %s ", jsonString)
 	return
@@ -493,13 +822,23 @@ func parseAuthConfig(authJson *fastjson.Value) {
 				proxywasm.LogWarnf("Was not able to read domain config for %s. %v", domainName, err)
 			}
 			for _, entry := range domainEntryArray {
+				// paths are either plain strings or objects, f.e. {"path": "/path", "useHttps": true}
 				pathEntry := string(entry.GetStringBytes())
+				useHttps := false
+				if entry.Type() == fastjson.TypeObject {
+					pathEntry = string(entry.GetStringBytes("path"))
+					useHttps = entry.GetBool("useHttps")
+				}
+				if pathEntry == "" {
+					proxywasm.LogWarnf("Was not able to read a path of %s-%s, ignore it.", authType, domainName)
+					continue
+				}
 
 				// Calculate a hash of domain and path, that will be used as key for the cache to allow the usage of one
 				// cache entry for all sub-paths of an entry
 				domainPathHash := fnv.New32a()
 				domainPathHash.Write([]byte(domainName + pathEntry))
-				authEntry := EndpointAuthEntry{domainPathHash.Sum32(), authType, domainName, pathEntry}
+				authEntry := EndpointAuthEntry{domainPathHash.Sum32(), authType, domainName, pathEntry, useHttps}
 
 				// the path matcher only takes sub-paths, if the pattern ends with a `*`.
 				// this will lead to:
@@ -534,6 +873,8 @@ func parsePluginConfigFromJson(parsedJson *fastjson.Value) (parsedConfig PluginC
 	authType := parsedJson.GetStringBytes("authType")
 	// in case of error, the boolean zero value is used
 	parsedConfig.EnableEndpointMatching = parsedJson.GetBool("enableEndpointMatching")
+	parsedConfig.UnsignedPayload = parsedJson.GetBool("unsignedPayload")
+	parsedConfig.UseHttps = parsedJson.GetBool("useHttps")
 
 	if authRequestTimeout > 0 {
 		parsedConfig.AuthRequestTimeout = uint32(authRequestTimeout)
@@ -568,8 +909,9 @@ func parseCachedAuthInformation(jsonString string) (cachedAuthInfo cachedAuthInf
 	expirationTime := parsedJson.GetInt64("expiration")
 	cachedHeaders := parsedJson.GetArray("cachedHeaders")
 	headersList := parseHeaderArray(cachedHeaders)
+	version := string(parsedJson.GetStringBytes("version"))
 
-	return cachedAuthInformation{expirationTime: expirationTime, cachedHeaders: headersList}, err
+	return cachedAuthInformation{expirationTime: expirationTime, cachedHeaders: headersList, version: version}, err
 
 }
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -434,7 +435,7 @@ func TestParseConfig(t *testing.T) {
 	h := fnv.New32a()
 	h.Write([]byte("other-domain.org/"))
 
-	defaultEndpointAuthConfig := map[string]map[string]EndpointAuthEntry{"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/"}}}
+	defaultEndpointAuthConfig := map[string]map[string]EndpointAuthEntry{"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", false}}}
 
 	tests := []test{
 		{testName: "Use default config when only endpoints are configured.",
@@ -443,19 +444,19 @@ func TestParseConfig(t *testing.T) {
 			expectedAuthConfiguration:   defaultEndpointAuthConfig},
 		{testName: "Use default for everything else than endpoint matching.",
 			testConfig:                  "{\"general\": {\"enableEndpointMatching\":true}, \"endpoints\":{\"ISHARE\":{\"other-domain.org\": [\"/\"]}}}",
-			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, true, defaultPluginConfig.AuthType},
+			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, true, defaultPluginConfig.AuthType, defaultPluginConfig.UnsignedPayload, defaultPluginConfig.UseHttps},
 			expectedAuthConfiguration:   defaultEndpointAuthConfig},
 		{testName: "Use default for everything else than provider name.",
 			testConfig:                  "{\"general\": {\"authProviderName\":\"outbound|80||ext-authz\"}}",
-			expectedPluginConfiguration: PluginConfiguration{"outbound|80||ext-authz", defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType},
+			expectedPluginConfiguration: PluginConfiguration{"outbound|80||ext-authz", defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType, defaultPluginConfig.UnsignedPayload, defaultPluginConfig.UseHttps},
 			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
 		{testName: "Use default for everything else than request timeout.",
 			testConfig:                  "{\"general\": {\"authRequestTimeout\":12}}",
-			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, 12, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType},
+			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, 12, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType, defaultPluginConfig.UnsignedPayload, defaultPluginConfig.UseHttps},
 			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
 		{testName: "Use default for everything else than authType.",
 			testConfig:                  "{\"general\": {\"authType\":\"OIDC\"}}",
-			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, "OIDC"},
+			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, "OIDC", defaultPluginConfig.UnsignedPayload, defaultPluginConfig.UseHttps},
 			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
 		{testName: "Use default in case of invalid general config.",
 			testConfig:                  "{\"general\": }}",
@@ -477,9 +478,25 @@ func TestParseConfig(t *testing.T) {
 			testConfig:                  "{\"general\": {\"enableEndpointMatching\": 42}}",
 			expectedPluginConfiguration: defaultPluginConfig,
 			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
+		{testName: "Use default for everything else than unsigned payload.",
+			testConfig:                  "{\"general\": {\"unsignedPayload\":true}}",
+			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType, true, defaultPluginConfig.UseHttps},
+			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
+		{testName: "Use default for everything else than https.",
+			testConfig:                  "{\"general\": {\"useHttps\":true}}",
+			expectedPluginConfiguration: PluginConfiguration{defaultPluginConfig.AuthProviderName, defaultPluginConfig.AuthRequestTimeout, defaultPluginConfig.EnableEndpointMatching, defaultPluginConfig.AuthType, defaultPluginConfig.UnsignedPayload, true},
+			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
+		{testName: "Read paths with https.",
+			testConfig:                  "{\"endpoints\":{\"ISHARE\":{\"other-domain.org\": [{\"path\": \"/\", \"useHttps\": true}]}}}",
+			expectedPluginConfiguration: defaultPluginConfig,
+			expectedAuthConfiguration:   map[string]map[string]EndpointAuthEntry{"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", true}}}},
+		{testName: "Read paths without https.",
+			testConfig:                  "{\"endpoints\":{\"ISHARE\":{\"other-domain.org\": [{\"path\": \"/\"}]}}}",
+			expectedPluginConfiguration: defaultPluginConfig,
+			expectedAuthConfiguration:   defaultEndpointAuthConfig},
 		{testName: "Set multiple configs.",
 			testConfig:                  "{\"general\": {\"enableEndpointMatching\": true,\"authRequestTimeout\": 42, \"authType\":\"OIDC\", \"authProviderName\":\"outbound|80||ext-authz\" }}",
-			expectedPluginConfiguration: PluginConfiguration{"outbound|80||ext-authz", 42, true, "OIDC"},
+			expectedPluginConfiguration: PluginConfiguration{"outbound|80||ext-authz", 42, true, "OIDC", defaultPluginConfig.UnsignedPayload, defaultPluginConfig.UseHttps},
 			expectedAuthConfiguration:   EndpointAuthConfiguration{}},
 	}
 
@@ -519,15 +536,19 @@ func TestAuthParseErrorCases(t *testing.T) {
 		{testName: "Do not include auth entries without domain and path.",
 			testConfig: "{\"ISHARE\":{\"other-domain.org\": [\"/\"]}, \"INVALID\":[]}",
 			expectedEndpointAuthConfig: map[string]map[string]EndpointAuthEntry{
-				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/"}}}},
+				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", false}}}},
 		{testName: "Do not include auth entries in an invalid format.",
 			testConfig: "{\"ISHARE\":{\"other-domain.org\": [\"/\"]}, \"INVALID\":{}}",
 			expectedEndpointAuthConfig: map[string]map[string]EndpointAuthEntry{
-				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/"}}}},
+				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", false}}}},
 		{testName: "Do not include auth entries without a path.",
 			testConfig: "{\"ISHARE\":{\"other-domain.org\": [\"/\"]}, \"INVALID\":{\"domain.org\":[]}}",
 			expectedEndpointAuthConfig: map[string]map[string]EndpointAuthEntry{
-				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/"}}}},
+				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", false}}}},
+		{testName: "Do not include path objects without a path.",
+			testConfig: "{\"ISHARE\":{\"other-domain.org\": [\"/\"], \"domain.org\": [{\"useHttps\": true}]}}",
+			expectedEndpointAuthConfig: map[string]map[string]EndpointAuthEntry{
+				"other-domain.org": map[string]EndpointAuthEntry{"/*": {h.Sum32(), "ISHARE", "other-domain.org", "/", false}}}},
 	}
 
 	for _, tc := range tests {
@@ -604,3 +625,413 @@ func TestPathMatching(t *testing.T) {
 		}
 	}
 }
+
+func TestSubjectBoundAuthTypes(t *testing.T) {
+
+	type test struct {
+		testName        string
+		authorization   string
+		authResponse    string
+		expectedAction  types.Action
+		expectedHeaders [][2]string
+		expectedStatus  uint32
+	}
+
+	exchangedResponse := `[{"name": "Authorization", "value": "Bearer exchangedToken"}]`
+
+	tests := []test{
+		{testName: "Replace the authorization on every request.", authorization: "Bearer userToken", authResponse: exchangedResponse,
+			expectedAction:  types.ActionPause,
+			expectedHeaders: [][2]string{{"authorization", "Bearer exchangedToken"}}},
+		{testName: "Do nothing without authorization.",
+			expectedAction:  types.ActionContinue,
+			expectedHeaders: [][2]string{}},
+		{testName: "Remove the authorization if no replacement is returned.", authorization: "Bearer userToken", authResponse: `[]`,
+			expectedAction:  types.ActionPause,
+			expectedHeaders: [][2]string{}},
+		{testName: "Reject the request if the auth-provider fails.", authorization: "Bearer userToken", authResponse: `{"type":"about:blank","status":502}`,
+			expectedAction: types.ActionPause,
+			expectedStatus: 502},
+	}
+
+	testConfig := "{\"general\":{\"enableEndpointMatching\":true},\"endpoints\":{\"TOKEN_EXCHANGE\":{\"domain.org\": [\"/\"]}}}"
+	responseHeaders := [][2]string{{"HTTP/1.1", "200 OK"}, {"cache-control", "max-age=60"}}
+
+	for _, tc := range tests {
+
+		t.Run(tc.testName, func(t *testing.T) {
+			opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte(testConfig)).WithVMContext(&vmContext{})
+			host, reset := proxytest.NewHostEmulator(opt)
+			defer reset()
+			log.Print("TestSubjectBoundAuthTypes +++++++++++++++++++++ Running test: " + tc.testName)
+
+			// the headers of the user are never cached, thus the second request requires a call, too
+			for i := 0; i < 2; i++ {
+				id := host.InitializeHttpContext()
+
+				hs := [][2]string{{":authority", "domain.org"}, {":path", "/"}, {":method", "GET"}}
+				if tc.authorization != "" {
+					hs = append(hs, [2]string{"authorization", tc.authorization})
+				}
+
+				action := host.CallOnRequestHeaders(id, hs, true)
+				if action != tc.expectedAction {
+					t.Errorf("%s: Action was expected to be %v, but was %v.", tc.testName, tc.expectedAction, action)
+				}
+				if action == types.ActionPause {
+					attrs := host.GetCalloutAttributesFromContext(id)
+					forwarded := false
+					for _, h := range attrs[0].Headers {
+						forwarded = forwarded || h == [2]string{"authorization", tc.authorization}
+					}
+					if !forwarded {
+						t.Errorf("%s: The authorization should be forwarded to the auth-provider, but headers were %v.", tc.testName, attrs[0].Headers)
+					}
+					host.CallOnHttpCallResponse(attrs[0].CalloutID, responseHeaders, nil, []byte(tc.authResponse))
+				}
+				if localResponse := host.GetSentLocalResponse(id); (localResponse == nil && tc.expectedStatus != 0) || (localResponse != nil && localResponse.StatusCode != tc.expectedStatus) {
+					t.Errorf("%s: Expected the request to be answered with %v, but was %v.", tc.testName, tc.expectedStatus, localResponse)
+					continue
+				}
+				if tc.expectedStatus != 0 {
+					// the request is answered by the filter, it never reaches the endpoint
+					continue
+				}
+
+				generalHeaders := [][2]string{{":authority", "domain.org"}, {":path", "/"}, {":method", "GET"}}
+				verifyHeaders(t, generalHeaders, tc.expectedHeaders, host.GetCurrentRequestHeaders(id), tc.testName)
+				verifyEndAction(t, host.GetCurrentHttpStreamAction(id), tc.testName)
+			}
+		})
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+
+	type test struct {
+		testName        string
+		authType        string
+		method          string
+		body            string
+		digestHeader    string
+		contentDigest   string
+		unsignedPayload bool
+		expectedDigest  string
+		expectedCallout string
+	}
+
+	tests := []test{
+		{testName: "Sign a request without body.", authType: "HTTP_SIGNATURE", method: "GET", digestHeader: "content-digest",
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Sign a request with the digest of its body.", authType: "HTTP_SIGNATURE", method: "POST", body: `{"hello": "world"}`, digestHeader: "content-digest",
+			expectedDigest:  "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:",
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2F&method=POST&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Keep an existing digest.", authType: "HTTP_SIGNATURE", method: "POST", body: `{"hello": "world"}`, digestHeader: "content-digest", contentDigest: "sha-512=:existing=:",
+			expectedDigest:  "sha-512=:existing=:",
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2F&method=POST&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Sign a request for aws with the hash of its body.", authType: "AWS_SIGV4", method: "PUT", body: `{"hello": "world"}`, digestHeader: "x-amz-content-sha256",
+			expectedDigest:  "5f8f04f6a3a892aaabbddb6cf273894493773960d4a325b105fee46eef4304f1",
+			expectedCallout: "/AWS_SIGV4/auth?domain=domain.org&path=%2F&method=PUT&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Sign a request for aws with the hash of the client.", authType: "AWS_SIGV4", method: "PUT", body: `{"hello": "world"}`, digestHeader: "x-amz-content-sha256", contentDigest: "existing",
+			expectedDigest:  "existing",
+			expectedCallout: "/AWS_SIGV4/auth?domain=domain.org&path=%2F&method=PUT&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Sign a request for aws with an unsigned payload.", authType: "AWS_SIGV4", method: "PUT", body: `{"hello": "world"}`, digestHeader: "x-amz-content-sha256", unsignedPayload: true,
+			expectedDigest:  "UNSIGNED-PAYLOAD",
+			expectedCallout: "/AWS_SIGV4/auth?domain=domain.org&path=%2F&method=PUT&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+		{testName: "Digest the body for http signatures with unsigned payloads enabled.", authType: "HTTP_SIGNATURE", method: "POST", body: `{"hello": "world"}`, digestHeader: "content-digest", unsignedPayload: true,
+			expectedDigest:  "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:",
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2F&method=POST&uri=https%3A%2F%2Fdomain.org%2Forders%3Fid%3D1"},
+	}
+
+	authResponse := authResponse{`[{"name": "Signature-Input", "value": "sig1=(\"@method\");created=1"}, {"name": "Signature", "value": "sig1=:c2lnbmF0dXJl:"}]`, [][2]string{{"HTTP/1.1", "200 OK"}, {"cache-control", "no-store"}}}
+	expectedHeaders := [][2]string{{"signature-input", "sig1=(\"@method\");created=1"}, {"signature", "sig1=:c2lnbmF0dXJl:"}}
+
+	for _, tc := range tests {
+
+		t.Run(tc.testName, func(t *testing.T) {
+			testConfig := "{\"general\":{\"enableEndpointMatching\":true,\"unsignedPayload\":" + fmt.Sprint(tc.unsignedPayload) + "},\"endpoints\":{\"" + tc.authType + "\":{\"domain.org\": [{\"path\": \"/\", \"useHttps\": true}]}}}"
+			opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte(testConfig)).WithVMContext(&vmContext{})
+			host, reset := proxytest.NewHostEmulator(opt)
+			defer reset()
+			log.Print("TestSignedRequests +++++++++++++++++++++ Running test: " + tc.testName)
+
+			// signatures are never cached, thus the second request requires a call, too
+			for i := 0; i < 2; i++ {
+				id := host.InitializeHttpContext()
+
+				hs := [][2]string{{":authority", "domain.org"}, {":path", "/orders?id=1"}, {":method", tc.method}, {":scheme", "http"}}
+				if tc.contentDigest != "" {
+					hs = append(hs, [2]string{tc.digestHeader, tc.contentDigest})
+				}
+				generalHeaders := append([][2]string{}, hs...)
+
+				action := host.CallOnRequestHeaders(id, hs, tc.body == "")
+				// the body is only buffered if its digest has to be calculated
+				if tc.body != "" && tc.contentDigest == "" && tc.expectedDigest != "UNSIGNED-PAYLOAD" {
+					// the signature has to wait for the body
+					if action != types.ActionPause || len(host.GetCalloutAttributesFromContext(id)) != 0 {
+						t.Errorf("%s: The request should wait for its body, but action was %v.", tc.testName, action)
+					}
+					action = host.CallOnRequestBody(id, []byte(tc.body), true)
+				}
+				if action != types.ActionPause {
+					t.Errorf("%s: Action was expected to be %v, but was %v.", tc.testName, types.ActionPause, action)
+				}
+
+				attrs := host.GetCalloutAttributesFromContext(id)
+				var calloutPath, forwardedDigest string
+				for _, h := range attrs[0].Headers {
+					if h[0] == ":path" {
+						calloutPath = h[1]
+					}
+					if h[0] == tc.digestHeader {
+						forwardedDigest = h[1]
+					}
+				}
+				if calloutPath != tc.expectedCallout {
+					t.Errorf("%s: Expected the request context %s to be sent, but was %s.", tc.testName, tc.expectedCallout, calloutPath)
+				}
+				if forwardedDigest != tc.expectedDigest {
+					t.Errorf("%s: Expected the digest %s to be forwarded, but was %s.", tc.testName, tc.expectedDigest, forwardedDigest)
+				}
+				host.CallOnHttpCallResponse(attrs[0].CalloutID, authResponse.headers, nil, []byte(authResponse.body))
+
+				requestHeaders := expectedHeaders
+				if tc.expectedDigest != "" && tc.contentDigest == "" {
+					requestHeaders = append([][2]string{{tc.digestHeader, tc.expectedDigest}}, expectedHeaders...)
+				}
+				verifyHeaders(t, generalHeaders, requestHeaders, host.GetCurrentRequestHeaders(id), tc.testName)
+				verifyEndAction(t, host.GetCurrentHttpStreamAction(id), tc.testName)
+			}
+		})
+	}
+}
+
+func TestAuthRequestParameters(t *testing.T) {
+
+	type test struct {
+		testName        string
+		authType        string
+		path            string
+		useHttps        bool
+		expectedCallout string
+	}
+
+	tests := []test{
+		{testName: "Plain path.", authType: "ISHARE", path: "/orders",
+			expectedCallout: "/ISHARE/auth?domain=domain.org&path=%2Forders"},
+		{testName: "Path with a query.", authType: "ISHARE", path: "/orders?id=1&state=open",
+			expectedCallout: "/ISHARE/auth?domain=domain.org&path=%2Forders%3Fid%3D1%26state%3Dopen"},
+		{testName: "Query overriding the request context.", authType: "HTTP_SIGNATURE", path: "/x?a&uri=https://other&method=DELETE", useHttps: true,
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2Fx%3Fa%26uri%3Dhttps%3A%2F%2Fother%26method%3DDELETE&method=GET&uri=https%3A%2F%2Fdomain.org%2Fx%3Fa%26uri%3Dhttps%3A%2F%2Fother%26method%3DDELETE"},
+		{testName: "Uri of an endpoint with https.", authType: "HTTP_SIGNATURE", path: "/orders", useHttps: true,
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2Forders&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders"},
+		{testName: "Uri of an endpoint without https.", authType: "HTTP_SIGNATURE", path: "/orders",
+			expectedCallout: "/HTTP_SIGNATURE/auth?domain=domain.org&path=%2Forders&method=GET&uri=http%3A%2F%2Fdomain.org%2Forders"},
+	}
+
+	for _, tc := range tests {
+
+		t.Run(tc.testName, func(t *testing.T) {
+			testConfig := "{\"general\":{\"authType\":\"" + tc.authType + "\",\"useHttps\":" + fmt.Sprint(tc.useHttps) + "}}"
+			opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte(testConfig)).WithVMContext(&vmContext{})
+			host, reset := proxytest.NewHostEmulator(opt)
+			defer reset()
+			log.Print("TestAuthRequestParameters +++++++++++++++++++++ Running test: " + tc.testName)
+
+			id := host.InitializeHttpContext()
+			// the application sends plain http to the sidecar, independent of the scheme towards the endpoint
+			hs := [][2]string{{":authority", "domain.org"}, {":path", tc.path}, {":method", "GET"}, {":scheme", "http"}}
+			if action := host.CallOnRequestHeaders(id, hs, true); action != types.ActionPause {
+				t.Errorf("%s: Action was expected to be %v, but was %v.", tc.testName, types.ActionPause, action)
+				return
+			}
+
+			for _, h := range host.GetCalloutAttributesFromContext(id)[0].Headers {
+				if h[0] == ":path" && h[1] != tc.expectedCallout {
+					t.Errorf("%s: Expected the callout %s, but was %s.", tc.testName, tc.expectedCallout, h[1])
+				}
+			}
+		})
+	}
+}
+
+func TestDpopNonces(t *testing.T) {
+
+	type test struct {
+		testName        string
+		domain          string
+		responseNonce   string
+		expectedCallout string
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "First request without nonce.", domain: "domain.org", responseNonce: "nonce-1",
+			expectedCallout: "/DPOP/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders"},
+		{testName: "Include the challenged nonce.", domain: "domain.org",
+			expectedCallout: "/DPOP/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders&nonce=nonce-1"},
+		{testName: "Nonces are kept per domain, the proof of an endpoint without https is for http.", domain: "other.org", responseNonce: "other-nonce",
+			expectedCallout: "/DPOP/auth?domain=other.org&path=%2F&method=GET&uri=http%3A%2F%2Fother.org%2Forders"},
+		{testName: "Renew the nonce.", domain: "domain.org", responseNonce: "nonce-2",
+			expectedCallout: "/DPOP/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders&nonce=nonce-1"},
+		{testName: "Include the renewed nonce.", domain: "domain.org",
+			expectedCallout: "/DPOP/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders&nonce=nonce-2"},
+		{testName: "Keep the nonce if no new one is provided.", domain: "domain.org",
+			expectedCallout: "/DPOP/auth?domain=domain.org&path=%2F&method=GET&uri=https%3A%2F%2Fdomain.org%2Forders&nonce=nonce-2"},
+		{testName: "Include the nonce of the other domain.", domain: "other.org",
+			expectedCallout: "/DPOP/auth?domain=other.org&path=%2F&method=GET&uri=http%3A%2F%2Fother.org%2Forders&nonce=other-nonce"},
+	}
+
+	testConfig := "{\"general\":{\"enableEndpointMatching\":true},\"endpoints\":{\"DPOP\":{\"domain.org\": [{\"path\": \"/\", \"useHttps\": true}], \"other.org\": [\"/\"]}}}"
+	authResponse := authResponse{`[{"name": "Authorization", "value": "DPoP myToken"}, {"name": "DPoP", "value": "proof"}]`, [][2]string{{"HTTP/1.1", "200 OK"}, {"cache-control", "no-store"}}}
+
+	opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte(testConfig)).WithVMContext(&vmContext{})
+	host, reset := proxytest.NewHostEmulator(opt)
+	defer reset()
+
+	for _, tc := range tests {
+		log.Print("TestDpopNonces +++++++++++++++++++++ Running test: " + tc.testName)
+
+		id := host.InitializeHttpContext()
+		// the application sends plain http to the sidecar, the proof has to be for the scheme the endpoint is reached with
+		hs := [][2]string{{":authority", tc.domain}, {":path", "/orders"}, {":method", "GET"}, {":scheme", "http"}, {"authorization", "DPoP oldToken"}}
+		if action := host.CallOnRequestHeaders(id, hs, true); action != types.ActionPause {
+			t.Errorf("%s: Action was expected to be %v, but was %v.", tc.testName, types.ActionPause, action)
+			continue
+		}
+
+		attrs := host.GetCalloutAttributesFromContext(id)
+		for _, h := range attrs[0].Headers {
+			if h[0] == ":path" && h[1] != tc.expectedCallout {
+				t.Errorf("%s: Expected the callout %s, but was %s.", tc.testName, tc.expectedCallout, h[1])
+			}
+		}
+		host.CallOnHttpCallResponse(attrs[0].CalloutID, authResponse.headers, nil, []byte(authResponse.body))
+
+		// the token and proof replace the ones of the request
+		generalHeaders := [][2]string{{":authority", tc.domain}, {":path", "/orders"}, {":method", "GET"}, {":scheme", "http"}}
+		verifyHeaders(t, generalHeaders, [][2]string{{"authorization", "DPoP myToken"}, {"dpop", "proof"}}, host.GetCurrentRequestHeaders(id), tc.testName)
+
+		responseHeaders := [][2]string{{":status", "401"}}
+		if tc.responseNonce != "" {
+			responseHeaders = append(responseHeaders, [2]string{"dpop-nonce", tc.responseNonce})
+		}
+		host.CallOnResponseHeaders(id, responseHeaders, true)
+		host.CompleteHttpContext(id)
+	}
+}
+
+func TestVersionChecks(t *testing.T) {
+
+	type test struct {
+		testName           string
+		testConfig         string
+		expectedTickPeriod uint32
+	}
+
+	tests := []test{
+		{testName: "Check the version of the default auth type.", testConfig: "{\"general\":{\"authType\":\"STATIC\"}}", expectedTickPeriod: 30000},
+		{testName: "Check the version of matched endpoints.", testConfig: "{\"general\":{\"enableEndpointMatching\":true},\"endpoints\":{\"ISHARE\":{\"domain.org\": [\"/\"]},\"STATIC\":{\"other.org\": [\"/\"]}}}", expectedTickPeriod: 30000},
+		{testName: "No checks for unversioned auth types.", testConfig: "{\"general\":{\"authType\":\"ISHARE\"}}"},
+		{testName: "No checks for unused auth types.", testConfig: "{\"general\":{\"authType\":\"ISHARE\"},\"endpoints\":{\"STATIC\":{\"other.org\": [\"/\"]}}}"},
+	}
+
+	for _, tc := range tests {
+		log.Print("TestVersionChecks +++++++++++++++++++++ Running test: " + tc.testName)
+
+		opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte(tc.testConfig)).WithVMContext(&vmContext{})
+		host, reset := proxytest.NewHostEmulator(opt)
+		host.StartPlugin()
+
+		if host.GetTickPeriod() != tc.expectedTickPeriod {
+			t.Errorf("%s: Expected the tick period %v, but was %v.", tc.testName, tc.expectedTickPeriod, host.GetTickPeriod())
+		}
+		host.Tick()
+		attrs := host.GetCalloutAttributesFromContext(proxytest.PluginContextID)
+		if tc.expectedTickPeriod == 0 && len(attrs) != 0 {
+			t.Errorf("%s: No version check expected, but was %v.", tc.testName, attrs)
+		}
+		if tc.expectedTickPeriod != 0 {
+			if len(attrs) != 1 {
+				t.Errorf("%s: Expected exactly one version check, but was %v.", tc.testName, attrs)
+			} else if attrs[0].Upstream != defaultPluginConfig.AuthProviderName || getHeader(attrs[0].Headers, ":path") != "/STATIC/version" {
+				t.Errorf("%s: Expected the version check at %s, but was %v.", tc.testName, defaultPluginConfig.AuthProviderName, attrs[0])
+			}
+		}
+		reset()
+	}
+}
+
+func TestVersionedCaching(t *testing.T) {
+
+	type test struct {
+		testName string
+		// response to the version check before the request, no check if empty
+		versionResponse [][2]string
+		// response of the auth-provider, if the request is expected to fetch new headers
+		authResponse   *authResponse
+		expectedHeader string
+	}
+
+	versionedResponse := func(token, version string) *authResponse {
+		return &authResponse{`[{"name": "Authorization", "value": "` + token + `"}]`, [][2]string{{":status", "200"}, {"cache-control", "max-age=3600"}, {"etag", version}}}
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Fetch the headers.", authResponse: versionedResponse("token-1", `"v1"`), expectedHeader: "token-1"},
+		{testName: "Use the cached headers.", expectedHeader: "token-1"},
+		{testName: "Use the cached headers of the current version.", versionResponse: [][2]string{{":status", "204"}, {"etag", `"v1"`}}, expectedHeader: "token-1"},
+		{testName: "Re-fetch the headers of an outdated version.", versionResponse: [][2]string{{":status", "204"}, {"etag", `"v2"`}}, authResponse: versionedResponse("token-2", `"v2"`), expectedHeader: "token-2"},
+		{testName: "Use the re-fetched headers.", expectedHeader: "token-2"},
+		{testName: "Keep the cached headers if the version is not available.", versionResponse: [][2]string{{":status", "500"}}, expectedHeader: "token-2"},
+		{testName: "Re-fetch the headers after a version change without etag.", versionResponse: [][2]string{{":status", "204"}, {"etag", `"v3"`}}, authResponse: &authResponse{`[{"name": "Authorization", "value": "token-3"}]`, [][2]string{{":status", "200"}, {"cache-control", "max-age=3600"}}}, expectedHeader: "token-3"},
+		{testName: "Keep unversioned headers.", versionResponse: [][2]string{{":status", "204"}, {"etag", `"v4"`}}, expectedHeader: "token-3"},
+	}
+
+	opt := proxytest.NewEmulatorOption().WithPluginConfiguration([]byte("{\"general\":{\"authType\":\"STATIC\"}}")).WithVMContext(&vmContext{})
+	host, reset := proxytest.NewHostEmulator(opt)
+	defer reset()
+	host.StartPlugin()
+
+	for _, tc := range tests {
+		log.Print("TestVersionedCaching +++++++++++++++++++++ Running test: " + tc.testName)
+
+		if tc.versionResponse != nil {
+			host.Tick()
+			attrs := host.GetCalloutAttributesFromContext(proxytest.PluginContextID)
+			host.CallOnHttpCallResponse(attrs[len(attrs)-1].CalloutID, tc.versionResponse, nil, nil)
+		}
+
+		id := host.InitializeHttpContext()
+		hs := [][2]string{{":authority", "domain.org"}, {":path", "/"}, {":method", "GET"}}
+		action := host.CallOnRequestHeaders(id, hs, true)
+		if tc.authResponse == nil {
+			if action != types.ActionContinue {
+				t.Errorf("%s: Request was expected to be served from cache, but action is %v.", tc.testName, action)
+			}
+		} else {
+			if action != types.ActionPause {
+				t.Errorf("%s: Request was expected to fetch new headers, but action is %v.", tc.testName, action)
+				host.CompleteHttpContext(id)
+				continue
+			}
+			attrs := host.GetCalloutAttributesFromContext(id)
+			host.CallOnHttpCallResponse(attrs[0].CalloutID, tc.authResponse.headers, nil, []byte(tc.authResponse.body))
+		}
+		if header := getHeader(host.GetCurrentRequestHeaders(id), "authorization"); header != tc.expectedHeader {
+			t.Errorf("%s: Expected the authorization %s, but was %s.", tc.testName, tc.expectedHeader, header)
+		}
+		host.CompleteHttpContext(id)
+	}
+}
+
+func getHeader(headers [][2]string, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h[0], name) {
+			return h[1]
+		}
+	}
+	return ""
+}
@@ -1,5 +1,8 @@
 package org.fiware.sidecar.mapping;
 
+import com.fasterxml.jackson.core.JsonProcessingException;
+import com.fasterxml.jackson.core.type.TypeReference;
+import com.fasterxml.jackson.databind.ObjectMapper;
 import org.fiware.sidecar.configuration.MeshExtensionProperties;
 import org.fiware.sidecar.model.AuthInfoVO;
 import org.fiware.sidecar.model.AuthType;
@@ -13,7 +16,9 @@ import org.mapstruct.Mapper;
 import org.mapstruct.Mapping;
 import org.mapstruct.Named;
 
+import java.util.HashMap;
 import java.util.Map;
+import java.util.Optional;
 import java.util.UUID;
 
 /**
@@ -30,6 +35,7 @@ public interface EndpointMapper {
 	@Mapping(source = "authCredentials.iShareIdpId", target = "IShareIdpId")
 	@Mapping(source = "authCredentials.iShareIdpAddress", target = "IShareIdpAddress")
 	@Mapping(source = "authCredentials.requestGrantType", target = "requestGrantType")
+	@Mapping(source = "authConfig", target = "authConfig", qualifiedByName = "authConfigToJson")
 	Endpoint endpointRegistrationVoToEndpoint(EndpointRegistrationVO endpointRegistrationVO);
 
 	AuthType authTypeVoToAuthType(AuthTypeVO authTypeVO);
@@ -44,11 +50,13 @@ public interface EndpointMapper {
 	default AuthInfoVO endpointToAuthInfoVo(Endpoint endpoint) {
 		AuthInfoVO authInfoVO = new AuthInfoVO();
 		authInfoVO.authType(authTypeToAuthTypeVo(endpoint.getAuthType()));
-		Map<String, Object> authInfoProperties = Map.of(
-				"iShareClientId", endpoint.getIShareClientId(),
-				"iShareIdpId", endpoint.getIShareIdpId(),
-				"iShareIdpAddress", endpoint.getIShareIdpAddress(),
-				"requestGrantType", endpoint.getRequestGrantType());
+		authInfoVO.config(authConfigFromJson(endpoint.getAuthConfig()));
+		// only iShare endpoints have credentials, the other types are configured through the config
+		Map<String, Object> authInfoProperties = new HashMap<>();
+		Optional.ofNullable(endpoint.getIShareClientId()).ifPresent(v -> authInfoProperties.put("iShareClientId", v));
+		Optional.ofNullable(endpoint.getIShareIdpId()).ifPresent(v -> authInfoProperties.put("iShareIdpId", v));
+		Optional.ofNullable(endpoint.getIShareIdpAddress()).ifPresent(v -> authInfoProperties.put("iShareIdpAddress", v));
+		Optional.ofNullable(endpoint.getRequestGrantType()).ifPresent(v -> authInfoProperties.put("requestGrantType", v));
 		return authInfoVO.setAdditionalProperties(authInfoProperties);
 	}
 
@@ -59,6 +67,31 @@ public interface EndpointMapper {
 		return useHttps ? "https" : null;
 	}
 
+	@Named("authConfigToJson")
+	static String authConfigToJson(Map<String, Object> authConfig) {
+		if (authConfig == null) {
+			return null;
+		}
+		try {
+			return MappingHelper.OBJECT_MAPPER.writeValueAsString(authConfig);
+		} catch (JsonProcessingException e) {
+			throw new IllegalArgumentException("The auth config cannot be serialized.", e);
+		}
+	}
+
+	@Named("authConfigFromJson")
+	static Map<String, Object> authConfigFromJson(String authConfig) {
+		if (authConfig == null) {
+			return null;
+		}
+		try {
+			return MappingHelper.OBJECT_MAPPER.readValue(authConfig, new TypeReference<Map<String, Object>>() {
+			});
+		} catch (JsonProcessingException e) {
+			throw new IllegalArgumentException("The stored auth config is not valid json.", e);
+		}
+	}
+
 	default UUID stringToUUID(String value) {
 		return UUID.fromString(value);
 	}
@@ -69,6 +102,8 @@ public interface EndpointMapper {
 
 	// helper class to prevent mapstruct creating a default mapping for int->Integer
 	class MappingHelper {
+		private static final ObjectMapper OBJECT_MAPPER = new ObjectMapper();
+
 		public static Integer setToNullIfZero(int integerValue) {
 			if (integerValue == 0) {
 				return null;
@@ -8,7 +8,16 @@ import lombok.Getter;
  */
 public enum AuthType {
 
-	ISHARE("iShare");
+	ISHARE("iShare"),
+	OAUTH2("OAUTH2"),
+	STATIC("STATIC"),
+	JWT_BEARER("JWT_BEARER"),
+	TOKEN_EXCHANGE("TOKEN_EXCHANGE"),
+	HTTP_SIGNATURE("HTTP_SIGNATURE"),
+	AWS_SIGV4("AWS_SIGV4"),
+	VERIFIABLE_CREDENTIALS("VERIFIABLE_CREDENTIALS"),
+	SERVICE_ACCOUNT_TOKEN("SERVICE_ACCOUNT_TOKEN"),
+	DPOP("DPOP");
 
 	@Getter
 	private final String value;
@@ -1,4 +1,4 @@
 package org.fiware.sidecar.model;
 
-public record MustachePath(String path) {
+public record MustachePath(String path, boolean useHttps) {
 }
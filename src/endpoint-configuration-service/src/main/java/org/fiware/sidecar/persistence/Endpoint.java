@@ -6,6 +6,7 @@ import org.fiware.sidecar.model.AuthType;
 import javax.persistence.Entity;
 import javax.persistence.GeneratedValue;
 import javax.persistence.Id;
+import javax.persistence.Lob;
 import java.util.UUID;
 
 /**
@@ -30,4 +31,7 @@ public class Endpoint {
 	private String iShareIdpId;
 	private String iShareIdpAddress;
 	private String requestGrantType;
+	// type-specific configuration of the auth-provider, stored as json
+	@Lob
+	private String authConfig;
 }
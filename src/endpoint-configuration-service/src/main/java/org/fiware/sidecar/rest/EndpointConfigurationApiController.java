@@ -50,6 +50,11 @@ public class EndpointConfigurationApiController implements EndpointConfiguration
 			return HttpResponse.status(HttpStatus.CONFLICT);
 		}
 
+		if (!endpointWriteService.isValidRegistration(endpointRegistrationVO)) {
+			log.debug("Received a registration without the credentials or config required for {}.", endpointRegistrationVO.authType());
+			return HttpResponse.status(HttpStatus.BAD_REQUEST);
+		}
+
 		if (endpointRegistrationVO.targetPort() == null && endpointRegistrationVO.port() == null) {
 			if (endpointRegistrationVO.useHttps()) {
 				log.debug("Setting the target port the https default: {}.", HTTPS_DEFAULT_PORT);
@@ -133,8 +138,8 @@ public class EndpointConfigurationApiController implements EndpointConfiguration
 	private EndpointWriteService getServiceForAuthType(AuthType authType) {
 		return subscriberWriteServices
 				.stream()
-				.filter(sws -> sws.supportedAuthType()
-						.equals(authType))
+				.filter(sws -> sws.supportedAuthTypes()
+						.contains(authType))
 				.findFirst()
 				.orElseThrow(() -> new UnsupportedOperationException(String.format("Auth type %s is not supported by this instance of the sidecar.", authType.getValue())));
 	}
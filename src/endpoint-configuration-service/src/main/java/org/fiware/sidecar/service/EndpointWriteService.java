@@ -4,6 +4,7 @@ import org.fiware.sidecar.exception.CredentialsConfigNotFound;
 import org.fiware.sidecar.model.AuthType;
 import org.fiware.sidecar.model.EndpointRegistrationVO;
 
+import java.util.Set;
 import java.util.UUID;
 
 /**
@@ -13,10 +14,17 @@ import java.util.UUID;
 public interface EndpointWriteService {
 
 	/**
-	 * Should return the auth-type supported by this write-service.
-	 * @return the {@link AuthType}
+	 * Should return the auth-types supported by this write-service.
+	 * @return the {@link AuthType}s
 	 */
-	AuthType supportedAuthType();
+	Set<AuthType> supportedAuthTypes();
+
+	/**
+	 * Check that the registration contains everything required by its auth-type
+	 * @param endpointRegistrationVO the endpoint registration to be checked
+	 * @return true if the endpoint can be created from the registration
+	 */
+	boolean isValidRegistration(EndpointRegistrationVO endpointRegistrationVO);
 
 	/**
 	 * Do all creations that are required for this specific endpoint type
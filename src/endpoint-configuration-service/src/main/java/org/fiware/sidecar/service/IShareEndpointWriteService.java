@@ -5,6 +5,7 @@ import org.fiware.sidecar.model.AuthType;
 import org.fiware.sidecar.model.EndpointRegistrationVO;
 
 import javax.inject.Singleton;
+import java.util.Set;
 import java.util.UUID;
 
 /**
@@ -13,8 +14,13 @@ import java.util.UUID;
 @Singleton
 public class IShareEndpointWriteService implements EndpointWriteService{
 	@Override
-	public AuthType supportedAuthType() {
-		return AuthType.ISHARE;
+	public Set<AuthType> supportedAuthTypes() {
+		return Set.of(AuthType.ISHARE);
+	}
+
+	@Override
+	public boolean isValidRegistration(EndpointRegistrationVO endpointRegistrationVO) {
+		return endpointRegistrationVO.authCredentials() != null;
 	}
 
 	@Override
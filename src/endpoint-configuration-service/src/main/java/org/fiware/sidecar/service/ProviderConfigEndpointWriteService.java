@@ -0,0 +1,44 @@
+package org.fiware.sidecar.service;
+
+import org.fiware.sidecar.exception.CredentialsConfigNotFound;
+import org.fiware.sidecar.model.AuthType;
+import org.fiware.sidecar.model.EndpointRegistrationVO;
+
+import javax.inject.Singleton;
+import java.util.EnumSet;
+import java.util.List;
+import java.util.Set;
+import java.util.UUID;
+
+/**
+ * Service for all auth-types configured through the type-specific auth config. The config is stored with the endpoint and handed
+ * to the auth-provider, the secrets are managed at the auth-provider itself.
+ */
+@Singleton
+public class ProviderConfigEndpointWriteService implements EndpointWriteService {
+
+	@Override
+	public Set<AuthType> supportedAuthTypes() {
+		return EnumSet.complementOf(EnumSet.of(AuthType.ISHARE));
+	}
+
+	@Override
+	public boolean isValidRegistration(EndpointRegistrationVO endpointRegistrationVO) {
+		return endpointRegistrationVO.authConfig() != null && !endpointRegistrationVO.authConfig().isEmpty();
+	}
+
+	@Override
+	public void createEndpoint(UUID uuid, EndpointRegistrationVO endpointRegistrationVO) {
+		//noop - the config is stored with the endpoint
+	}
+
+	@Override
+	public void deleteEndpoint(UUID uuid) {
+		//noop - the config is removed with the endpoint
+	}
+
+	@Override
+	public void updateEndpointCredential(UUID id, String credentialType, String credentialBody) throws CredentialsConfigNotFound {
+		throw new CredentialsConfigNotFound("Credentials are managed at the auth-provider.", credentialType, List.of());
+	}
+}
@@ -120,7 +120,7 @@ public class ServiceMeshUpdateService extends MustacheUpdateService {
 	}
 
 	private List<MustachePath> endpointsToMustachePaths(List<Endpoint> endpoints) {
-		return endpoints.stream().map(e -> new MustachePath(e.getPath())).toList();
+		return endpoints.stream().map(e -> new MustachePath(e.getPath(), e.isUseHttps())).toList();
 	}
 
 	@Override
@@ -6,19 +6,23 @@ import org.fiware.sidecar.mapping.EndpointMapper;
 import org.fiware.sidecar.mapping.EndpointMapperImpl;
 import org.fiware.sidecar.model.AuthInfoVO;
 import org.fiware.sidecar.model.AuthType;
+import org.fiware.sidecar.model.AuthTypeVO;
 import org.fiware.sidecar.persistence.Endpoint;
 import org.fiware.sidecar.persistence.EndpointRepository;
 import org.junit.jupiter.api.BeforeEach;
+import org.junit.jupiter.api.Test;
 import org.junit.jupiter.params.ParameterizedTest;
 import org.junit.jupiter.params.provider.Arguments;
 import org.junit.jupiter.params.provider.MethodSource;
 
 import java.util.List;
+import java.util.Map;
 import java.util.UUID;
 import java.util.stream.Collectors;
 import java.util.stream.Stream;
 
 import static org.junit.jupiter.api.Assertions.assertEquals;
+import static org.junit.jupiter.api.Assertions.assertTrue;
 import static org.mockito.Mockito.mock;
 import static org.mockito.Mockito.when;
 
@@ -49,6 +53,23 @@ class AuthConfigurationApiControllerTest {
 		assertEquals(expectedEndpoint.getIShareClientId(), response.body().getAdditionalProperties().get("iShareClientId"), String.format("The endpoint with path %s should be returned.", expectedPath));
 	}
 
+	@Test
+	public void returnAuthConfig() {
+		Endpoint endpoint = new Endpoint();
+		endpoint.setAuthType(AuthType.HTTP_SIGNATURE);
+		endpoint.setId(UUID.randomUUID());
+		endpoint.setPath("/");
+		endpoint.setAuthConfig("{\"keyId\":\"myKey\",\"components\":[\"@method\"]}");
+		when(endpointRepository.findByDomain("test.de"))
+				.thenReturn(List.of(endpoint));
+
+		HttpResponse<AuthInfoVO> response = authConfigurationApiController.getEndpointByDomainAndPath("test.de", "/");
+		assertEquals(HttpStatus.OK, response.getStatus(), "An authInfo should be responded");
+		assertEquals(AuthTypeVO.HTTP_SIGNATURE, response.body().getAuthType(), "The auth type should be returned.");
+		assertEquals(Map.of("keyId", "myKey", "components", List.of("@method")), response.body().getConfig(), "The auth config should be returned.");
+		assertTrue(response.body().getAdditionalProperties().isEmpty(), "No iShare properties should be returned.");
+	}
+
 	private static Stream<Arguments> providePathMatchConfig() {
 		return Stream.of(
 				Arguments.of("/", "/", List.of("/test", "/test/path", "/path/test")),
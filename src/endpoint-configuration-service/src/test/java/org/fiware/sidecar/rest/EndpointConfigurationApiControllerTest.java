@@ -13,6 +13,7 @@ import org.fiware.sidecar.persistence.Endpoint;
 import org.fiware.sidecar.persistence.EndpointRepository;
 import org.fiware.sidecar.service.EnvoyUpdateService;
 import org.fiware.sidecar.service.IShareEndpointWriteService;
+import org.fiware.sidecar.service.ProviderConfigEndpointWriteService;
 import org.junit.jupiter.api.Assertions;
 import org.junit.jupiter.api.BeforeEach;
 import org.junit.jupiter.api.Test;
@@ -25,6 +26,7 @@ import org.mockito.Mockito;
 import java.util.ArrayList;
 import java.util.Arrays;
 import java.util.List;
+import java.util.Map;
 import java.util.Optional;
 import java.util.UUID;
 import java.util.stream.Collectors;
@@ -45,7 +47,7 @@ class EndpointConfigurationApiControllerTest {
 	@BeforeEach
 	public void setup() {
 		endpointRepository = mock(EndpointRepository.class);
-		endpointConfigurationApiController = new EndpointConfigurationApiController(List.of(new IShareEndpointWriteService()), endpointRepository, ENDPOINT_MAPPER, List.of(mock(EnvoyUpdateService.class)));
+		endpointConfigurationApiController = new EndpointConfigurationApiController(List.of(new IShareEndpointWriteService(), new ProviderConfigEndpointWriteService()), endpointRepository, ENDPOINT_MAPPER, List.of(mock(EnvoyUpdateService.class)));
 	}
 
 	@ParameterizedTest
@@ -99,6 +101,44 @@ class EndpointConfigurationApiControllerTest {
 
 	}
 
+	@Test
+	public void createEndpoint_withAuthConfig() {
+		EndpointRegistrationVO endpointRegistrationVO = new EndpointRegistrationVO()
+				.authType(AuthTypeVO.OAUTH2)
+				.domain("domain")
+				.path("/")
+				.authConfig(Map.of("tokenEndpoint", "https://idp.org/token", "clientId", "myClient"));
+
+		Endpoint e = new Endpoint();
+		e.setId(UUID.randomUUID());
+
+		ArgumentCaptor<Endpoint> captor = ArgumentCaptor.forClass(Endpoint.class);
+		when(endpointRepository.save(any())).thenReturn(e);
+
+		HttpResponse<Object> response = endpointConfigurationApiController.createEndpoint(endpointRegistrationVO);
+		Assertions.assertEquals(HttpStatus.CREATED, response.getStatus(), "The endpoint should have been created.");
+
+		verify(endpointRepository).save(captor.capture());
+		Endpoint persistedEndpoint = captor.getValue();
+		Assertions.assertEquals(AuthType.OAUTH2, persistedEndpoint.getAuthType(), "The correct auth type should be persisted.");
+		Assertions.assertEquals(endpointRegistrationVO.authConfig(), ENDPOINT_MAPPER.endpointToAuthInfoVo(persistedEndpoint).getConfig(), "The auth config should be persisted.");
+	}
+
+	@ParameterizedTest
+	@MethodSource("incompleteRegistrations")
+	public void createEndpoint_incomplete(EndpointRegistrationVO endpointRegistrationVO) {
+		HttpResponse<Object> response = endpointConfigurationApiController.createEndpoint(endpointRegistrationVO);
+		Assertions.assertEquals(HttpStatus.BAD_REQUEST, response.getStatus(), "The endpoint should not have been created.");
+	}
+
+	static Stream<Arguments> incompleteRegistrations() {
+		return Stream.of(
+				Arguments.of(new EndpointRegistrationVO().authType(AuthTypeVO.ISHARE).domain("domain")),
+				Arguments.of(new EndpointRegistrationVO().authType(AuthTypeVO.ISHARE).domain("domain").authConfig(Map.of("clientId", "myClient"))),
+				Arguments.of(new EndpointRegistrationVO().authType(AuthTypeVO.OAUTH2).domain("domain")),
+				Arguments.of(new EndpointRegistrationVO().authType(AuthTypeVO.STATIC).domain("domain").authConfig(Map.of())));
+	}
+
 	@Test
 	public void createEndpoint_duplicateDomain() {
 		EndpointRegistrationVO endpointRegistrationVO = new EndpointRegistrationVO()
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errInvalidAssertionSettings = errors.New("invalid_assertion_settings")
+
+/**
+* Lifetime, backdating and nbf-claim of client assertions. Durations are configured in seconds. Unset values fall back to the
+* global settings.
+ */
+type assertionSettings struct {
+	Lifetime *int  `json:"lifetime,omitempty"`
+	Backdate *int  `json:"backdate,omitempty"`
+	Nbf      *bool `json:"nbf,omitempty"`
+}
+
+/**
+* Lifetime of the assertion, counted from its iat. iShare requires 30s.
+ */
+var assertionLifetime = 30 * time.Second
+
+/**
+* Time to move the iat into the past, to be accepted by idps whose clock is behind ours.
+ */
+var assertionBackdate time.Duration
+
+/**
+* Should the assertion contain an nbf claim.
+ */
+var assertionNbfEnabled bool
+
+/**
+* Assertion settings per idp, indexed by the id used as audience.
+ */
+var idpAssertionSettings = map[string]assertionSettings{}
+
+/**
+* Should the assertion times be corrected by the clock offset observed for the idp.
+ */
+var clockSkewCorrectionEnabled bool
+
+/**
+* Clock offsets observed from the Date headers of the token endpoints, indexed by the audience.
+ */
+type clockOffsets struct {
+	mutex   sync.RWMutex
+	offsets map[string]time.Duration
+}
+
+var idpClockOffsets = clockOffsets{offsets: map[string]time.Duration{}}
+
+/**
+* Metric containing the last observed clock skew in seconds per idp. Positive values mean the idp is ahead of us.
+ */
+var clockSkewMetric = expvar.NewMap("idp_clock_skew_seconds")
+
+/**
+* Parse the per-idp settings, f.e. {"EU.EORI.IDP": {"lifetime": 10, "backdate": 2, "nbf": true}}
+ */
+func parseIdpAssertionSettings(settingsJson string) (settings map[string]assertionSettings, err error) {
+	settings = map[string]assertionSettings{}
+	err = json.Unmarshal([]byte(settingsJson), &settings)
+	if err != nil {
+		return settings, fmt.Errorf("%w: %v", errInvalidAssertionSettings, err)
+	}
+	for idp, idpSettings := range settings {
+		lifetime, backdate, _ := idpSettings.resolve()
+		err = validateAssertionTimes(lifetime, backdate)
+		if err != nil {
+			return settings, fmt.Errorf("%w for %s", err, idp)
+		}
+	}
+	return settings, err
+}
+
+/**
+* The assertion has to be valid after backdating, thus the backdate has to be shorter than the lifetime.
+ */
+func validateAssertionTimes(lifetime time.Duration, backdate time.Duration) error {
+	if lifetime <= 0 {
+		return fmt.Errorf("%w: lifetime has to be positive", errInvalidAssertionSettings)
+	}
+	if backdate < 0 || backdate >= lifetime {
+		return fmt.Errorf("%w: backdate has to be between 0 and the lifetime", errInvalidAssertionSettings)
+	}
+	return nil
+}
+
+/**
+* Get lifetime, backdate and nbf, filling unset values from the global settings.
+ */
+func (as assertionSettings) resolve() (lifetime time.Duration, backdate time.Duration, nbf bool) {
+	lifetime, backdate, nbf = assertionLifetime, assertionBackdate, assertionNbfEnabled
+	if as.Lifetime != nil {
+		lifetime = time.Duration(*as.Lifetime) * time.Second
+	}
+	if as.Backdate != nil {
+		backdate = time.Duration(*as.Backdate) * time.Second
+	}
+	if as.Nbf != nil {
+		nbf = *as.Nbf
+	}
+	return lifetime, backdate, nbf
+}
+
+/**
+* Set the time claims of an assertion for the given audience. The exp is always iat + lifetime.
+ */
+func setAssertionTimes(claims map[string]interface{}, audience string) {
+	lifetime, backdate, nbf := idpAssertionSettings[audience].resolve()
+
+	now := time.Now()
+	if clockSkewCorrectionEnabled {
+		now = now.Add(idpClockOffsets.get(audience))
+	}
+	iat := now.Add(-backdate).Unix()
+
+	claims["iat"] = iat
+	claims["exp"] = iat + int64(lifetime/time.Second)
+	if nbf {
+		claims["nbf"] = iat
+	}
+}
+
+/**
+* Estimate the clock offset of the audience from the Date header of its response. The header only has a resolution of one second,
+* offsets below are ignored.
+ */
+func recordClockSkew(audience string, requestStart time.Time, resp *http.Response) {
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	requestEnd := time.Now()
+	localTime := requestStart.Add(requestEnd.Sub(requestStart) / 2)
+
+	offset := serverTime.Sub(localTime).Truncate(time.Second)
+	clockSkewMetric.Set(audience, expvarFloat(offset.Seconds()))
+	if offset > time.Second || offset < -time.Second {
+		logger.Debugf("Observed a clock skew of %v for %s.", offset, audience)
+	} else {
+		offset = 0
+	}
+	idpClockOffsets.set(audience, offset)
+}
+
+func (co *clockOffsets) get(audience string) time.Duration {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.offsets[audience]
+}
+
+func (co *clockOffsets) set(audience string, offset time.Duration) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	co.offsets[audience] = offset
+}
+
+func expvarFloat(value float64) *expvar.Float {
+	f := new(expvar.Float)
+	f.Set(value)
+	return f
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSetAssertionTimes(t *testing.T) {
+
+	ten, five, enabled, disabled := 10, 5, true, false
+
+	type test struct {
+		testName       string
+		lifetime       time.Duration
+		backdate       time.Duration
+		nbf            bool
+		idpSettings    map[string]assertionSettings
+		clockOffset    time.Duration
+		skewCorrection bool
+		expectedIat    int64
+		expectedExp    int64
+		expectedNbf    bool
+	}
+
+	tests := []test{
+		{testName: "Default settings.", lifetime: 30 * time.Second, expectedIat: 0, expectedExp: 30},
+		{testName: "Shorter lifetime.", lifetime: 10 * time.Second, expectedIat: 0, expectedExp: 10},
+		{testName: "Backdated assertion.", lifetime: 30 * time.Second, backdate: 5 * time.Second, expectedIat: -5, expectedExp: 25},
+		{testName: "Assertion with nbf.", lifetime: 30 * time.Second, backdate: 5 * time.Second, nbf: true, expectedIat: -5, expectedExp: 25, expectedNbf: true},
+		{testName: "Idp specific settings.", lifetime: 30 * time.Second, idpSettings: map[string]assertionSettings{"idpId": {Lifetime: &ten, Backdate: &five, Nbf: &enabled}}, expectedIat: -5, expectedExp: 5, expectedNbf: true},
+		{testName: "Partial idp settings.", lifetime: 30 * time.Second, nbf: true, idpSettings: map[string]assertionSettings{"idpId": {Nbf: &disabled}}, expectedIat: 0, expectedExp: 30},
+		{testName: "Settings of other idps are ignored.", lifetime: 30 * time.Second, idpSettings: map[string]assertionSettings{"otherIdp": {Lifetime: &ten}}, expectedIat: 0, expectedExp: 30},
+		{testName: "Clock skew is corrected.", lifetime: 30 * time.Second, clockOffset: -20 * time.Second, skewCorrection: true, expectedIat: -20, expectedExp: 10},
+		{testName: "Clock skew is not corrected if disabled.", lifetime: 30 * time.Second, clockOffset: -20 * time.Second, expectedIat: 0, expectedExp: 30},
+	}
+
+	defer func() {
+		assertionLifetime, assertionBackdate, assertionNbfEnabled = 30*time.Second, 0, false
+		idpAssertionSettings = map[string]assertionSettings{}
+		clockSkewCorrectionEnabled = false
+		idpClockOffsets = clockOffsets{offsets: map[string]time.Duration{}}
+	}()
+
+	for _, tc := range tests {
+		log.Info("TestSetAssertionTimes +++++++++++++++++++++ Running test: " + tc.testName)
+
+		assertionLifetime, assertionBackdate, assertionNbfEnabled = tc.lifetime, tc.backdate, tc.nbf
+		idpAssertionSettings = tc.idpSettings
+		clockSkewCorrectionEnabled = tc.skewCorrection
+		idpClockOffsets = clockOffsets{offsets: map[string]time.Duration{"idpId": tc.clockOffset}}
+
+		claims := map[string]interface{}{}
+		now := time.Now().Unix()
+		setAssertionTimes(claims, "idpId")
+
+		iat := claims["iat"].(int64) - now
+		exp := claims["exp"].(int64) - now
+		// allow the clock to tick during the test
+		if iat != tc.expectedIat && iat != tc.expectedIat+1 {
+			t.Errorf(tc.testName + ": Expected iat " + fmt.Sprint(tc.expectedIat) + " but was " + fmt.Sprint(iat))
+		}
+		if exp-iat != tc.expectedExp-tc.expectedIat {
+			t.Errorf(tc.testName + ": Expected lifetime " + fmt.Sprint(tc.expectedExp-tc.expectedIat) + " but was " + fmt.Sprint(exp-iat))
+		}
+		nbf, hasNbf := claims["nbf"]
+		if hasNbf != tc.expectedNbf {
+			t.Errorf(tc.testName + ": Expected nbf to be present: " + fmt.Sprint(tc.expectedNbf))
+		}
+		if hasNbf && nbf.(int64)-now != iat {
+			t.Errorf(tc.testName + ": Expected nbf to be the iat, but was " + fmt.Sprint(nbf))
+		}
+	}
+}
+
+func TestParseIdpAssertionSettings(t *testing.T) {
+
+	type test struct {
+		testName      string
+		settings      string
+		expectedIdps  int
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Valid settings.", settings: `{"idpA": {"lifetime": 10, "backdate": 2, "nbf": true}, "idpB": {"nbf": false}}`, expectedIdps: 2},
+		{testName: "Invalid json.", settings: `{"idpA": `, expectedError: errInvalidAssertionSettings},
+		{testName: "Negative lifetime.", settings: `{"idpA": {"lifetime": -1}}`, expectedError: errInvalidAssertionSettings},
+		{testName: "Backdate exceeds lifetime.", settings: `{"idpA": {"lifetime": 10, "backdate": 10}}`, expectedError: errInvalidAssertionSettings},
+		{testName: "Backdate exceeds global lifetime.", settings: `{"idpA": {"backdate": 30}}`, expectedError: errInvalidAssertionSettings},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestParseIdpAssertionSettings +++++++++++++++++++++ Running test: " + tc.testName)
+
+		settings, err := parseIdpAssertionSettings(tc.settings)
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if tc.expectedError == nil && len(settings) != tc.expectedIdps {
+			t.Errorf(tc.testName + ": Expected settings for " + fmt.Sprint(tc.expectedIdps) + " idps but got: " + fmt.Sprint(settings))
+		}
+	}
+}
+
+func TestRecordClockSkew(t *testing.T) {
+
+	type test struct {
+		testName       string
+		dateHeader     string
+		expectedOffset time.Duration
+		expectedMetric string
+	}
+
+	now := time.Now()
+	tests := []test{
+		{testName: "Idp is ahead.", dateHeader: now.Add(30 * time.Second).UTC().Format(http.TimeFormat), expectedOffset: 30 * time.Second, expectedMetric: "30"},
+		{testName: "Idp is behind.", dateHeader: now.Add(-30 * time.Second).UTC().Format(http.TimeFormat), expectedOffset: -30 * time.Second, expectedMetric: "-30"},
+		{testName: "Offsets below the header resolution are ignored.", dateHeader: now.UTC().Format(http.TimeFormat), expectedOffset: 0},
+		{testName: "No date header.", expectedOffset: 0},
+	}
+
+	defer func() { idpClockOffsets = clockOffsets{offsets: map[string]time.Duration{}} }()
+
+	for _, tc := range tests {
+		log.Info("TestRecordClockSkew +++++++++++++++++++++ Running test: " + tc.testName)
+
+		idpClockOffsets = clockOffsets{offsets: map[string]time.Duration{}}
+		clockSkewMetric.Init()
+		resp := &http.Response{Header: http.Header{}}
+		if tc.dateHeader != "" {
+			resp.Header.Set("Date", tc.dateHeader)
+		}
+
+		recordClockSkew("idpId", now, resp)
+
+		// the header truncates to seconds
+		offset := idpClockOffsets.get("idpId")
+		if offset != tc.expectedOffset && offset != tc.expectedOffset-time.Second {
+			t.Errorf(tc.testName + ": Expected offset " + fmt.Sprint(tc.expectedOffset) + " but was " + fmt.Sprint(offset))
+		}
+		metric := clockSkewMetric.Get("idpId")
+		if tc.expectedMetric != "" && (metric == nil || (metric.String() != tc.expectedMetric && metric.String() != fmt.Sprint(tc.expectedOffset.Seconds()-1))) {
+			t.Errorf(tc.testName + ": Expected the metric to be " + tc.expectedMetric + " but was " + fmt.Sprint(metric))
+		}
+		if tc.dateHeader == "" && metric != nil {
+			t.Errorf(tc.testName + ": No metric expected without date header.")
+		}
+	}
+}
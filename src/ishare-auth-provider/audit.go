@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/**
+* Credential management operations recorded in the audit log.
+ */
+const (
+	auditListCredentials            = "list_credentials"
+	auditShowCredentials            = "show_credentials"
+	auditCreateCredentials          = "create_credentials"
+	auditDeleteCredentials          = "delete_credentials"
+	auditUpdateCertificate          = "update_certificate_chain"
+	auditUpdateSigningKey           = "update_signing_key"
+	auditUpdatePkcs11Reference      = "update_pkcs11_reference"
+	auditDeletePkcs11Reference      = "delete_pkcs11_reference"
+	auditCreateCsr                  = "create_csr"
+	auditActivateCertificate        = "activate_certificate_chain"
+	auditExportCredentials          = "export_credentials"
+	auditImportCredentials          = "import_credentials"
+	auditListOAuth2Credentials      = "list_oauth2_credentials"
+	auditCreateOAuth2Credentials    = "create_oauth2_credentials"
+	auditDeleteOAuth2Credentials    = "delete_oauth2_credentials"
+	auditUpdateClientSecret         = "update_oauth2_client_secret"
+	auditUpdateOAuth2SigningKey     = "update_oauth2_signing_key"
+	auditUpdateDpopKey              = "update_dpop_key"
+	auditListStaticSecrets          = "list_static_secrets"
+	auditShowStaticSecrets          = "show_static_secrets"
+	auditUpdateStaticSecret         = "update_static_secret"
+	auditDeleteStaticSecrets        = "delete_static_secrets"
+	auditListSigV4Credentials       = "list_sigv4_credentials"
+	auditUpdateSigV4Credentials     = "update_sigv4_credentials"
+	auditDeleteSigV4Credentials     = "delete_sigv4_credentials"
+	auditListVcHolders              = "list_vc_holders"
+	auditShowVcHolder               = "show_vc_holder"
+	auditUpdateVcHolderKey          = "update_vc_holder_key"
+	auditUpdateVerifiableCredential = "update_verifiable_credential"
+	auditDeleteVcHolder             = "delete_vc_holder"
+)
+
+/**
+* Logger for the audit log. Always writes json, separated from the application log.
+ */
+var auditLogger = newAuditLogger(os.Stdout)
+
+/**
+* Header containing the identity of the caller, as set by an authenticating proxy. Unset by default, since any client could
+* set it when the provider is reached directly.
+ */
+var auditIdentityHeader string
+
+/**
+* Entry of the audit log. Only contains certificate fingerprints, never any key material. The new certificate is read after
+* successful operations, failed operations did not change it.
+ */
+type auditEntry struct {
+	operation         string
+	clientId          string
+	certificateFolder string
+	oldCertificate    string
+	newCertificate    string
+	err               error
+}
+
+func newAuditLogger(out io.Writer) *logrus.Logger {
+	auditLog := logrus.New()
+	auditLog.SetOutput(out)
+	auditLog.SetFormatter(&logrus.JSONFormatter{})
+	auditLog.SetLevel(logrus.InfoLevel)
+	return auditLog
+}
+
+/**
+* Open the audit log file for appending. Existing entries are never modified.
+ */
+func openAuditLog(auditLogFile string) (auditLog io.Writer, err error) {
+	return os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+/**
+* Start the audit entry for an operation on the given client. The fingerprint of its current certificate is recorded as old certificate.
+ */
+func startAudit(operation string, clientId string) *auditEntry {
+	entry := &auditEntry{operation: operation, clientId: clientId}
+	if clientId != "" {
+		entry.certificateFolder = buildCredentialsFolderPath(clientId)
+		entry.oldCertificate = getCertificateFingerprint(entry.certificateFolder)
+	}
+	return entry
+}
+
+/**
+* Start the audit entry for an operation on secrets of the given client. Secrets are not fingerprinted.
+ */
+func startSecretAudit(operation string, clientId string) *auditEntry {
+	return &auditEntry{operation: operation, clientId: clientId}
+}
+
+/**
+* Write the entry, after the request was handled. Success is decided by the response status.
+ */
+func (ae *auditEntry) log(c *gin.Context) {
+	status := c.Writer.Status()
+	outcome := "success"
+	if status >= http.StatusBadRequest {
+		outcome = "failure"
+	}
+
+	fields := logrus.Fields{
+		"type":      "audit",
+		"operation": ae.operation,
+		"outcome":   outcome,
+		"status":    status,
+	}
+	if c.Request != nil {
+		fields["sourceIp"] = c.ClientIP()
+		fields["caller"] = getCallerIdentity(c)
+	}
+	if ae.clientId != "" {
+		fields["clientId"] = ae.clientId
+	}
+	if ae.oldCertificate != "" {
+		fields["oldCertificate"] = ae.oldCertificate
+	}
+	if outcome == "success" && ae.certificateFolder != "" && ae.operation != auditDeleteCredentials {
+		ae.newCertificate = getCertificateFingerprint(ae.certificateFolder)
+	}
+	if ae.newCertificate != "" {
+		fields["newCertificate"] = ae.newCertificate
+	}
+	if ae.err != nil {
+		fields["error"] = ae.err.Error()
+	}
+	auditLogger.WithFields(fields).Info("Credentials management operation.")
+}
+
+/**
+* Identity of the caller, either from the configured header or the subject of its client certificate.
+ */
+func getCallerIdentity(c *gin.Context) string {
+	if auditIdentityHeader != "" {
+		if identity := c.GetHeader(auditIdentityHeader); identity != "" {
+			return identity
+		}
+	}
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		return c.Request.TLS.PeerCertificates[0].Subject.String()
+	}
+	return ""
+}
+
+/**
+* Sha256 fingerprint of the stored certificate. Empty if the client has none.
+ */
+func getCertificateFingerprint(credentialsFolderPath string) string {
+	cert, err := globalFileAccessor.read(credentialsFolderPath + certChainFile)
+	if err != nil || len(cert) == 0 {
+		return ""
+	}
+	return fingerprint(cert)
+}
+
+/**
+* Sha256 fingerprint of the first certificate in the chain, or of the raw content if it is not pem encoded.
+ */
+func fingerprint(certChain []byte) string {
+	if block, _ := pem.Decode(certChain); block != nil {
+		certChain = block.Bytes
+	}
+	hash := sha256.Sum256(certChain)
+	return hex.EncodeToString(hash[:])
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+
+	"ishare-auth-provider/model"
 )
 
 /**
@@ -27,179 +33,364 @@ const keyfile = "key.pem"
 const certChainFile = "cert.cer"
 
 /**
-* Struct for holding the required auth info.
+* Assertion type to be used for jwt-based client authentication.
+ */
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+/**
+* Struct for holding the auth info of an endpoint. The iSHARE configuration is kept on the top level, as provided by the
+* configuration service. Other auth types read their configuration from config.
  */
 type AuthInfo struct {
-	AuthType         string `json:"authType"`
-	IShareIdpAddress string `json:"iShareIdpAddress"`
-	RequestGrantType string `json:"requestGrantType"`
-	IShareClientID   string `json:"iShareClientId"`
-	IShareIdpID      string `json:"iShareIdpId"`
+	AuthType                     string                 `json:"authType"`
+	IShareIdpAddress             string                 `json:"iShareIdpAddress"`
+	RequestGrantType             string                 `json:"requestGrantType"`
+	IShareClientID               string                 `json:"iShareClientId"`
+	IShareIdpID                  string                 `json:"iShareIdpId"`
+	AuthorizationRegistryID      string                 `json:"authorizationRegistryId,omitempty"`
+	AuthorizationRegistryAddress string                 `json:"authorizationRegistryAddress,omitempty"`
+	DelegationRequest            json.RawMessage        `json:"delegationRequest,omitempty"`
+	DelegationHeader             string                 `json:"delegationHeader,omitempty"`
+	Scope                        string                 `json:"scope,omitempty"`
+	AdditionalParameters         map[string]string      `json:"additionalParameters,omitempty"`
+	AdditionalClaims             map[string]interface{} `json:"additionalClaims,omitempty"`
+	Config                       json.RawMessage        `json:"config,omitempty"`
 }
 
-type Header struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
-}
+type Header = model.Header
 
 /**
 * Struct to contain headers to be returned by the auth provider.
  */
-type HeadersList []Header
+type HeadersList = model.HeadersList
 
 var errEmptyDomain error = errors.New("empty_domain")
 var errEmptyPath error = errors.New("empty_path")
 var errNoResponseBody = errors.New("no_response_body")
 var errCertDecode = errors.New("cert_decode_failed")
+var errInvalidSigningKey = errors.New("invalid_signing_key")
+var errNoAccessToken = errors.New("no_access_token")
+var errDisallowedParameter = errors.New("disallowed_parameter")
+var errDisallowedClaim = errors.New("disallowed_claim")
+var errUnknownEndpoint = errors.New("unknown_endpoint")
+var errUnexpectedStatus = errors.New("unexpected_status")
+var errIdpRejectedClient = errors.New("idp_rejected_client")
+var errIdpUnavailable = errors.New("idp_unavailable")
+
+/**
+* OAuth2 error codes(RFC 6749, section 5.2) that mean the client was refused. Every other error is handled as an unavailable idp.
+ */
+var clientRejectionErrors = map[string]bool{"invalid_request": true, "invalid_client": true, "invalid_grant": true, "unauthorized_client": true, "unsupported_grant_type": true, "invalid_scope": true, "access_denied": true}
+
+/**
+* Error response as defined by RFC 6749, section 5.2. Unwraps to the failure class.
+ */
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	failure     error
+}
+
+func (oe *oauthError) Error() string {
+	if oe.Description == "" {
+		return oe.Code
+	}
+	return oe.Code + ": " + oe.Description
+}
+
+func (oe *oauthError) Unwrap() error {
+	return oe.failure
+}
+
+/**
+* Scope to be requested at the idp, if nothing else is configured.
+ */
+const defaultScope = "iSHARE"
+
+/**
+* Form parameters that can be added to the token request through the auth info. Everything else is either set by the provider or unknown.
+ */
+var allowedAdditionalParameters = map[string]bool{"resource": true, "audience": true, "requested_token_type": true}
+
+/**
+* Claims of the client assertion that are set by the provider and cannot be overwritten through the auth info.
+ */
+var reservedClaims = map[string]bool{"jti": true, "iss": true, "sub": true, "aud": true, "iat": true, "exp": true, "nbf": true}
 
 // auth getter interface to improve testability
 type AuthGetterInterface interface {
 	getAuthInfo(domain string, path string) (authInfo AuthInfo, err error)
-	getSigningKey(credentialsFolderPath string) (key *rsa.PrivateKey, err error)
+	getSigningKey(credentialsFolderPath string) (key crypto.Signer, err error)
 	getCertificate(credentialsFolderPath string) (encodedCert string, err error)
 }
 
 type AuthGetter struct{}
 
+/**
+* Get the auth info from the mapping file, if configured. The configuration service is asked if there is no file or if the file does
+* not contain the endpoint.
+ */
 func (AuthGetter) getAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
-	return getAuthInformation(domain, path)
+	if authInfoFile == "" {
+		return getAuthInformation(domain, path)
+	}
+	authInfo, err = getMappedAuthInfo(domain, path)
+	if errors.Is(err, errUnknownEndpoint) && configurationServiceUrl != "" {
+		logger.Debugf("No mapping for %s - %s, ask the configuration service.", domain, path)
+		return getAuthInformation(domain, path)
+	}
+	return authInfo, err
 }
 
-func (AuthGetter) getSigningKey(credentialsFolderPath string) (key *rsa.PrivateKey, err error) {
-	return getSigningKey(credentialsFolderPath)
+func (AuthGetter) getSigningKey(credentialsFolderPath string) (key crypto.Signer, err error) {
+	return parsedCredentials.getSigner(credentialsFolderPath)
 }
 
 func (AuthGetter) getCertificate(credentialsFolderPath string) (encodedCert string, err error) {
-	return getEncodedCertificate(credentialsFolderPath)
+	return parsedCredentials.getCertificate(credentialsFolderPath)
 }
 
 var authGetter AuthGetterInterface = &AuthGetter{}
 
 /**
-* Route implementation for auth retrieval
+* Route implementation for auth retrieval, handing the request to the provider of the requested auth type.
  */
 func getAuth(c *gin.Context) {
 
+	authType := c.Param("authType")
+	provider, err := getAuthProvider(authType)
+	if err != nil {
+		logger.Infof("Auth for the unsupported type %s was requested.", authType)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownAuthType, "The auth type "+authType+" is not supported.")
+		return
+	}
+
 	domain := c.Query("domain")
 	if domain == "" {
 		logger.Warn("Empty domain was requested.")
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "No domain was requested.")
 		return
 	}
 	path := c.Query("path")
 	if path == "" {
 		logger.Warn("Empty path was requested.")
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "No path was requested.")
 		return
 	}
 
-	logger.Info("Get auth for " + domain + " - " + path)
+	logger.Info("Get " + authType + " auth for " + domain + " - " + path)
 
-	authInfo, err := authGetter.getAuthInfo(domain, path)
+	authInfo, err := provider.resolveAuthInfo(domain, path)
 	if err != nil {
-		logger.Warn("Was not able to retrieve auth-info. ", err)
-		c.String(http.StatusBadGateway, "Was not able to retrieve auth info from the config-service.")
+		abortWithAuthFailure(c, err)
+		return
+	}
+	// the version is read first, headers created from a newer state are re-fetched once more instead of being kept
+	version := getProviderVersion(provider, authType)
+	var headersList HeadersList
+	var cacheLifetime time.Duration
+	if requestProvider, ok := provider.(requestBoundProvider); ok {
+		request := requestContext{method: c.Query("method"), targetUri: c.Query("uri"), headers: c.Request.Header, nonce: c.Query("nonce")}
+		headersList, cacheLifetime, err = requestProvider.getRequestHeaders(authInfo, domain, path, request)
+	} else {
+		headersList, cacheLifetime, err = provider.getHeaders(authInfo, domain, path)
+	}
+	if err != nil {
+		abortWithAuthFailure(c, err)
 		return
 	}
 
-	// the files are stored in folders namend by the clientId
-	credentialsFolderPath := buildCredentialsFolderPath(authInfo.IShareClientID)
-
-	logger.Info("CredentialsFolderPath: " + credentialsFolderPath)
-
-	randomUuid, err := uuid.NewRandom()
+	if maxAge := int64(cacheLifetime.Seconds()); maxAge > 0 {
+		c.Header("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10))
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+	if version != "" {
+		c.Header("ETag", strconv.Quote(version))
+	}
+	c.JSON(http.StatusOK, headersList)
+}
 
-	if err != nil {
-		logger.Warn("Was not able to generate a uuid.", err)
-		c.String(http.StatusInternalServerError, "Failed to generate a uuid.")
+/**
+* Route implementation for the version of an auth type, polled by the filter to detect outdated headers.
+ */
+func getAuthVersion(c *gin.Context) {
+
+	authType := c.Param("authType")
+	provider, err := getAuthProvider(authType)
+	versioned, ok := provider.(versionedProvider)
+	if err != nil || !ok {
+		logger.Infof("The version of the unversioned type %s was requested.", authType)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownAuthType, "The auth type "+authType+" has no version.")
 		return
 	}
 
-	// prepare token headers
-	now := time.Now().Unix()
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"jti": randomUuid.String(),
-		"iss": authInfo.IShareClientID,
-		"sub": authInfo.IShareClientID,
-		"aud": authInfo.IShareIdpID,
-		"iat": now,
-		"exp": now + 30,
-	})
-
-	key, err := authGetter.getSigningKey(credentialsFolderPath)
+	version, err := versioned.getVersion()
 	if err != nil {
-		logger.Warn("Was not able to read the signing key.")
-		c.String(http.StatusInternalServerError, "Error reading the signingKey.")
+		logger.Warnf("Was not able to get the version of %s. Err: %v", authType, err)
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to get the version of "+authType+".")
 		return
 	}
-	if key == nil {
-		logger.Warn("Was not able to read a valid signing key.")
-		c.String(http.StatusInternalServerError, "Error reading the signingKey.")
-		return
+	c.Header("Cache-Control", "no-store")
+	if version != "" {
+		c.Header("ETag", strconv.Quote(version))
 	}
+	c.Status(http.StatusNoContent)
+}
 
-	cert, err := authGetter.getCertificate(credentialsFolderPath)
+/**
+* Version of the state the headers of the provider are created from, empty if the provider is not versioned or it is unknown. Without
+* a version, the headers are only re-fetched once they expire.
+ */
+func getProviderVersion(provider authProvider, authType string) string {
+	versioned, ok := provider.(versionedProvider)
+	if !ok {
+		return ""
+	}
+	version, err := versioned.getVersion()
 	if err != nil {
-		logger.Warn("Was not able to read the certificate.")
-		c.String(http.StatusInternalServerError, "Error reading the certificateChain.")
+		logger.Warnf("Was not able to get the version of %s, the headers are returned without. Err: %v", authType, err)
+		return ""
+	}
+	return version
+}
+
+/**
+* Get the token of a bearer authorization, empty for other schemes.
+ */
+func getBearerToken(authorization string) string {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+/**
+* Answer with the problem described by the failure of the provider. Unexpected errors are internal errors.
+ */
+func abortWithAuthFailure(c *gin.Context, err error) {
+	var failure *authFailure
+	if !errors.As(err, &failure) {
+		logger.Warn("The provider failed unexpectedly. ", err)
+		abortWithProblem(c, http.StatusInternalServerError, reasonInternalError, "Was not able to create the auth headers.")
 		return
 	}
+	if failure.clientId != "" {
+		c.Set(problemClientIdKey, failure.clientId)
+	}
+	abortWithProblem(c, failure.status, failure.reason, failure.detail)
+}
 
-	x5cCerts := [1]string{cert}
-	jwtToken.Header["x5c"] = x5cCerts
+/**
+* Check that the auth info only adds allowed form parameters and does not overwrite any of the claims set by the provider.
+ */
+func validateOverrides(authInfo AuthInfo) (err error) {
+	for name := range authInfo.AdditionalParameters {
+		if !allowedAdditionalParameters[name] {
+			return fmt.Errorf("%w: %s", errDisallowedParameter, name)
+		}
+	}
+	for name := range authInfo.AdditionalClaims {
+		if reservedClaims[name] {
+			return fmt.Errorf("%w: %s", errDisallowedClaim, name)
+		}
+	}
+	return err
+}
+
+/**
+* Create a client assertion for the given client, signed with its key and containing its certificate in the x5c header.
+* The additional claims are expected to be validated already.
+ */
+func createClientAssertion(clientId string, audience string, credentialsFolderPath string, additionalClaims map[string]interface{}) (signedToken string, err error) {
 
-	// sign the token
-	signedToken, err := jwtToken.SignedString(key)
+	randomUuid, err := uuid.NewRandom()
 	if err != nil {
-		logger.Warn("Was not able to sign the jwt.", err)
-		c.String(http.StatusInternalServerError, "Error signing the request jwt.")
-		return
+		logger.Warn("Was not able to generate a uuid.", err)
+		return signedToken, err
 	}
 
-	// prepare the form-body
-	data := url.Values{
-		"grant_type":            {authInfo.RequestGrantType},
-		"scope":                 {"iSHARE"},
-		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
-		"client_assertion":      {signedToken},
-		"client_id":             {authInfo.IShareClientID},
+	// prepare token headers
+	claims := jwt.MapClaims{}
+	for name, value := range additionalClaims {
+		claims[name] = value
 	}
+	claims["jti"] = randomUuid.String()
+	claims["iss"] = clientId
+	claims["sub"] = clientId
+	claims["aud"] = audience
+	setAssertionTimes(claims, audience)
+
+	return signAssertion(claims, credentialsFolderPath, assertionKeyHeaders{certificateHeader: x5cHeader})
+}
+
+/**
+* Request a token with the given form-data and return the decoded response. The response is required to contain an access token.
+* Its Date header is used to estimate the clock offset of the audience.
+ */
+func requestToken(tokenEndpoint string, audience string, data url.Values) (res map[string]interface{}, err error) {
 
-	// get the token
-	resp, err := globalHttpClient.PostForm(authInfo.IShareIdpAddress, data)
+	requestStart := time.Now()
+	resp, err := globalHttpClient.PostForm(tokenEndpoint, data)
 	if err != nil {
-		logger.Warn("Was not able to get the token from the idp.", err)
-		c.String(http.StatusBadGateway, "Was not able to get the token from the idp.")
-		return
+		logger.Warn("Was not able to request the token.", err)
+		return res, fmt.Errorf("%w: %v", errIdpUnavailable, err)
 	}
+	recordClockSkew(audience, requestStart, resp)
+	return decodeTokenResponse(resp)
+}
+
+/**
+* Decode a token response, it is required to contain an access token. Failed responses are classified by getOAuthError.
+ */
+func decodeTokenResponse(resp *http.Response) (res map[string]interface{}, err error) {
 
 	if resp.Body == nil {
-		logger.Warn("Did not receive a valid body from the idp.")
-		c.AbortWithStatus(http.StatusBadGateway)
-		return
+		logger.Warn("Did not receive a valid body from the token endpoint.")
+		return res, errNoResponseBody
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return res, getOAuthError(resp)
 	}
 
 	// decode and return
-	var res map[string]interface{}
 	err = json.NewDecoder(resp.Body).Decode(&res)
 	if err != nil {
-		logger.Warnf("Was not able to decode idp response. Err: %v", err)
-		c.AbortWithStatus(http.StatusBadGateway)
-		return
+		logger.Warnf("Was not able to decode the token response. Err: %v", err)
+		return res, err
 	}
 
-	if res == nil || res["access_token"] == nil {
-		logger.Warnf("Did not receive an access token from the idp. Resp: %v", res)
-		c.AbortWithStatus(http.StatusBadGateway)
-		return
+	if _, ok := res["access_token"].(string); !ok {
+		logger.Warnf("Did not receive an access token. Resp: %v", res)
+		return res, errNoAccessToken
 	}
+	return res, err
+}
 
-	header := Header{"Authorization", "Bearer " + res["access_token"].(string)}
-	headersList := HeadersList{header}
-
-	// Ishare tokens are defined to expire after max 30s. Thus, they should be cached for a little less time.
-	c.Header("Cache-Control", "max-age=25")
-	c.JSON(http.StatusOK, headersList)
+/**
+* Read the error from a failed token response and classify it as rejection of the client or unavailability of the token endpoint.
+ */
+func getOAuthError(resp *http.Response) (err error) {
+	oauthErr := &oauthError{}
+	decodeErr := json.NewDecoder(resp.Body).Decode(oauthErr)
+
+	switch {
+	case decodeErr == nil && clientRejectionErrors[oauthErr.Code]:
+		oauthErr.failure = errIdpRejectedClient
+	case decodeErr == nil && oauthErr.Code != "":
+		oauthErr.failure = errIdpUnavailable
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		oauthErr.Code = "status " + strconv.Itoa(resp.StatusCode)
+		oauthErr.failure = errIdpRejectedClient
+	default:
+		oauthErr.Code = "status " + strconv.Itoa(resp.StatusCode)
+		oauthErr.failure = errIdpUnavailable
+	}
+	logger.Warnf("Token request failed with status %d. Err: %v", resp.StatusCode, oauthErr)
+	return oauthErr
 }
 
 /**
@@ -236,6 +427,15 @@ func getAuthInformation(domain string, path string) (authInfo AuthInfo, err erro
 		logger.Warn("Did not receive an response body.")
 		return authInfo, errNoResponseBody
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return authInfo, errUnknownEndpoint
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warnf("The config service responded with status %d.", resp.StatusCode)
+		return authInfo, fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	}
 
 	// decode and return
 	err = json.NewDecoder(resp.Body).Decode(&authInfo)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -13,10 +14,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -47,6 +50,10 @@ func (mhc mockHttpClient) PostForm(url string, data url.Values) (response *http.
 	return mhc.mockPostResponse, mhc.mockPostError
 }
 
+func (mhc mockHttpClient) Do(req *http.Request) (response *http.Response, err error) {
+	return mhc.mockGetResponse, mhc.mockGetError
+}
+
 type mockAuthGetter struct {
 	mockAuthInfo AuthInfo
 	infoGetError error
@@ -59,7 +66,10 @@ type mockAuthGetter struct {
 func (mag mockAuthGetter) getAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
 	return mag.mockAuthInfo, mag.infoGetError
 }
-func (mag mockAuthGetter) getSigningKey(credentialsFolderPath string) (key *rsa.PrivateKey, err error) {
+func (mag mockAuthGetter) getSigningKey(credentialsFolderPath string) (key crypto.Signer, err error) {
+	if mag.mockKey == nil {
+		return nil, mag.keyGetError
+	}
 	return mag.mockKey, mag.keyGetError
 }
 func (mag mockAuthGetter) getCertificate(credentialsFolderPath string) (encodedCert string, err error) {
@@ -149,7 +159,7 @@ func TestGetSigningKey(t *testing.T) {
 
 func TestGetAuthInformation(t *testing.T) {
 
-	successfullResponse := &http.Response{Body: io.NopCloser(strings.NewReader(
+	successfullResponse := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(
 		"{\"authType\":\"iShare\"," +
 			"\"iShareIdpAddress\": \"http://my-idp\"," +
 			"\"requestGrantType\": \"client_credentials\"," +
@@ -175,8 +185,10 @@ func TestGetAuthInformation(t *testing.T) {
 		{testName: "Empty domain error", testDomain: "", testPath: "/path", expectedError: errEmptyDomain},
 		{testName: "Empty path error", testDomain: "https://test.domain", testPath: "", expectedError: errEmptyPath},
 		{testName: "Error from config service", testDomain: "https://test.domain", testPath: "/auth", mockError: mockError, expectedError: mockError},
-		{testName: "Error from config service - invalid json", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{Body: io.NopCloser(strings.NewReader("no-json"))}, expectGenericError: true},
-		{testName: "Error from config service - empty body", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{}, expectedError: errNoResponseBody},
+		{testName: "Error from config service - invalid json", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("no-json"))}, expectGenericError: true},
+		{testName: "Error from config service - empty body", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{StatusCode: 200}, expectedError: errNoResponseBody},
+		{testName: "Unknown endpoint", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{StatusCode: 404, Body: io.NopCloser(strings.NewReader(""))}, expectedError: errUnknownEndpoint},
+		{testName: "Error status from config service", testDomain: "https://test.domain", testPath: "/auth", mockResponse: &http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader("{}"))}, expectedError: errUnexpectedStatus},
 	}
 
 	for _, tc := range tests {
@@ -185,7 +197,7 @@ func TestGetAuthInformation(t *testing.T) {
 
 		authInfo, err := getAuthInformation(tc.testDomain, tc.testPath)
 
-		if authInfo != tc.expectedInfo {
+		if !reflect.DeepEqual(authInfo, tc.expectedInfo) {
 			t.Errorf(tc.testName + ": Expected auth info: " + fmt.Sprint(tc.expectedInfo) + " but got: " + fmt.Sprint(authInfo))
 		}
 		if err == nil && tc.expectGenericError {
@@ -214,34 +226,51 @@ func TestGetAuthRoute(t *testing.T) {
 		mockCertReadError error
 		mockIdpResponse   *http.Response
 		mockIdpError      error
+		verifyToken       bool
 		expectedCode      int
 		expectedHeader    string
+		expectedReason    string
 	}
 
 	validKey, _ := getValidKey()
 	validAuthInfo := AuthInfo{AuthType: "iShare", IShareIdpAddress: "http://ishare.de", RequestGrantType: "client_credentials", IShareClientID: "clientId", IShareIdpID: "idpId"}
-	accesTokenResponse := &http.Response{Body: io.NopCloser(strings.NewReader("{\"access_token\":\"myToken\"}"))}
+	accesTokenResponse := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{\"access_token\":\"myToken\"}"))}
 
 	tests := []test{
 		{testName: "Successful auth retrieval", testDomain: "test.domain", testPath: "/", mockIdpResponse: accesTokenResponse, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedHeader: "Bearer myToken", expectedCode: 200},
-		{testName: "502: No body returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
-		{testName: "502: Invalid body returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{Body: io.NopCloser(strings.NewReader("myToken"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
-		{testName: "502: Json body withou token returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{Body: io.NopCloser(strings.NewReader("{\"valid\":\"json\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
+		{testName: "502: No body returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 200}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
+		{testName: "502: Invalid body returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("myToken"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
+		{testName: "502: Json body withou token returned from idp", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{\"valid\":\"json\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 502},
 		{testName: "502: Error on config-service", testDomain: "test.domain", testPath: "/", mockAuthInfoError: errors.New("service_error"), expectedCode: 502},
-		{testName: "500: Error reading signing key", testDomain: "test.domain", testPath: "/", mockKeyReadError: errors.New("read_error"), expectedCode: 500},
-		{testName: "500: Error reading certificate", testDomain: "test.domain", testPath: "/", mockCertReadError: errors.New("read_error"), expectedCode: 500},
+		{testName: "404: Unknown endpoint", testDomain: "test.domain", testPath: "/", mockAuthInfoError: errUnknownEndpoint, expectedCode: 404, expectedReason: reasonUnknownEndpoint},
+		{testName: "502: Auth info without client", testDomain: "test.domain", testPath: "/", mockAuthInfo: AuthInfo{IShareIdpAddress: "http://ishare.de"}, expectedCode: 502, expectedReason: reasonInvalidAuthInfo},
+		{testName: "502: Auth info with a client outside of the credentials folder", testDomain: "test.domain", testPath: "/", mockAuthInfo: AuthInfo{IShareClientID: "../other", IShareIdpID: "idpId", IShareIdpAddress: "http://ishare.de"}, expectedCode: 502, expectedReason: reasonInvalidAuthInfo},
+		{testName: "502: Auth info with a hidden client", testDomain: "test.domain", testPath: "/", mockAuthInfo: AuthInfo{IShareClientID: ".hidden", IShareIdpID: "idpId", IShareIdpAddress: "http://ishare.de"}, expectedCode: 502, expectedReason: reasonInvalidAuthInfo},
+		{testName: "500: Error reading signing key", testDomain: "test.domain", testPath: "/", mockAuthInfo: validAuthInfo, mockKeyReadError: errors.New("read_error"), expectedCode: 500, expectedReason: reasonInvalidCredentials},
+		{testName: "500: Error reading certificate", testDomain: "test.domain", testPath: "/", mockAuthInfo: validAuthInfo, mockKey: validKey, mockCertReadError: errors.New("read_error"), expectedCode: 500, expectedReason: reasonInvalidCredentials},
+		{testName: "500: Missing credentials", testDomain: "test.domain", testPath: "/", mockAuthInfo: validAuthInfo, mockKeyReadError: fs.ErrNotExist, expectedCode: 500, expectedReason: reasonMissingCredentials},
+		{testName: "403: Idp rejected the client", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("{\"error\":\"invalid_client\",\"error_description\":\"unknown client\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 403, expectedReason: reasonIdpRejectedClient},
+		{testName: "403: Idp responded unauthorized without body", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 401, Body: io.NopCloser(strings.NewReader(""))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 403, expectedReason: reasonIdpRejectedClient},
+		{testName: "503: Idp temporarily unavailable", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader("{\"error\":\"temporarily_unavailable\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 503, expectedReason: reasonIdpUnavailable},
+		{testName: "503: Idp server error", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader("<html></html>"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 503, expectedReason: reasonIdpUnavailable},
+		{testName: "503: Idp not reachable", testDomain: "test.domain", testPath: "/", mockIdpError: errors.New("connection_refused"), mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 503, expectedReason: reasonIdpUnavailable},
 		{testName: "500: Signing error - nil key", testDomain: "test.domain", testPath: "/", mockIdpResponse: accesTokenResponse, mockCert: "cert", mockAuthInfo: validAuthInfo, expectedCode: 500},
 		{testName: "400: Empty path received", testDomain: "test.domain", testPath: "", expectedCode: 400},
+		{testName: "Successful auth retrieval with verification", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{\"access_token\":\"myToken\",\"token_type\":\"Bearer\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, verifyToken: true, expectedHeader: "Bearer myToken", expectedCode: 200},
+		{testName: "502: Token verification failed", testDomain: "test.domain", testPath: "/", mockIdpResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{\"access_token\":\"myToken\"}"))}, mockKey: validKey, mockCert: "cert", mockAuthInfo: validAuthInfo, verifyToken: true, expectedCode: 502},
 	}
+	defer func() { tokenVerificationEnabled = false }()
 
 	for _, tc := range tests {
 		log.Info("TestGetAuth +++++++++++++++++++++ Running test: " + tc.testName)
 		recorder = httptest.NewRecorder()
 		ginContext, _ = gin.CreateTestContext(recorder)
-		ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/auth?domain="+tc.testDomain+"&path="+tc.testPath, nil)
+		ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/ISHARE/auth?domain="+tc.testDomain+"&path="+tc.testPath, nil)
+		ginContext.Params = gin.Params{{Key: "authType", Value: "ISHARE"}}
 
 		globalHttpClient = &mockHttpClient{mockPostResponse: tc.mockIdpResponse, mockPostError: tc.mockIdpError}
 		authGetter = &mockAuthGetter{mockKey: tc.mockKey, mockCert: tc.mockCert, mockAuthInfo: tc.mockAuthInfo, infoGetError: tc.mockAuthInfoError, keyGetError: tc.mockKeyReadError, certGetError: tc.mockCertReadError}
+		tokenVerificationEnabled = tc.verifyToken
 
 		getAuth(ginContext)
 
@@ -249,6 +278,20 @@ func TestGetAuthRoute(t *testing.T) {
 			t.Errorf(tc.testName + ": Did not receive the correct code. Expected: " + fmt.Sprint(tc.expectedCode) + " Actual: " + fmt.Sprint(recorder.Code))
 		}
 
+		if tc.expectedReason != "" {
+			var errResponse problem
+			json.NewDecoder(recorder.Body).Decode(&errResponse)
+			if errResponse.Type != problemTypeBase+tc.expectedReason || errResponse.Reason != tc.expectedReason {
+				t.Errorf(tc.testName + ": Did not receive the correct problem type. Expected: " + tc.expectedReason + " Actual: " + errResponse.Type)
+			}
+			if errResponse.Status != tc.expectedCode || errResponse.Title == "" || errResponse.Domain != tc.testDomain || errResponse.Path != tc.testPath {
+				t.Errorf(tc.testName + ": Did not receive a complete problem. Was: " + fmt.Sprint(errResponse))
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != problemContentType {
+				t.Errorf(tc.testName + ": Expected a problem content type, but was " + contentType)
+			}
+		}
+
 		if tc.expectedHeader != "" && recorder.Body == nil {
 			t.Errorf(tc.testName + ": Did receive a nil body.")
 		}
@@ -295,3 +338,99 @@ func getPemEncoded(cert string) []byte {
 	}
 	return pem.EncodeToMemory(certBlock)
 }
+
+func TestValidateOverrides(t *testing.T) {
+
+	type test struct {
+		testName      string
+		authInfo      AuthInfo
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "No overrides.", authInfo: AuthInfo{}},
+		{testName: "Allowed parameters and claims.", authInfo: AuthInfo{AdditionalParameters: map[string]string{"resource": "https://my-api", "audience": "my-api"}, AdditionalClaims: map[string]interface{}{"client_name": "test"}}},
+		{testName: "Overwrite client_id.", authInfo: AuthInfo{AdditionalParameters: map[string]string{"client_id": "other"}}, expectedError: errDisallowedParameter},
+		{testName: "Overwrite scope.", authInfo: AuthInfo{AdditionalParameters: map[string]string{"scope": "other"}}, expectedError: errDisallowedParameter},
+		{testName: "Unknown parameter.", authInfo: AuthInfo{AdditionalParameters: map[string]string{"something": "value"}}, expectedError: errDisallowedParameter},
+		{testName: "Overwrite iss.", authInfo: AuthInfo{AdditionalClaims: map[string]interface{}{"iss": "other"}}, expectedError: errDisallowedClaim},
+		{testName: "Overwrite sub.", authInfo: AuthInfo{AdditionalClaims: map[string]interface{}{"sub": "other"}}, expectedError: errDisallowedClaim},
+		{testName: "Overwrite exp.", authInfo: AuthInfo{AdditionalClaims: map[string]interface{}{"exp": 0}}, expectedError: errDisallowedClaim},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestValidateOverrides +++++++++++++++++++++ Running test: " + tc.testName)
+
+		err := validateOverrides(tc.authInfo)
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+	}
+}
+
+func TestGetAuthRouteOverrides(t *testing.T) {
+
+	type test struct {
+		testName       string
+		authInfo       AuthInfo
+		expectedCode   int
+		expectedForm   map[string]string
+		expectedClaims map[string]interface{}
+	}
+
+	validKey, _ := getValidKey()
+	tests := []test{
+		{testName: "Default scope.", authInfo: AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId", RequestGrantType: "client_credentials"}, expectedCode: 200,
+			expectedForm: map[string]string{"scope": "iSHARE", "grant_type": "client_credentials", "client_id": "clientId"}, expectedClaims: map[string]interface{}{"iss": "clientId", "sub": "clientId", "aud": "idpId"}},
+		{testName: "Configured scope, parameters and claims.", authInfo: AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId", RequestGrantType: "client_credentials", Scope: "iSHARE openid", AdditionalParameters: map[string]string{"resource": "https://my-api"}, AdditionalClaims: map[string]interface{}{"tenant": "myTenant"}}, expectedCode: 200,
+			expectedForm: map[string]string{"scope": "iSHARE openid", "resource": "https://my-api", "client_id": "clientId"}, expectedClaims: map[string]interface{}{"iss": "clientId", "tenant": "myTenant"}},
+		{testName: "502: Disallowed claim.", authInfo: AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId", AdditionalClaims: map[string]interface{}{"iss": "other"}}, expectedCode: 502},
+		{testName: "502: Disallowed parameter.", authInfo: AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId", AdditionalParameters: map[string]string{"client_assertion": "other"}}, expectedCode: 502},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetAuthRouteOverrides +++++++++++++++++++++ Running test: " + tc.testName)
+
+		var receivedForm url.Values
+		idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			receivedForm = r.PostForm
+			w.Write([]byte(`{"access_token":"myToken","token_type":"Bearer"}`))
+		}))
+		authInfo := tc.authInfo
+		authInfo.IShareIdpAddress = idp.URL
+
+		globalHttpClient = &http.Client{}
+		authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert", mockAuthInfo: authInfo}
+		recorder := httptest.NewRecorder()
+		ginContext, _ := gin.CreateTestContext(recorder)
+		ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/ISHARE/auth?domain=test.domain&path=/", nil)
+		ginContext.Params = gin.Params{{Key: "authType", Value: "ISHARE"}}
+
+		getAuth(ginContext)
+		idp.Close()
+
+		if recorder.Code != tc.expectedCode {
+			t.Errorf(tc.testName + ": Did not receive the correct code. Expected: " + fmt.Sprint(tc.expectedCode) + " Actual: " + fmt.Sprint(recorder.Code))
+			continue
+		}
+		if tc.expectedCode != 200 {
+			if receivedForm != nil {
+				t.Errorf(tc.testName + ": The idp should not have been called.")
+			}
+			continue
+		}
+		for name, value := range tc.expectedForm {
+			if receivedForm.Get(name) != value {
+				t.Errorf(tc.testName + ": Expected form parameter " + name + "=" + value + " but was " + receivedForm.Get(name))
+			}
+		}
+		claims := jwt.MapClaims{}
+		new(jwt.Parser).ParseUnverified(receivedForm.Get("client_assertion"), claims)
+		for name, value := range tc.expectedClaims {
+			if claims[name] != value {
+				t.Errorf(tc.testName + ": Expected claim " + name + "=" + fmt.Sprint(value) + " but was " + fmt.Sprint(claims[name]))
+			}
+		}
+	}
+}
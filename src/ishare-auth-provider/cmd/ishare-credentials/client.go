@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"ishare-auth-provider/model"
+)
+
+var errRequestFailed = errors.New("request_failed")
+
+/**
+* Client for the credentials management api of the auth-provider.
+ */
+type credentialsClient struct {
+	baseUrl    string
+	httpClient *http.Client
+}
+
+func newCredentialsClient(baseUrl string) *credentialsClient {
+	return &credentialsClient{baseUrl: strings.TrimSuffix(baseUrl, "/"), httpClient: &http.Client{}}
+}
+
+func (cc *credentialsClient) list() (clientIds []string, err error) {
+	body, err := cc.do(http.MethodGet, "/credentials", "", nil, http.StatusOK)
+	if err != nil {
+		return clientIds, err
+	}
+	err = json.Unmarshal(body, &clientIds)
+	return clientIds, err
+}
+
+func (cc *credentialsClient) show(clientId string) (credentials model.Credentials, err error) {
+	body, err := cc.do(http.MethodGet, "/credentials/"+url.PathEscape(clientId), "", nil, http.StatusOK)
+	if err != nil {
+		return credentials, err
+	}
+	err = json.Unmarshal(body, &credentials)
+	return credentials, err
+}
+
+func (cc *credentialsClient) add(clientId string, credentials model.Credentials) (err error) {
+	content, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	_, err = cc.do(http.MethodPost, "/credentials/"+url.PathEscape(clientId), "application/json", content, http.StatusCreated)
+	return err
+}
+
+func (cc *credentialsClient) replaceCertificateChain(clientId string, certChain string) (err error) {
+	_, err = cc.do(http.MethodPut, "/credentials/"+url.PathEscape(clientId)+"/certificateChain", "text/plain", []byte(certChain), http.StatusNoContent)
+	return err
+}
+
+func (cc *credentialsClient) replaceSigningKey(clientId string, key string) (err error) {
+	_, err = cc.do(http.MethodPut, "/credentials/"+url.PathEscape(clientId)+"/signingKey", "text/plain", []byte(key), http.StatusNoContent)
+	return err
+}
+
+/**
+* Let the provider generate a key for the client, the pem encoded csr for it is returned.
+ */
+func (cc *credentialsClient) createCsr(clientId string, csrRequest model.CsrRequest) (csr string, err error) {
+	content, err := json.Marshal(csrRequest)
+	if err != nil {
+		return csr, err
+	}
+	body, err := cc.do(http.MethodPost, "/credentials/"+url.PathEscape(clientId)+"/csr", "application/json", content, http.StatusCreated)
+	return string(body), err
+}
+
+func (cc *credentialsClient) activateCertificateChain(clientId string, certChain string) (err error) {
+	_, err = cc.do(http.MethodPut, "/credentials/"+url.PathEscape(clientId)+"/csr/certificateChain", "text/plain", []byte(certChain), http.StatusNoContent)
+	return err
+}
+
+func (cc *credentialsClient) delete(clientId string) (err error) {
+	_, err = cc.do(http.MethodDelete, "/credentials/"+url.PathEscape(clientId), "", nil, http.StatusNoContent)
+	return err
+}
+
+/**
+* Request the auth headers for the given endpoint, the provider does the full token request with the configured credentials.
+ */
+func (cc *credentialsClient) testAuth(domain string, path string) (headers model.HeadersList, err error) {
+	query := url.Values{"domain": {domain}, "path": {path}}
+	body, err := cc.do(http.MethodGet, "/ISHARE/auth?"+query.Encode(), "", nil, http.StatusOK)
+	if err != nil {
+		return headers, err
+	}
+	err = json.Unmarshal(body, &headers)
+	return headers, err
+}
+
+func (cc *credentialsClient) do(method string, path string, contentType string, content []byte, expectedStatus int) (body []byte, err error) {
+	request, err := http.NewRequest(method, cc.baseUrl+path, bytes.NewReader(content))
+	if err != nil {
+		return body, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := cc.httpClient.Do(request)
+	if err != nil {
+		return body, err
+	}
+	defer response.Body.Close()
+
+	body, err = io.ReadAll(response.Body)
+	if err != nil {
+		return body, err
+	}
+	if response.StatusCode != expectedStatus {
+		return body, fmt.Errorf("%w: %s", errRequestFailed, describeFailure(response.StatusCode, body))
+	}
+	return body, err
+}
+
+/**
+* Describe the failure, using the problem returned by the provider if available.
+ */
+func describeFailure(status int, body []byte) string {
+	var problem model.Problem
+	if json.Unmarshal(body, &problem) == nil && problem.Reason != "" {
+		return fmt.Sprintf("%d %s - %s", status, problem.Reason, problem.Detail)
+	}
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return fmt.Sprintf("%d - %s", status, message)
+	}
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+var errNoCertificate = errors.New("no_certificate")
+var errNoKey = errors.New("no_key")
+var errUnsupportedKey = errors.New("unsupported_key")
+var errKeyMismatch = errors.New("key_mismatch")
+
+/**
+* Read the certificate chain and the signing key from pem files. The key is converted to pkcs8, as required by the provider.
+ */
+func loadPem(certFile string, keyFile string) (certChain string, key string, err error) {
+	if certFile != "" {
+		certChain, err = loadCertificateChain(certFile)
+		if err != nil {
+			return certChain, key, err
+		}
+	}
+	if keyFile != "" {
+		key, err = loadSigningKey(keyFile)
+	}
+	return certChain, key, err
+}
+
+/**
+* Read the certificate chain from a pem file, only the certificates are kept.
+ */
+func loadCertificateChain(certFile string) (certChain string, err error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return certChain, err
+	}
+	var chain bytes.Buffer
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes})
+		}
+	}
+	if chain.Len() == 0 {
+		return certChain, fmt.Errorf("%w: %s", errNoCertificate, certFile)
+	}
+	return chain.String(), err
+}
+
+/**
+* Read the signing key from a pem file, either in pkcs1 or pkcs8 format.
+ */
+func loadSigningKey(keyFile string) (key string, err error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return key, err
+	}
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return key, err
+			}
+			return encodePkcs8(rsaKey)
+		case "PRIVATE KEY":
+			parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return key, err
+			}
+			return encodePkcs8(parsedKey)
+		}
+	}
+	return key, fmt.Errorf("%w: %s", errNoKey, keyFile)
+}
+
+/**
+* Read the certificate chain and the signing key from a pkcs12 file.
+ */
+func loadPkcs12(pkcs12File string, password string) (certChain string, key string, err error) {
+	content, err := os.ReadFile(pkcs12File)
+	if err != nil {
+		return certChain, key, err
+	}
+	blocks, err := pkcs12.ToPEM(content, password)
+	if err != nil {
+		return certChain, key, err
+	}
+
+	var certificates []*x509.Certificate
+	var signingKey *rsa.PrivateKey
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return certChain, key, err
+			}
+			certificates = append(certificates, certificate)
+		case "PRIVATE KEY":
+			// the pkcs12 library returns rsa keys in pkcs1
+			signingKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return certChain, key, fmt.Errorf("%w: only rsa keys are supported", errUnsupportedKey)
+			}
+		}
+	}
+	if len(certificates) == 0 {
+		return certChain, key, fmt.Errorf("%w: %s", errNoCertificate, pkcs12File)
+	}
+	if signingKey == nil {
+		return certChain, key, fmt.Errorf("%w: %s", errNoKey, pkcs12File)
+	}
+	certificates, err = orderCertificateChain(certificates, &signingKey.PublicKey)
+	if err != nil {
+		return certChain, key, fmt.Errorf("%w: %s", err, pkcs12File)
+	}
+	key, err = encodePkcs8(signingKey)
+	if err != nil {
+		return certChain, key, err
+	}
+
+	var chain bytes.Buffer
+	for _, certificate := range certificates {
+		pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	}
+	return chain.String(), key, err
+}
+
+/**
+* Pkcs12 files do not define an order of their certificates. The provider expects the certificate of the signing key first, thus it
+* is moved to the front, the rest of the chain keeps its order.
+ */
+func orderCertificateChain(certificates []*x509.Certificate, publicKey *rsa.PublicKey) (ordered []*x509.Certificate, err error) {
+	for i, certificate := range certificates {
+		if certificatePublicKey, ok := certificate.PublicKey.(*rsa.PublicKey); ok && publicKey.Equal(certificatePublicKey) {
+			ordered = append(ordered, certificate)
+			ordered = append(ordered, certificates[:i]...)
+			return append(ordered, certificates[i+1:]...), err
+		}
+	}
+	return ordered, fmt.Errorf("%w: no certificate matches the key", errKeyMismatch)
+}
+
+func encodePkcs8(key interface{}) (encoded string, err error) {
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		return encoded, fmt.Errorf("%w: only rsa keys are supported", errUnsupportedKey)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return encoded, err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), err
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ishare-auth-provider/model"
+)
+
+/**
+* Address of the auth-provider, if not given via -url.
+ */
+const defaultProviderUrl = "http://localhost:8080"
+
+var errUsage = errors.New("usage")
+
+const usage = `Usage: ishare-credentials [-url <provider-url>] <command> [arguments]
+
+Manage the iShare client credentials of an auth-provider.
+
+Commands:
+  list                                        List all clients with credentials.
+  show <clientId>                             Show the certificate chain and pkcs11 reference of a client.
+  add <clientId> -cert <file> -key <file>     Add credentials from pem files.
+  add <clientId> -pkcs12 <file> [-password <password>]
+                                              Add credentials from a pkcs12 file.
+  replace-cert <clientId> -cert <file>        Replace the certificate chain of a client.
+  replace-key <clientId> -key <file>          Replace the signing key of a client.
+  delete <clientId>                           Delete the credentials of a client.
+  csr <clientId> -cn <commonName> [-serial <eori>] [-o <organization>] [-c <country>] [-key-size <bits>] [-out <file>]
+                                              Generate a key inside the provider and print a csr for it.
+  activate <clientId> -cert <file>            Activate the certificate chain issued for the csr.
+  test -domain <domain> -path <path>          Request a token for the endpoint, without calling it.
+
+The provider url can also be set via ISHARE_AUTH_PROVIDER_URL.
+`
+
+/**
+* Admin cli for the credentials management api of the iShare auth-provider.
+ */
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("ishare-credentials", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	providerUrl := flags.String("url", getEnv("ISHARE_AUTH_PROVIDER_URL", defaultProviderUrl), "Address of the auth-provider.")
+	if err = flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	client := newCredentialsClient(*providerUrl)
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "list":
+		return listCommand(client, out)
+	case "show":
+		return showCommand(client, commandArgs, out)
+	case "add":
+		return addCommand(client, commandArgs, out)
+	case "replace-cert":
+		return replaceCertCommand(client, commandArgs, out)
+	case "replace-key":
+		return replaceKeyCommand(client, commandArgs, out)
+	case "delete":
+		return deleteCommand(client, commandArgs, out)
+	case "csr":
+		return csrCommand(client, commandArgs, out)
+	case "activate":
+		return activateCommand(client, commandArgs, out)
+	case "test":
+		return testCommand(client, commandArgs, out)
+	default:
+		fmt.Fprintf(flags.Output(), "Unknown command %s.\n\n", command)
+		flags.Usage()
+		return errUsage
+	}
+}
+
+func listCommand(client *credentialsClient, out io.Writer) error {
+	clientIds, err := client.list()
+	if err != nil {
+		return err
+	}
+	for _, clientId := range clientIds {
+		fmt.Fprintln(out, clientId)
+	}
+	return nil
+}
+
+func showCommand(client *credentialsClient, args []string, out io.Writer) error {
+	clientId, err := parseClientCommand("show", args)
+	if err != nil {
+		return err
+	}
+	credentials, err := client.show(clientId)
+	if err != nil {
+		return err
+	}
+	return printJson(out, credentials)
+}
+
+func addCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("add")
+	certFile := flags.String("cert", "", "Pem file containing the certificate chain.")
+	keyFile := flags.String("key", "", "Pem file containing the rsa signing key.")
+	pkcs12File := flags.String("pkcs12", "", "Pkcs12 file containing certificate chain and signing key.")
+	password := flags.String("password", "", "Password of the pkcs12 file.")
+	clientId, err := parseClientCommandFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	var credentials model.Credentials
+	switch {
+	case *pkcs12File != "" && *certFile == "" && *keyFile == "":
+		credentials.CertificateChain, credentials.SigningKey, err = loadPkcs12(*pkcs12File, *password)
+	case *pkcs12File == "" && *certFile != "" && *keyFile != "":
+		credentials.CertificateChain, credentials.SigningKey, err = loadPem(*certFile, *keyFile)
+	default:
+		fmt.Fprintln(flags.Output(), "Either -cert and -key or -pkcs12 have to be provided.")
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = client.add(clientId, credentials); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Added credentials for %s.\n", clientId)
+	return nil
+}
+
+func replaceCertCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("replace-cert")
+	certFile := flags.String("cert", "", "Pem file containing the certificate chain.")
+	clientId, err := parseClientCommandFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *certFile == "" {
+		fmt.Fprintln(flags.Output(), "No -cert was provided.")
+		return errUsage
+	}
+
+	certChain, err := loadCertificateChain(*certFile)
+	if err != nil {
+		return err
+	}
+	if err = client.replaceCertificateChain(clientId, certChain); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Replaced the certificate chain of %s.\n", clientId)
+	return nil
+}
+
+func replaceKeyCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("replace-key")
+	keyFile := flags.String("key", "", "Pem file containing the rsa signing key.")
+	clientId, err := parseClientCommandFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		fmt.Fprintln(flags.Output(), "No -key was provided.")
+		return errUsage
+	}
+
+	key, err := loadSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	if err = client.replaceSigningKey(clientId, key); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Replaced the signing key of %s.\n", clientId)
+	return nil
+}
+
+func deleteCommand(client *credentialsClient, args []string, out io.Writer) error {
+	clientId, err := parseClientCommand("delete", args)
+	if err != nil {
+		return err
+	}
+	if err = client.delete(clientId); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted the credentials of %s.\n", clientId)
+	return nil
+}
+
+/**
+* Let the provider generate a key and print the csr for it, or write it to the given file. The key is never transferred.
+ */
+func csrCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("csr")
+	commonName := flags.String("cn", "", "Common name of the subject.")
+	serialNumber := flags.String("serial", "", "Serial number of the subject, defaults to the clientId.")
+	organization := flags.String("o", "", "Organization of the subject.")
+	country := flags.String("c", "", "Two-letter country code of the subject.")
+	keySize := flags.Int("key-size", 0, "Size of the generated rsa key in bits.")
+	outFile := flags.String("out", "", "File to write the csr to.")
+	clientId, err := parseClientCommandFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *commonName == "" {
+		fmt.Fprintln(flags.Output(), "No -cn was provided.")
+		return errUsage
+	}
+
+	csrRequest := model.CsrRequest{
+		Subject: model.CsrSubject{CommonName: *commonName, SerialNumber: *serialNumber, Organization: *organization, Country: *country},
+		KeySize: *keySize,
+	}
+	csr, err := client.createCsr(clientId, csrRequest)
+	if err != nil {
+		return err
+	}
+	if *outFile == "" {
+		fmt.Fprint(out, csr)
+		return nil
+	}
+	if err = os.WriteFile(*outFile, []byte(csr), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote the csr of %s to %s.\n", clientId, *outFile)
+	return nil
+}
+
+func activateCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("activate")
+	certFile := flags.String("cert", "", "Pem file containing the certificate chain issued for the csr.")
+	clientId, err := parseClientCommandFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *certFile == "" {
+		fmt.Fprintln(flags.Output(), "No -cert was provided.")
+		return errUsage
+	}
+
+	certChain, err := loadCertificateChain(*certFile)
+	if err != nil {
+		return err
+	}
+	if err = client.activateCertificateChain(clientId, certChain); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Activated the certificate chain of %s.\n", clientId)
+	return nil
+}
+
+/**
+* Let the provider request a token for the endpoint. The endpoint itself is not called and the token is not printed.
+ */
+func testCommand(client *credentialsClient, args []string, out io.Writer) error {
+	flags := newCommandFlags("test")
+	domain := flags.String("domain", "", "Domain of the endpoint.")
+	path := flags.String("path", "/", "Path of the endpoint.")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *domain == "" {
+		fmt.Fprintln(flags.Output(), "No -domain was provided.")
+		return errUsage
+	}
+
+	headers, err := client.testAuth(*domain, *path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Successfully retrieved auth for %s%s:\n", *domain, *path)
+	for _, header := range headers {
+		fmt.Fprintf(out, "  %s: %s\n", header.Name, maskToken(header.Value))
+	}
+	return nil
+}
+
+func newCommandFlags(command string) *flag.FlagSet {
+	return flag.NewFlagSet(command, flag.ContinueOnError)
+}
+
+/**
+* Parse commands that only take the clientId.
+ */
+func parseClientCommand(command string, args []string) (clientId string, err error) {
+	return parseClientCommandFlags(newCommandFlags(command), args)
+}
+
+/**
+* Parse the clientId, followed by the command flags.
+ */
+func parseClientCommandFlags(flags *flag.FlagSet, args []string) (clientId string, err error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(flags.Output(), "No clientId was provided to %s.\n", flags.Name())
+		return clientId, errUsage
+	}
+	if err = flags.Parse(args[1:]); err != nil {
+		return clientId, errUsage
+	}
+	return args[0], err
+}
+
+/**
+* Only show the beginning of tokens, to not leak them into terminals and logs.
+ */
+func maskToken(value string) string {
+	scheme, token := "", value
+	if parts := strings.SplitN(value, " ", 2); len(parts) == 2 {
+		scheme, token = parts[0]+" ", parts[1]
+	}
+	if len(token) > 12 {
+		token = token[:12] + "..."
+	}
+	return scheme + token
+}
+
+func printJson(out io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func getEnv(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
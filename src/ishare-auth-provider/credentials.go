@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"os"
 
 	"github.com/gin-gonic/gin"
+
+	"ishare-auth-provider/model"
 )
 
 type CredentialsType int
@@ -15,28 +18,42 @@ type CredentialsType int
 const (
 	certificateChain CredentialsType = iota
 	signingKey       CredentialsType = iota
+	pkcs11Reference  CredentialsType = iota
 )
 
-type Credentials struct {
-	CertificateChain string `json:"certificateChain"`
-	SigningKey       string `json:"signingKey"`
+/**
+* Credentials of a client, shared with the admin cli.
+ */
+type Credentials = model.Credentials
+
+/**
+* Client ids are used as folder names, thus cannot point outside of the credentials folder.
+ */
+func requireValidClientId(c *gin.Context) {
+	if clientId, ok := c.Params.Get("clientId"); ok && !isValidClientId(clientId) {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The client id "+clientId+" is not allowed.")
+	}
 }
 
 // route implementations
 func getCredentialsList(c *gin.Context) {
 
+	audit := startAudit(auditListCredentials, "")
+	defer audit.log(c)
+
 	folders, err := globalFolderAccessor.get(credentialsBaseFolder)
 
 	if err != nil {
 		logger.Warn("Was not able to read credentials folder.", err)
-		c.String(http.StatusInternalServerError, "Was not able to read credentials folder.")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the credentials folder.")
 		return
 	}
 
 	credentialsList := []string{}
 
 	for _, folder := range folders {
-		if folder.IsDir() {
+		if folder.IsDir() && !isStagingFolder(folder.Name()) {
 			credentialsList = append(credentialsList, folder.Name())
 		}
 	}
@@ -44,45 +61,106 @@ func getCredentialsList(c *gin.Context) {
 	c.JSON(http.StatusOK, credentialsList)
 }
 
+/**
+* Return the certificate chain and pkcs11 reference of a client. The signing key never leaves the provider.
+ */
+func getCredentials(c *gin.Context) {
+	clientId := c.Param("clientId")
+	credentialsFolderPath := buildCredentialsFolderPath(clientId)
+
+	audit := startAudit(auditShowCredentials, clientId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(credentialsFolderPath); errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No credentials for "+clientId+" exist.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No credentials exist for client "+clientId+".")
+		return
+	}
+
+	// clients created through a csr have no certificate chain until it is activated
+	_, pendingKeyErr := globalFileAccessor.read(credentialsFolderPath + pendingKeyFile)
+	certChain, err := globalFileAccessor.read(credentialsFolderPath + certChainFile)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && pendingKeyErr == nil) {
+		logger.Warn("Was not able to read the certificate of: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the credentials of "+clientId+".")
+		return
+	}
+	credentials := Credentials{CertificateChain: string(certChain), PendingKey: pendingKeyErr == nil}
+
+	pkcs11Config, err := globalFileAccessor.read(credentialsFolderPath + pkcs11File)
+	if err == nil {
+		config, err := parsePkcs11Config(pkcs11Config)
+		if err != nil {
+			logger.Warn("Was not able to read the pkcs11 reference of: "+clientId, err)
+			audit.err = err
+			abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the credentials of "+clientId+".")
+			return
+		}
+		credentials.Pkcs11 = &config
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
 func postCredentials(c *gin.Context) {
 
+	audit := startAudit(auditCreateCredentials, c.Param("clientId"))
+	defer audit.log(c)
+
 	c.SetAccepted("application/json")
 	var credentials Credentials
-	err := c.BindJSON(&credentials)
+	err := c.ShouldBindJSON(&credentials)
 	if err != nil {
 		logger.Warn("Was not able to read credentials to json.")
-		c.AbortWithStatus(http.StatusBadRequest)
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the credentials: "+err.Error())
 		return
 	}
 
 	clientId := c.Param("clientId")
 	if clientId == "" {
 		logger.Warn("No clientId present.")
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "No clientId was provided.")
 		return
 	}
 
+	// keys inside a pkcs11 token are referenced instead of stored
+	keyFile, keyContent := keyfile, []byte(credentials.SigningKey)
+	if credentials.Pkcs11 != nil {
+		if err := validatePkcs11Config(*credentials.Pkcs11); err != nil {
+			logger.Warn("Received an invalid pkcs11 reference. ", err)
+			audit.err = err
+			abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, err.Error())
+			return
+		}
+		keyFile = pkcs11File
+		keyContent, _ = json.Marshal(credentials.Pkcs11)
+	}
+
 	// the files are stored in folders namend by the clientId
 	credentialsFolderPath := buildCredentialsFolderPath(clientId)
 
 	// on post, we dont allow override
 	if _, err := diskFs.Stat(credentialsFolderPath); err == nil {
 		logger.Warn("Credentials for " + clientId + " already exist.")
-		c.AbortWithStatus(http.StatusConflict)
+		abortWithProblem(c, http.StatusConflict, reasonCredentialsExist, "Credentials for "+clientId+" already exist.")
 		return
 	}
 
 	err = diskFs.MkdirAll(credentialsFolderPath, os.ModePerm)
 	if err != nil {
 		logger.Warn("Was not able to create folder: "+credentialsFolderPath, err)
-		c.String(http.StatusInternalServerError, "Was not able to store the credentials.")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the credentials of "+clientId+".")
 		return
 	}
 
-	err = globalFileAccessor.write(credentialsFolderPath+keyfile, []byte(credentials.SigningKey), 0666)
+	err = globalFileAccessor.write(credentialsFolderPath+keyFile, keyContent, 0666)
 	if err != nil {
 		logger.Warn("Was not able to store signingKey for: "+clientId, err)
-		c.String(http.StatusInternalServerError, "Was not able to store the credentials.")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the credentials of "+clientId+".")
 		diskFs.RemoveAll(credentialsFolderPath)
 		return
 	}
@@ -90,7 +168,8 @@ func postCredentials(c *gin.Context) {
 	err = globalFileAccessor.write(credentialsFolderPath+certChainFile, []byte(credentials.CertificateChain), 0666)
 	if err != nil {
 		logger.Warn("Was not able to store certificate for: "+clientId, err)
-		c.String(http.StatusInternalServerError, "Was not able to store the credentials.")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the credentials of "+clientId+".")
 		diskFs.RemoveAll(credentialsFolderPath)
 		return
 	}
@@ -106,41 +185,85 @@ func putSigningKey(c *gin.Context) {
 	storeCredential(c, signingKey)
 }
 
+func putPkcs11Reference(c *gin.Context) {
+	storeCredential(c, pkcs11Reference)
+}
+
+/**
+* Remove the pkcs11 reference, the client falls back to its key file.
+ */
+func deletePkcs11Reference(c *gin.Context) {
+	clientId := c.Param("clientId")
+	credentialsFolderPath := buildCredentialsFolderPath(clientId)
+
+	audit := startAudit(auditDeletePkcs11Reference, clientId)
+	defer audit.log(c)
+
+	_, err := diskFs.Stat(credentialsFolderPath + pkcs11File)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No pkcs11 reference for "+clientId+" exists.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownPkcs11Reference, "No pkcs11 reference exists for client "+clientId+".")
+		return
+	}
+
+	releasePkcs11Reference(credentialsFolderPath)
+	err = diskFs.RemoveAll(credentialsFolderPath + pkcs11File)
+	if err != nil {
+		logger.Warn("Was not able to delete the pkcs11 reference for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the pkcs11 reference of "+clientId+".")
+		return
+	}
+	jwtBearerCache.invalidate(clientId)
+	parsedCredentials.invalidate(clientId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
 func deleteCredentials(c *gin.Context) {
 	clientId := c.Param("clientId")
 	// the files are stored in folders namend by the clientId
 	credentialsFolderPath := buildCredentialsFolderPath(clientId)
 
+	audit := startAudit(auditDeleteCredentials, clientId)
+	defer audit.log(c)
+
 	_, err := diskFs.Stat(credentialsFolderPath)
 
 	if errors.Is(err, os.ErrNotExist) {
 		logger.Warn("No credentials for "+clientId+" exist.", err)
-		c.String(http.StatusNotFound, "No such client exists.")
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No credentials exist for client "+clientId+".")
 		return
 	}
 
+	releasePkcs11Reference(credentialsFolderPath)
 	err = diskFs.RemoveAll(credentialsFolderPath)
 	if err != nil {
 		logger.Warn("Was not able to delete the credentials for: "+clientId, err)
-		c.String(http.StatusInternalServerError, "Was not able to delete credentials.")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the credentials of "+clientId+".")
 		return
 	}
+	jwtBearerCache.invalidate(clientId)
+	parsedCredentials.invalidate(clientId)
 	c.AbortWithStatus(http.StatusNoContent)
 }
 
 func storeCredential(c *gin.Context, credentialsType CredentialsType) {
+	audit := startAudit(credentialsType.auditOperation(), c.Param("clientId"))
+	defer audit.log(c)
+
 	c.SetAccepted("text/plain")
 	clientId := c.Param("clientId")
 	if clientId == "" {
 		logger.Warn("No clientId present.")
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "No clientId was provided.")
 		return
 	}
 
 	credential, err := io.ReadAll(c.Request.Body)
 	if err != nil || bytes.Equal([]byte(credential), []byte{}) {
 		logger.Warn("Was not able to read the request body.", err)
-		c.String(http.StatusBadRequest, "Was not able to read the body.")
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the body.")
 		return
 	}
 
@@ -151,7 +274,7 @@ func storeCredential(c *gin.Context, credentialsType CredentialsType) {
 
 	if errors.Is(err, os.ErrNotExist) {
 		logger.Warn("No credentials for "+clientId+" exist.", err)
-		c.String(http.StatusNotFound, "No such client exists.")
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No credentials exist for client "+clientId+".")
 		return
 	}
 
@@ -160,6 +283,16 @@ func storeCredential(c *gin.Context, credentialsType CredentialsType) {
 	if credentialsType == certificateChain {
 		filePath = credentialsFolderPath + certChainFile
 		errorMsg = "certrificate"
+	} else if credentialsType == pkcs11Reference {
+		if _, err := parsePkcs11Config(credential); err != nil {
+			logger.Warn("Received an invalid pkcs11 reference. ", err)
+			audit.err = err
+			abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, err.Error())
+			return
+		}
+		filePath = credentialsFolderPath + pkcs11File
+		errorMsg = "pkcs11 reference"
+		releasePkcs11Reference(credentialsFolderPath)
 	} else {
 		filePath = credentialsFolderPath + keyfile
 		errorMsg = "signingKey"
@@ -167,8 +300,23 @@ func storeCredential(c *gin.Context, credentialsType CredentialsType) {
 	err = globalFileAccessor.write(filePath, []byte(credential), 0666)
 	if err != nil {
 		logger.Warn("Was not able to store "+errorMsg+" for: "+clientId, err)
-		c.String(http.StatusInternalServerError, "Was not able to store the "+errorMsg+".")
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the "+errorMsg+" of "+clientId+".")
 		return
 	}
+	// tokens requested with the old credentials must not be handed out anymore
+	jwtBearerCache.invalidate(clientId)
+	parsedCredentials.invalidate(clientId)
 	c.AbortWithStatus(http.StatusNoContent)
 }
+
+func (ct CredentialsType) auditOperation() string {
+	switch ct {
+	case certificateChain:
+		return auditUpdateCertificate
+	case pkcs11Reference:
+		return auditUpdatePkcs11Reference
+	default:
+		return auditUpdateSigningKey
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/**
+* Parsed signers and encoded certificates of the iSHARE clients, by their credentials folder. Reading and parsing the files for every
+* token request is noticeable under load. Entries are dropped through the credentials api and whenever the files change on disk,
+* thus the cache is only enabled once the credentials folder is watched.
+ */
+var parsedCredentials = parsedCredentialsCache{signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+
+type parsedCredentialsCache struct {
+	mutex   sync.RWMutex
+	enabled bool
+	// incremented on every invalidation, so that credentials read before it are not cached afterwards
+	generation   uint64
+	signers      map[string]crypto.Signer
+	certificates map[string]string
+}
+
+/**
+* Get the signer of the folder, only credentials inside the credentials folder are cached. Failures are not cached, the files are
+* read again on the next request.
+ */
+func (pc *parsedCredentialsCache) getSigner(credentialsFolderPath string) (signer crypto.Signer, err error) {
+	pc.mutex.RLock()
+	signer, cached := pc.signers[credentialsFolderPath]
+	generation, cacheable := pc.generation, pc.enabled && isCredentialsFolder(credentialsFolderPath)
+	pc.mutex.RUnlock()
+	if cached {
+		return signer, err
+	}
+
+	signer, err = getSigner(credentialsFolderPath)
+	if err != nil || !cacheable {
+		return signer, err
+	}
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if pc.generation == generation {
+		pc.signers[credentialsFolderPath] = signer
+	}
+	return signer, err
+}
+
+/**
+* Get the base64 encoded certificate of the folder, to be used in the x5c header. Cached the same way as the signers.
+ */
+func (pc *parsedCredentialsCache) getCertificate(credentialsFolderPath string) (encodedCert string, err error) {
+	pc.mutex.RLock()
+	encodedCert, cached := pc.certificates[credentialsFolderPath]
+	generation, cacheable := pc.generation, pc.enabled && isCredentialsFolder(credentialsFolderPath)
+	pc.mutex.RUnlock()
+	if cached {
+		return encodedCert, err
+	}
+
+	encodedCert, err = getEncodedCertificate(credentialsFolderPath)
+	if err != nil || !cacheable {
+		return encodedCert, err
+	}
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if pc.generation == generation {
+		pc.certificates[credentialsFolderPath] = encodedCert
+	}
+	return encodedCert, err
+}
+
+func (pc *parsedCredentialsCache) enable() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.enabled = true
+}
+
+/**
+* Without a watch on all client folders, changes would be missed. The files are read for every request again.
+ */
+func (pc *parsedCredentialsCache) disable() {
+	pc.invalidateAll()
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.enabled = false
+}
+
+/**
+* Drop the credentials of the client, they are read again on the next request.
+ */
+func (pc *parsedCredentialsCache) invalidate(clientId string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.generation++
+	delete(pc.signers, buildCredentialsFolderPath(clientId))
+	delete(pc.certificates, buildCredentialsFolderPath(clientId))
+}
+
+func (pc *parsedCredentialsCache) invalidateAll() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.generation++
+	pc.signers = map[string]crypto.Signer{}
+	pc.certificates = map[string]string{}
+}
+
+func isCredentialsFolder(credentialsFolderPath string) bool {
+	return credentialsBaseFolder != "" && strings.HasPrefix(credentialsFolderPath, credentialsBaseFolder+"/")
+}
+
+/**
+* Drop cached credentials whenever files inside the credentials folder change, f.e. through mounted secrets. The client folders are
+* watched as well, since changes inside them are not reported for the base folder.
+ */
+func watchCredentialsFolder(baseFolder string) (watcher *fsnotify.Watcher, err error) {
+	baseFolder = filepath.Clean(baseFolder)
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return watcher, err
+	}
+	err = watcher.Add(baseFolder)
+	if err == nil {
+		err = watchClientFolders(watcher, baseFolder)
+	}
+	if err != nil {
+		watcher.Close()
+		return watcher, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				logger.Debugf("Credentials folder changed: %v", event)
+				clientId := getChangedClientId(baseFolder, event.Name)
+				// mounted secrets replace all files at once, by switching the symlink to their hidden data folder
+				if clientId == "" || strings.HasPrefix(clientId, ".") {
+					parsedCredentials.invalidateAll()
+				} else {
+					parsedCredentials.invalidate(clientId)
+				}
+				if event.Op&fsnotify.Create != 0 && filepath.Dir(event.Name) == baseFolder {
+					if err := watchClientFolders(watcher, baseFolder); err != nil {
+						logger.Warn("Was not able to watch the new credentials, disable the credentials cache. ", err)
+						parsedCredentials.disable()
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// events might have been lost
+				logger.Warn("Error watching the credentials folder. ", err)
+				parsedCredentials.invalidateAll()
+			}
+		}
+	}()
+	return watcher, err
+}
+
+/**
+* Add all client folders to the watcher. Folders of mounted secrets are symlinks, thus they are resolved and watched again whenever
+* the symlinks are switched.
+ */
+func watchClientFolders(watcher *fsnotify.Watcher, baseFolder string) (err error) {
+	entries, err := os.ReadDir(baseFolder)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		clientFolder := filepath.Join(baseFolder, entry.Name())
+		if info, err := os.Stat(clientFolder); err != nil || !info.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err = watcher.Add(clientFolder); err != nil {
+			return fmt.Errorf("was not able to watch the credentials of %s: %w", entry.Name(), err)
+		}
+	}
+	return err
+}
+
+/**
+* The client a changed file belongs to, empty if it is the base folder itself.
+ */
+func getChangedClientId(baseFolder string, changedPath string) string {
+	relativePath, err := filepath.Rel(baseFolder, changedPath)
+	if err != nil || relativePath == "." {
+		return ""
+	}
+	return strings.SplitN(filepath.ToSlash(relativePath), "/", 2)[0]
+}
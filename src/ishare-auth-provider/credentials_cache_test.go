@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+* Write a new key together with a certificate for it into the folder.
+ */
+func writeClientCredentials(folder string) {
+	key, _ := getValidKey()
+	os.MkdirAll(folder, 0700)
+	os.WriteFile(filepath.Join(folder, keyfile), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	os.WriteFile(filepath.Join(folder, certChainFile), []byte(issueCertificate(&key.PublicKey, time.Hour)), 0600)
+}
+
+func TestParsedCredentialsCache(t *testing.T) {
+
+	type test struct {
+		testName       string
+		disabled       bool
+		outsideFolder  bool
+		missingFiles   bool
+		invalidate     string
+		expectedCached bool
+	}
+
+	tests := []test{
+		{testName: "Cache the credentials.", expectedCached: true},
+		{testName: "Invalidated client.", invalidate: "client", expectedCached: false},
+		{testName: "Invalidated other client.", invalidate: "other", expectedCached: true},
+		{testName: "Disabled cache.", disabled: true, expectedCached: false},
+		{testName: "Folder outside of the credentials folder.", outsideFolder: true, expectedCached: false},
+		{testName: "Missing credentials are read again.", missingFiles: true, expectedCached: false},
+	}
+
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		credentialsBaseFolder = "test/credentials"
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		parsedCredentials = parsedCredentialsCache{signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+	}()
+
+	for _, tc := range tests {
+		log.Info("TestParsedCredentialsCache +++++++++++++++++++++ Running test: " + tc.testName)
+
+		credentialsBaseFolder = t.TempDir()
+		parsedCredentials = parsedCredentialsCache{enabled: !tc.disabled, signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+		credentialsFolderPath := buildCredentialsFolderPath("client")
+		if tc.outsideFolder {
+			credentialsFolderPath = t.TempDir() + "/"
+		}
+		if !tc.missingFiles {
+			writeClientCredentials(credentialsFolderPath)
+		}
+
+		signer, err := parsedCredentials.getSigner(credentialsFolderPath)
+		cert, certErr := parsedCredentials.getCertificate(credentialsFolderPath)
+		if tc.missingFiles {
+			if !errors.Is(err, fs.ErrNotExist) || !errors.Is(certErr, fs.ErrNotExist) {
+				t.Errorf("%s: Expected the credentials to be missing, but was %v - %v.", tc.testName, err, certErr)
+			}
+		} else if err != nil || certErr != nil {
+			t.Errorf("%s: No error expected, but was %v - %v.", tc.testName, err, certErr)
+			continue
+		}
+
+		// replaced without notifying the cache
+		writeClientCredentials(credentialsFolderPath)
+		if tc.invalidate != "" {
+			parsedCredentials.invalidate(tc.invalidate)
+		}
+
+		secondSigner, err := parsedCredentials.getSigner(credentialsFolderPath)
+		secondCert, certErr := parsedCredentials.getCertificate(credentialsFolderPath)
+		if err != nil || certErr != nil {
+			t.Errorf("%s: No error expected, but was %v - %v.", tc.testName, err, certErr)
+			continue
+		}
+		if cached := secondSigner == signer && secondCert == cert; cached != tc.expectedCached {
+			t.Errorf("%s: Expected the credentials to be cached %v, but were %v.", tc.testName, tc.expectedCached, cached)
+		}
+	}
+}
+
+func TestWatchCredentialsFolder(t *testing.T) {
+
+	type test struct {
+		testName       string
+		change         func(baseFolder string)
+		changedClients []string
+	}
+
+	// mounted secrets are linked through the hidden data folder, which is switched on updates
+	mountSecret := func(baseFolder string, version string) {
+		writeClientCredentials(filepath.Join(baseFolder, version, "mounted"))
+		os.Symlink(version, filepath.Join(baseFolder, "..data_tmp"))
+		os.Rename(filepath.Join(baseFolder, "..data_tmp"), filepath.Join(baseFolder, "..data"))
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Credentials of a client changed.", change: func(baseFolder string) { writeClientCredentials(filepath.Join(baseFolder, "plain")) }, changedClients: []string{"plain"}},
+		{testName: "Mounted secret updated.", change: func(baseFolder string) { mountSecret(baseFolder, "..2026_10_19_2") }, changedClients: []string{"mounted"}},
+		{testName: "Mounted secret updated again.", change: func(baseFolder string) { mountSecret(baseFolder, "..2026_10_19_3") }, changedClients: []string{"mounted"}},
+		{testName: "Client added.", change: func(baseFolder string) { writeClientCredentials(filepath.Join(baseFolder, "added")) }, changedClients: []string{"added"}},
+		{testName: "Credentials of the added client changed.", change: func(baseFolder string) { writeClientCredentials(filepath.Join(baseFolder, "added")) }, changedClients: []string{"added"}},
+	}
+
+	credentialsBaseFolder = t.TempDir()
+	writeClientCredentials(filepath.Join(credentialsBaseFolder, "plain"))
+	mountSecret(credentialsBaseFolder, "..2026_10_19_1")
+	os.Symlink("..data/mounted", filepath.Join(credentialsBaseFolder, "mounted"))
+
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	parsedCredentials = parsedCredentialsCache{enabled: true, signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+	defer func() {
+		credentialsBaseFolder = "test/credentials"
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		parsedCredentials = parsedCredentialsCache{signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+	}()
+
+	watcher, err := watchCredentialsFolder(credentialsBaseFolder)
+	if err != nil {
+		t.Fatalf("Was not able to watch the credentials folder. %v", err)
+	}
+	defer watcher.Close()
+
+	for _, tc := range tests {
+		log.Info("TestWatchCredentialsFolder +++++++++++++++++++++ Running test: " + tc.testName)
+
+		cachedCerts := map[string]string{}
+		for _, clientId := range tc.changedClients {
+			cachedCerts[clientId], _ = parsedCredentials.getCertificate(buildCredentialsFolderPath(clientId))
+		}
+
+		tc.change(credentialsBaseFolder)
+
+		for _, clientId := range tc.changedClients {
+			storedCert, _ := getEncodedCertificate(buildCredentialsFolderPath(clientId))
+			reloaded := false
+			for i := 0; i < 50 && !reloaded; i++ {
+				cert, _ := parsedCredentials.getCertificate(buildCredentialsFolderPath(clientId))
+				reloaded = cert == storedCert && cert != cachedCerts[clientId]
+				time.Sleep(20 * time.Millisecond)
+			}
+			if !reloaded {
+				t.Errorf("%s: Expected the credentials of %s to be reloaded.", tc.testName, clientId)
+			}
+		}
+	}
+}
+
+func TestGetChangedClientId(t *testing.T) {
+
+	type test struct {
+		testName         string
+		changedPath      string
+		expectedClientId string
+	}
+
+	tests := []test{
+		{testName: "File of a client.", changedPath: "/credentials/EU.EORI.CLIENT/cert.cer", expectedClientId: "EU.EORI.CLIENT"},
+		{testName: "Folder of a client.", changedPath: "/credentials/EU.EORI.CLIENT", expectedClientId: "EU.EORI.CLIENT"},
+		{testName: "Data folder of a mounted secret.", changedPath: "/credentials/..data", expectedClientId: "..data"},
+		{testName: "Credentials folder.", changedPath: "/credentials", expectedClientId: ""},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetChangedClientId +++++++++++++++++++++ Running test: " + tc.testName)
+
+		if clientId := getChangedClientId("/credentials", tc.changedPath); clientId != tc.expectedClientId {
+			t.Errorf("%s: Expected the client %s, but was %s.", tc.testName, tc.expectedClientId, clientId)
+		}
+	}
+}
+
+/**
+* Compare the token requests of a client reading its credentials from disk with those using the cache.
+ */
+func BenchmarkGetCredentials(b *testing.B) {
+	benchmarkWithCredentials(b, func(credentialsFolderPath string) {
+		authGetter.getSigningKey(credentialsFolderPath)
+		authGetter.getCertificate(credentialsFolderPath)
+	})
+}
+
+func BenchmarkCreateClientAssertion(b *testing.B) {
+	benchmarkWithCredentials(b, func(credentialsFolderPath string) {
+		if _, err := createClientAssertion("EU.EORI.CLIENT", "EU.EORI.IDP", credentialsFolderPath, nil); err != nil {
+			b.Fatalf("Was not able to create the assertion. %v", err)
+		}
+	})
+}
+
+func benchmarkWithCredentials(b *testing.B, request func(credentialsFolderPath string)) {
+	credentialsBaseFolder = b.TempDir()
+	writeClientCredentials(filepath.Join(credentialsBaseFolder, "EU.EORI.CLIENT"))
+	authGetter = &AuthGetter{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		credentialsBaseFolder = "test/credentials"
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		parsedCredentials = parsedCredentialsCache{signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+	}()
+
+	for _, cacheEnabled := range []bool{false, true} {
+		name := "files"
+		if cacheEnabled {
+			name = "cache"
+		}
+		b.Run(name, func(b *testing.B) {
+			parsedCredentials = parsedCredentialsCache{enabled: cacheEnabled, signers: map[string]crypto.Signer{}, certificates: map[string]string{}}
+			credentialsFolderPath := buildCredentialsFolderPath("EU.EORI.CLIENT")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				request(credentialsFolderPath)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -57,6 +59,8 @@ func (mfs mockFS) RemoveAll(path string) error {
 	return mfs.mockErrDelete
 }
 
+func (mfs mockFS) Rename(oldPath string, newPath string) error { return nil }
+
 func mock_get_folder(path string) (folders []fs.FileInfo, err error) {
 	return mockFolders, mockError
 }
@@ -115,7 +119,7 @@ func TestGetCredentialsList(t *testing.T) {
 
 	tests := []test{
 		{"Get empty list", emptyMockFolders(), nil, 200, "[]"},
-		{"500: cannot read folder.", nil, errors.New("Cannot read folder"), 500, "Was not able to read credentials folder."},
+		{"500: cannot read folder.", nil, errors.New("Cannot read folder"), 500, `{"type":"https://github.com/fiware/endpoint-auth-service/blob/main/doc/ERRORS.md#storage_failure","title":"Storage failure","status":500,"detail":"Was not able to read the credentials folder.","reason":"storage_failure"}`},
 		{"Get single client.", singleMockFolder(), nil, 200, "[\"myClient\"]"},
 		{"Get multiple clients.", multipleMockFolders(), nil, 200, "[\"myClient1\",\"myClient2\",\"myClient3\"]"},
 		{"Get multiple clients with file in folder.", multipleWithFile(), nil, 200, "[\"myClient1\",\"myClient2\"]"},
@@ -171,6 +175,7 @@ func TestPostCredentials(t *testing.T) {
 	tests := []test{
 		{testName: "Successfull creation.", mockRequestContent: reqBody, clientId: "testClient", expectedCode: 201, mockErrRead: errors.New("No such folder."), expectStored: true},
 		{testName: "No request body.", expectedCode: 400, expectStored: false},
+		{testName: "Invalid pkcs11 reference.", mockRequestContent: "{\"certificateChain\":\"cert\",\"pkcs11\":{\"module\":\"/lib/hsm.so\"}}", clientId: "testClient", expectedCode: 400, mockErrRead: errors.New("No such folder."), expectStored: false},
 		{testName: "Credentials already exist.", mockRequestContent: reqBody, clientId: "testClient", mockErrRead: nil, expectedCode: 409, expectStored: false},
 		{testName: "500: cannot create folder.", mockRequestContent: reqBody, clientId: "testClient", expectedCode: 500, mockErrRead: errors.New("No such folder."), mockErrCreateFolder: errors.New("Cannot create folder."), expectStored: false},
 		{testName: "500: cannot store key.", mockRequestContent: reqBody, clientId: "testClient", mockErrWrite: map[string]error{"test/credentials/testClient/key.pem": errors.New("Err")}, mockErrRead: errors.New("No such folder."), expectStored: false, expectedCode: 500},
@@ -244,6 +249,10 @@ func TestStoreCredentials(t *testing.T) {
 
 	mockKey := "newKey"
 	mockCert := "newCert"
+	mockPkcs11 := "{\"module\":\"/lib/hsm.so\",\"slot\":0,\"keyLabel\":\"clientKey\"}"
+	expectedPkcs11File := FileWriteRecord{mockPkcs11, "test/credentials/testClient/pkcs11.json"}
+	pkcs11Modules = map[string]bool{"/lib/hsm.so": true}
+	defer func() { pkcs11Modules = map[string]bool{} }()
 
 	tests := []test{
 		{testName: "Update key.", mockRequestContent: mockKey, clientId: "testClient", expectedCode: 204, expectStored: true, mockErrRead: nil, credentialsType: signingKey},
@@ -254,6 +263,9 @@ func TestStoreCredentials(t *testing.T) {
 		{testName: "No credentials exist for cert.", clientId: "testClient", mockRequestContent: mockCert, expectedCode: 404, mockErrRead: fs.ErrNotExist, expectStored: false, credentialsType: certificateChain},
 		{testName: "500: cannot store key", clientId: "testClient", mockRequestContent: mockKey, expectedCode: 500, mockErrWrite: map[string]error{"test/credentials/testClient/key.pem": errors.New("Err")}, expectStored: false, credentialsType: signingKey},
 		{testName: "500: cannot store cert", clientId: "testClient", mockRequestContent: mockCert, expectedCode: 500, mockErrWrite: map[string]error{"test/credentials/testClient/cert.cer": errors.New("Err")}, expectStored: false, credentialsType: certificateChain},
+		{testName: "Update pkcs11 reference.", mockRequestContent: mockPkcs11, clientId: "testClient", expectedCode: 204, expectStored: true, credentialsType: pkcs11Reference},
+		{testName: "Invalid pkcs11 reference.", mockRequestContent: "{\"module\":\"/lib/hsm.so\"}", clientId: "testClient", expectedCode: 400, expectStored: false, credentialsType: pkcs11Reference},
+		{testName: "Pkcs11 module not allowed.", mockRequestContent: "{\"module\":\"/tmp/module.so\",\"slot\":0,\"keyLabel\":\"clientKey\"}", clientId: "testClient", expectedCode: 400, expectStored: false, credentialsType: pkcs11Reference},
 	}
 
 	var ginContext *gin.Context
@@ -285,6 +297,10 @@ func TestStoreCredentials(t *testing.T) {
 			t.Fatalf("Cert was not stored correctly")
 		}
 
+		if tc.credentialsType == pkcs11Reference && tc.expectStored != contains(fileWriteRecord, expectedPkcs11File) {
+			t.Fatalf("Pkcs11 reference was not handled correctly")
+		}
+
 		if !tc.expectStored && contains(fileWriteRecord, expectedKeyFile) {
 			t.Fatalf("Key should not have been stored.")
 
@@ -297,6 +313,71 @@ func TestStoreCredentials(t *testing.T) {
 
 }
 
+func TestGetCredentials(t *testing.T) {
+
+	credentialsBaseFolder = "test/credentials"
+	pkcs11Modules = map[string]bool{"/lib/pkcs11.so": true}
+	defer func() { pkcs11Modules = map[string]bool{} }()
+
+	type test struct {
+		testName         string
+		storedFiles      map[string][]byte
+		mockErrRead      error
+		expectedCode     int
+		expectedCertPart string
+		expectPkcs11     bool
+		expectPendingKey bool
+	}
+
+	tests := []test{
+		{testName: "Get credentials.", storedFiles: map[string][]byte{"test/credentials/testClient/cert.cer": []byte("cert"), "test/credentials/testClient/key.pem": []byte("secretKey")}, expectedCode: 200, expectedCertPart: "cert"},
+		{testName: "Get credentials with pkcs11 reference.", storedFiles: map[string][]byte{"test/credentials/testClient/cert.cer": []byte("cert"), "test/credentials/testClient/pkcs11.json": []byte(`{"module":"/lib/pkcs11.so","tokenLabel":"token","keyLabel":"key"}`)}, expectedCode: 200, expectedCertPart: "cert", expectPkcs11: true},
+		{testName: "Invalid pkcs11 reference.", storedFiles: map[string][]byte{"test/credentials/testClient/cert.cer": []byte("cert"), "test/credentials/testClient/pkcs11.json": []byte(`{}`)}, expectedCode: 500},
+		{testName: "Get credentials with pending key.", storedFiles: map[string][]byte{"test/credentials/testClient/cert.cer": []byte("cert"), "test/credentials/testClient/pending-key.pem": []byte("secretKey")}, expectedCode: 200, expectedCertPart: "cert", expectPendingKey: true},
+		{testName: "Pending key without certificate.", storedFiles: map[string][]byte{"test/credentials/testClient/pending-key.pem": []byte("secretKey")}, expectedCode: 200, expectPendingKey: true},
+		{testName: "No certificate.", storedFiles: map[string][]byte{}, expectedCode: 500},
+		{testName: "No such credentials.", mockErrRead: fs.ErrNotExist, expectedCode: 404},
+	}
+
+	defer func() { globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content} }()
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_existing}
+
+	for _, tc := range tests {
+		log.Info("TestGetCredentials +++++++++++++++++++++ Running test: " + tc.testName)
+
+		contentMock = tc.storedFiles
+		recorder := httptest.NewRecorder()
+		ginContext, _ := gin.CreateTestContext(recorder)
+		ginContext.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		ginContext.Params = []gin.Param{{Key: "clientId", Value: "testClient"}}
+		diskFs = &mockFS{mockErrRead: tc.mockErrRead}
+
+		getCredentials(ginContext)
+
+		if recorder.Code != tc.expectedCode {
+			t.Errorf(tc.testName + ": Should have been " + fmt.Sprint(tc.expectedCode) + ", but was " + fmt.Sprint(recorder.Code))
+			continue
+		}
+		if tc.expectedCode != 200 {
+			continue
+		}
+		if strings.Contains(recorder.Body.String(), "secretKey") {
+			t.Errorf(tc.testName + ": The signing key must never be returned.")
+		}
+		var credentials Credentials
+		json.Unmarshal(recorder.Body.Bytes(), &credentials)
+		if credentials.CertificateChain != tc.expectedCertPart {
+			t.Errorf(tc.testName + ": Expected the certificate chain, but was " + credentials.CertificateChain)
+		}
+		if (credentials.Pkcs11 != nil) != tc.expectPkcs11 {
+			t.Errorf(tc.testName + ": Expected a pkcs11 reference " + fmt.Sprint(tc.expectPkcs11))
+		}
+		if credentials.PendingKey != tc.expectPendingKey {
+			t.Errorf(tc.testName + ": Expected a pending key " + fmt.Sprint(tc.expectPendingKey))
+		}
+	}
+}
+
 func TestDeleteCredentials(t *testing.T) {
 
 	credentialsBaseFolder = "test/credentials"
@@ -344,6 +425,48 @@ func TestDeleteCredentials(t *testing.T) {
 
 }
 
+func TestRequireValidClientId(t *testing.T) {
+
+	type test struct {
+		testName       string
+		method         string
+		url            string
+		expectedStatus int
+	}
+
+	tests := []test{
+		{testName: "Valid client id.", method: http.MethodPost, url: "/credentials/EU.EORI.client/csr", expectedStatus: 200},
+		{testName: "List without client id.", method: http.MethodGet, url: "/credentials", expectedStatus: 200},
+		{testName: "Csr for the parent folder.", method: http.MethodPost, url: "/credentials/../csr", expectedStatus: 400},
+		{testName: "Certificate for the parent folder.", method: http.MethodPut, url: "/credentials/../csr/certificateChain", expectedStatus: 400},
+		{testName: "Client id with a backslash.", method: http.MethodDelete, url: "/credentials/client%5Cother", expectedStatus: 400},
+		{testName: "Client id of the current folder.", method: http.MethodGet, url: "/credentials/.", expectedStatus: 400},
+		{testName: "Client id of a staging folder.", method: http.MethodPost, url: "/credentials/.import-client/csr", expectedStatus: 400},
+		{testName: "Hidden client id.", method: http.MethodGet, url: "/credentials/.client", expectedStatus: 400},
+	}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	credentials := router.Group("/credentials", requireValidClientId)
+	credentials.GET("", ok)
+	credentials.GET("/:clientId", ok)
+	credentials.DELETE("/:clientId", ok)
+	credentials.POST("/:clientId/csr", ok)
+	credentials.PUT("/:clientId/csr/certificateChain", ok)
+
+	for _, tc := range tests {
+		log.Info("TestRequireValidClientId +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(tc.method, tc.url, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.expectedStatus, recorder.Code)
+		}
+	}
+}
+
 func contains(s []FileWriteRecord, e FileWriteRecord) bool {
 	for _, a := range s {
 		if a == e {
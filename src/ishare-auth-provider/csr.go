@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+
+	"ishare-auth-provider/model"
+)
+
+/**
+* Name of the file containing a key generated by the provider, until the certificate chain issued for it is activated.
+ */
+const pendingKeyFile = "pending-key.pem"
+
+/**
+* Size of the generated keys, if the request does not specify one.
+ */
+const defaultCsrKeySize = 2048
+
+/**
+* Key sizes the provider generates keys with.
+ */
+var csrKeySizes = map[int]bool{2048: true, 3072: true, 4096: true}
+
+var errInvalidCsrRequest = errors.New("invalid_csr_request")
+var errCertificateKeyMismatch = errors.New("certificate_key_mismatch")
+var errCertificateExpired = errors.New("certificate_expired")
+
+/**
+* Request for a certificate signing request, shared with the admin cli.
+ */
+type CsrRequest = model.CsrRequest
+
+/**
+* Generate a key for the client and return a certificate signing request for it. The key never leaves the provider, it is kept
+* as pending key until the certificate chain issued for it is activated. Active credentials of the client stay in use until then,
+* a previous pending key is replaced.
+ */
+func postCsr(c *gin.Context) {
+	clientId := c.Param("clientId")
+
+	audit := startAudit(auditCreateCsr, clientId)
+	defer audit.log(c)
+
+	var csrRequest CsrRequest
+	err := c.ShouldBindJSON(&csrRequest)
+	if err == nil {
+		err = validateCsrRequest(&csrRequest, clientId)
+	}
+	if err != nil {
+		logger.Warn("Received an invalid csr request.", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the csr request: "+err.Error())
+		return
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, csrRequest.KeySize)
+	if err != nil {
+		logger.Warn("Was not able to generate a key for "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonInternalError, "Was not able to generate a key for "+clientId+".")
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: getCsrSubject(csrRequest.Subject)}, key)
+	if err != nil {
+		logger.Warn("Was not able to create the csr for "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonInternalError, "Was not able to create the csr for "+clientId+".")
+		return
+	}
+	encodedKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		logger.Warn("Was not able to encode the key for "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonInternalError, "Was not able to generate a key for "+clientId+".")
+		return
+	}
+
+	credentialsFolderPath := buildCredentialsFolderPath(clientId)
+	err = diskFs.MkdirAll(credentialsFolderPath, os.ModePerm)
+	if err != nil {
+		logger.Warn("Was not able to create folder: "+credentialsFolderPath, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the pending key of "+clientId+".")
+		return
+	}
+	err = globalFileAccessor.write(credentialsFolderPath+pendingKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedKey}), 0600)
+	if err != nil {
+		logger.Warn("Was not able to store the pending key for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the pending key of "+clientId+".")
+		return
+	}
+
+	c.Data(http.StatusCreated, "text/plain", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+}
+
+/**
+* Activate the certificate chain issued for the pending key. The first certificate of the chain has to contain the public key of
+* the pending key, then both replace the credentials of the client. A pkcs11 reference is removed, since it would take precedence.
+ */
+func putCsrCertificateChain(c *gin.Context) {
+	clientId := c.Param("clientId")
+	credentialsFolderPath := buildCredentialsFolderPath(clientId)
+
+	audit := startAudit(auditActivateCertificate, clientId)
+	defer audit.log(c)
+
+	certChain, err := io.ReadAll(c.Request.Body)
+	if err != nil || len(bytes.TrimSpace(certChain)) == 0 {
+		logger.Warn("Was not able to read the request body.", err)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the body.")
+		return
+	}
+
+	pendingKey, err := globalFileAccessor.read(credentialsFolderPath + pendingKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No pending key for "+clientId+" exists.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownPendingKey, "No pending key exists for client "+clientId+".")
+		return
+	}
+	if err != nil {
+		logger.Warn("Was not able to read the pending key of: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the pending key of "+clientId+".")
+		return
+	}
+
+	if err = verifyCertificateForKey(certChain, pendingKey); err != nil {
+		logger.Warn("Received a certificate chain not usable with the pending key of "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The certificate chain cannot be activated: "+err.Error())
+		return
+	}
+
+	// the activated key replaces a pkcs11 reference
+	releasePkcs11Reference(credentialsFolderPath)
+	err = globalFileAccessor.write(credentialsFolderPath+keyfile, pendingKey, 0600)
+	if err == nil {
+		err = globalFileAccessor.write(credentialsFolderPath+certChainFile, certChain, 0666)
+	}
+	if err == nil {
+		err = diskFs.RemoveAll(credentialsFolderPath + pkcs11File)
+	}
+	if err != nil {
+		logger.Warn("Was not able to activate the certificate chain for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to activate the certificate chain of "+clientId+".")
+		return
+	}
+	// the key is active now, a failure to remove it only leaves a copy inside the folder
+	if err = diskFs.RemoveAll(credentialsFolderPath + pendingKeyFile); err != nil {
+		logger.Warn("Was not able to remove the pending key of: "+clientId, err)
+	}
+
+	// tokens requested with the old credentials must not be handed out anymore
+	jwtBearerCache.invalidate(clientId)
+	parsedCredentials.invalidate(clientId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+/**
+* Validate the request and fill in the defaults. The serial number defaults to the clientId, which is the EORI of the party.
+ */
+func validateCsrRequest(csrRequest *CsrRequest, clientId string) (err error) {
+	if csrRequest.KeySize == 0 {
+		csrRequest.KeySize = defaultCsrKeySize
+	}
+	if csrRequest.Subject.SerialNumber == "" {
+		csrRequest.Subject.SerialNumber = clientId
+	}
+	if csrRequest.Subject.CommonName == "" {
+		return fmt.Errorf("%w: a commonName is required", errInvalidCsrRequest)
+	}
+	if country := csrRequest.Subject.Country; country != "" && len(country) != 2 {
+		return fmt.Errorf("%w: the country has to be a two-letter code, but was %s", errInvalidCsrRequest, country)
+	}
+	if !csrKeySizes[csrRequest.KeySize] {
+		return fmt.Errorf("%w: unsupported key size %d", errInvalidCsrRequest, csrRequest.KeySize)
+	}
+	return err
+}
+
+func getCsrSubject(subject model.CsrSubject) pkix.Name {
+	name := pkix.Name{CommonName: subject.CommonName, SerialNumber: subject.SerialNumber}
+	if subject.Organization != "" {
+		name.Organization = []string{subject.Organization}
+	}
+	if subject.OrganizationalUnit != "" {
+		name.OrganizationalUnit = []string{subject.OrganizationalUnit}
+	}
+	if subject.Locality != "" {
+		name.Locality = []string{subject.Locality}
+	}
+	if subject.Province != "" {
+		name.Province = []string{subject.Province}
+	}
+	if subject.Country != "" {
+		name.Country = []string{subject.Country}
+	}
+	return name
+}
+
+/**
+* Verify that the first certificate of the chain was issued for the key and is still valid.
+ */
+func verifyCertificateForKey(certChain []byte, pemKey []byte) (err error) {
+	block, _ := pem.Decode(certChain)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errCertDecode
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCertDecode, err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return err
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return fmt.Errorf("%w: the certificate %s was not issued for the pending key", errCertificateKeyMismatch, cert.Subject)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("%w: the certificate expired at %s", errCertificateExpired, cert.NotAfter.Format(time.RFC3339))
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCsr(t *testing.T) {
+
+	type test struct {
+		testName        string
+		method          string
+		url             string
+		body            string
+		certificate     func(publicKey crypto.PublicKey) string
+		expectedStatus  int
+		expectedSubject string
+		expectedFiles   []string
+		expectedMissing []string
+	}
+
+	validKey, _ := getValidKey()
+	otherKey := &validKey.PublicKey
+	validCertificate := func(publicKey crypto.PublicKey) string { return issueCertificate(publicKey, time.Hour) }
+	expiredCertificate := func(publicKey crypto.PublicKey) string { return issueCertificate(publicKey, -time.Hour) }
+	otherCertificate := func(crypto.PublicKey) string { return issueCertificate(otherKey, time.Hour) }
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Create csr.", method: http.MethodPost, url: "/credentials/EU.EORI.NEW/csr", body: `{"subject":{"commonName":"New Party","organization":"New Party B.V.","country":"NL"}}`, expectedStatus: 201,
+			expectedSubject: "SERIALNUMBER=EU.EORI.NEW,CN=New Party,O=New Party B.V.,C=NL", expectedFiles: []string{"EU.EORI.NEW/" + pendingKeyFile}, expectedMissing: []string{"EU.EORI.NEW/" + keyfile}},
+		{testName: "Create csr with serial number.", method: http.MethodPost, url: "/credentials/EU.EORI.NEW/csr", body: `{"subject":{"commonName":"New Party","serialNumber":"EU.EORI.NL000000001"},"keySize":3072}`, expectedStatus: 201,
+			expectedSubject: "SERIALNUMBER=EU.EORI.NL000000001,CN=New Party", expectedFiles: []string{"EU.EORI.NEW/" + pendingKeyFile}},
+		{testName: "Create csr without common name.", method: http.MethodPost, url: "/credentials/EU.EORI.NEW/csr", body: `{"subject":{"organization":"New Party B.V."}}`, expectedStatus: 400},
+		{testName: "Create csr with invalid country.", method: http.MethodPost, url: "/credentials/EU.EORI.NEW/csr", body: `{"subject":{"commonName":"New Party","country":"Netherlands"}}`, expectedStatus: 400},
+		{testName: "Create csr with a weak key.", method: http.MethodPost, url: "/credentials/EU.EORI.NEW/csr", body: `{"subject":{"commonName":"New Party"},"keySize":1024}`, expectedStatus: 400},
+		{testName: "Activate certificate of another key.", method: http.MethodPut, url: "/credentials/EU.EORI.NEW/csr/certificateChain", certificate: otherCertificate, expectedStatus: 400,
+			expectedFiles: []string{"EU.EORI.NEW/" + pendingKeyFile}, expectedMissing: []string{"EU.EORI.NEW/" + keyfile, "EU.EORI.NEW/" + certChainFile}},
+		{testName: "Activate expired certificate.", method: http.MethodPut, url: "/credentials/EU.EORI.NEW/csr/certificateChain", certificate: expiredCertificate, expectedStatus: 400,
+			expectedFiles: []string{"EU.EORI.NEW/" + pendingKeyFile}, expectedMissing: []string{"EU.EORI.NEW/" + keyfile}},
+		{testName: "Activate no certificate.", method: http.MethodPut, url: "/credentials/EU.EORI.NEW/csr/certificateChain", body: "certificate", expectedStatus: 400},
+		{testName: "Activate certificate.", method: http.MethodPut, url: "/credentials/EU.EORI.NEW/csr/certificateChain", certificate: validCertificate, expectedStatus: 204,
+			expectedFiles: []string{"EU.EORI.NEW/" + keyfile, "EU.EORI.NEW/" + certChainFile}, expectedMissing: []string{"EU.EORI.NEW/" + pendingKeyFile}},
+		{testName: "Activate without pending key.", method: http.MethodPut, url: "/credentials/EU.EORI.NEW/csr/certificateChain", certificate: validCertificate, expectedStatus: 404},
+		{testName: "Rotate key of a pkcs11 client.", method: http.MethodPost, url: "/credentials/EU.EORI.HSM/csr", body: `{"subject":{"commonName":"Hsm Party"}}`, expectedStatus: 201,
+			expectedSubject: "SERIALNUMBER=EU.EORI.HSM,CN=Hsm Party", expectedFiles: []string{"EU.EORI.HSM/" + pendingKeyFile, "EU.EORI.HSM/" + pkcs11File}},
+		{testName: "Activate rotated key.", method: http.MethodPut, url: "/credentials/EU.EORI.HSM/csr/certificateChain", certificate: validCertificate, expectedStatus: 204,
+			expectedFiles: []string{"EU.EORI.HSM/" + keyfile, "EU.EORI.HSM/" + certChainFile}, expectedMissing: []string{"EU.EORI.HSM/" + pendingKeyFile, "EU.EORI.HSM/" + pkcs11File}},
+	}
+
+	credentialsBaseFolder = t.TempDir()
+	os.MkdirAll(filepath.Join(credentialsBaseFolder, "EU.EORI.HSM"), 0700)
+	os.WriteFile(filepath.Join(credentialsBaseFolder, "EU.EORI.HSM", pkcs11File), []byte(`{"module":"/lib/pkcs11.so","tokenLabel":"token","keyLabel":"key"}`), 0600)
+	diskFs = &osFS{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		credentialsBaseFolder = "test/credentials"
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+
+	router := gin.New()
+	router.POST("/credentials/:clientId/csr", postCsr)
+	router.PUT("/credentials/:clientId/csr/certificateChain", putCsrCertificateChain)
+
+	// public key of the latest csr, the certificates are issued for it
+	var requestedKey crypto.PublicKey
+	var pendingKey []byte
+
+	for _, tc := range tests {
+		log.Info("TestCsr +++++++++++++++++++++ Running test: " + tc.testName)
+
+		jwtBearerCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+		jwtBearerCache.put("assertion", "EU.EORI.NEW", "myToken", time.Now().Add(time.Minute))
+		jwtBearerCache.put("hsm-assertion", "EU.EORI.HSM", "myToken", time.Now().Add(time.Minute))
+
+		body := tc.body
+		if tc.certificate != nil {
+			body = tc.certificate(requestedKey)
+		}
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		for _, file := range tc.expectedFiles {
+			if _, err := os.Stat(filepath.Join(credentialsBaseFolder, file)); err != nil {
+				t.Errorf("%s: Expected %s to exist.", tc.testName, file)
+			}
+		}
+		for _, file := range tc.expectedMissing {
+			if _, err := os.Stat(filepath.Join(credentialsBaseFolder, file)); !os.IsNotExist(err) {
+				t.Errorf("%s: Expected %s to not exist.", tc.testName, file)
+			}
+		}
+
+		if tc.expectedSubject != "" {
+			block, _ := pem.Decode(recorder.Body.Bytes())
+			if block == nil || block.Type != "CERTIFICATE REQUEST" {
+				t.Errorf("%s: Expected a pem encoded csr, but was %s.", tc.testName, recorder.Body.String())
+				continue
+			}
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil || csr.CheckSignature() != nil {
+				t.Errorf("%s: Expected a valid csr. Err: %v", tc.testName, err)
+				continue
+			}
+			if csr.Subject.String() != tc.expectedSubject {
+				t.Errorf("%s: Expected the subject %s, but was %s.", tc.testName, tc.expectedSubject, csr.Subject)
+			}
+			requestedKey = csr.PublicKey
+			pendingKey, _ = os.ReadFile(filepath.Join(credentialsBaseFolder, filepath.Dir(tc.expectedFiles[0]), pendingKeyFile))
+		}
+
+		_, _, cached := jwtBearerCache.get("assertion")
+		_, _, hsmCached := jwtBearerCache.get("hsm-assertion")
+		if activated := tc.method == http.MethodPut && tc.expectedStatus == 204; activated == (cached && hsmCached) {
+			t.Errorf("%s: Expected cached tokens of the client to only be dropped on activation.", tc.testName)
+		}
+		if tc.method == http.MethodPut && tc.expectedStatus == 204 {
+			activeKey, _ := os.ReadFile(filepath.Join(credentialsBaseFolder, filepath.Dir(tc.expectedFiles[0]), keyfile))
+			if !bytes.Equal(activeKey, pendingKey) {
+				t.Errorf("%s: Expected the pending key to be activated.", tc.testName)
+			}
+		}
+	}
+}
+
+/**
+* Issue a certificate for the public key, signed by a throwaway ca.
+ */
+func issueCertificate(publicKey crypto.PublicKey, validity time.Duration) string {
+	caKey, _ := getValidKey()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "party", SerialNumber: "EU.EORI.NEW"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	certificate, _ := x509.CreateCertificate(rand.Reader, template, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}}, publicKey, caKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/**
+* Name of the header to return the delegation evidence in, if nothing else is configured.
+ */
+const defaultDelegationHeader = "Delegation-Evidence"
+
+var errNoDelegationToken = errors.New("no_delegation_token")
+var errInvalidDelegationToken = errors.New("invalid_delegation_token")
+var errNoDelegationRequest = errors.New("no_delegation_request")
+
+/**
+* Claims of the delegation token returned by the authorization registry.
+ */
+type delegationTokenClaims struct {
+	jwt.StandardClaims
+	DelegationEvidence struct {
+		NotBefore    int64 `json:"notBefore"`
+		NotOnOrAfter int64 `json:"notOnOrAfter"`
+	} `json:"delegationEvidence"`
+}
+
+/**
+* Cache of delegation tokens, indexed by a hash over registry, client and the rendered delegation request.
+ */
+type delegationEvidenceCache struct {
+	mutex  sync.RWMutex
+	tokens map[string]cachedDelegationToken
+}
+
+type cachedDelegationToken struct {
+	token  string
+	expiry time.Time
+}
+
+var delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+
+/**
+* Get the delegation evidence for the endpoint as header. The evidence is requested at the configured authorization registry, if
+* no valid one is cached.
+ */
+func getDelegationHeader(authInfo AuthInfo, domain string, path string, credentialsFolderPath string) (header Header, expiry time.Time, err error) {
+
+	headerName := authInfo.DelegationHeader
+	if headerName == "" {
+		headerName = defaultDelegationHeader
+	}
+
+	if len(authInfo.DelegationRequest) == 0 {
+		return header, expiry, errNoDelegationRequest
+	}
+
+	delegationRequest := renderDelegationRequest(authInfo, domain, path)
+	cacheKey := getDelegationCacheKey(authInfo, delegationRequest)
+
+	if token, expiry, ok := delegationCache.get(cacheKey); ok {
+		logger.Debugf("Use cached delegation evidence for %s.", authInfo.IShareClientID)
+		return Header{Name: headerName, Value: token}, expiry, err
+	}
+
+	token, expiry, err := requestDelegationEvidence(authInfo, delegationRequest, credentialsFolderPath)
+	if err != nil {
+		return header, expiry, err
+	}
+	delegationCache.put(cacheKey, token, expiry)
+
+	return Header{Name: headerName, Value: token}, expiry, err
+}
+
+/**
+* Fill the placeholders of the configured delegation request template. Supported are {{clientId}}, {{domain}} and {{path}}.
+ */
+func renderDelegationRequest(authInfo AuthInfo, domain string, path string) []byte {
+	replacer := strings.NewReplacer(
+		"{{clientId}}", jsonEscape(authInfo.IShareClientID),
+		"{{domain}}", jsonEscape(domain),
+		"{{path}}", jsonEscape(path))
+	return []byte(replacer.Replace(string(authInfo.DelegationRequest)))
+}
+
+/**
+* Request the delegation evidence at the authorization registry, using an access token of the client for the registry.
+ */
+func requestDelegationEvidence(authInfo AuthInfo, delegationRequest []byte, credentialsFolderPath string) (token string, expiry time.Time, err error) {
+
+	signedToken, err := createClientAssertion(authInfo.IShareClientID, authInfo.AuthorizationRegistryID, credentialsFolderPath, nil)
+	if err != nil {
+		logger.Warn("Was not able to create the client assertion for the authorization registry.", err)
+		return token, expiry, err
+	}
+
+	tokenResponse, err := requestToken(authInfo.AuthorizationRegistryAddress+"/connect/token", authInfo.AuthorizationRegistryID, url.Values{
+		"grant_type":            {"client_credentials"},
+		"scope":                 {defaultScope},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {signedToken},
+		"client_id":             {authInfo.IShareClientID},
+	})
+	if err != nil {
+		logger.Warn("Was not able to get a token from the authorization registry.", err)
+		return token, expiry, err
+	}
+
+	requestBody := bytes.NewBufferString(`{"delegationRequest":`)
+	requestBody.Write(delegationRequest)
+	requestBody.WriteString(`}`)
+
+	req, err := http.NewRequest(http.MethodPost, authInfo.AuthorizationRegistryAddress+"/delegation", requestBody)
+	if err != nil {
+		logger.Warn("Was not able to build the delegation request. Invalid authorization registry address.", err)
+		return token, expiry, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tokenResponse["access_token"].(string))
+
+	resp, err := globalHttpClient.Do(req)
+	if err != nil {
+		logger.Warn("Was not able to get the delegation evidence.", err)
+		return token, expiry, err
+	}
+	if resp.Body == nil {
+		logger.Warn("Did not receive a delegation response body.")
+		return token, expiry, errNoResponseBody
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warnf("The authorization registry responded to the delegation request with status %d.", resp.StatusCode)
+		return token, expiry, fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	}
+
+	var delegationResponse map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&delegationResponse)
+	if err != nil {
+		logger.Warn("Was not able to decode the delegation response.", err)
+		return token, expiry, err
+	}
+
+	token, ok := delegationResponse["delegation_token"].(string)
+	if !ok {
+		logger.Warnf("Did not receive a delegation token. Resp: %v", delegationResponse)
+		return token, expiry, errNoDelegationToken
+	}
+
+	expiry, err = getDelegationExpiry(token)
+	return token, expiry, err
+}
+
+/**
+* Get the time until the delegation evidence can be used. Its the earlier of the token expiry and the end of the evidence validity.
+ */
+func getDelegationExpiry(token string) (expiry time.Time, err error) {
+
+	claims := &delegationTokenClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil {
+		logger.Warn("Was not able to parse the delegation token.", err)
+		return expiry, errInvalidDelegationToken
+	}
+
+	validUntil := claims.DelegationEvidence.NotOnOrAfter
+	if claims.ExpiresAt > 0 && (validUntil == 0 || claims.ExpiresAt < validUntil) {
+		validUntil = claims.ExpiresAt
+	}
+	if validUntil == 0 {
+		logger.Debug("Delegation evidence does not define its validity, it will not be cached.")
+		return time.Now(), err
+	}
+	return time.Unix(validUntil, 0), err
+}
+
+func getDelegationCacheKey(authInfo AuthInfo, delegationRequest []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(authInfo.AuthorizationRegistryID + "|" + authInfo.IShareClientID + "|"))
+	hash.Write(delegationRequest)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func jsonEscape(value string) string {
+	escaped, _ := json.Marshal(value)
+	// remove the surrounding quotes, the placeholders are expected to be inside of json strings
+	return string(escaped[1 : len(escaped)-1])
+}
+
+func (dc *delegationEvidenceCache) get(key string) (token string, expiry time.Time, ok bool) {
+	dc.mutex.RLock()
+	defer dc.mutex.RUnlock()
+	entry, ok := dc.tokens[key]
+	if !ok || !time.Now().Before(entry.expiry) {
+		return token, expiry, false
+	}
+	return entry.token, entry.expiry, true
+}
+
+/**
+* Add the evidence, expired ones are removed on the way. The key covers the rendered request, thus every requested path would pile up otherwise.
+ */
+func (dc *delegationEvidenceCache) put(key string, token string, expiry time.Time) {
+	now := time.Now()
+	if !now.Before(expiry) {
+		return
+	}
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	for cachedKey, entry := range dc.tokens {
+		if !now.Before(entry.expiry) {
+			delete(dc.tokens, cachedKey)
+		}
+	}
+	dc.tokens[key] = cachedDelegationToken{token, expiry}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+type standInRegistry struct {
+	evidenceValidity   int64
+	tokenExpiry        int64
+	noDelegationToken  bool
+	delegationStatus   int
+	delegationRequests int
+	lastRequest        map[string]interface{}
+	signingKey         *rsa.PrivateKey
+}
+
+/**
+* Minimal authorization registry, providing the token and the delegation endpoint.
+ */
+func (sir *standInRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/connect/token":
+		w.Write([]byte(`{"access_token":"registryToken","token_type":"Bearer","expires_in":3600}`))
+	case "/delegation":
+		if r.Header.Get("Authorization") != "Bearer registryToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sir.delegationRequests++
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &sir.lastRequest)
+		if sir.noDelegationToken {
+			w.Write([]byte(`{}`))
+			return
+		}
+		claims := jwt.MapClaims{"iss": "registryId", "delegationEvidence": map[string]interface{}{"notOnOrAfter": sir.evidenceValidity}}
+		if sir.tokenExpiry > 0 {
+			claims["exp"] = sir.tokenExpiry
+		}
+		// generating a key takes time, tests depending on the evidence validity provide one upfront
+		key := sir.signingKey
+		if key == nil {
+			key, _ = getValidKey()
+		}
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		if sir.delegationStatus != 0 {
+			w.WriteHeader(sir.delegationStatus)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"delegation_token": token})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGetDelegationHeader(t *testing.T) {
+
+	validKey, _ := getValidKey()
+	now := time.Now().Unix()
+	delegationRequest := `{"policyIssuer":"EU.EORI.ISSUER","target":{"accessSubject":"{{clientId}}"},"policySets":[{"policies":[{"target":{"resource":{"type":"EntityType","identifiers":["*"],"attributes":["*"]},"actions":["GET"]},"rules":[{"effect":"Permit"}]}]}]}`
+
+	type test struct {
+		testName          string
+		registry          standInRegistry
+		delegationRequest string
+		delegationHeader  string
+		expectedHeader    string
+		expectedExpiry    int64
+		expectedError     error
+	}
+
+	tests := []test{
+		{testName: "Get evidence.", registry: standInRegistry{evidenceValidity: now + 60}, delegationRequest: delegationRequest, expectedHeader: "Delegation-Evidence", expectedExpiry: now + 60},
+		{testName: "Get evidence with custom header.", registry: standInRegistry{evidenceValidity: now + 60}, delegationRequest: delegationRequest, delegationHeader: "X-Delegation", expectedHeader: "X-Delegation", expectedExpiry: now + 60},
+		{testName: "Token expires before the evidence.", registry: standInRegistry{evidenceValidity: now + 60, tokenExpiry: now + 30}, delegationRequest: delegationRequest, expectedHeader: "Delegation-Evidence", expectedExpiry: now + 30},
+		{testName: "No delegation request configured.", registry: standInRegistry{evidenceValidity: now + 60}, expectedError: errNoDelegationRequest},
+		{testName: "No delegation token returned.", registry: standInRegistry{noDelegationToken: true}, delegationRequest: delegationRequest, expectedError: errNoDelegationToken},
+		{testName: "Error response of the registry.", registry: standInRegistry{evidenceValidity: now + 60, delegationStatus: http.StatusForbidden}, delegationRequest: delegationRequest, expectedError: errUnexpectedStatus},
+	}
+
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert"}
+	globalHttpClient = &http.Client{}
+
+	for _, tc := range tests {
+		log.Info("TestGetDelegationHeader +++++++++++++++++++++ Running test: " + tc.testName)
+
+		delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+		server := httptest.NewServer(&tc.registry)
+		authInfo := AuthInfo{IShareClientID: "clientId", AuthorizationRegistryID: "registryId", AuthorizationRegistryAddress: server.URL, DelegationRequest: []byte(tc.delegationRequest), DelegationHeader: tc.delegationHeader}
+
+		header, expiry, err := getDelegationHeader(authInfo, "domain", "/path", "folder/")
+		server.Close()
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+			continue
+		}
+		if tc.expectedError != nil {
+			continue
+		}
+		if header.Name != tc.expectedHeader || header.Value == "" {
+			t.Errorf(tc.testName + ": Did not receive the expected header. Was: " + fmt.Sprint(header))
+		}
+		if expiry.Unix() != tc.expectedExpiry {
+			t.Errorf(tc.testName + ": Expected expiry " + fmt.Sprint(tc.expectedExpiry) + " but was " + fmt.Sprint(expiry.Unix()))
+		}
+		target := tc.registry.lastRequest["delegationRequest"].(map[string]interface{})["target"].(map[string]interface{})
+		if target["accessSubject"] != "clientId" {
+			t.Errorf(tc.testName + ": The delegation request template was not rendered. Was: " + fmt.Sprint(tc.registry.lastRequest))
+		}
+	}
+}
+
+func TestDelegationCaching(t *testing.T) {
+
+	validKey, _ := getValidKey()
+	log.Info("TestDelegationCaching +++++++++++++++++++++")
+
+	registry := standInRegistry{evidenceValidity: time.Now().Unix() + 60}
+	server := httptest.NewServer(&registry)
+	defer server.Close()
+
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert"}
+	globalHttpClient = &http.Client{}
+	delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+
+	authInfo := AuthInfo{IShareClientID: "clientId", AuthorizationRegistryID: "registryId", AuthorizationRegistryAddress: server.URL, DelegationRequest: []byte(`{"target":{"accessSubject":"{{clientId}}","path":"{{path}}"}}`)}
+
+	getDelegationHeader(authInfo, "domain", "/path", "folder/")
+	getDelegationHeader(authInfo, "domain", "/path", "folder/")
+	if registry.delegationRequests != 1 {
+		t.Errorf("Valid evidence should be served from cache, but was requested " + fmt.Sprint(registry.delegationRequests) + " times.")
+	}
+
+	getDelegationHeader(authInfo, "domain", "/other-path", "folder/")
+	if registry.delegationRequests != 2 {
+		t.Errorf("Different rendered requests should not share a cache entry.")
+	}
+
+	registry.evidenceValidity = time.Now().Unix() - 1
+	delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+	getDelegationHeader(authInfo, "domain", "/path", "folder/")
+	getDelegationHeader(authInfo, "domain", "/path", "folder/")
+	if registry.delegationRequests != 4 {
+		t.Errorf("Expired evidence should not be cached.")
+	}
+}
+
+func TestDelegationCachePruning(t *testing.T) {
+
+	log.Info("TestDelegationCachePruning +++++++++++++++++++++")
+
+	cache := delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+	cache.tokens["expired"] = cachedDelegationToken{"expiredToken", time.Now().Add(-time.Second)}
+	cache.put("keyA", "tokenA", time.Now().Add(time.Minute))
+	cache.put("keyB", "tokenB", time.Now().Add(time.Minute))
+
+	if _, ok := cache.tokens["expired"]; ok {
+		t.Errorf("Expired evidence should be removed when adding new one.")
+	}
+	if len(cache.tokens) != 2 {
+		t.Errorf("Valid evidence should be kept, but the cache was %v.", cache.tokens)
+	}
+}
+
+func TestGetAuthRouteWithDelegation(t *testing.T) {
+
+	validKey, _ := getValidKey()
+	log.Info("TestGetAuthRouteWithDelegation +++++++++++++++++++++")
+
+	registry := standInRegistry{evidenceValidity: time.Now().Unix() + 10, signingKey: validKey}
+	server := httptest.NewServer(&registry)
+	defer server.Close()
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"myToken","token_type":"Bearer"}`))
+	}))
+	defer idp.Close()
+
+	globalHttpClient = &http.Client{}
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert", mockAuthInfo: AuthInfo{IShareIdpAddress: idp.URL, IShareClientID: "clientId", IShareIdpID: "idpId", AuthorizationRegistryID: "registryId", AuthorizationRegistryAddress: server.URL, DelegationRequest: []byte(`{}`)}}
+	delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+
+	recorder := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(recorder)
+	ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/ISHARE/auth?domain=test.domain&path=/", nil)
+	ginContext.Params = gin.Params{{Key: "authType", Value: "ISHARE"}}
+
+	getAuth(ginContext)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, but was: " + fmt.Sprint(recorder.Code))
+	}
+	var result HeadersList
+	json.NewDecoder(recorder.Body).Decode(&result)
+	if len(result) != 2 || result[0].Name != "Authorization" || result[1].Name != "Delegation-Evidence" {
+		t.Errorf("Expected authorization and delegation header, but was: " + fmt.Sprint(result))
+	}
+	cacheControl := recorder.Header().Get("Cache-Control")
+	if cacheControl != "max-age=9" && cacheControl != "max-age=10" {
+		t.Errorf("Cache lifetime should be limited by the evidence, but was: " + cacheControl)
+	}
+
+	// error responses of the registry are not decoded
+	registry.delegationStatus = http.StatusInternalServerError
+	delegationCache = delegationEvidenceCache{tokens: map[string]cachedDelegationToken{}}
+	recorder = httptest.NewRecorder()
+	ginContext, _ = gin.CreateTestContext(recorder)
+	ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/ISHARE/auth?domain=test.domain&path=/", nil)
+	ginContext.Params = gin.Params{{Key: "authType", Value: "ISHARE"}}
+
+	getAuth(ginContext)
+
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), `"reason":"`+reasonDelegationFailed+`"`) {
+		t.Errorf("Failed delegation requests should be answered with %s, but was: %v %s", reasonDelegationFailed, recorder.Code, recorder.Body.String())
+	}
+}
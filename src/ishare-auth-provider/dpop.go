@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+/**
+* Auth type of the DPoP provider, as used in its route /DPOP/auth.
+ */
+const dpopAuthType = "DPOP"
+
+/**
+* Token type, header names and error code of RFC 9449.
+ */
+const (
+	dpopTokenType    = "DPoP"
+	dpopHeader       = "DPoP"
+	dpopNonceHeader  = "DPoP-Nonce"
+	dpopNonceError   = "use_dpop_nonce"
+	dpopProofJwtType = "dpop+jwt"
+)
+
+/**
+* Name of the file containing the DPoP key of the client. It is kept separate from the signing key, since the proof key must not
+* be used for anything else.
+ */
+const dpopKeyFile = "dpop.pem"
+
+var errInvalidDpopKey = errors.New("invalid_dpop_key")
+var errNoDpopToken = errors.New("no_dpop_token")
+
+/**
+* Sender-constrained tokens, indexed like the oauth2 tokens. Only the token is cached, the proofs are created for every request.
+ */
+var dpopTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Latest nonces provided by the authorization servers, by their token endpoint.
+ */
+var dpopNonces = dpopNonceStore{nonces: map[string]string{}}
+
+type dpopNonceStore struct {
+	mutex  sync.RWMutex
+	nonces map[string]string
+}
+
+/**
+* Key to sign the proofs with, together with its algorithm and public jwk.
+ */
+type dpopKey struct {
+	key    interface{}
+	method jwt.SigningMethod
+	jwk    map[string]string
+}
+
+/**
+* Provider requesting DPoP-bound access tokens through the client credentials grant, as defined by RFC 9449. Every request to the
+* endpoint gets a fresh proof over its method and uri, signed with the DPoP key of the client.
+ */
+type dpopProvider struct{}
+
+func (dpopProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(dpopAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getOAuth2Config(authInfo); err != nil {
+		logger.Warnf("Received invalid dpop auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid dpop auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+/**
+* Without the original request, no proof can be created.
+ */
+func (dpopProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "The auth type " + dpopAuthType + " requires the method and uri of the original request."}
+}
+
+/**
+* Return the cached token together with a proof for the original request. A nonce the endpoint challenged the previous request
+* with is included in the proof. The headers are only valid for this request and must not be cached.
+ */
+func (dpopProvider) getRequestHeaders(authInfo AuthInfo, domain string, path string, request requestContext) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getOAuth2Config(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+	htu, err := getDpopTargetUri(request)
+	if err != nil {
+		logger.Infof("Was not able to create a proof for the request to %s - %s. Err: %v", domain, path, err)
+		return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "Was not able to create the proof: " + err.Error(), err: err}
+	}
+
+	key, err := getDpopKey(buildOAuth2CredentialsFolderPath(config.ClientId))
+	if err != nil {
+		return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, err)
+	}
+
+	cacheKey := getOAuth2CacheKey(config)
+	token, expiry, cached := dpopTokenCache.get(cacheKey)
+	if !cached {
+		token, expiry, err = requestDpopToken(config, key)
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, err)
+		}
+		dpopTokenCache.put(cacheKey, config.ClientId, token, expiry)
+	}
+
+	proof, err := createDpopProof(key, strings.ToUpper(request.method), htu, token, request.nonce)
+	if err != nil {
+		return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, fmt.Errorf("%w: %v", errInvalidClientCredentials, err))
+	}
+
+	headers = HeadersList{
+		Header{Name: "Authorization", Value: dpopTokenType + " " + token},
+		Header{Name: dpopHeader, Value: proof},
+	}
+	return headers, 0, err
+}
+
+/**
+* Request a token through the client credentials grant, with a proof of the DPoP key. If the authorization server challenges the
+* request with a nonce, it is retried once including it. Nonces are kept for the following token requests.
+ */
+func requestDpopToken(config oauth2Config, key dpopKey) (token string, expiry time.Time, err error) {
+	nonce := dpopNonces.get(config.TokenEndpoint)
+	for retried := false; ; retried = true {
+		proof, err := createDpopProof(key, http.MethodPost, config.TokenEndpoint, "", nonce)
+		if err != nil {
+			return token, expiry, fmt.Errorf("%w: %v", errInvalidClientCredentials, err)
+		}
+
+		requestStart := time.Now()
+		resp, err := sendOAuth2TokenRequest(config, getClientCredentialsGrant(config), http.Header{dpopHeader: {proof}})
+		if err != nil {
+			return token, expiry, err
+		}
+		challengedNonce := resp.Header.Get(dpopNonceHeader)
+		if challengedNonce != "" {
+			dpopNonces.put(config.TokenEndpoint, challengedNonce)
+		}
+		res, err := decodeTokenResponse(resp)
+
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) && oauthErr.Code == dpopNonceError && challengedNonce != "" && !retried {
+			logger.Debugf("The token endpoint %s requires a nonce, retry with it.", config.TokenEndpoint)
+			nonce = challengedNonce
+			continue
+		}
+		if err != nil {
+			return token, expiry, err
+		}
+		if tokenType, _ := res["token_type"].(string); !strings.EqualFold(tokenType, dpopTokenType) {
+			logger.Warnf("Received a token of type %s instead of a DPoP-bound one.", tokenType)
+			return token, expiry, fmt.Errorf("%w: received token type %s", errNoDpopToken, tokenType)
+		}
+
+		token = res["access_token"].(string)
+		expiry = requestStart
+		if expiresIn, ok := res["expires_in"].(float64); ok {
+			expiry = requestStart.Add(time.Duration(expiresIn)*time.Second - oauth2ExpiryMargin)
+		}
+		return token, expiry, err
+	}
+}
+
+/**
+* Create the proof jwt of RFC 9449, section 4.2. Proofs for resource requests are bound to the access token through its hash.
+ */
+func createDpopProof(key dpopKey, htm string, htu string, accessToken string, nonce string) (proof string, err error) {
+	randomUuid, err := uuid.NewRandom()
+	if err != nil {
+		return proof, err
+	}
+	claims := jwt.MapClaims{
+		"jti": randomUuid.String(),
+		"htm": htm,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	jwtToken := jwt.NewWithClaims(key.method, claims)
+	jwtToken.Header["typ"] = dpopProofJwtType
+	jwtToken.Header["jwk"] = key.jwk
+	return jwtToken.SignedString(key.key)
+}
+
+/**
+* The htu claim is the uri of the request, without query and fragment.
+ */
+func getDpopTargetUri(request requestContext) (htu string, err error) {
+	if request.method == "" || request.targetUri == "" {
+		return htu, fmt.Errorf("%w: method and uri of the request are required", errMissingComponent)
+	}
+	targetUri, err := url.Parse(request.targetUri)
+	if err != nil || targetUri.Scheme == "" || targetUri.Host == "" {
+		return htu, fmt.Errorf("%w: invalid uri %s", errMissingComponent, request.targetUri)
+	}
+	targetUri.RawQuery = ""
+	targetUri.ForceQuery = false
+	targetUri.Fragment = ""
+	return targetUri.String(), err
+}
+
+/**
+* Read the DPoP key of the client. Rsa keys sign with RS256, ec keys of the P-256 curve with ES256.
+ */
+func getDpopKey(credentialsFolderPath string) (key dpopKey, err error) {
+	pemKey, err := globalFileAccessor.read(credentialsFolderPath + dpopKeyFile)
+	if err != nil {
+		logger.Warn("Was not able to read the dpop key.", err)
+		return key, err
+	}
+
+	if ecKey, err := jwt.ParseECPrivateKeyFromPEM(pemKey); err == nil {
+		if ecKey.Curve != elliptic.P256() {
+			return key, fmt.Errorf("%w: unsupported curve %s", errInvalidClientCredentials, ecKey.Curve.Params().Name)
+		}
+		return dpopKey{key: ecKey, method: jwt.SigningMethodES256, jwk: getEcJwk(&ecKey.PublicKey)}, nil
+	}
+	rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return key, fmt.Errorf("%w: %v", errInvalidClientCredentials, errInvalidDpopKey)
+	}
+	return dpopKey{key: rsaKey, method: jwt.SigningMethodRS256, jwk: getRsaJwk(&rsaKey.PublicKey)}, nil
+}
+
+/**
+* Public jwk of the key, as defined by RFC 7518, section 6.
+ */
+func getRsaJwk(publicKey *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+func getEcJwk(publicKey *ecdsa.PublicKey) map[string]string {
+	// coordinates are padded to the size of the curve
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC",
+		"crv": publicKey.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func (ns *dpopNonceStore) get(tokenEndpoint string) string {
+	ns.mutex.RLock()
+	defer ns.mutex.RUnlock()
+	return ns.nonces[tokenEndpoint]
+}
+
+func (ns *dpopNonceStore) put(tokenEndpoint string, nonce string) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	ns.nonces[tokenEndpoint] = nonce
+}
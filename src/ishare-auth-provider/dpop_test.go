@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+* Stand-in for an authorization server issuing DPoP-bound tokens. If a nonce is set, proofs without it are challenged.
+ */
+type standInDpopServer struct {
+	nonce     string
+	tokenType string
+	status    int
+	proofs    []jwt.MapClaims
+}
+
+func (sds *standInDpopServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := verifyDpopProof(r.Header.Get(dpopHeader))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_dpop_proof"}`))
+		return
+	}
+	sds.proofs = append(sds.proofs, claims)
+	if sds.nonce != "" && claims["nonce"] != sds.nonce {
+		w.Header().Set(dpopNonceHeader, sds.nonce)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"use_dpop_nonce","error_description":"Authorization server requires nonce in DPoP proof"}`))
+		return
+	}
+	if sds.status != 0 {
+		w.WriteHeader(sds.status)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+	w.Write([]byte(`{"access_token":"myToken","token_type":"` + sds.tokenType + `","expires_in":60}`))
+}
+
+/**
+* Verify the proof with the jwk of its header, as a resource server would.
+ */
+func verifyDpopProof(proof string) (claims jwt.MapClaims, err error) {
+	claims = jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != dpopProofJwtType {
+			return nil, errors.New("no dpop proof")
+		}
+		jwk, _ := token.Header["jwk"].(map[string]interface{})
+		decode := func(name string) *big.Int {
+			value, _ := base64.RawURLEncoding.DecodeString(jwk[name].(string))
+			return new(big.Int).SetBytes(value)
+		}
+		if jwk["kty"] == "EC" {
+			return &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode("x"), Y: decode("y")}, nil
+		}
+		return &rsa.PublicKey{N: decode("n"), E: int(decode("e").Int64())}, nil
+	})
+	return claims, err
+}
+
+func TestDpopGetRequestHeaders(t *testing.T) {
+
+	type test struct {
+		testName       string
+		keyType        string
+		serverNonce    string
+		tokenType      string
+		status         int
+		request        requestContext
+		expectedHtu    string
+		expectedProofs int
+		expectedReason string
+	}
+
+	request := requestContext{method: "post", targetUri: "https://orders.provider.org/orders?id=1#top"}
+	tests := []test{
+		{testName: "Proof with an ec key.", keyType: "EC", tokenType: "DPoP", request: request, expectedHtu: "https://orders.provider.org/orders", expectedProofs: 1},
+		{testName: "Proof with an rsa key.", keyType: "RSA", tokenType: "DPoP", request: request, expectedHtu: "https://orders.provider.org/orders", expectedProofs: 1},
+		{testName: "Retry with the nonce of the authorization server.", keyType: "EC", serverNonce: "server-nonce", tokenType: "DPoP", request: request, expectedHtu: "https://orders.provider.org/orders", expectedProofs: 2},
+		{testName: "Nonce of the resource server.", keyType: "EC", tokenType: "dpop", request: requestContext{method: "GET", targetUri: "https://orders.provider.org/", nonce: "resource-nonce"}, expectedHtu: "https://orders.provider.org/", expectedProofs: 1},
+		{testName: "Bearer token.", keyType: "EC", tokenType: "Bearer", request: request, expectedReason: reasonIdpInvalidResponse},
+		{testName: "Rejected client.", keyType: "EC", status: 401, request: request, expectedReason: reasonIdpRejectedClient},
+		{testName: "No dpop key.", request: request, expectedReason: reasonMissingCredentials},
+		{testName: "Invalid dpop key.", keyType: "invalid", request: request, expectedReason: reasonInvalidCredentials},
+		{testName: "No request context.", keyType: "EC", tokenType: "DPoP", expectedReason: reasonInvalidRequest},
+	}
+
+	oauth2CredentialsFolder = t.TempDir()
+	clientFolder := filepath.Join(oauth2CredentialsFolder, "client")
+	os.MkdirAll(clientFolder, 0700)
+	os.WriteFile(filepath.Join(clientFolder, clientSecretFile), []byte("secret"), 0600)
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalHttpClient = &http.Client{}
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		oauth2CredentialsFolder = ""
+	}()
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecKeyBytes, _ := x509.MarshalECPrivateKey(ecKey)
+	rsaKey, _ := getValidKey()
+	keys := map[string][]byte{
+		"EC":      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecKeyBytes}),
+		"RSA":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"invalid": []byte("key"),
+	}
+
+	for _, tc := range tests {
+		log.Info("TestDpopGetRequestHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		os.Remove(filepath.Join(clientFolder, dpopKeyFile))
+		if tc.keyType != "" {
+			os.WriteFile(filepath.Join(clientFolder, dpopKeyFile), keys[tc.keyType], 0600)
+		}
+		dpopTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+		dpopNonces = dpopNonceStore{nonces: map[string]string{}}
+
+		authorizationServer := &standInDpopServer{nonce: tc.serverNonce, tokenType: tc.tokenType, status: tc.status}
+		server := httptest.NewServer(authorizationServer)
+		authInfo := AuthInfo{AuthType: dpopAuthType, Config: []byte(`{"tokenEndpoint":"` + server.URL + `/token","clientId":"client"}`)}
+
+		headers, cacheLifetime, err := dpopProvider{}.getRequestHeaders(authInfo, "orders.provider.org", "/", tc.request)
+		// the token is cached, the proof is not
+		secondHeaders, _, secondErr := dpopProvider{}.getRequestHeaders(authInfo, "orders.provider.org", "/", tc.request)
+		server.Close()
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil || secondErr != nil {
+			t.Errorf("%s: No error expected, but was %v - %v.", tc.testName, err, secondErr)
+			continue
+		}
+		if cacheLifetime != 0 {
+			t.Errorf("%s: Expected the proof to not be cached, but the lifetime was %v.", tc.testName, cacheLifetime)
+		}
+		if len(headers) != 2 || headers[0].Value != "DPoP myToken" || headers[1].Name != dpopHeader {
+			t.Errorf("%s: Expected the token with a proof, but was %v.", tc.testName, headers)
+			continue
+		}
+		if len(authorizationServer.proofs) != tc.expectedProofs {
+			t.Errorf("%s: Expected %d token requests, but were %d.", tc.testName, tc.expectedProofs, len(authorizationServer.proofs))
+		}
+		tokenProof := authorizationServer.proofs[len(authorizationServer.proofs)-1]
+		if tokenProof["htm"] != "POST" || tokenProof["htu"] != server.URL+"/token" || tokenProof["ath"] != nil || tokenProof["nonce"] != nilIfEmpty(tc.serverNonce) {
+			t.Errorf("%s: Expected a proof for the token request, but was %v.", tc.testName, tokenProof)
+		}
+		if dpopNonces.get(server.URL+"/token") != tc.serverNonce {
+			t.Errorf("%s: Expected the nonce %s to be kept for the next token request.", tc.testName, tc.serverNonce)
+		}
+
+		claims, err := verifyDpopProof(headers[1].Value)
+		if err != nil {
+			t.Errorf("%s: Expected a valid proof, but was %v. Err: %v", tc.testName, headers[1].Value, err)
+			continue
+		}
+		tokenHash := sha256.Sum256([]byte("myToken"))
+		if claims["htm"] != strings.ToUpper(tc.request.method) || claims["htu"] != tc.expectedHtu || claims["ath"] != base64.RawURLEncoding.EncodeToString(tokenHash[:]) || claims["nonce"] != nilIfEmpty(tc.request.nonce) {
+			t.Errorf("%s: Expected a proof for the request, but was %v.", tc.testName, claims)
+		}
+		if iat, _ := claims["iat"].(float64); time.Since(time.Unix(int64(iat), 0)) > 2*time.Second {
+			t.Errorf("%s: Expected a fresh proof, but was issued at %v.", tc.testName, claims["iat"])
+		}
+		if secondHeaders[0].Value != headers[0].Value || secondHeaders[1].Value == headers[1].Value {
+			t.Errorf("%s: Expected the same token with a new proof, but was %v.", tc.testName, secondHeaders)
+		}
+	}
+}
+
+/**
+* Claims that are not set are nil in the parsed proof.
+ */
+func nilIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
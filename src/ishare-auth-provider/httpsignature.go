@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/**
+* Auth type of the http message signatures provider, as used in its route /HTTP_SIGNATURE/auth.
+ */
+const httpSignatureAuthType = "HTTP_SIGNATURE"
+
+/**
+* Algorithms of the HTTP Signature Algorithms registry of RFC 9421, that can be used with the rsa keys of the credentials store.
+ */
+const (
+	rsaPssSha512   = "rsa-pss-sha512"
+	rsaV15Sha256   = "rsa-v1_5-sha256"
+	signatureLabel = "sig1"
+)
+
+/**
+* Header carrying the digest of the request body, added by the filter before the signature is requested.
+ */
+const contentDigestComponent = "content-digest"
+
+var errInvalidHttpSignatureConfig = errors.New("invalid_http_signature_config")
+var errMissingComponent = errors.New("missing_component")
+
+/**
+* Derived components of RFC 9421, section 2.2, that can be covered by the signature.
+ */
+var derivedComponents = map[string]bool{"@method": true, "@target-uri": true, "@authority": true, "@scheme": true, "@path": true, "@query": true}
+
+/**
+* Configuration of an endpoint using http message signatures, read from the config of its auth info. The key is taken from the
+* credentials of the client.
+ */
+type httpSignatureConfig struct {
+	ClientId   string   `json:"clientId"`
+	KeyId      string   `json:"keyId,omitempty"`
+	Algorithm  string   `json:"algorithm,omitempty"`
+	Components []string `json:"components,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Tag        string   `json:"tag,omitempty"`
+	Expires    int64    `json:"expires,omitempty"`
+	Nonce      bool     `json:"nonce,omitempty"`
+}
+
+/**
+* Provider signing the original request as defined by RFC 9421. The signature covers the method, the target uri and the selected
+* headers of the request, thus it is created for every request and never cached.
+ */
+type httpSignatureProvider struct{}
+
+func (httpSignatureProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(httpSignatureAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getHttpSignatureConfig(authInfo); err != nil {
+		logger.Warnf("Received invalid http signature auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid http signature auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+/**
+* Without the original request, there is nothing to sign.
+ */
+func (httpSignatureProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "The auth type " + httpSignatureAuthType + " requires the method and uri of the original request."}
+}
+
+/**
+* Sign the original request with the key of the client. The headers are only valid for this request and must not be cached.
+ */
+func (httpSignatureProvider) getRequestHeaders(authInfo AuthInfo, domain string, path string, request requestContext) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getHttpSignatureConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	signatureInput, signature, err := signRequest(config, request, time.Now())
+	if errors.Is(err, errMissingComponent) {
+		logger.Infof("Was not able to sign the request to %s - %s. Err: %v", domain, path, err)
+		return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "Was not able to sign the request: " + err.Error(), err: err}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warnf("No credentials exist for %s.", config.ClientId)
+		return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonMissingCredentials, detail: "No credentials exist for client " + config.ClientId + ".", clientId: config.ClientId, err: err}
+	}
+	if err != nil {
+		logger.Warnf("Was not able to sign the request with the key of %s. Err: %v", config.ClientId, err)
+		return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonInvalidCredentials, detail: "Was not able to sign the request with the credentials of " + config.ClientId + ".", clientId: config.ClientId, err: err}
+	}
+
+	headers = HeadersList{
+		Header{Name: "Signature-Input", Value: config.Label + "=" + signatureInput},
+		Header{Name: "Signature", Value: config.Label + "=:" + signature + ":"},
+	}
+	return headers, 0, err
+}
+
+/**
+* Decode and validate the http signature configuration of the auth info. The key id defaults to the client, the algorithm to
+* rsa-pss-sha512.
+ */
+func getHttpSignatureConfig(authInfo AuthInfo) (config httpSignatureConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidHttpSignatureConfig, err)
+	}
+	if !isValidClientId(config.ClientId) {
+		return config, fmt.Errorf("%w: invalid client id %s", errInvalidHttpSignatureConfig, config.ClientId)
+	}
+	if config.KeyId == "" {
+		config.KeyId = config.ClientId
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = rsaPssSha512
+	}
+	if config.Algorithm != rsaPssSha512 && config.Algorithm != rsaV15Sha256 {
+		return config, fmt.Errorf("%w: unsupported algorithm %s", errInvalidHttpSignatureConfig, config.Algorithm)
+	}
+	if config.Label == "" {
+		config.Label = signatureLabel
+	}
+	if !isValidSignatureLabel(config.Label) {
+		return config, fmt.Errorf("%w: invalid label %s", errInvalidHttpSignatureConfig, config.Label)
+	}
+	if config.Expires < 0 {
+		return config, fmt.Errorf("%w: expires has to be positive", errInvalidHttpSignatureConfig)
+	}
+	if strings.ContainsAny(config.KeyId+config.Tag, "\"\\") {
+		return config, fmt.Errorf("%w: key id and tag must not contain quotes or backslashes", errInvalidHttpSignatureConfig)
+	}
+	for i, component := range config.Components {
+		component = strings.ToLower(component)
+		if strings.HasPrefix(component, "@") && !derivedComponents[component] {
+			return config, fmt.Errorf("%w: unsupported component %s", errInvalidHttpSignatureConfig, component)
+		}
+		if component == "" || strings.ContainsAny(component, "\" :") {
+			return config, fmt.Errorf("%w: invalid component %s", errInvalidHttpSignatureConfig, component)
+		}
+		config.Components[i] = component
+	}
+	return config, err
+}
+
+/**
+* Labels are keys of a structured field dictionary, as defined by RFC 8941.
+ */
+func isValidSignatureLabel(label string) bool {
+	if label == "" || !(label[0] == '*' || (label[0] >= 'a' && label[0] <= 'z')) {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-.*", c) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+* Sign the request, returning the signature parameters and the base64 encoded signature. Without configured components, the method,
+* the target uri and, if present, the content digest are covered.
+ */
+func signRequest(config httpSignatureConfig, request requestContext, created time.Time) (signatureInput string, signature string, err error) {
+	components := config.Components
+	if len(components) == 0 {
+		components = []string{"@method", "@target-uri"}
+		if request.headers.Get(contentDigestComponent) != "" {
+			components = append(components, contentDigestComponent)
+		}
+	}
+
+	signatureInput, err = getSignatureParams(config, components, created)
+	if err != nil {
+		return signatureInput, signature, err
+	}
+	signatureBase, err := createSignatureBase(components, request, signatureInput)
+	if err != nil {
+		return signatureInput, signature, err
+	}
+
+	key, err := authGetter.getSigningKey(buildCredentialsFolderPath(config.ClientId))
+	if err != nil {
+		return signatureInput, signature, err
+	}
+	if key == nil {
+		return signatureInput, signature, errInvalidSigningKey
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return signatureInput, signature, fmt.Errorf("%w: only rsa keys are supported", errInvalidSigningKey)
+	}
+
+	var hash crypto.Hash
+	var opts crypto.SignerOpts
+	if config.Algorithm == rsaPssSha512 {
+		hash = crypto.SHA512
+		opts = &rsa.PSSOptions{SaltLength: 64, Hash: hash}
+	} else {
+		hash = crypto.SHA256
+		opts = hash
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signatureBase))
+	signed, err := key.Sign(rand.Reader, hasher.Sum(nil), opts)
+	if err != nil {
+		return signatureInput, signature, err
+	}
+	return signatureInput, base64.StdEncoding.EncodeToString(signed), err
+}
+
+/**
+* Serialize the inner list of the covered components with the signature parameters, as defined by RFC 9421, section 2.3.
+ */
+func getSignatureParams(config httpSignatureConfig, components []string, created time.Time) (signatureParams string, err error) {
+	quoted := make([]string, len(components))
+	for i, component := range components {
+		quoted[i] = "\"" + component + "\""
+	}
+	signatureParams = "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(created.Unix(), 10)
+	if config.Expires > 0 {
+		signatureParams = signatureParams + ";expires=" + strconv.FormatInt(created.Unix()+config.Expires, 10)
+	}
+	if config.Nonce {
+		nonce, err := uuid.NewRandom()
+		if err != nil {
+			return signatureParams, err
+		}
+		signatureParams = signatureParams + ";nonce=\"" + nonce.String() + "\""
+	}
+	signatureParams = signatureParams + ";keyid=\"" + config.KeyId + "\";alg=\"" + config.Algorithm + "\""
+	if config.Tag != "" {
+		signatureParams = signatureParams + ";tag=\"" + config.Tag + "\""
+	}
+	return signatureParams, err
+}
+
+/**
+* Create the signature base of the request, as defined by RFC 9421, section 2.5. Every component has to be present in the request.
+ */
+func createSignatureBase(components []string, request requestContext, signatureParams string) (signatureBase string, err error) {
+	if request.method == "" || request.targetUri == "" {
+		return signatureBase, fmt.Errorf("%w: method and uri of the request are required", errMissingComponent)
+	}
+	targetUri, err := url.Parse(request.targetUri)
+	if err != nil || !targetUri.IsAbs() {
+		return signatureBase, fmt.Errorf("%w: invalid target uri %s", errMissingComponent, request.targetUri)
+	}
+
+	var lines []string
+	for _, component := range components {
+		value, err := getComponentValue(component, request, targetUri)
+		if err != nil {
+			return signatureBase, err
+		}
+		lines = append(lines, "\""+component+"\": "+value)
+	}
+	lines = append(lines, "\"@signature-params\": "+signatureParams)
+	return strings.Join(lines, "\n"), err
+}
+
+func getComponentValue(component string, request requestContext, targetUri *url.URL) (value string, err error) {
+	switch component {
+	case "@method":
+		return strings.ToUpper(request.method), err
+	case "@target-uri":
+		return request.targetUri, err
+	case "@authority":
+		return strings.ToLower(targetUri.Host), err
+	case "@scheme":
+		return strings.ToLower(targetUri.Scheme), err
+	case "@path":
+		if targetUri.EscapedPath() == "" {
+			return "/", err
+		}
+		return targetUri.EscapedPath(), err
+	case "@query":
+		return "?" + targetUri.RawQuery, err
+	}
+
+	values := request.headers.Values(component)
+	if len(values) == 0 {
+		return value, fmt.Errorf("%w: the request has no header %s", errMissingComponent, component)
+	}
+	trimmed := make([]string, len(values))
+	for i, headerValue := range values {
+		trimmed[i] = strings.TrimSpace(headerValue)
+	}
+	return strings.Join(trimmed, ", "), err
+}
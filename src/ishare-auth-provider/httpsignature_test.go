@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetHttpSignatureConfig(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedConfig httpSignatureConfig
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Defaults.", config: `{"clientId":"client"}`,
+			expectedConfig: httpSignatureConfig{ClientId: "client", KeyId: "client", Algorithm: rsaPssSha512, Label: signatureLabel}},
+		{testName: "Configured signature.", config: `{"clientId":"client","keyId":"key","algorithm":"rsa-v1_5-sha256","components":["@Method","@authority","Content-Type"],"label":"partner","tag":"app","expires":300,"nonce":true}`,
+			expectedConfig: httpSignatureConfig{ClientId: "client", KeyId: "key", Algorithm: rsaV15Sha256, Components: []string{"@method", "@authority", "content-type"}, Label: "partner", Tag: "app", Expires: 300, Nonce: true}},
+		{testName: "Unsupported algorithm.", config: `{"clientId":"client","algorithm":"ecdsa-p256-sha256"}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Unsupported derived component.", config: `{"clientId":"client","components":["@request-response"]}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Invalid component.", config: `{"clientId":"client","components":["content type"]}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Invalid label.", config: `{"clientId":"client","label":"Sig"}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Quoted key id.", config: `{"clientId":"client","keyId":"my\"key"}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Negative expiry.", config: `{"clientId":"client","expires":-1}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "Client id outside of the folder.", config: `{"clientId":".."}`, expectedError: errInvalidHttpSignatureConfig},
+		{testName: "No config.", expectedError: errInvalidHttpSignatureConfig},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetHttpSignatureConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getHttpSignatureConfig(AuthInfo{AuthType: httpSignatureAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && fmt.Sprint(config) != fmt.Sprint(tc.expectedConfig) {
+			t.Errorf("%s: Expected config %v but was %v.", tc.testName, tc.expectedConfig, config)
+		}
+	}
+}
+
+func TestCreateSignatureBase(t *testing.T) {
+
+	type test struct {
+		testName      string
+		components    []string
+		method        string
+		targetUri     string
+		headers       http.Header
+		expectedBase  string
+		expectedError error
+	}
+
+	params := `("@method");created=1618884473;keyid="client";alg="rsa-pss-sha512"`
+	tests := []test{
+		{testName: "Derived components.", components: []string{"@method", "@target-uri", "@authority", "@scheme", "@path", "@query"}, method: "post", targetUri: "https://Example.com/foo?param=Value&Pet=dog",
+			expectedBase: "\"@method\": POST\n\"@target-uri\": https://Example.com/foo?param=Value&Pet=dog\n\"@authority\": example.com\n\"@scheme\": https\n\"@path\": /foo\n\"@query\": ?param=Value&Pet=dog\n\"@signature-params\": " + params},
+		{testName: "Empty path and query.", components: []string{"@path", "@query"}, method: "GET", targetUri: "https://example.com",
+			expectedBase: "\"@path\": /\n\"@query\": ?\n\"@signature-params\": " + params},
+		{testName: "Headers are combined and trimmed.", components: []string{"content-digest", "x-custom"}, method: "GET", targetUri: "https://example.com/",
+			headers:      http.Header{"Content-Digest": {"sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"}, "X-Custom": {" a ", "b"}},
+			expectedBase: "\"content-digest\": sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:\n\"x-custom\": a, b\n\"@signature-params\": " + params},
+		{testName: "Missing header.", components: []string{"content-digest"}, method: "GET", targetUri: "https://example.com/", expectedError: errMissingComponent},
+		{testName: "No method.", components: []string{"@method"}, targetUri: "https://example.com/", expectedError: errMissingComponent},
+		{testName: "Relative uri.", components: []string{"@method"}, method: "GET", targetUri: "/foo", expectedError: errMissingComponent},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestCreateSignatureBase +++++++++++++++++++++ Running test: " + tc.testName)
+
+		signatureBase, err := createSignatureBase(tc.components, requestContext{method: tc.method, targetUri: tc.targetUri, headers: tc.headers}, params)
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && signatureBase != tc.expectedBase {
+			t.Errorf("%s: Expected signature base \n%s\n but was \n%s", tc.testName, tc.expectedBase, signatureBase)
+		}
+	}
+}
+
+func TestHttpSignatureGetRequestHeaders(t *testing.T) {
+
+	type test struct {
+		testName         string
+		config           string
+		request          requestContext
+		mockKeyReadError error
+		expectedReason   string
+		expectedInput    string
+		expectedBase     string
+	}
+
+	request := requestContext{method: "POST", targetUri: "https://api.org/orders?id=1", headers: http.Header{"Content-Digest": {"sha-256=:digest=:"}, "Content-Type": {"application/json"}}}
+	tests := []test{
+		{testName: "Default components with digest.", config: `{"clientId":"client"}`, request: request,
+			expectedInput: `sig1=("@method" "@target-uri" "content-digest");created=%d;keyid="client";alg="rsa-pss-sha512"`,
+			expectedBase:  "\"@method\": POST\n\"@target-uri\": https://api.org/orders?id=1\n\"content-digest\": sha-256=:digest=:\n\"@signature-params\": "},
+		{testName: "Default components without digest.", config: `{"clientId":"client"}`, request: requestContext{method: "GET", targetUri: "https://api.org/orders"},
+			expectedInput: `sig1=("@method" "@target-uri");created=%d;keyid="client";alg="rsa-pss-sha512"`,
+			expectedBase:  "\"@method\": GET\n\"@target-uri\": https://api.org/orders\n\"@signature-params\": "},
+		{testName: "Configured components.", config: `{"clientId":"client","keyId":"key","algorithm":"rsa-v1_5-sha256","components":["@authority","content-type"],"label":"partner","tag":"app","expires":60}`, request: request,
+			expectedInput: `partner=("@authority" "content-type");created=%d;expires=%d;keyid="key";alg="rsa-v1_5-sha256";tag="app"`,
+			expectedBase:  "\"@authority\": api.org\n\"content-type\": application/json\n\"@signature-params\": "},
+		{testName: "Missing header.", config: `{"clientId":"client","components":["authorization"]}`, request: request, expectedReason: reasonInvalidRequest},
+		{testName: "No request context.", config: `{"clientId":"client"}`, expectedReason: reasonInvalidRequest},
+		{testName: "Missing credentials.", config: `{"clientId":"client"}`, request: request, mockKeyReadError: fs.ErrNotExist, expectedReason: reasonMissingCredentials},
+		{testName: "Invalid credentials.", config: `{"clientId":"client"}`, request: request, mockKeyReadError: errors.New("parse_error"), expectedReason: reasonInvalidCredentials},
+	}
+
+	validKey, _ := getValidKey()
+
+	for _, tc := range tests {
+		log.Info("TestHttpSignatureGetRequestHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		authGetter = &mockAuthGetter{mockKey: validKey, keyGetError: tc.mockKeyReadError}
+		authInfo := AuthInfo{AuthType: httpSignatureAuthType, Config: json.RawMessage(tc.config)}
+
+		created := time.Now().Unix()
+		headers, cacheLifetime, err := httpSignatureProvider{}.getRequestHeaders(authInfo, "test.domain", "/", tc.request)
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if cacheLifetime != 0 {
+			t.Errorf("%s: Expected the signature to not be cached, but the lifetime was %v.", tc.testName, cacheLifetime)
+		}
+		if len(headers) != 2 || headers[0].Name != "Signature-Input" || headers[1].Name != "Signature" {
+			t.Errorf("%s: Expected the signature headers, but was %v.", tc.testName, headers)
+			continue
+		}
+		// the second might have passed during signing
+		var signedAt int64
+		fmt.Sscanf(headers[0].Value[strings.Index(headers[0].Value, ";created=")+len(";created="):], "%d", &signedAt)
+		if signedAt != created && signedAt != created+1 {
+			t.Errorf("%s: Expected the signature to be created at %d, but was %d.", tc.testName, created, signedAt)
+		}
+		expectedInput := fmt.Sprintf(tc.expectedInput, signedAt, signedAt+60)
+		if strings.Count(tc.expectedInput, "%d") == 1 {
+			expectedInput = fmt.Sprintf(tc.expectedInput, signedAt)
+		}
+		if headers[0].Value != expectedInput {
+			t.Errorf("%s: Expected signature input %s but was %s.", tc.testName, expectedInput, headers[0].Value)
+			continue
+		}
+
+		// verify the signature over the expected base
+		label := strings.SplitN(headers[0].Value, "=", 2)
+		signatureBase := tc.expectedBase + label[1]
+		encodedSignature := strings.TrimSuffix(strings.TrimPrefix(headers[1].Value, label[0]+"=:"), ":")
+		signature, _ := base64.StdEncoding.DecodeString(encodedSignature)
+		if strings.Contains(headers[0].Value, rsaPssSha512) {
+			hash := sha512.Sum512([]byte(signatureBase))
+			err = rsa.VerifyPSS(&validKey.PublicKey, crypto.SHA512, hash[:], signature, &rsa.PSSOptions{SaltLength: 64})
+		} else {
+			hash := sha256.Sum256([]byte(signatureBase))
+			err = rsa.VerifyPKCS1v15(&validKey.PublicKey, crypto.SHA256, hash[:], signature)
+		}
+		if err != nil {
+			t.Errorf("%s: Expected a valid signature over \n%s\n but was %v.", tc.testName, signatureBase, err)
+		}
+	}
+}
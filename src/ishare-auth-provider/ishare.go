@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+/**
+* Auth type of the iSHARE provider, as used in its route /ISHARE/auth.
+ */
+const iShareAuthType = "ISHARE"
+
+/**
+* Ishare tokens are defined to expire after max 30s. Thus, they should be cached for a little less time.
+ */
+const iShareCacheLifetime = 25 * time.Second
+
+/**
+* Provider requesting iSHARE access tokens from the idp of the endpoint, using a client assertion signed with the stored
+* credentials of the client. Optionally, delegation evidence is added.
+ */
+type iShareProvider struct{}
+
+func (iShareProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(iShareAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	// the clientId names the credentials folder, thus it must not leave the credentials folder
+	if !isValidClientId(authInfo.IShareClientID) {
+		logger.Warnf("Received auth-info without a valid clientId for %s - %s.", domain, path)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received auth info without a valid clientId from the config-service."}
+	}
+	err = validateOverrides(authInfo)
+	if err != nil {
+		logger.Warnf("Received invalid auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid auth info from the config-service: " + err.Error(), clientId: authInfo.IShareClientID, err: err}
+	}
+	return authInfo, err
+}
+
+func (iShareProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+
+	// all problems relate to the client
+	fail := func(status int, reason string, detail string, err error) error {
+		return &authFailure{status: status, reason: reason, detail: detail, clientId: authInfo.IShareClientID, err: err}
+	}
+
+	// the files are stored in folders namend by the clientId
+	credentialsFolderPath := buildCredentialsFolderPath(authInfo.IShareClientID)
+
+	logger.Info("CredentialsFolderPath: " + credentialsFolderPath)
+
+	var party PartyInfo
+	if satelliteUrl != "" {
+		party, err = getTrustedParty(authInfo, credentialsFolderPath)
+		if err != nil {
+			logger.Warnf("The idp %s is not a trusted party. Err: %v", authInfo.IShareIdpID, err)
+			return headers, cacheLifetime, fail(http.StatusBadGateway, reasonIdpNotTrusted, "The idp is not a trusted party: "+err.Error(), err)
+		}
+	}
+
+	signedToken, err := createClientAssertion(authInfo.IShareClientID, authInfo.IShareIdpID, credentialsFolderPath, authInfo.AdditionalClaims)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warnf("No credentials exist for %s.", authInfo.IShareClientID)
+		return headers, cacheLifetime, fail(http.StatusInternalServerError, reasonMissingCredentials, "No credentials exist for client "+authInfo.IShareClientID+".", err)
+	}
+	if err != nil {
+		logger.Warn("Was not able to create the client assertion.", err)
+		return headers, cacheLifetime, fail(http.StatusInternalServerError, reasonInvalidCredentials, "Was not able to create the client assertion with the credentials of "+authInfo.IShareClientID+".", err)
+	}
+
+	scope := authInfo.Scope
+	if scope == "" {
+		scope = defaultScope
+	}
+
+	// prepare the form-body
+	data := url.Values{
+		"grant_type":            {authInfo.RequestGrantType},
+		"scope":                 {scope},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {signedToken},
+		"client_id":             {authInfo.IShareClientID},
+	}
+	for name, value := range authInfo.AdditionalParameters {
+		data.Set(name, value)
+	}
+
+	// get the token
+	res, err := requestToken(authInfo.IShareIdpAddress, authInfo.IShareIdpID, data)
+	if errors.Is(err, errIdpRejectedClient) {
+		logger.Warnf("The idp rejected %s. Err: %v", authInfo.IShareClientID, err)
+		return headers, cacheLifetime, fail(http.StatusForbidden, reasonIdpRejectedClient, "The idp rejected the client: "+err.Error(), err)
+	}
+	if errors.Is(err, errIdpUnavailable) {
+		logger.Warn("The idp is not available.", err)
+		return headers, cacheLifetime, fail(http.StatusServiceUnavailable, reasonIdpUnavailable, "The idp is not available: "+err.Error(), err)
+	}
+	if err != nil {
+		logger.Warn("Was not able to get the token from the idp.", err)
+		return headers, cacheLifetime, fail(http.StatusBadGateway, reasonIdpInvalidResponse, "Did not receive a valid token response from the idp.", err)
+	}
+
+	if tokenVerificationEnabled {
+		err = verifyAccessToken(res, authInfo)
+		if err != nil {
+			logger.Warnf("The access token from the idp failed verification. Err: %v", err)
+			return headers, cacheLifetime, fail(http.StatusBadGateway, reasonTokenVerificationFailed, "The access token from the idp failed verification: "+err.Error(), err)
+		}
+	}
+
+	if satelliteUrl != "" {
+		err = verifyPartyCertificate(res["access_token"].(string), party)
+		if err != nil {
+			logger.Warnf("The access token was not signed by a certificate of %s. Err: %v", authInfo.IShareIdpID, err)
+			return headers, cacheLifetime, fail(http.StatusBadGateway, reasonIdpNotTrusted, "The access token was not signed by a certificate of the idp: "+err.Error(), err)
+		}
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + res["access_token"].(string)}}
+	cacheLifetime = iShareCacheLifetime
+
+	if authInfo.AuthorizationRegistryAddress != "" {
+		delegationHeader, expiry, err := getDelegationHeader(authInfo, domain, path, credentialsFolderPath)
+		if err != nil {
+			logger.Warnf("Was not able to get the delegation evidence from %s. Err: %v", authInfo.AuthorizationRegistryID, err)
+			return headers, cacheLifetime, fail(http.StatusBadGateway, reasonDelegationFailed, "Was not able to get the delegation evidence from the authorization registry: "+err.Error(), err)
+		}
+		headers = append(headers, delegationHeader)
+		// the evidence must not be used after its validity
+		if validity := time.Until(expiry); validity < cacheLifetime {
+			cacheLifetime = validity
+		}
+	}
+	return headers, cacheLifetime, err
+}
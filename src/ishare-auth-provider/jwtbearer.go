@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+/**
+* Auth type of the JWT-bearer provider, as used in its route /JWT_BEARER/auth.
+ */
+const jwtBearerAuthType = "JWT_BEARER"
+
+/**
+* Grant type of RFC 7523, section 2.1. The assertion itself is the authorization grant.
+ */
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+/**
+* Headers to reference the certificate of the signing key, as defined by RFC 7515.
+ */
+const (
+	x5cHeader       = "x5c"
+	x5tS256Header   = "x5t#S256"
+	noCertificateId = "none"
+)
+
+var errInvalidJwtBearerConfig = errors.New("invalid_jwt_bearer_config")
+
+/**
+* Configuration of an endpoint using JWT-bearer assertions, read from the config of its auth info. The key and certificate are taken
+* from the credentials of the client.
+ */
+type jwtBearerConfig struct {
+	TokenEndpoint        string   `json:"tokenEndpoint"`
+	ClientId             string   `json:"clientId"`
+	GrantType            string   `json:"grantType,omitempty"`
+	Issuer               string   `json:"issuer,omitempty"`
+	Subject              string   `json:"subject,omitempty"`
+	Audience             string   `json:"audience,omitempty"`
+	Scopes               []string `json:"scopes,omitempty"`
+	KeyId                string   `json:"keyId,omitempty"`
+	KeyIdFromCertificate bool     `json:"keyIdFromCertificate,omitempty"`
+	CertificateHeader    string   `json:"certificateHeader,omitempty"`
+}
+
+/**
+* Headers identifying the key an assertion is signed with.
+ */
+type assertionKeyHeaders struct {
+	keyId                string
+	keyIdFromCertificate bool
+	certificateHeader    string
+}
+
+/**
+* Tokens of the JWT-bearer provider, invalidated when the credentials of their client change.
+ */
+var jwtBearerCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Provider requesting access tokens with an assertion signed by the stored credentials of the client, either as authorization grant
+* or as client assertion of the client credentials grant. Tokens are cached based on their expires_in.
+ */
+type jwtBearerProvider struct{}
+
+func (jwtBearerProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(jwtBearerAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getJwtBearerConfig(authInfo); err == nil {
+		err = validateOverrides(authInfo)
+	}
+	if err != nil {
+		logger.Warnf("Received invalid jwt-bearer auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid jwt-bearer auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+func (jwtBearerProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getJwtBearerConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	cacheKey := getJwtBearerCacheKey(config, authInfo.AdditionalClaims)
+	token, expiry, cached := jwtBearerCache.get(cacheKey)
+	if !cached {
+		token, expiry, err = requestJwtBearerToken(config, authInfo.AdditionalClaims)
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, err)
+		}
+		jwtBearerCache.put(cacheKey, config.ClientId, token, expiry)
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + token}}
+	return headers, time.Until(expiry), err
+}
+
+/**
+* Decode and validate the jwt-bearer configuration of the auth info. Issuer and subject default to the client, the audience to
+* the token endpoint.
+ */
+func getJwtBearerConfig(authInfo AuthInfo) (config jwtBearerConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidJwtBearerConfig, err)
+	}
+	if config.TokenEndpoint == "" || config.ClientId == "" {
+		return config, fmt.Errorf("%w: token endpoint and client id are required", errInvalidJwtBearerConfig)
+	}
+	if !isValidClientId(config.ClientId) {
+		return config, fmt.Errorf("%w: invalid client id %s", errInvalidJwtBearerConfig, config.ClientId)
+	}
+	if config.GrantType == "" {
+		config.GrantType = jwtBearerGrantType
+	}
+	if config.GrantType != jwtBearerGrantType && config.GrantType != "client_credentials" {
+		return config, fmt.Errorf("%w: unsupported grant type %s", errInvalidJwtBearerConfig, config.GrantType)
+	}
+	if config.CertificateHeader == "" {
+		config.CertificateHeader = x5cHeader
+	}
+	if config.CertificateHeader != x5cHeader && config.CertificateHeader != x5tS256Header && config.CertificateHeader != noCertificateId {
+		return config, fmt.Errorf("%w: unsupported certificate header %s", errInvalidJwtBearerConfig, config.CertificateHeader)
+	}
+	if config.KeyId != "" && config.KeyIdFromCertificate {
+		return config, fmt.Errorf("%w: either a key id or its derivation from the certificate can be configured", errInvalidJwtBearerConfig)
+	}
+	if config.Issuer == "" {
+		config.Issuer = config.ClientId
+	}
+	if config.Subject == "" {
+		config.Subject = config.ClientId
+	}
+	if config.Audience == "" {
+		config.Audience = config.TokenEndpoint
+	}
+	return config, err
+}
+
+/**
+* Request a token with an assertion of the client. For the jwt-bearer grant, the assertion is the grant, for client credentials it
+* authenticates the client. The additional claims are added to the assertion, they are expected to be validated already.
+ */
+func requestJwtBearerToken(config jwtBearerConfig, additionalClaims map[string]interface{}) (token string, expiry time.Time, err error) {
+	randomUuid, err := uuid.NewRandom()
+	if err != nil {
+		return token, expiry, err
+	}
+	claims := jwt.MapClaims{}
+	for name, value := range additionalClaims {
+		claims[name] = value
+	}
+	claims["jti"] = randomUuid.String()
+	claims["iss"] = config.Issuer
+	claims["sub"] = config.Subject
+	claims["aud"] = config.Audience
+	setAssertionTimes(claims, config.Audience)
+
+	keyHeaders := assertionKeyHeaders{keyId: config.KeyId, keyIdFromCertificate: config.KeyIdFromCertificate, certificateHeader: config.CertificateHeader}
+	assertion, err := signAssertion(claims, buildCredentialsFolderPath(config.ClientId), keyHeaders)
+	if errors.Is(err, fs.ErrNotExist) {
+		return token, expiry, err
+	}
+	if err != nil {
+		return token, expiry, fmt.Errorf("%w: %v", errInvalidClientCredentials, err)
+	}
+
+	data := url.Values{"grant_type": {config.GrantType}}
+	if config.GrantType == jwtBearerGrantType {
+		data.Set("assertion", assertion)
+	} else {
+		data.Set("client_id", config.ClientId)
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	}
+	if len(config.Scopes) > 0 {
+		data.Set("scope", strings.Join(config.Scopes, " "))
+	}
+
+	requestStart := time.Now()
+	res, err := requestToken(config.TokenEndpoint, config.Audience, data)
+	if err != nil {
+		return token, expiry, err
+	}
+
+	token = res["access_token"].(string)
+	expiry = requestStart
+	if expiresIn, ok := res["expires_in"].(float64); ok {
+		expiry = requestStart.Add(time.Duration(expiresIn)*time.Second - oauth2ExpiryMargin)
+	}
+	return token, expiry, err
+}
+
+/**
+* Sign the claims with the key of the credentials folder. The certificate is added to the headers as configured, the key id is
+* either set explicitly or derived from the certificate thumbprint.
+ */
+func signAssertion(claims jwt.MapClaims, credentialsFolderPath string, keyHeaders assertionKeyHeaders) (signedToken string, err error) {
+	jwtToken := jwt.NewWithClaims(signingMethodSignerRS256, claims)
+
+	key, err := authGetter.getSigningKey(credentialsFolderPath)
+	if err != nil {
+		logger.Warn("Was not able to read the signing key.")
+		return signedToken, err
+	}
+	if key == nil {
+		logger.Warn("Was not able to read a valid signing key.")
+		return signedToken, errInvalidSigningKey
+	}
+
+	if keyHeaders.certificateHeader != noCertificateId || keyHeaders.keyIdFromCertificate {
+		cert, err := authGetter.getCertificate(credentialsFolderPath)
+		if err != nil {
+			logger.Warn("Was not able to read the certificate.")
+			return signedToken, err
+		}
+		if keyHeaders.certificateHeader == x5cHeader {
+			jwtToken.Header[x5cHeader] = [1]string{cert}
+		}
+		if keyHeaders.certificateHeader == x5tS256Header || keyHeaders.keyIdFromCertificate {
+			thumbprint, err := getCertificateThumbprint(cert)
+			if err != nil {
+				logger.Warn("Was not able to calculate the certificate thumbprint.")
+				return signedToken, err
+			}
+			if keyHeaders.certificateHeader == x5tS256Header {
+				jwtToken.Header[x5tS256Header] = thumbprint
+			}
+			if keyHeaders.keyIdFromCertificate {
+				jwtToken.Header["kid"] = thumbprint
+			}
+		}
+	}
+	if keyHeaders.keyId != "" {
+		jwtToken.Header["kid"] = keyHeaders.keyId
+	}
+
+	// sign the token
+	signedToken, err = jwtToken.SignedString(key)
+	if err != nil {
+		logger.Warn("Was not able to sign the jwt.", err)
+	}
+	return signedToken, err
+}
+
+/**
+* Get the base64url encoded SHA-256 thumbprint of the (base64 encoded) certificate, as used for x5t#S256.
+ */
+func getCertificateThumbprint(encodedCert string) (thumbprint string, err error) {
+	der, err := base64.StdEncoding.DecodeString(encodedCert)
+	if err != nil {
+		return thumbprint, fmt.Errorf("%w: %v", errCertDecode, err)
+	}
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), err
+}
+
+func getJwtBearerCacheKey(config jwtBearerConfig, additionalClaims map[string]interface{}) string {
+	// maps are encoded with sorted keys
+	encodedClaims, _ := json.Marshal(additionalClaims)
+	hash := sha256.Sum256([]byte(config.TokenEndpoint + "|" + config.ClientId + "|" + config.GrantType + "|" + config.Issuer + "|" + config.Subject + "|" + config.Audience + "|" + strings.Join(config.Scopes, " ") + "|" + string(encodedClaims)))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetJwtBearerConfig(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedConfig jwtBearerConfig
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Defaults.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client"}`,
+			expectedConfig: jwtBearerConfig{TokenEndpoint: "https://idp.org/token", ClientId: "client", GrantType: jwtBearerGrantType, Issuer: "client", Subject: "client", Audience: "https://idp.org/token", CertificateHeader: x5cHeader}},
+		{testName: "Configured claims.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","grantType":"client_credentials","issuer":"iss","subject":"sub","audience":"https://idp.org","certificateHeader":"x5t#S256"}`,
+			expectedConfig: jwtBearerConfig{TokenEndpoint: "https://idp.org/token", ClientId: "client", GrantType: "client_credentials", Issuer: "iss", Subject: "sub", Audience: "https://idp.org", CertificateHeader: x5tS256Header}},
+		{testName: "Unsupported grant type.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","grantType":"password"}`, expectedError: errInvalidJwtBearerConfig},
+		{testName: "Unsupported certificate header.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","certificateHeader":"x5u"}`, expectedError: errInvalidJwtBearerConfig},
+		{testName: "Key id and derived key id.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","keyId":"key","keyIdFromCertificate":true}`, expectedError: errInvalidJwtBearerConfig},
+		{testName: "No token endpoint.", config: `{"clientId":"client"}`, expectedError: errInvalidJwtBearerConfig},
+		{testName: "Client id outside of the folder.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":".."}`, expectedError: errInvalidJwtBearerConfig},
+		{testName: "No config.", expectedError: errInvalidJwtBearerConfig},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetJwtBearerConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getJwtBearerConfig(AuthInfo{AuthType: jwtBearerAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && fmt.Sprint(config) != fmt.Sprint(tc.expectedConfig) {
+			t.Errorf("%s: Expected config %v but was %v.", tc.testName, tc.expectedConfig, config)
+		}
+	}
+}
+
+func TestJwtBearerGetHeaders(t *testing.T) {
+
+	type test struct {
+		testName         string
+		config           string
+		mockKeyReadError error
+		status           int
+		response         string
+		expectedReason   string
+		expectedForm     map[string]string
+		expectedHeaders  map[string]interface{}
+		expectedClaims   map[string]interface{}
+	}
+
+	cert := base64.StdEncoding.EncodeToString([]byte("myCert"))
+	certHash := sha256.Sum256([]byte("myCert"))
+	thumbprint := base64.RawURLEncoding.EncodeToString(certHash[:])
+
+	tokenResponse := `{"access_token":"myToken","token_type":"Bearer","expires_in":60}`
+	tests := []test{
+		{testName: "Jwt-bearer grant with x5c.", config: `{"clientId":"client","scopes":["read"]}`, response: tokenResponse,
+			expectedForm:    map[string]string{"grant_type": jwtBearerGrantType, "scope": "read", "client_assertion": ""},
+			expectedHeaders: map[string]interface{}{"x5c": []interface{}{cert}, "kid": nil},
+			expectedClaims:  map[string]interface{}{"iss": "client", "sub": "client", "custom": "claim"}},
+		{testName: "Client assertion with thumbprint.", config: `{"clientId":"client","grantType":"client_credentials","issuer":"iss","subject":"sub","audience":"https://idp.org","certificateHeader":"x5t#S256","keyIdFromCertificate":true}`, response: tokenResponse,
+			expectedForm:    map[string]string{"grant_type": "client_credentials", "client_id": "client", "client_assertion_type": clientAssertionType, "assertion": ""},
+			expectedHeaders: map[string]interface{}{"x5t#S256": thumbprint, "kid": thumbprint, "x5c": nil},
+			expectedClaims:  map[string]interface{}{"iss": "iss", "sub": "sub", "aud": "https://idp.org"}},
+		{testName: "Configured key id without certificate.", config: `{"clientId":"client","certificateHeader":"none","keyId":"myKey"}`, response: tokenResponse,
+			expectedHeaders: map[string]interface{}{"kid": "myKey", "x5c": nil}},
+		{testName: "Missing credentials.", config: `{"clientId":"client"}`, mockKeyReadError: fs.ErrNotExist, expectedReason: reasonMissingCredentials},
+		{testName: "Invalid credentials.", config: `{"clientId":"client"}`, mockKeyReadError: errors.New("parse_error"), expectedReason: reasonInvalidCredentials},
+		{testName: "Rejected client.", config: `{"clientId":"client"}`, status: 400, response: `{"error":"invalid_grant"}`, expectedReason: reasonIdpRejectedClient},
+		{testName: "Unavailable token endpoint.", config: `{"clientId":"client"}`, status: 500, response: ``, expectedReason: reasonIdpUnavailable},
+	}
+
+	validKey, _ := getValidKey()
+	globalHttpClient = &http.Client{}
+
+	for _, tc := range tests {
+		log.Info("TestJwtBearerGetHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		authGetter = &mockAuthGetter{mockKey: validKey, mockCert: cert, keyGetError: tc.mockKeyReadError}
+		jwtBearerCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+		endpoint := &standInTokenEndpoint{status: tc.status, response: tc.response}
+		server := httptest.NewServer(endpoint)
+		var config map[string]interface{}
+		json.Unmarshal([]byte(tc.config), &config)
+		config["tokenEndpoint"] = server.URL
+		encodedConfig, _ := json.Marshal(config)
+		authInfo := AuthInfo{AuthType: jwtBearerAuthType, Config: encodedConfig, AdditionalClaims: map[string]interface{}{"custom": "claim"}}
+
+		headers, cacheLifetime, err := jwtBearerProvider{}.getHeaders(authInfo, "test.domain", "/")
+		// a second request is served from the cache
+		jwtBearerProvider{}.getHeaders(authInfo, "test.domain", "/")
+		server.Close()
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason || failure.clientId != "client" {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if len(headers) != 1 || headers[0].Value != "Bearer myToken" {
+			t.Errorf("%s: Expected the bearer token, but was %v.", tc.testName, headers)
+		}
+		if cacheLifetime > 55*time.Second || cacheLifetime < 53*time.Second {
+			t.Errorf("%s: Expected a cache lifetime of 55s, but was %v.", tc.testName, cacheLifetime)
+		}
+		if endpoint.requests != 1 {
+			t.Errorf("%s: Expected the token to be cached, but %d requests were sent.", tc.testName, endpoint.requests)
+		}
+		for name, value := range tc.expectedForm {
+			if endpoint.form[name] != value {
+				t.Errorf("%s: Expected form parameter %s=%s, but was %s.", tc.testName, name, value, endpoint.form[name])
+			}
+		}
+
+		assertion := endpoint.form["assertion"]
+		if assertion == "" {
+			assertion = endpoint.form["client_assertion"]
+		}
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) { return &validKey.PublicKey, nil })
+		if err != nil {
+			t.Errorf("%s: Expected a valid assertion, but was %v. Err: %v", tc.testName, assertion, err)
+			continue
+		}
+		if _, ok := tc.expectedClaims["aud"]; !ok && claims["aud"] != server.URL {
+			t.Errorf("%s: Expected the token endpoint as audience, but was %v.", tc.testName, claims["aud"])
+		}
+		for name, value := range tc.expectedClaims {
+			if fmt.Sprint(claims[name]) != fmt.Sprint(value) {
+				t.Errorf("%s: Expected claim %s=%v, but was %v.", tc.testName, name, value, claims[name])
+			}
+		}
+		for name, value := range tc.expectedHeaders {
+			if fmt.Sprint(token.Header[name]) != fmt.Sprint(value) {
+				t.Errorf("%s: Expected header %s=%v, but was %v.", tc.testName, name, value, token.Header[name])
+			}
+		}
+	}
+}
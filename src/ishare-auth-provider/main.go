@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/x509"
+	"expvar"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -8,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -28,6 +32,46 @@ var credentialsBaseFolder string
  */
 var configurationServiceUrl string
 
+/**
+* File mapping endpoints to their auth info. If set, the configuration service is only asked for unmapped endpoints.
+ */
+var authInfoFile string
+
+/**
+* Should the tokens returned by the idp be verified before handing them out.
+ */
+var tokenVerificationEnabled bool
+
+/**
+* Certificates to be trusted when verifying the idp tokens.
+ */
+var idpTrustPool *x509.CertPool
+
+/**
+* Optional jwks endpoint to retrieve the idp keys from.
+ */
+var idpJwksUrl string
+
+/**
+* Address of the iShare satellite to verify the idps at. Verification is disabled if empty.
+ */
+var satelliteUrl string
+
+/**
+* iShare id of the satellite.
+ */
+var satelliteId string
+
+/**
+* Certificates to be trusted when verifying the party tokens of the satellite.
+ */
+var satelliteTrustPool *x509.CertPool
+
+/**
+* Time to cache the party information received from the satellite.
+ */
+var satelliteCacheTtl = 5 * time.Minute
+
 /**
 * Global filesystem accessor
  */
@@ -54,18 +98,70 @@ var globalHttpClient httpClient = &http.Client{}
 func main() {
 
 	router := gin.Default()
+	// requests are validated against the api documents, the admin api authenticates them first
+	api := router.Group("", limitRequestBody(maxRequestBodySize), validateRequest)
 	// auth api
-	router.GET("/ISHARE/auth", getAuth)
+	api.GET("/:authType/auth", getAuth)
+	api.GET("/:authType/version", getAuthVersion)
 
 	// credentials management api
-	router.GET("/credentials", getCredentialsList)
-	router.DELETE("/credentials/:clientId", deleteCredentials)
-	router.POST("/credentials/:clientId", postCredentials)
-	router.PUT("/credentials/:clientId/certificateChain", putCertificateChain)
-	router.PUT("/credentials/:clientId/signingKey", putSigningKey)
+	credentials := api.Group("/credentials", requireValidClientId)
+	credentials.GET("", getCredentialsList)
+	credentials.GET("/:clientId", getCredentials)
+	credentials.DELETE("/:clientId", deleteCredentials)
+	credentials.POST("/:clientId", postCredentials)
+	credentials.PUT("/:clientId/certificateChain", putCertificateChain)
+	credentials.PUT("/:clientId/signingKey", putSigningKey)
+	credentials.PUT("/:clientId/pkcs11", putPkcs11Reference)
+	credentials.DELETE("/:clientId/pkcs11", deletePkcs11Reference)
+	credentials.POST("/:clientId/csr", postCsr)
+	credentials.PUT("/:clientId/csr/certificateChain", putCsrCertificateChain)
+
+	// oauth2 credentials management api
+	oauth2 := api.Group("/oauth2/credentials", requireOAuth2CredentialsFolder)
+	oauth2.GET("", getOAuth2CredentialsList)
+	oauth2.POST("/:clientId", postOAuth2Credentials)
+	oauth2.DELETE("/:clientId", deleteOAuth2Credentials)
+	oauth2.PUT("/:clientId/clientSecret", putClientSecret)
+	oauth2.PUT("/:clientId/signingKey", putOAuth2SigningKey)
+	oauth2.PUT("/:clientId/dpopKey", putDpopKey)
+
+	// static secrets management api
+	static := api.Group("/static/secrets", requireStaticSecretsFolder)
+	static.GET("", getStaticSecretsList)
+	static.GET("/:secretId", getStaticSecretNames)
+	static.DELETE("/:secretId", deleteStaticSecrets)
+	static.PUT("/:secretId/:name", putStaticSecret)
+	static.DELETE("/:secretId/:name", deleteStaticSecret)
+
+	// sigv4 credentials management api
+	sigV4 := api.Group("/sigv4/credentials", requireSigV4CredentialsFolder)
+	sigV4.GET("", getSigV4CredentialsList)
+	sigV4.PUT("/:accessKeyId", putSigV4Credentials)
+	sigV4.DELETE("/:accessKeyId", deleteSigV4Credentials)
+
+	// verifiable credentials management api
+	vc := api.Group("/vc/holders", requireVcHoldersFolder)
+	vc.GET("", getVcHoldersList)
+	vc.GET("/:holderId", getVcHolderCredentials)
+	vc.DELETE("/:holderId", deleteVcHolder)
+	vc.PUT("/:holderId/holderKey", putVcHolderKey)
+	vc.PUT("/:holderId/credentials/:name", putVerifiableCredential)
+	vc.DELETE("/:holderId/credentials/:name", deleteVerifiableCredential)
+
+	// admin api
+	admin := router.Group("/admin", requireAdminToken, limitRequestBody(maxArchiveSize), validateRequest)
+	admin.GET("/credentials/export", exportCredentials)
+	admin.POST("/credentials/import", importCredentials)
+	// metrics, they include the command line and memory stats and therefore stay behind the admin token
+	admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
+	// api documents
+	router.GET("/openapi/:document", getOpenApiDocument)
 
 	serverPort := os.Getenv("SERVER_PORT")
 	configurationServiceUrl = os.Getenv("CONFIGURATION_SERVICE_URL")
+	authInfoFile = os.Getenv("AUTH_INFO_FILE")
 	credentialsBaseFolder = os.Getenv("CERTIFICATE_FOLDER")
 	enableJsonLogging, err := strconv.ParseBool(os.Getenv("JSON_LOGGING_ENABLED"))
 
@@ -81,18 +177,281 @@ func main() {
 	if serverPort == "" {
 		logger.Fatal("No server port was provided.")
 	}
-	if configurationServiceUrl == "" {
-		logger.Fatal("No URL for the configuration service was provided.")
+	if configurationServiceUrl == "" && authInfoFile == "" {
+		logger.Fatal("Neither a URL for the configuration service nor an auth info file was provided.")
 	}
 
 	if credentialsBaseFolder == "" {
 		logger.Fatal("No credentials base folder was provided.")
 	}
 
+	configureTrustedProxies(router)
+	configureAuditLog()
+	configureRequestValidation()
+	configureAdminToken()
+	configureAuthInfoFile()
+	configureCredentialsCache()
+	configurePkcs11()
+	configureAssertion()
+	configureTokenVerification()
+	configureSatellite()
+	configureOAuth2()
+	configureStatic()
+	configureSigV4()
+	configureVc()
+	configureKubernetes()
+
 	logger.Info("Start router at " + serverPort)
 	router.Run("0.0.0.0:" + serverPort)
 }
 
+/**
+* Only trust the forwarded-for headers of the configured proxies, so that the source ip in the audit log cannot be set by the client.
+ */
+func configureTrustedProxies(router *gin.Engine) {
+	router.TrustedProxies = nil
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		for _, trustedProxy := range strings.Split(trustedProxies, ",") {
+			router.TrustedProxies = append(router.TrustedProxies, strings.TrimSpace(trustedProxy))
+		}
+	}
+}
+
+/**
+* Direct the audit log to its own file, if configured. Otherwise it is written to stdout.
+ */
+func configureAuditLog() {
+	if identityHeader := os.Getenv("AUDIT_IDENTITY_HEADER"); identityHeader != "" {
+		auditIdentityHeader = identityHeader
+	}
+	auditLogFile := os.Getenv("AUDIT_LOG_FILE")
+	if auditLogFile == "" {
+		return
+	}
+	auditLog, err := openAuditLog(auditLogFile)
+	if err != nil {
+		logger.Fatalf("Was not able to open the audit log %s. %v", auditLogFile, err)
+	}
+	auditLogger = newAuditLogger(auditLog)
+}
+
+/**
+* Load the embedded api documents to validate the requests against.
+ */
+func configureRequestValidation() {
+	apiRouters, err := loadOpenApiRouters()
+	if err != nil {
+		logger.Fatalf("Was not able to load the api documents. %v", err)
+	}
+	openApiRouters = apiRouters
+}
+
+/**
+* Enable the admin endpoints, if a token is configured.
+ */
+func configureAdminToken() {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		logger.Info("No admin token configured, export and import of the credentials are disabled.")
+	}
+}
+
+/**
+* Load the auth info file and watch it for changes.
+ */
+func configureAuthInfoFile() {
+	if authInfoFile == "" {
+		return
+	}
+	err := loadAuthInfoMapping(authInfoFile)
+	if err != nil {
+		logger.Fatalf("Was not able to load the auth info file %s. %v", authInfoFile, err)
+	}
+	_, err = watchAuthInfoMapping(authInfoFile)
+	if err != nil {
+		logger.Fatalf("Was not able to watch the auth info file %s. %v", authInfoFile, err)
+	}
+}
+
+/**
+* Keep parsed credentials in memory, as long as changes to the credentials folder can be watched.
+ */
+func configureCredentialsCache() {
+	if enabled, err := strconv.ParseBool(os.Getenv("CREDENTIALS_CACHE_ENABLED")); err == nil && !enabled {
+		logger.Info("The credentials cache is disabled, credentials are read for every request.")
+		return
+	}
+	if _, err := watchCredentialsFolder(credentialsBaseFolder); err != nil {
+		logger.Warnf("Was not able to watch the credentials folder %s, credentials are read for every request. %v", credentialsBaseFolder, err)
+		return
+	}
+	parsedCredentials.enable()
+}
+
+/**
+* Read the PKCS#11 modules references may load. Without, no PKCS#11 reference is accepted.
+ */
+func configurePkcs11() {
+	pkcs11Modules = map[string]bool{}
+	if modules := os.Getenv("PKCS11_MODULES"); modules != "" {
+		for _, module := range strings.Split(modules, ",") {
+			pkcs11Modules[strings.TrimSpace(module)] = true
+		}
+	}
+}
+
+/**
+* Read the configuration for the client assertion times.
+ */
+func configureAssertion() {
+	if lifetime := os.Getenv("ASSERTION_LIFETIME"); lifetime != "" {
+		lifetimeSeconds, err := strconv.Atoi(lifetime)
+		if err != nil {
+			logger.Fatalf("Invalid assertion lifetime %s. %v", lifetime, err)
+		}
+		assertionLifetime = time.Duration(lifetimeSeconds) * time.Second
+	}
+	if backdate := os.Getenv("ASSERTION_BACKDATE"); backdate != "" {
+		backdateSeconds, err := strconv.Atoi(backdate)
+		if err != nil {
+			logger.Fatalf("Invalid assertion backdate %s. %v", backdate, err)
+		}
+		assertionBackdate = time.Duration(backdateSeconds) * time.Second
+	}
+	if err := validateAssertionTimes(assertionLifetime, assertionBackdate); err != nil {
+		logger.Fatal("Invalid assertion configuration. ", err)
+	}
+	assertionNbfEnabled, _ = strconv.ParseBool(os.Getenv("ASSERTION_NBF_ENABLED"))
+	clockSkewCorrectionEnabled, _ = strconv.ParseBool(os.Getenv("CLOCK_SKEW_CORRECTION_ENABLED"))
+
+	if idpSettings := os.Getenv("ASSERTION_IDP_SETTINGS"); idpSettings != "" {
+		var err error
+		idpAssertionSettings, err = parseIdpAssertionSettings(idpSettings)
+		if err != nil {
+			logger.Fatal("Invalid idp assertion settings. ", err)
+		}
+	}
+}
+
+/**
+* Read the configuration for verifying idp tokens.
+ */
+func configureTokenVerification() {
+	var err error
+	tokenVerificationEnabled, err = strconv.ParseBool(os.Getenv("TOKEN_VERIFICATION_ENABLED"))
+	if err != nil || !tokenVerificationEnabled {
+		logger.Info("Token verification is disabled.")
+		tokenVerificationEnabled = false
+		return
+	}
+
+	idpJwksUrl = os.Getenv("TOKEN_VERIFICATION_JWKS_URL")
+	trustStorePath := os.Getenv("TOKEN_VERIFICATION_TRUST_STORE")
+
+	if trustStorePath != "" {
+		idpTrustPool, err = loadTrustStore(trustStorePath)
+		if err != nil {
+			logger.Fatalf("Was not able to load the trust store from %s. %v", trustStorePath, err)
+		}
+	}
+
+	if idpTrustPool == nil && idpJwksUrl == "" {
+		logger.Fatal("Token verification requires a trust store or a jwks url.")
+	}
+}
+
+/**
+* Read the configuration for verifying idps at the satellite.
+ */
+func configureSatellite() {
+	satelliteUrl = os.Getenv("SATELLITE_URL")
+	if satelliteUrl == "" {
+		logger.Info("No satellite configured, idps will not be verified.")
+		return
+	}
+
+	satelliteId = os.Getenv("SATELLITE_ID")
+	if satelliteId == "" {
+		logger.Fatal("No id for the satellite was provided.")
+	}
+
+	trustStorePath := os.Getenv("SATELLITE_TRUST_STORE")
+	if trustStorePath == "" {
+		logger.Fatal("No trust store for the satellite was provided.")
+	}
+	var err error
+	satelliteTrustPool, err = loadTrustStore(trustStorePath)
+	if err != nil {
+		logger.Fatalf("Was not able to load the satellite trust store from %s. %v", trustStorePath, err)
+	}
+
+	if cacheTtl := os.Getenv("SATELLITE_CACHE_TTL"); cacheTtl != "" {
+		ttlSeconds, err := strconv.Atoi(cacheTtl)
+		if err != nil {
+			logger.Fatalf("Invalid satellite cache ttl %s. %v", cacheTtl, err)
+		}
+		satelliteCacheTtl = time.Duration(ttlSeconds) * time.Second
+	}
+}
+
+/**
+* Read the folder for the oauth2 client credentials.
+ */
+func configureOAuth2() {
+	oauth2CredentialsFolder = os.Getenv("OAUTH2_CREDENTIALS_FOLDER")
+	if oauth2CredentialsFolder == "" {
+		logger.Info("No oauth2 credentials folder configured, oauth2 credentials cannot be managed.")
+	}
+}
+
+/**
+* Read the folder for the secrets of static headers.
+ */
+func configureStatic() {
+	staticSecretsFolder = os.Getenv("STATIC_SECRETS_FOLDER")
+	if staticSecretsFolder == "" {
+		logger.Info("No static secrets folder configured, static secrets cannot be managed.")
+	}
+}
+
+/**
+* Read the folder for the access keys of Signature Version 4.
+ */
+func configureSigV4() {
+	sigV4CredentialsFolder = os.Getenv("SIGV4_CREDENTIALS_FOLDER")
+	if sigV4CredentialsFolder == "" {
+		logger.Info("No sigv4 credentials folder configured, sigv4 credentials cannot be managed.")
+	}
+}
+
+/**
+* Read the folder for the holder keys and verifiable credentials.
+ */
+func configureVc() {
+	vcHoldersFolder = os.Getenv("VC_HOLDERS_FOLDER")
+	if vcHoldersFolder == "" {
+		logger.Info("No verifiable credentials folder configured, holders cannot be managed.")
+	}
+}
+
+/**
+* Connect to the Kubernetes api, to request service account tokens. Outside of a cluster, a kubeconfig has to be provided.
+ */
+func configureKubernetes() {
+	kubernetesNamespace = os.Getenv("KUBERNETES_NAMESPACE")
+	if kubernetesNamespace == "" {
+		if namespace, err := os.ReadFile(podNamespaceFile); err == nil {
+			kubernetesNamespace = strings.TrimSpace(string(namespace))
+		}
+	}
+
+	var err error
+	kubernetesClient, err = newKubernetesClient(os.Getenv("KUBECONFIG"))
+	if err != nil {
+		logger.Infof("No kubernetes api available, service account tokens cannot be requested. Err: %v", err)
+	}
+}
+
 // Interfaces for accessing the file system.
 // Introduced to improve testability
 
@@ -114,6 +473,7 @@ type fileSystem interface {
 	Stat(name string) (os.FileInfo, error)
 	MkdirAll(path string, perm fs.FileMode) error
 	RemoveAll(path string) error
+	Rename(oldPath string, newPath string) error
 }
 
 type file interface {
@@ -130,6 +490,7 @@ func (osFS) Open(name string) (file, error)               { return os.Open(name)
 func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
 func (osFS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
 func (osFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (osFS) Rename(oldPath string, newPath string) error  { return os.Rename(oldPath, newPath) }
 
 func getFolderContent(path string) (folders []fs.FileInfo, err error) {
 	return ioutil.ReadDir(path)
@@ -147,4 +508,5 @@ func readFile(filename string) ([]byte, error) {
 type httpClient interface {
 	Get(url string) (*http.Response, error)
 	PostForm(url string, data url.Values) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
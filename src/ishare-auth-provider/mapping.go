@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+var errInvalidMapping = errors.New("invalid_mapping")
+
+/**
+* Content of the auth info file. Yaml and json are supported.
+ */
+type authInfoMappingFile struct {
+	Endpoints []mappedEndpoint `json:"endpoints"`
+}
+
+/**
+* Auth info for all paths of the domain matching the given path.
+ */
+type mappedEndpoint struct {
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+	AuthInfo
+}
+
+/**
+* Auth info read from the file, indexed by domain and path pattern.
+ */
+type authInfoMapping struct {
+	mutex     sync.RWMutex
+	endpoints map[string]map[string]AuthInfo
+}
+
+var fileMapping = authInfoMapping{endpoints: map[string]map[string]AuthInfo{}}
+
+/**
+* Get the auth info for domain and path from the mapping file.
+ */
+func getMappedAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	if domain == "" {
+		return authInfo, errEmptyDomain
+	}
+	if path == "" {
+		return authInfo, errEmptyPath
+	}
+
+	authInfo, match := fileMapping.match(domain, path)
+	if match {
+		return authInfo, err
+	}
+	return authInfo, errUnknownEndpoint
+}
+
+/**
+* Find the auth info with the longest path pattern matching the path. Patterns are built the same way as in the filter,
+* `/path` matches itself and all sub-paths, `/path/` only the sub-paths.
+ */
+func (aim *authInfoMapping) match(domain string, requestPath string) (authInfo AuthInfo, match bool) {
+	aim.mutex.RLock()
+	defer aim.mutex.RUnlock()
+
+	matchLength := 0
+	for pattern, info := range aim.endpoints[domain] {
+		patternMatch, err := path.Match(pattern, requestPath)
+		if err != nil || !patternMatch {
+			continue
+		}
+		if len(pattern) > matchLength {
+			matchLength = len(pattern)
+			authInfo = info
+		}
+	}
+	return authInfo, matchLength > 0
+}
+
+func (aim *authInfoMapping) set(endpoints map[string]map[string]AuthInfo) {
+	aim.mutex.Lock()
+	defer aim.mutex.Unlock()
+	aim.endpoints = endpoints
+}
+
+/**
+* Read the mapping file and replace the current mapping. The current mapping is kept if the file is invalid.
+ */
+func loadAuthInfoMapping(mappingFile string) (err error) {
+	content, err := globalFileAccessor.read(mappingFile)
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := parseAuthInfoMapping(content)
+	if err != nil {
+		return err
+	}
+	fileMapping.set(endpoints)
+	logger.Infof("Loaded auth info for %d domains from %s.", len(endpoints), mappingFile)
+	return err
+}
+
+/**
+* Parse the mapping file content. Since json is valid yaml, the content is read as yaml and converted to json to make use of
+* the json tags of the auth info.
+ */
+func parseAuthInfoMapping(content []byte) (endpoints map[string]map[string]AuthInfo, err error) {
+	var rawMapping interface{}
+	err = yaml.Unmarshal(content, &rawMapping)
+	if err != nil {
+		return endpoints, fmt.Errorf("%w: %v", errInvalidMapping, err)
+	}
+	if rawMapping == nil {
+		// files are truncated before they are written, an empty file is most likely in the middle of an update
+		return endpoints, fmt.Errorf("%w: empty mapping", errInvalidMapping)
+	}
+	jsonMapping, err := json.Marshal(rawMapping)
+	if err != nil {
+		return endpoints, fmt.Errorf("%w: %v", errInvalidMapping, err)
+	}
+	var mappingFile authInfoMappingFile
+	err = json.Unmarshal(jsonMapping, &mappingFile)
+	if err != nil {
+		return endpoints, fmt.Errorf("%w: %v", errInvalidMapping, err)
+	}
+
+	endpoints = map[string]map[string]AuthInfo{}
+	for _, endpoint := range mappingFile.Endpoints {
+		if endpoint.Domain == "" || endpoint.Path == "" {
+			return endpoints, fmt.Errorf("%w: domain and path are required, was %s - %s", errInvalidMapping, endpoint.Domain, endpoint.Path)
+		}
+		if _, err := path.Match(endpoint.Path, ""); err != nil {
+			return endpoints, fmt.Errorf("%w: invalid path %s", errInvalidMapping, endpoint.Path)
+		}
+		if _, ok := endpoints[endpoint.Domain]; !ok {
+			endpoints[endpoint.Domain] = map[string]AuthInfo{}
+		}
+		// same as in the filter: `/path` -> [`/path`, `/path/*`], `/path/` -> [`/path/*`]
+		if endpoint.Path[len(endpoint.Path)-1] != '/' {
+			endpoints[endpoint.Domain][endpoint.Path] = endpoint.AuthInfo
+			endpoints[endpoint.Domain][endpoint.Path+"/*"] = endpoint.AuthInfo
+		} else {
+			endpoints[endpoint.Domain][endpoint.Path+"*"] = endpoint.AuthInfo
+		}
+	}
+	return endpoints, err
+}
+
+/**
+* Reload the mapping whenever the file changes. The folder is watched, since mounted config maps replace the file through a symlink.
+ */
+func watchAuthInfoMapping(mappingFile string) (watcher *fsnotify.Watcher, err error) {
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return watcher, err
+	}
+	err = watcher.Add(filepath.Dir(mappingFile))
+	if err != nil {
+		watcher.Close()
+		return watcher, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				logger.Debugf("Mapping folder changed: %v", event)
+				if err := loadAuthInfoMapping(mappingFile); err != nil {
+					logger.Warnf("Was not able to reload the auth info mapping, keep the current one. %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Error watching the auth info mapping. ", err)
+			}
+		}
+	}()
+	return watcher, err
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const yamlMapping = `
+endpoints:
+  - domain: orders.provider.org
+    path: /orders
+    iShareClientId: ordersClient
+    iShareIdpId: idpId
+    iShareIdpAddress: https://idp.org/token
+    additionalParameters:
+      resource: https://orders.provider.org
+  - domain: orders.provider.org
+    path: /orders/archive/
+    iShareClientId: archiveClient
+  - domain: orders.provider.org
+    path: /
+    iShareClientId: defaultClient
+`
+
+const jsonMapping = `{"endpoints":[{"domain":"orders.provider.org","path":"/orders","iShareClientId":"ordersClient","iShareIdpId":"idpId","iShareIdpAddress":"https://idp.org/token","additionalParameters":{"resource":"https://orders.provider.org"}}]}`
+
+func TestGetMappedAuthInfo(t *testing.T) {
+
+	ordersInfo := AuthInfo{IShareClientID: "ordersClient", IShareIdpID: "idpId", IShareIdpAddress: "https://idp.org/token", AdditionalParameters: map[string]string{"resource": "https://orders.provider.org"}}
+
+	type test struct {
+		testName      string
+		mapping       string
+		domain        string
+		path          string
+		expectedInfo  AuthInfo
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Exact path match.", mapping: yamlMapping, domain: "orders.provider.org", path: "/orders", expectedInfo: ordersInfo},
+		{testName: "Sub-path match.", mapping: yamlMapping, domain: "orders.provider.org", path: "/orders/123", expectedInfo: ordersInfo},
+		{testName: "Longest match wins.", mapping: yamlMapping, domain: "orders.provider.org", path: "/orders/archive/2021", expectedInfo: AuthInfo{IShareClientID: "archiveClient"}},
+		{testName: "Trailing slash only matches sub-paths.", mapping: yamlMapping, domain: "orders.provider.org", path: "/orders/archive", expectedInfo: ordersInfo},
+		{testName: "Fallback to root.", mapping: yamlMapping, domain: "orders.provider.org", path: "/ordersList", expectedInfo: AuthInfo{IShareClientID: "defaultClient"}},
+		{testName: "Json mapping.", mapping: jsonMapping, domain: "orders.provider.org", path: "/orders/123", expectedInfo: ordersInfo},
+		{testName: "Unknown domain.", mapping: yamlMapping, domain: "other.org", path: "/orders", expectedError: errUnknownEndpoint},
+		{testName: "Unknown path.", mapping: jsonMapping, domain: "orders.provider.org", path: "/ordersList", expectedError: errUnknownEndpoint},
+		{testName: "Empty domain.", mapping: yamlMapping, path: "/orders", expectedError: errEmptyDomain},
+		{testName: "Empty path.", mapping: yamlMapping, domain: "orders.provider.org", expectedError: errEmptyPath},
+	}
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+
+	for _, tc := range tests {
+		log.Info("TestGetMappedAuthInfo +++++++++++++++++++++ Running test: " + tc.testName)
+
+		contentMock = map[string][]byte{"mapping.yaml": []byte(tc.mapping)}
+		if err := loadAuthInfoMapping("mapping.yaml"); err != nil {
+			t.Errorf(tc.testName + ": Was not able to load the mapping. " + fmt.Sprint(err))
+			continue
+		}
+
+		authInfo, err := getMappedAuthInfo(tc.domain, tc.path)
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if !reflect.DeepEqual(authInfo, tc.expectedInfo) {
+			t.Errorf(tc.testName + ": Expected auth info: " + fmt.Sprint(tc.expectedInfo) + " but got: " + fmt.Sprint(authInfo))
+		}
+	}
+}
+
+func TestGetAuthInfoWithMapping(t *testing.T) {
+
+	ordersInfo := AuthInfo{IShareClientID: "ordersClient", IShareIdpID: "idpId", IShareIdpAddress: "https://idp.org/token", AdditionalParameters: map[string]string{"resource": "https://orders.provider.org"}}
+	serviceInfo := AuthInfo{IShareClientID: "serviceClient"}
+
+	type test struct {
+		testName      string
+		mappingFile   string
+		configService string
+		domain        string
+		expectedInfo  AuthInfo
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Mapped endpoint.", mappingFile: "mapping.json", configService: "http://config-service", domain: "orders.provider.org", expectedInfo: ordersInfo},
+		{testName: "Unmapped endpoint is requested from the configuration service.", mappingFile: "mapping.json", configService: "http://config-service", domain: "other.org", expectedInfo: serviceInfo},
+		{testName: "Unmapped endpoint without configuration service.", mappingFile: "mapping.json", domain: "other.org", expectedError: errUnknownEndpoint},
+		{testName: "No mapping file.", configService: "http://config-service", domain: "orders.provider.org", expectedInfo: serviceInfo},
+	}
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	contentMock = map[string][]byte{"mapping.json": []byte(jsonMapping)}
+	if err := loadAuthInfoMapping("mapping.json"); err != nil {
+		t.Fatalf("Was not able to load the mapping. %v", err)
+	}
+	defer func() { authInfoFile = ""; configurationServiceUrl = "" }()
+
+	for _, tc := range tests {
+		log.Info("TestGetAuthInfoWithMapping +++++++++++++++++++++ Running test: " + tc.testName)
+
+		authInfoFile = tc.mappingFile
+		configurationServiceUrl = tc.configService
+		globalHttpClient = &mockHttpClient{mockGetResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"iShareClientId":"serviceClient"}`))}}
+
+		authInfo, err := AuthGetter{}.getAuthInfo(tc.domain, "/orders")
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if !reflect.DeepEqual(authInfo, tc.expectedInfo) {
+			t.Errorf(tc.testName + ": Expected auth info: " + fmt.Sprint(tc.expectedInfo) + " but got: " + fmt.Sprint(authInfo))
+		}
+	}
+}
+
+func TestLoadAuthInfoMapping(t *testing.T) {
+
+	type test struct {
+		testName      string
+		mapping       string
+		readError     error
+		expectedError error
+	}
+
+	readError := errors.New("not_readable")
+	tests := []test{
+		{testName: "Valid yaml.", mapping: yamlMapping},
+		{testName: "Valid json.", mapping: jsonMapping},
+		{testName: "Empty mapping.", mapping: `endpoints: []`},
+		{testName: "Empty file.", mapping: "", expectedError: errInvalidMapping},
+		{testName: "Unreadable file.", readError: readError, expectedError: readError},
+		{testName: "Invalid yaml.", mapping: "endpoints: [", expectedError: errInvalidMapping},
+		{testName: "Invalid auth info.", mapping: "endpoints:\n  - domain: a.org\n    path: /\n    additionalParameters: no-map", expectedError: errInvalidMapping},
+		{testName: "Missing domain.", mapping: "endpoints:\n  - path: /", expectedError: errInvalidMapping},
+		{testName: "Missing path.", mapping: "endpoints:\n  - domain: a.org", expectedError: errInvalidMapping},
+		{testName: "Invalid path pattern.", mapping: "endpoints:\n  - domain: a.org\n    path: /[", expectedError: errInvalidMapping},
+	}
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+
+	for _, tc := range tests {
+		log.Info("TestLoadAuthInfoMapping +++++++++++++++++++++ Running test: " + tc.testName)
+
+		contentMock = map[string][]byte{"mapping.yaml": []byte(tc.mapping)}
+		mockReadErr = tc.readError
+
+		err := loadAuthInfoMapping("mapping.yaml")
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+	}
+	mockReadErr = nil
+}
+
+func TestWatchAuthInfoMapping(t *testing.T) {
+
+	log.Info("TestWatchAuthInfoMapping +++++++++++++++++++++")
+
+	mappingFile := filepath.Join(t.TempDir(), "mapping.yaml")
+	os.WriteFile(mappingFile, []byte(jsonMapping), 0644)
+
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() { globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content} }()
+
+	if err := loadAuthInfoMapping(mappingFile); err != nil {
+		t.Fatalf("Was not able to load the mapping. %v", err)
+	}
+	watcher, err := watchAuthInfoMapping(mappingFile)
+	if err != nil {
+		t.Fatalf("Was not able to watch the mapping. %v", err)
+	}
+	defer watcher.Close()
+
+	os.WriteFile(mappingFile, []byte("endpoints: ["), 0644)
+	time.Sleep(100 * time.Millisecond)
+	if _, match := fileMapping.match("orders.provider.org", "/orders"); !match {
+		t.Errorf("An invalid update should keep the current mapping.")
+	}
+
+	os.WriteFile(mappingFile, []byte(yamlMapping), 0644)
+	for i := 0; i < 50; i++ {
+		if _, match := fileMapping.match("orders.provider.org", "/ordersList"); match {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("The mapping should be reloaded on change.")
+}
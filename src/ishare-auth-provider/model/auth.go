@@ -0,0 +1,37 @@
+package model
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+/**
+* Struct to contain headers to be returned by the auth provider.
+ */
+type HeadersList []Header
+
+/**
+* Body of a failed request, as defined by RFC 7807. The reason is the last segment of the type, the client, domain and path
+* are set if the problem relates to them.
+ */
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Reason   string       `json:"reason"`
+	ClientId string       `json:"clientId,omitempty"`
+	Domain   string       `json:"domain,omitempty"`
+	Path     string       `json:"path,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+/**
+* Violation of the api specification.
+ */
+type FieldError struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
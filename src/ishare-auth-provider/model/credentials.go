@@ -0,0 +1,46 @@
+package model
+
+/**
+* Credentials of an iShare client, as accepted by the credentials management api. The signing key is never returned by the api.
+* Pending key is set by the api, if a key generated for a certificate signing request waits for its certificate chain.
+ */
+type Credentials struct {
+	CertificateChain string        `json:"certificateChain"`
+	SigningKey       string        `json:"signingKey,omitempty"`
+	Pkcs11           *Pkcs11Config `json:"pkcs11,omitempty"`
+	PendingKey       bool          `json:"pendingKey,omitempty"`
+}
+
+/**
+* Reference to a signing key inside a PKCS#11 token. The token is selected either by its slot or its label. The pin is never stored,
+* it is read from the environment.
+ */
+type Pkcs11Config struct {
+	Module      string `json:"module"`
+	Slot        *int   `json:"slot,omitempty"`
+	TokenLabel  string `json:"tokenLabel,omitempty"`
+	KeyLabel    string `json:"keyLabel"`
+	PinVariable string `json:"pinVariable,omitempty"`
+}
+
+/**
+* Request to generate a key inside the provider, returning a certificate signing request for it. The key size defaults to 2048 bits.
+ */
+type CsrRequest struct {
+	Subject CsrSubject `json:"subject"`
+	KeySize int        `json:"keySize,omitempty"`
+}
+
+/**
+* Subject of the certificate signing request. iSHARE certificates carry the EORI of the party as serial number, it defaults to the
+* clientId.
+ */
+type CsrSubject struct {
+	CommonName         string `json:"commonName"`
+	SerialNumber       string `json:"serialNumber,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizationalUnit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	Country            string `json:"country,omitempty"`
+}
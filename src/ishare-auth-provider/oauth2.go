@@ -0,0 +1,372 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+/**
+* Auth type of the OAuth2 client credentials provider, as used in its route /OAUTH2/auth.
+ */
+const oauth2AuthType = "OAUTH2"
+
+/**
+* Client authentication methods, as defined by OpenID Connect Core, section 9.
+ */
+const (
+	clientSecretBasic = "client_secret_basic"
+	clientSecretPost  = "client_secret_post"
+	privateKeyJwt     = "private_key_jwt"
+)
+
+/**
+* Name of the file containing the client secret.
+ */
+const clientSecretFile = "client.secret"
+
+/**
+* Tokens are handed out for a little less than their lifetime, to not expire on their way to the endpoint.
+ */
+const oauth2ExpiryMargin = 5 * time.Second
+
+/**
+* Lifetime of the client assertion used for private_key_jwt.
+ */
+const oauth2AssertionLifetime = 30 * time.Second
+
+var errInvalidOAuth2Config = errors.New("invalid_oauth2_config")
+var errNoClientSecret = errors.New("no_client_secret")
+var errInvalidClientCredentials = errors.New("invalid_client_credentials")
+
+/**
+* Folder to store the OAuth2 client credentials in, one folder per client. Separated from the iSHARE credentials, since clients of
+* both types can share their id.
+ */
+var oauth2CredentialsFolder string
+
+/**
+* Configuration of an endpoint using OAuth2 client credentials, read from the config of its auth info.
+ */
+type oauth2Config struct {
+	TokenEndpoint    string   `json:"tokenEndpoint"`
+	ClientId         string   `json:"clientId"`
+	ClientAuthMethod string   `json:"clientAuthMethod,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
+	Audience         string   `json:"audience,omitempty"`
+	KeyId            string   `json:"keyId,omitempty"`
+}
+
+/**
+* Cache of access tokens, indexed by a hash over token endpoint, client, scopes and audience.
+ */
+type oauth2TokenCache struct {
+	mutex  sync.RWMutex
+	tokens map[string]cachedOAuth2Token
+}
+
+type cachedOAuth2Token struct {
+	clientId string
+	token    string
+	expiry   time.Time
+}
+
+var oauth2Cache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Provider requesting access tokens through the OAuth2 client credentials grant. The client authenticates with its secret or
+* with a jwt signed by its key. Tokens are cached based on their expires_in.
+ */
+type oauth2Provider struct{}
+
+func (oauth2Provider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(oauth2AuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getOAuth2Config(authInfo); err != nil {
+		logger.Warnf("Received invalid oauth2 auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid oauth2 auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+func (oauth2Provider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getOAuth2Config(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	cacheKey := getOAuth2CacheKey(config)
+	token, expiry, cached := oauth2Cache.get(cacheKey)
+	if !cached {
+		token, expiry, err = requestOAuth2Token(config)
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, err)
+		}
+		oauth2Cache.put(cacheKey, config.ClientId, token, expiry)
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + token}}
+	return headers, time.Until(expiry), err
+}
+
+/**
+* Map the error of a token request to the failure of the client.
+ */
+func getTokenRequestFailure(clientId string, err error) error {
+	fail := func(status int, reason string, detail string) error {
+		return &authFailure{status: status, reason: reason, detail: detail, clientId: clientId, err: err}
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNoClientSecret):
+		logger.Warnf("No credentials exist for %s.", clientId)
+		return fail(http.StatusInternalServerError, reasonMissingCredentials, "No credentials exist for client "+clientId+".")
+	case errors.Is(err, errInvalidClientCredentials):
+		logger.Warn("Was not able to create the assertion.", err)
+		return fail(http.StatusInternalServerError, reasonInvalidCredentials, "Was not able to create the assertion with the credentials of "+clientId+".")
+	case errors.Is(err, errIdpRejectedClient):
+		logger.Warnf("The token endpoint rejected %s. Err: %v", clientId, err)
+		return fail(http.StatusForbidden, reasonIdpRejectedClient, "The idp rejected the client: "+err.Error())
+	case errors.Is(err, errIdpUnavailable):
+		logger.Warn("The token endpoint is not available.", err)
+		return fail(http.StatusServiceUnavailable, reasonIdpUnavailable, "The idp is not available: "+err.Error())
+	default:
+		logger.Warn("Was not able to get the token from the token endpoint.", err)
+		return fail(http.StatusBadGateway, reasonIdpInvalidResponse, "Did not receive a valid token response from the idp.")
+	}
+}
+
+/**
+* Decode and validate the oauth2 configuration of the auth info.
+ */
+func getOAuth2Config(authInfo AuthInfo) (config oauth2Config, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidOAuth2Config, err)
+	}
+	return config, validateOAuth2Client(&config)
+}
+
+/**
+* Validate token endpoint and client of the configuration, the client auth method defaults to client_secret_basic.
+ */
+func validateOAuth2Client(config *oauth2Config) (err error) {
+	if config.TokenEndpoint == "" || config.ClientId == "" {
+		return fmt.Errorf("%w: token endpoint and client id are required", errInvalidOAuth2Config)
+	}
+	if !isValidClientId(config.ClientId) {
+		return fmt.Errorf("%w: invalid client id %s", errInvalidOAuth2Config, config.ClientId)
+	}
+	if config.ClientAuthMethod == "" {
+		config.ClientAuthMethod = clientSecretBasic
+	}
+	if config.ClientAuthMethod != clientSecretBasic && config.ClientAuthMethod != clientSecretPost && config.ClientAuthMethod != privateKeyJwt {
+		return fmt.Errorf("%w: unsupported client auth method %s", errInvalidOAuth2Config, config.ClientAuthMethod)
+	}
+	return err
+}
+
+/**
+* Request a token through the client credentials grant, authenticated as configured. The expiry is taken from expires_in, tokens
+* without it are not cached.
+ */
+func requestOAuth2Token(config oauth2Config) (token string, expiry time.Time, err error) {
+	return postOAuth2TokenRequest(config, getClientCredentialsGrant(config))
+}
+
+/**
+* Form-data of the client credentials grant, with the configured scopes and audience.
+ */
+func getClientCredentialsGrant(config oauth2Config) (data url.Values) {
+	data = url.Values{"grant_type": {"client_credentials"}}
+	if len(config.Scopes) > 0 {
+		data.Set("scope", strings.Join(config.Scopes, " "))
+	}
+	if config.Audience != "" {
+		data.Set("audience", config.Audience)
+	}
+	return data
+}
+
+/**
+* Send the token request with the given form-data to the token endpoint, authenticating the client as configured. The expiry is taken
+* from expires_in, tokens without it expire immediately.
+ */
+func postOAuth2TokenRequest(config oauth2Config, data url.Values) (token string, expiry time.Time, err error) {
+	requestStart := time.Now()
+	resp, err := sendOAuth2TokenRequest(config, data, http.Header{})
+	if err != nil {
+		return token, expiry, err
+	}
+	res, err := decodeTokenResponse(resp)
+	if err != nil {
+		return token, expiry, err
+	}
+
+	token = res["access_token"].(string)
+	expiry = requestStart
+	if expiresIn, ok := res["expires_in"].(float64); ok {
+		expiry = requestStart.Add(time.Duration(expiresIn)*time.Second - oauth2ExpiryMargin)
+	}
+	return token, expiry, err
+}
+
+/**
+* Send the token request with the given form-data and additional headers, authenticating the client as configured. The response is
+* left to the caller.
+ */
+func sendOAuth2TokenRequest(config oauth2Config, data url.Values, header http.Header) (resp *http.Response, err error) {
+	credentialsFolderPath := buildOAuth2CredentialsFolderPath(config.ClientId)
+
+	var clientSecret string
+	switch config.ClientAuthMethod {
+	case privateKeyJwt:
+		assertion, err := createOAuth2ClientAssertion(config, credentialsFolderPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return resp, err
+		}
+		if err != nil {
+			return resp, fmt.Errorf("%w: %v", errInvalidClientCredentials, err)
+		}
+		data.Set("client_id", config.ClientId)
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	default:
+		clientSecret, err = getClientSecret(credentialsFolderPath)
+		if err != nil {
+			return resp, err
+		}
+		if config.ClientAuthMethod == clientSecretPost {
+			data.Set("client_id", config.ClientId)
+			data.Set("client_secret", clientSecret)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return resp, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if config.ClientAuthMethod == clientSecretBasic {
+		// RFC 6749, section 2.3.1 requires the form-encoding of id and secret
+		req.SetBasicAuth(url.QueryEscape(config.ClientId), url.QueryEscape(clientSecret))
+	}
+
+	resp, err = globalHttpClient.Do(req)
+	if err != nil {
+		logger.Warn("Was not able to request the token.", err)
+		return resp, fmt.Errorf("%w: %v", errIdpUnavailable, err)
+	}
+	return resp, err
+}
+
+/**
+* Create the client assertion for private_key_jwt, as defined by RFC 7523. The token endpoint is its audience.
+ */
+func createOAuth2ClientAssertion(config oauth2Config, credentialsFolderPath string) (signedToken string, err error) {
+	randomUuid, err := uuid.NewRandom()
+	if err != nil {
+		return signedToken, err
+	}
+	now := time.Now()
+	jwtToken := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{
+		"jti": randomUuid.String(),
+		"iss": config.ClientId,
+		"sub": config.ClientId,
+		"aud": config.TokenEndpoint,
+		"iat": now.Unix(),
+		"exp": now.Add(oauth2AssertionLifetime).Unix(),
+	})
+	if config.KeyId != "" {
+		jwtToken.Header["kid"] = config.KeyId
+	}
+
+	key, err := authGetter.getSigningKey(credentialsFolderPath)
+	if err != nil {
+		logger.Warn("Was not able to read the oauth2 signing key.")
+		return signedToken, err
+	}
+	if key == nil {
+		return signedToken, errInvalidSigningKey
+	}
+	return jwtToken.SignedString(key)
+}
+
+func getClientSecret(credentialsFolderPath string) (clientSecret string, err error) {
+	secret, err := globalFileAccessor.read(credentialsFolderPath + clientSecretFile)
+	if err != nil {
+		logger.Warn("Was not able to read the client secret. ", err)
+		return clientSecret, err
+	}
+	clientSecret = strings.TrimSpace(string(secret))
+	if clientSecret == "" {
+		return clientSecret, errNoClientSecret
+	}
+	return clientSecret, err
+}
+
+/**
+* Build the path to the oauth2 credentials folder of the client. It will include the trailing /
+ */
+func buildOAuth2CredentialsFolderPath(clientId string) string {
+	return strings.TrimSuffix(oauth2CredentialsFolder, "/") + "/" + clientId + "/"
+}
+
+func getOAuth2CacheKey(config oauth2Config) string {
+	hash := sha256.Sum256([]byte(config.TokenEndpoint + "|" + config.ClientId + "|" + config.ClientAuthMethod + "|" + strings.Join(config.Scopes, " ") + "|" + config.Audience))
+	return hex.EncodeToString(hash[:])
+}
+
+func (oc *oauth2TokenCache) get(key string) (token string, expiry time.Time, ok bool) {
+	oc.mutex.RLock()
+	defer oc.mutex.RUnlock()
+	entry, ok := oc.tokens[key]
+	if !ok || !time.Now().Before(entry.expiry) {
+		return token, expiry, false
+	}
+	return entry.token, entry.expiry, true
+}
+
+/**
+* Add the token, expired ones are removed on the way. Otherwise the tokens of every subject would pile up.
+ */
+func (oc *oauth2TokenCache) put(key string, clientId string, token string, expiry time.Time) {
+	now := time.Now()
+	if !now.Before(expiry) {
+		return
+	}
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+	for cachedKey, entry := range oc.tokens {
+		if !now.Before(entry.expiry) {
+			delete(oc.tokens, cachedKey)
+		}
+	}
+	oc.tokens[key] = cachedOAuth2Token{clientId, token, expiry}
+}
+
+/**
+* Remove all tokens of the client, f.e. after its credentials changed.
+ */
+func (oc *oauth2TokenCache) invalidate(clientId string) {
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+	for key, entry := range oc.tokens {
+		if entry.clientId == clientId {
+			delete(oc.tokens, key)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* Credentials of an OAuth2 client. The secret is used for client_secret_basic and client_secret_post, the key for private_key_jwt.
+* The optional DPoP key signs the proofs of sender-constrained tokens. They are never returned by the api.
+ */
+type OAuth2Credentials struct {
+	ClientSecret string `json:"clientSecret,omitempty"`
+	SigningKey   string `json:"signingKey,omitempty"`
+	DpopKey      string `json:"dpopKey,omitempty"`
+}
+
+/**
+* The oauth2 credentials api is only available if a folder to store them is configured. Client ids are used as folder names,
+* thus cannot point outside of it.
+ */
+func requireOAuth2CredentialsFolder(c *gin.Context) {
+	if oauth2CredentialsFolder == "" {
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "No folder for oauth2 credentials is configured.")
+		return
+	}
+	if clientId, ok := c.Params.Get("clientId"); ok && !isValidClientId(clientId) {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The client id "+clientId+" is not allowed.")
+	}
+}
+
+func getOAuth2CredentialsList(c *gin.Context) {
+
+	audit := startSecretAudit(auditListOAuth2Credentials, "")
+	defer audit.log(c)
+
+	folders, err := globalFolderAccessor.get(oauth2CredentialsFolder)
+	if err != nil {
+		logger.Warn("Was not able to read the oauth2 credentials folder.", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the oauth2 credentials folder.")
+		return
+	}
+
+	clientIds := []string{}
+	for _, folder := range folders {
+		if folder.IsDir() {
+			clientIds = append(clientIds, folder.Name())
+		}
+	}
+	c.JSON(http.StatusOK, clientIds)
+}
+
+func postOAuth2Credentials(c *gin.Context) {
+	clientId := c.Param("clientId")
+
+	audit := startSecretAudit(auditCreateOAuth2Credentials, clientId)
+	defer audit.log(c)
+
+	var credentials OAuth2Credentials
+	err := c.ShouldBindJSON(&credentials)
+	if err != nil {
+		logger.Warn("Was not able to read oauth2 credentials to json.")
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the credentials: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(credentials.ClientSecret) == "" && credentials.SigningKey == "" {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Either a client secret or a signing key is required.")
+		return
+	}
+
+	credentialsFolderPath := buildOAuth2CredentialsFolderPath(clientId)
+
+	// on post, we dont allow override
+	if _, err := diskFs.Stat(credentialsFolderPath); err == nil {
+		logger.Warn("OAuth2 credentials for " + clientId + " already exist.")
+		abortWithProblem(c, http.StatusConflict, reasonCredentialsExist, "OAuth2 credentials for "+clientId+" already exist.")
+		return
+	}
+
+	err = diskFs.MkdirAll(credentialsFolderPath, 0700)
+	if err == nil && credentials.ClientSecret != "" {
+		err = globalFileAccessor.write(credentialsFolderPath+clientSecretFile, []byte(credentials.ClientSecret), 0600)
+	}
+	if err == nil && credentials.SigningKey != "" {
+		err = globalFileAccessor.write(credentialsFolderPath+keyfile, []byte(credentials.SigningKey), 0600)
+	}
+	if err == nil && credentials.DpopKey != "" {
+		err = globalFileAccessor.write(credentialsFolderPath+dpopKeyFile, []byte(credentials.DpopKey), 0600)
+	}
+	if err != nil {
+		logger.Warn("Was not able to store the oauth2 credentials for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the oauth2 credentials of "+clientId+".")
+		diskFs.RemoveAll(credentialsFolderPath)
+		return
+	}
+	c.AbortWithStatus(http.StatusCreated)
+}
+
+func putClientSecret(c *gin.Context) {
+	storeOAuth2Credential(c, clientSecretFile, auditUpdateClientSecret)
+}
+
+func putOAuth2SigningKey(c *gin.Context) {
+	storeOAuth2Credential(c, keyfile, auditUpdateOAuth2SigningKey)
+}
+
+func putDpopKey(c *gin.Context) {
+	storeOAuth2Credential(c, dpopKeyFile, auditUpdateDpopKey)
+}
+
+func deleteOAuth2Credentials(c *gin.Context) {
+	clientId := c.Param("clientId")
+	credentialsFolderPath := buildOAuth2CredentialsFolderPath(clientId)
+
+	audit := startSecretAudit(auditDeleteOAuth2Credentials, clientId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(credentialsFolderPath); errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No oauth2 credentials for "+clientId+" exist.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No oauth2 credentials exist for client "+clientId+".")
+		return
+	}
+
+	err := diskFs.RemoveAll(credentialsFolderPath)
+	if err != nil {
+		logger.Warn("Was not able to delete the oauth2 credentials for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the oauth2 credentials of "+clientId+".")
+		return
+	}
+	oauth2Cache.invalidate(clientId)
+	tokenExchangeCache.invalidate(clientId)
+	dpopTokenCache.invalidate(clientId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+/**
+* Replace a single credential of an existing client. Tokens requested with the old one are dropped.
+ */
+func storeOAuth2Credential(c *gin.Context, fileName string, operation string) {
+	clientId := c.Param("clientId")
+
+	audit := startSecretAudit(operation, clientId)
+	defer audit.log(c)
+
+	credential, err := io.ReadAll(c.Request.Body)
+	if err != nil || len(credential) == 0 {
+		logger.Warn("Was not able to read the request body.", err)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the body.")
+		return
+	}
+
+	credentialsFolderPath := buildOAuth2CredentialsFolderPath(clientId)
+	if _, err := diskFs.Stat(credentialsFolderPath); errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No oauth2 credentials for "+clientId+" exist.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No oauth2 credentials exist for client "+clientId+".")
+		return
+	}
+
+	err = globalFileAccessor.write(credentialsFolderPath+fileName, credential, 0600)
+	if err != nil {
+		logger.Warn("Was not able to store the oauth2 credential for: "+clientId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the oauth2 credentials of "+clientId+".")
+		return
+	}
+	oauth2Cache.invalidate(clientId)
+	tokenExchangeCache.invalidate(clientId)
+	dpopTokenCache.invalidate(clientId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
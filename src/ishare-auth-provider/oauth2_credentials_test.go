@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestOAuth2Credentials(t *testing.T) {
+
+	type test struct {
+		testName       string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		expectedFiles  map[string]string
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Create with secret.", method: http.MethodPost, url: "/oauth2/credentials/client", body: `{"clientSecret":"secret","dpopKey":"key"}`, expectedStatus: 201,
+			expectedFiles: map[string]string{"client/" + clientSecretFile: "secret", "client/" + dpopKeyFile: "key"}},
+		{testName: "Create existing.", method: http.MethodPost, url: "/oauth2/credentials/client", body: `{"clientSecret":"other"}`, expectedStatus: 409,
+			expectedFiles: map[string]string{"client/" + clientSecretFile: "secret"}},
+		{testName: "Create without credentials.", method: http.MethodPost, url: "/oauth2/credentials/other", body: `{}`, expectedStatus: 400},
+		{testName: "Create outside of the folder.", method: http.MethodPost, url: "/oauth2/credentials/..", body: `{"clientSecret":"secret"}`, expectedStatus: 400},
+		{testName: "Replace secret.", method: http.MethodPut, url: "/oauth2/credentials/client/clientSecret", body: "rotated", expectedStatus: 204,
+			expectedFiles: map[string]string{"client/" + clientSecretFile: "rotated"}},
+		{testName: "Add signing key.", method: http.MethodPut, url: "/oauth2/credentials/client/signingKey", body: "key", expectedStatus: 204,
+			expectedFiles: map[string]string{"client/" + keyfile: "key"}},
+		{testName: "Replace dpop key.", method: http.MethodPut, url: "/oauth2/credentials/client/dpopKey", body: "dpop", expectedStatus: 204,
+			expectedFiles: map[string]string{"client/" + dpopKeyFile: "dpop"}},
+		{testName: "Replace secret of unknown client.", method: http.MethodPut, url: "/oauth2/credentials/unknown/clientSecret", body: "secret", expectedStatus: 404},
+		{testName: "Replace with empty secret.", method: http.MethodPut, url: "/oauth2/credentials/client/clientSecret", body: "", expectedStatus: 400},
+		{testName: "List.", method: http.MethodGet, url: "/oauth2/credentials", expectedStatus: 200},
+		{testName: "Delete.", method: http.MethodDelete, url: "/oauth2/credentials/client", expectedStatus: 204},
+		{testName: "Delete unknown.", method: http.MethodDelete, url: "/oauth2/credentials/client", expectedStatus: 404},
+	}
+
+	oauth2CredentialsFolder = t.TempDir()
+	diskFs = &osFS{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	defer func() {
+		oauth2CredentialsFolder = ""
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+
+	router := gin.New()
+	oauth2 := router.Group("/oauth2/credentials", requireOAuth2CredentialsFolder)
+	oauth2.GET("", getOAuth2CredentialsList)
+	oauth2.POST("/:clientId", postOAuth2Credentials)
+	oauth2.DELETE("/:clientId", deleteOAuth2Credentials)
+	oauth2.PUT("/:clientId/clientSecret", putClientSecret)
+	oauth2.PUT("/:clientId/signingKey", putOAuth2SigningKey)
+	oauth2.PUT("/:clientId/dpopKey", putDpopKey)
+
+	// tokens of the client are dropped when its credentials change
+	oauth2Cache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+	dpopTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+	for _, tc := range tests {
+		log.Info("TestOAuth2Credentials +++++++++++++++++++++ Running test: " + tc.testName)
+		oauth2Cache.put("key", "client", "token", time.Now().Add(time.Minute))
+		dpopTokenCache.put("key", "client", "token", time.Now().Add(time.Minute))
+
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		for file, expectedContent := range tc.expectedFiles {
+			content, err := os.ReadFile(filepath.Join(oauth2CredentialsFolder, file))
+			if err != nil || string(content) != expectedContent {
+				t.Errorf("%s: Expected %s to contain %s, but was %s.", tc.testName, file, expectedContent, string(content))
+			}
+		}
+		_, _, cached := oauth2Cache.get("key")
+		_, _, dpopCached := dpopTokenCache.get("key")
+		if cached == (tc.expectedStatus == http.StatusNoContent) || cached != dpopCached {
+			t.Errorf("%s: The cached tokens should only be dropped on changed credentials.", tc.testName)
+		}
+		if tc.method == http.MethodGet && recorder.Body.String() != `["client"]` {
+			t.Errorf("%s: Expected the client to be listed, but was %s.", tc.testName, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+* Token endpoint recording the client authentication of the last request.
+ */
+type standInTokenEndpoint struct {
+	status      int
+	response    string
+	requests    int
+	basicUser   string
+	basicSecret string
+	form        map[string]string
+}
+
+func (ste *standInTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ste.requests++
+	ste.basicUser, ste.basicSecret, _ = r.BasicAuth()
+	r.ParseForm()
+	ste.form = map[string]string{}
+	for name := range r.PostForm {
+		ste.form[name] = r.PostForm.Get(name)
+	}
+	if ste.status != 0 {
+		w.WriteHeader(ste.status)
+	}
+	w.Write([]byte(ste.response))
+}
+
+func TestGetOAuth2Config(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedMethod string
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Default auth method.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client"}`, expectedMethod: clientSecretBasic},
+		{testName: "Private key jwt.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","clientAuthMethod":"private_key_jwt"}`, expectedMethod: privateKeyJwt},
+		{testName: "Unsupported auth method.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":"client","clientAuthMethod":"tls_client_auth"}`, expectedError: errInvalidOAuth2Config},
+		{testName: "No token endpoint.", config: `{"clientId":"client"}`, expectedError: errInvalidOAuth2Config},
+		{testName: "Client id outside of the folder.", config: `{"tokenEndpoint":"https://idp.org/token","clientId":".."}`, expectedError: errInvalidOAuth2Config},
+		{testName: "No config.", expectedError: errInvalidOAuth2Config},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetOAuth2Config +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getOAuth2Config(AuthInfo{AuthType: oauth2AuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && config.ClientAuthMethod != tc.expectedMethod {
+			t.Errorf("%s: Expected auth method %s but was %s.", tc.testName, tc.expectedMethod, config.ClientAuthMethod)
+		}
+	}
+}
+
+func TestOAuth2GetHeaders(t *testing.T) {
+
+	type test struct {
+		testName          string
+		authMethod        string
+		clientSecret      string
+		status            int
+		response          string
+		expectedReason    string
+		expectedLifetime  time.Duration
+		expectedBasicUser string
+		expectedForm      map[string]string
+	}
+
+	tokenResponse := `{"access_token":"myToken","token_type":"Bearer","expires_in":60}`
+	tests := []test{
+		{testName: "Client secret basic.", authMethod: clientSecretBasic, clientSecret: "secret", response: tokenResponse, expectedLifetime: 55 * time.Second, expectedBasicUser: "client",
+			expectedForm: map[string]string{"grant_type": "client_credentials", "scope": "read write", "audience": "https://api.org", "client_secret": ""}},
+		{testName: "Client secret post.", authMethod: clientSecretPost, clientSecret: "secret", response: tokenResponse, expectedLifetime: 55 * time.Second,
+			expectedForm: map[string]string{"client_id": "client", "client_secret": "secret"}},
+		{testName: "Private key jwt.", authMethod: privateKeyJwt, response: tokenResponse, expectedLifetime: 55 * time.Second,
+			expectedForm: map[string]string{"client_id": "client", "client_assertion_type": clientAssertionType, "client_secret": ""}},
+		{testName: "Token without expiry.", authMethod: clientSecretBasic, clientSecret: "secret", response: `{"access_token":"myToken"}`, expectedBasicUser: "client"},
+		{testName: "Missing client secret.", authMethod: clientSecretBasic, response: tokenResponse, expectedReason: reasonMissingCredentials},
+		{testName: "Rejected client.", authMethod: clientSecretBasic, clientSecret: "secret", status: 401, response: `{"error":"invalid_client"}`, expectedReason: reasonIdpRejectedClient},
+		{testName: "Unavailable token endpoint.", authMethod: clientSecretBasic, clientSecret: "secret", status: 503, response: `{"error":"temporarily_unavailable"}`, expectedReason: reasonIdpUnavailable},
+		{testName: "No access token.", authMethod: clientSecretBasic, clientSecret: "secret", response: `{"token_type":"Bearer"}`, expectedReason: reasonIdpInvalidResponse},
+	}
+
+	validKey, _ := getValidKey()
+	globalHttpClient = &http.Client{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		oauth2CredentialsFolder = ""
+	}()
+
+	for _, tc := range tests {
+		log.Info("TestOAuth2GetHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		oauth2CredentialsFolder = t.TempDir()
+		os.MkdirAll(filepath.Join(oauth2CredentialsFolder, "client"), 0700)
+		if tc.clientSecret != "" {
+			os.WriteFile(filepath.Join(oauth2CredentialsFolder, "client", clientSecretFile), []byte(tc.clientSecret+"\n"), 0600)
+		}
+		authGetter = &mockAuthGetter{mockKey: validKey}
+		oauth2Cache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+		endpoint := &standInTokenEndpoint{status: tc.status, response: tc.response}
+		server := httptest.NewServer(endpoint)
+		config := fmt.Sprintf(`{"tokenEndpoint":"%s","clientId":"client","clientAuthMethod":"%s","scopes":["read","write"],"audience":"https://api.org"}`, server.URL, tc.authMethod)
+		authInfo := AuthInfo{AuthType: oauth2AuthType, Config: json.RawMessage(config)}
+
+		headers, cacheLifetime, err := oauth2Provider{}.getHeaders(authInfo, "test.domain", "/")
+		// a second request is served from the cache, if the token has an expiry
+		oauth2Provider{}.getHeaders(authInfo, "test.domain", "/")
+		server.Close()
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason || failure.clientId != "client" {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if len(headers) != 1 || headers[0].Value != "Bearer myToken" {
+			t.Errorf("%s: Expected the bearer token, but was %v.", tc.testName, headers)
+		}
+		if cacheLifetime > tc.expectedLifetime || cacheLifetime < tc.expectedLifetime-2*time.Second {
+			t.Errorf("%s: Expected a cache lifetime of %v, but was %v.", tc.testName, tc.expectedLifetime, cacheLifetime)
+		}
+		expectedRequests := 1
+		if tc.expectedLifetime == 0 {
+			expectedRequests = 2
+		}
+		if endpoint.requests != expectedRequests {
+			t.Errorf("%s: Expected %d token requests, but were %d.", tc.testName, expectedRequests, endpoint.requests)
+		}
+		if endpoint.basicUser != tc.expectedBasicUser || (tc.expectedBasicUser != "" && endpoint.basicSecret != tc.clientSecret) {
+			t.Errorf("%s: Expected basic auth of %s, but was %s.", tc.testName, tc.expectedBasicUser, endpoint.basicUser)
+		}
+		for name, value := range tc.expectedForm {
+			if endpoint.form[name] != value {
+				t.Errorf("%s: Expected form parameter %s=%s, but was %s.", tc.testName, name, value, endpoint.form[name])
+			}
+		}
+		if tc.authMethod == privateKeyJwt {
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(endpoint.form["client_assertion"], claims, func(token *jwt.Token) (interface{}, error) { return &validKey.PublicKey, nil })
+			if err != nil || claims["iss"] != "client" || claims["aud"] != server.URL {
+				t.Errorf("%s: Expected a valid client assertion, but was %v. Err: %v", tc.testName, claims, err)
+			}
+		}
+	}
+}
+
+func TestOAuth2TokenCacheInvalidation(t *testing.T) {
+
+	log.Info("TestOAuth2TokenCacheInvalidation +++++++++++++++++++++")
+
+	cache := oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+	cache.put("keyA", "clientA", "tokenA", time.Now().Add(time.Minute))
+	cache.put("keyB", "clientB", "tokenB", time.Now().Add(time.Minute))
+	cache.put("keyC", "clientC", "tokenC", time.Now().Add(-time.Minute))
+
+	cache.invalidate("clientA")
+
+	if _, _, ok := cache.get("keyA"); ok {
+		t.Errorf("The token of the invalidated client should be removed.")
+	}
+	if token, _, ok := cache.get("keyB"); !ok || token != "tokenB" {
+		t.Errorf("The token of other clients should be kept.")
+	}
+	if _, _, ok := cache.get("keyC"); ok {
+		t.Errorf("Expired tokens should not be cached.")
+	}
+}
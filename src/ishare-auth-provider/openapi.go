@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* The api documents, kept in sync with the ones in the api folder of the repository.
+ */
+//go:embed openapi/*.yaml
+var openApiFiles embed.FS
+
+const openApiFolder = "openapi"
+
+/**
+* Largest request body accepted, since bodies are read completely for the validation. Archives of the admin api are limited to maxArchiveSize.
+ */
+const maxRequestBodySize = 1 << 20
+
+/**
+* Routers of all embedded documents, requests are validated against the first document containing their path.
+ */
+var openApiRouters []routers.Router
+
+/**
+* Load and validate the embedded documents. Their servers are ignored, the provider serves them on any address.
+ */
+func loadOpenApiRouters() (apiRouters []routers.Router, err error) {
+	documents, err := openApiFiles.ReadDir(openApiFolder)
+	if err != nil {
+		return apiRouters, err
+	}
+	for _, document := range documents {
+		content, err := openApiFiles.ReadFile(path.Join(openApiFolder, document.Name()))
+		if err != nil {
+			return apiRouters, err
+		}
+		spec, err := openapi3.NewLoader().LoadFromData(content)
+		if err != nil {
+			return apiRouters, err
+		}
+		if err = spec.Validate(context.Background()); err != nil {
+			return apiRouters, err
+		}
+		spec.Servers = openapi3.Servers{{URL: "/"}}
+		router, err := gorillamux.NewRouter(spec)
+		if err != nil {
+			return apiRouters, err
+		}
+		apiRouters = append(apiRouters, router)
+	}
+	return apiRouters, err
+}
+
+/**
+* Serve the embedded documents at /openapi/<document>.
+ */
+func getOpenApiDocument(c *gin.Context) {
+	content, err := openApiFiles.ReadFile(path.Join(openApiFolder, path.Base(c.Param("document"))))
+	if err != nil {
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownDocument, "No document "+c.Param("document")+" exists.")
+		return
+	}
+	c.Data(http.StatusOK, "application/yaml", content)
+}
+
+/**
+* Limit the body of the request, reading beyond the limit fails. Has to run before the body is read, f.e. for the validation.
+ */
+func limitRequestBody(maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+		c.Next()
+	}
+}
+
+/**
+* Reject requests that do not match the api documents, with all violations found. Requests to paths that are not part of the
+* documents are passed on. Authentication is not part of the validation, it is done by the handlers.
+ */
+func validateRequest(c *gin.Context) {
+	for _, router := range openApiRouters {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			continue
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{MultiError: true, AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			logger.Infof("Received a request violating the api: %v", err)
+			abortWithFieldErrors(c, http.StatusBadRequest, reasonInvalidRequest, "The request does not match the api specification.", getFieldErrors(err))
+			return
+		}
+		break
+	}
+	c.Next()
+}
+
+/**
+* Flatten the validation errors to the violated fields.
+ */
+func getFieldErrors(err error) (fieldErrors []fieldError) {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, wrapped := range e {
+			fieldErrors = append(fieldErrors, getFieldErrors(wrapped)...)
+		}
+		return fieldErrors
+	case *openapi3filter.RequestError:
+		location, field := "body", ""
+		if e.Parameter != nil {
+			location, field = e.Parameter.In, e.Parameter.Name
+		}
+		if schemaErrors, ok := e.Err.(openapi3.MultiError); ok {
+			for _, schemaError := range schemaErrors {
+				fieldErrors = append(fieldErrors, getSchemaFieldError(location, field, schemaError))
+			}
+			return fieldErrors
+		}
+		if e.Err != nil {
+			return []fieldError{getSchemaFieldError(location, field, e.Err)}
+		}
+		return []fieldError{{In: location, Field: field, Message: e.Reason}}
+	default:
+		return []fieldError{{Message: err.Error()}}
+	}
+}
+
+func getSchemaFieldError(location string, field string, err error) fieldError {
+	var schemaError *openapi3.SchemaError
+	if !errors.As(err, &schemaError) {
+		return fieldError{In: location, Field: field, Message: err.Error()}
+	}
+	if location == "body" {
+		field = "/" + strings.Join(schemaError.JSONPointer(), "/")
+	}
+	return fieldError{In: location, Field: field, Message: schemaError.Reason}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestValidateRequest(t *testing.T) {
+
+	type test struct {
+		testName       string
+		method         string
+		url            string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedErrors []fieldError
+	}
+
+	validCredentials := `{"certificateChain":"cert","signingKey":"key"}`
+	tests := []test{
+		{testName: "Valid credentials.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: validCredentials, expectedStatus: 200},
+		{testName: "Valid pkcs11 credentials.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `{"certificateChain":"cert","pkcs11":{"module":"/lib/pkcs11.so","keyLabel":"key"}}`, expectedStatus: 200},
+		{testName: "Empty signing key.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `{"certificateChain":"cert","signingKey":""}`, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body", Field: "/signingKey"}}},
+		{testName: "No signing key.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `{"certificateChain":"cert"}`, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body", Field: "/"}}},
+		{testName: "Invalid pkcs11 reference.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `{"certificateChain":"cert","pkcs11":{"keyLabel":"key"}}`, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body", Field: "/pkcs11/module"}}},
+		{testName: "No certificate chain.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `{"signingKey":"key"}`, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body", Field: "/certificateChain"}}},
+		{testName: "No json.", method: http.MethodPost, url: "/credentials/client", contentType: "application/json", body: `no-json`, expectedStatus: 400},
+		{testName: "Valid certificate chain.", method: http.MethodPut, url: "/credentials/client/certificateChain", contentType: "text/plain", body: "cert", expectedStatus: 200},
+		{testName: "Empty certificate chain.", method: http.MethodPut, url: "/credentials/client/certificateChain", contentType: "text/plain", body: "", expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body"}}},
+		{testName: "Body exceeding the limit.", method: http.MethodPut, url: "/credentials/client/certificateChain", contentType: "text/plain", body: strings.Repeat("c", maxRequestBodySize+1), expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body"}}},
+		{testName: "Valid csr request.", method: http.MethodPost, url: "/credentials/client/csr", contentType: "application/json", body: `{"subject":{"commonName":"party","country":"NL"},"keySize":4096}`, expectedStatus: 200},
+		{testName: "Csr request with unsupported key size.", method: http.MethodPost, url: "/credentials/client/csr", contentType: "application/json", body: `{"subject":{"commonName":"party"},"keySize":1024}`, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "body", Field: "/keySize"}}},
+		{testName: "Valid auth request.", method: http.MethodGet, url: "/ISHARE/auth?domain=domain&path=/path", expectedStatus: 200},
+		{testName: "Auth request without domain.", method: http.MethodGet, url: "/ISHARE/auth?path=/path", expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "query", Field: "domain"}}},
+		{testName: "Auth request without domain and path.", method: http.MethodGet, url: "/ISHARE/auth", expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "query", Field: "domain"}, {In: "query", Field: "path"}}},
+		{testName: "Valid import.", method: http.MethodPost, url: "/admin/credentials/import?dryRun=true&conflictPolicy=skip", contentType: "application/octet-stream", body: "archive", expectedStatus: 200},
+		{testName: "Import with unknown conflict policy.", method: http.MethodPost, url: "/admin/credentials/import?conflictPolicy=merge", contentType: "application/octet-stream", body: "archive", expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "query", Field: "conflictPolicy"}}},
+		{testName: "Client id pointing to the parent folder.", method: http.MethodPost, url: "/credentials/..", contentType: "application/json", body: validCredentials, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "path", Field: "clientId"}}},
+		{testName: "Client id of a staging folder.", method: http.MethodPost, url: "/credentials/.import-client", contentType: "application/json", body: validCredentials, expectedStatus: 400,
+			expectedErrors: []fieldError{{In: "path", Field: "clientId"}}},
+		{testName: "Client id with dots.", method: http.MethodPost, url: "/credentials/EU.EORI.NL000000001", contentType: "application/json", body: validCredentials, expectedStatus: 200},
+		{testName: "Path outside of the api.", method: http.MethodGet, url: "/debug/vars", expectedStatus: 200},
+	}
+
+	apiRouters, err := loadOpenApiRouters()
+	if err != nil {
+		t.Fatalf("Was not able to load the api documents. %v", err)
+	}
+	openApiRouters = apiRouters
+	defer func() { openApiRouters = nil }()
+
+	// the handler returns the body it received, to verify it is still readable after the validation
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "text/plain", body)
+	}
+	router := gin.New()
+	router.Use(limitRequestBody(maxRequestBodySize), validateRequest)
+	router.POST("/credentials/:clientId", echo)
+	router.PUT("/credentials/:clientId/certificateChain", echo)
+	router.POST("/credentials/:clientId/csr", echo)
+	router.GET("/ISHARE/auth", echo)
+	router.POST("/admin/credentials/import", echo)
+	router.GET("/debug/vars", echo)
+
+	for _, tc := range tests {
+		log.Info("TestValidateRequest +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		if tc.contentType != "" {
+			request.Header.Set("Content-Type", tc.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if tc.expectedStatus == http.StatusOK {
+			if recorder.Body.String() != tc.body {
+				t.Errorf("%s: The handler should receive the full body, but got %s.", tc.testName, recorder.Body.String())
+			}
+			continue
+		}
+
+		var response problem
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Reason != reasonInvalidRequest {
+			t.Errorf("%s: Expected an %s error response, but was %s.", tc.testName, reasonInvalidRequest, recorder.Body.String())
+			continue
+		}
+		for _, expectedError := range tc.expectedErrors {
+			if !containsFieldError(response.Errors, expectedError) {
+				t.Errorf("%s: Expected a violation of %s %s, but got %s.", tc.testName, expectedError.In, expectedError.Field, fmt.Sprint(response.Errors))
+			}
+		}
+	}
+}
+
+func TestGetOpenApiDocument(t *testing.T) {
+
+	type test struct {
+		testName       string
+		document       string
+		expectedStatus int
+	}
+
+	tests := []test{
+		{testName: "Auth provider api.", document: "auth-provider-api.yaml", expectedStatus: 200},
+		{testName: "Credentials management api.", document: "ishare-credentials-management-api.yaml", expectedStatus: 200},
+		{testName: "Unknown document.", document: "unknown.yaml", expectedStatus: 404},
+		{testName: "Path traversal.", document: "..%2Fmain.go", expectedStatus: 404},
+	}
+
+	router := gin.New()
+	router.GET("/openapi/:document", getOpenApiDocument)
+
+	for _, tc := range tests {
+		log.Info("TestGetOpenApiDocument +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(http.MethodGet, "/openapi/"+tc.document, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.expectedStatus, recorder.Code)
+		}
+	}
+}
+
+/**
+* The embedded documents need to be the same as the ones published in the api folder.
+ */
+func TestEmbeddedDocumentsInSync(t *testing.T) {
+
+	log.Info("TestEmbeddedDocumentsInSync +++++++++++++++++++++")
+
+	documents, _ := openApiFiles.ReadDir(openApiFolder)
+	for _, document := range documents {
+		published, err := os.ReadFile(path.Join("..", "..", "api", document.Name()))
+		if os.IsNotExist(err) {
+			t.Skip("The api folder is not available.")
+		}
+		embedded, _ := openApiFiles.ReadFile(path.Join(openApiFolder, document.Name()))
+		if !bytes.Equal(published, embedded) {
+			t.Errorf("The embedded %s differs from the published one, copy it from the api folder.", document.Name())
+		}
+	}
+}
+
+func containsFieldError(fieldErrors []fieldError, expected fieldError) bool {
+	for _, fe := range fieldErrors {
+		if fe.In == expected.In && fe.Field == expected.Field && fe.Message != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+/**
+* Open PKCS#11 contexts, indexed by module, token and pin. Opening a context logs into the token, thus they are reused for all keys.
+ */
+type pkcs11Contexts struct {
+	mutex    sync.Mutex
+	contexts map[string]*crypto11.Context
+}
+
+var openPkcs11Contexts = pkcs11Contexts{contexts: map[string]*crypto11.Context{}}
+
+/**
+* Key inside a PKCS#11 token. A failed signature closes the context of the token, f.e. after the token was removed or its session
+* expired, so that the next request logs in again.
+ */
+type pkcs11Signer struct {
+	crypto.Signer
+	contextKey string
+}
+
+func (ps *pkcs11Signer) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	signature, err = ps.Signer.Sign(random, digest, opts)
+	if err != nil {
+		logger.Warnf("Was not able to sign with the pkcs11 key, close its context. %v", err)
+		openPkcs11Contexts.close(ps.contextKey)
+	}
+	return signature, err
+}
+
+/**
+* Find the referenced key inside the PKCS#11 token.
+ */
+func getPkcs11Signer(config Pkcs11Config) (signer crypto.Signer, err error) {
+	contextKey := getPkcs11ContextKey(config)
+	context, err := openPkcs11Contexts.get(contextKey, config)
+	if err != nil {
+		logger.Warnf("Was not able to open the pkcs11 token of %s. %v", config.Module, err)
+		return signer, err
+	}
+
+	keyPair, err := context.FindKeyPair(nil, []byte(config.KeyLabel))
+	if err != nil {
+		logger.Warnf("Was not able to search the key %s. %v", config.KeyLabel, err)
+		openPkcs11Contexts.close(contextKey)
+		return signer, err
+	}
+	if keyPair == nil {
+		return signer, fmt.Errorf("%w: %s", errPkcs11KeyNotFound, config.KeyLabel)
+	}
+	return &pkcs11Signer{keyPair, contextKey}, err
+}
+
+/**
+* Close the context used for the reference, before it is changed or removed. It is opened again on the next use.
+ */
+func closePkcs11Context(config Pkcs11Config) {
+	openPkcs11Contexts.close(getPkcs11ContextKey(config))
+}
+
+/**
+* Contexts are logged in with the pin, thus a changed pin requires a new one. Only a hash of the pin is kept.
+ */
+func getPkcs11ContextKey(config Pkcs11Config) string {
+	contextKey := config.Module + "|" + config.TokenLabel
+	if config.Slot != nil {
+		contextKey += "|" + strconv.Itoa(*config.Slot)
+	}
+	pinHash := sha256.Sum256([]byte(getPkcs11Pin(config)))
+	return contextKey + "|" + hex.EncodeToString(pinHash[:])
+}
+
+func (pc *pkcs11Contexts) get(contextKey string, config Pkcs11Config) (context *crypto11.Context, err error) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if context, ok := pc.contexts[contextKey]; ok {
+		return context, err
+	}
+	context, err = crypto11.Configure(&crypto11.Config{Path: config.Module, SlotNumber: config.Slot, TokenLabel: config.TokenLabel, Pin: getPkcs11Pin(config)})
+	if err != nil {
+		return context, err
+	}
+	pc.contexts[contextKey] = context
+	return context, err
+}
+
+/**
+* Remove the context and close it, once its running operations are finished. Cached signers of the context cannot be used anymore,
+* thus the parsed credentials are dropped.
+ */
+func (pc *pkcs11Contexts) close(contextKey string) {
+	pc.mutex.Lock()
+	context, ok := pc.contexts[contextKey]
+	delete(pc.contexts, contextKey)
+	pc.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	parsedCredentials.invalidateAll()
+	if err := context.Close(); err != nil {
+		logger.Warnf("Was not able to close the pkcs11 context. %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import "crypto"
+
+/**
+* PKCS#11 modules are loaded through cgo, without it no token can be used.
+ */
+func getPkcs11Signer(config Pkcs11Config) (signer crypto.Signer, err error) {
+	logger.Warn("The provider was built without cgo, pkcs11 tokens are not supported.")
+	return signer, errPkcs11Unsupported
+}
+
+/**
+* Without cgo, no contexts are opened.
+ */
+func closePkcs11Context(config Pkcs11Config) {}
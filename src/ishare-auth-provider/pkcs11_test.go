@@ -0,0 +1,128 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+* Locations of the SoftHSM module in the common distributions. Can be overwritten through SOFTHSM2_MODULE.
+ */
+var softHsmModules = []string{"/usr/lib/softhsm/libsofthsm2.so", "/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so", "/usr/local/lib/softhsm/libsofthsm2.so"}
+
+func TestPkcs11Signer(t *testing.T) {
+
+	log.Info("TestPkcs11Signer +++++++++++++++++++++")
+	module := getSoftHsmModule(t)
+
+	// initialize a fresh token and generate the client key inside of it
+	tokenDir := t.TempDir()
+	softHsmConfig := filepath.Join(tokenDir, "softhsm2.conf")
+	os.WriteFile(softHsmConfig, []byte("directories.tokendir = "+tokenDir+"\nobjectstore.backend = file\n"), 0600)
+	t.Setenv("SOFTHSM2_CONF", softHsmConfig)
+	t.Setenv("PKCS11_PIN_HSM", "1234")
+
+	output, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "ishare", "--so-pin", "5678", "--pin", "1234").CombinedOutput()
+	if err != nil {
+		t.Skipf("Was not able to initialize a SoftHSM token. %v: %s", err, output)
+	}
+
+	config := Pkcs11Config{Module: module, TokenLabel: "ishare", KeyLabel: "clientKey", PinVariable: "PKCS11_PIN_HSM"}
+	pkcs11Modules = map[string]bool{module: true}
+	defer func() { pkcs11Modules = map[string]bool{} }()
+	context, err := crypto11.Configure(&crypto11.Config{Path: module, TokenLabel: "ishare", Pin: "1234"})
+	if err != nil {
+		t.Fatalf("Was not able to open the token. %v", err)
+	}
+	generated, err := context.GenerateRSAKeyPairWithLabel([]byte("clientKeyId"), []byte("clientKey"), 2048)
+	if err != nil {
+		t.Fatalf("Was not able to generate the key. %v", err)
+	}
+	publicKey := generated.Public().(*rsa.PublicKey)
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_existing}
+	defer func() { globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content} }()
+	configContent, _ := json.Marshal(config)
+	contentMock = map[string][]byte{"folder/pkcs11.json": configContent}
+
+	signer, err := getSigner("folder/")
+	if err != nil {
+		t.Fatalf("Was not able to get the pkcs11 signer. %v", err)
+	}
+
+	signedToken, err := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{"iss": "clientId"}).SignedString(signer)
+	if err != nil {
+		t.Fatalf("Was not able to sign with the pkcs11 key. %v", err)
+	}
+	if _, err := jwt.Parse(signedToken, func(token *jwt.Token) (interface{}, error) { return publicKey, nil }); err != nil {
+		t.Errorf("The token should be verifiable with the public key of the token. %v", err)
+	}
+
+	config.KeyLabel = "unknownKey"
+	if _, err := getPkcs11Signer(config); !errors.Is(err, errPkcs11KeyNotFound) {
+		t.Errorf("Expected %v for an unknown key, but got %v", errPkcs11KeyNotFound, err)
+	}
+
+	// signers of a closed context are not usable anymore, the next one opens the token again
+	closePkcs11Context(config)
+	if _, err := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{"iss": "clientId"}).SignedString(signer); err == nil {
+		t.Errorf("The signer of a closed context should not be usable.")
+	}
+	signer, err = getSigner("folder/")
+	if err != nil {
+		t.Fatalf("Was not able to reopen the pkcs11 signer. %v", err)
+	}
+	if _, err := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{"iss": "clientId"}).SignedString(signer); err != nil {
+		t.Errorf("The reopened signer should be usable. %v", err)
+	}
+}
+
+func TestPkcs11ContextKey(t *testing.T) {
+
+	log.Info("TestPkcs11ContextKey +++++++++++++++++++++")
+	slot := 1
+	config := Pkcs11Config{Module: "/lib/pkcs11.so", TokenLabel: "ishare", KeyLabel: "clientKey", PinVariable: "PKCS11_PIN_HSM"}
+
+	t.Setenv("PKCS11_PIN_HSM", "1234")
+	contextKey := getPkcs11ContextKey(config)
+	if contextKey != getPkcs11ContextKey(Pkcs11Config{Module: "/lib/pkcs11.so", TokenLabel: "ishare", KeyLabel: "otherKey", PinVariable: "PKCS11_PIN_HSM"}) {
+		t.Errorf("Keys of the same token should share the context.")
+	}
+	if contextKey == getPkcs11ContextKey(Pkcs11Config{Module: "/lib/pkcs11.so", Slot: &slot, KeyLabel: "clientKey", PinVariable: "PKCS11_PIN_HSM"}) {
+		t.Errorf("Other tokens should not share the context.")
+	}
+	if strings.Contains(contextKey, "1234") {
+		t.Errorf("The context key must not contain the pin.")
+	}
+
+	t.Setenv("PKCS11_PIN_HSM", "5678")
+	if contextKey == getPkcs11ContextKey(config) {
+		t.Errorf("A changed pin should require a new context.")
+	}
+}
+
+func getSoftHsmModule(t *testing.T) string {
+	if module := os.Getenv("SOFTHSM2_MODULE"); module != "" {
+		return module
+	}
+	for _, module := range softHsmModules {
+		if _, err := os.Stat(module); err == nil {
+			return module
+		}
+	}
+	t.Skip("SoftHSM is not installed.")
+	return ""
+}
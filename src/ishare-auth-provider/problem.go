@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ishare-auth-provider/model"
+)
+
+/**
+* Base of the problem types, every type points to its entry in the error catalogue.
+ */
+const problemTypeBase = "https://github.com/fiware/endpoint-auth-service/blob/main/doc/ERRORS.md#"
+
+const problemContentType = "application/problem+json"
+
+/**
+* Key of the gin context to provide the client a problem relates to, if it is not part of the request.
+ */
+const problemClientIdKey = "problemClientId"
+
+/**
+* Machine-readable reasons for failed requests, returned together with a distinct status. The problem type is derived from them.
+ */
+const (
+	reasonInvalidRequest           = "invalid_request"
+	reasonUnknownAuthType          = "unknown_auth_type"
+	reasonUnknownEndpoint          = "unknown_endpoint"
+	reasonConfigServiceUnavailable = "config_service_unavailable"
+	reasonInvalidAuthInfo          = "invalid_auth_info"
+	reasonMissingSubjectToken      = "missing_subject_token"
+	reasonSubjectTokenRejected     = "subject_token_rejected"
+	reasonMissingCredentials       = "missing_credentials"
+	reasonInvalidCredentials       = "invalid_credentials"
+	reasonIdpRejectedClient        = "idp_rejected_client"
+	reasonIdpUnavailable           = "idp_unavailable"
+	reasonIdpInvalidResponse       = "idp_invalid_response"
+	reasonIdpNotTrusted            = "idp_not_trusted"
+	reasonTokenVerificationFailed  = "token_verification_failed"
+	reasonDelegationFailed         = "delegation_failed"
+	reasonUnknownClient            = "unknown_client"
+	reasonCredentialsExist         = "credentials_exist"
+	reasonUnknownPkcs11Reference   = "unknown_pkcs11_reference"
+	reasonUnknownPendingKey        = "unknown_pending_key"
+	reasonStorageFailure           = "storage_failure"
+	reasonUnauthorized             = "unauthorized"
+	reasonInvalidArchive           = "invalid_archive"
+	reasonInternalError            = "internal_error"
+	reasonUnknownDocument          = "unknown_document"
+)
+
+/**
+* Short, human-readable summary of every problem type.
+ */
+var problemTitles = map[string]string{
+	reasonInvalidRequest:           "Invalid request",
+	reasonUnknownAuthType:          "Unknown auth type",
+	reasonUnknownEndpoint:          "Unknown endpoint",
+	reasonConfigServiceUnavailable: "Configuration service unavailable",
+	reasonInvalidAuthInfo:          "Invalid auth info",
+	reasonMissingSubjectToken:      "Missing subject token",
+	reasonSubjectTokenRejected:     "Subject token rejected",
+	reasonMissingCredentials:       "Missing credentials",
+	reasonInvalidCredentials:       "Invalid credentials",
+	reasonIdpRejectedClient:        "Client rejected by the idp",
+	reasonIdpUnavailable:           "Idp unavailable",
+	reasonIdpInvalidResponse:       "Invalid idp response",
+	reasonIdpNotTrusted:            "Idp not trusted",
+	reasonTokenVerificationFailed:  "Token verification failed",
+	reasonDelegationFailed:         "Delegation failed",
+	reasonUnknownClient:            "Unknown client",
+	reasonCredentialsExist:         "Credentials already exist",
+	reasonUnknownPkcs11Reference:   "Unknown pkcs11 reference",
+	reasonUnknownPendingKey:        "Unknown pending key",
+	reasonStorageFailure:           "Storage failure",
+	reasonUnauthorized:             "Unauthorized",
+	reasonInvalidArchive:           "Invalid archive",
+	reasonInternalError:            "Internal error",
+	reasonUnknownDocument:          "Unknown api document",
+}
+
+/**
+* Body of a failed request, as defined by RFC 7807.
+ */
+type problem = model.Problem
+
+/**
+* Violation of the api specification, as part of the problem.
+ */
+type fieldError = model.FieldError
+
+/**
+* Abort the request with the given status and a problem body. The client, domain and path are taken from the request.
+ */
+func abortWithProblem(c *gin.Context, status int, reason string, detail string) {
+	abortWithFieldErrors(c, status, reason, detail, nil)
+}
+
+func abortWithFieldErrors(c *gin.Context, status int, reason string, detail string, fieldErrors []fieldError) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(status, newProblem(c, status, reason, detail, fieldErrors))
+}
+
+func newProblem(c *gin.Context, status int, reason string, detail string, fieldErrors []fieldError) problem {
+	title, ok := problemTitles[reason]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	clientId := c.Param("clientId")
+	if contextClientId := c.GetString(problemClientIdKey); contextClientId != "" {
+		clientId = contextClientId
+	}
+	p := problem{Type: problemTypeBase + reason, Title: title, Status: status, Detail: detail, Reason: reason, ClientId: clientId, Errors: fieldErrors}
+	if c.Request != nil {
+		p.Instance = c.Request.URL.Path
+		p.Domain = c.Query("domain")
+		p.Path = c.Query("path")
+	}
+	return p
+}
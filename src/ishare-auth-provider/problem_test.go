@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAbortWithProblem(t *testing.T) {
+
+	type test struct {
+		testName        string
+		url             string
+		clientIdParam   string
+		contextClientId string
+		status          int
+		reason          string
+		expectedProblem problem
+	}
+
+	tests := []test{
+		{testName: "Auth problem.", url: "/ISHARE/auth?domain=provider.org&path=/orders", contextClientId: "clientA", status: 503, reason: reasonIdpUnavailable,
+			expectedProblem: problem{Type: problemTypeBase + "idp_unavailable", Title: "Idp unavailable", Status: 503, Detail: "detail", Instance: "/ISHARE/auth", Reason: "idp_unavailable", ClientId: "clientA", Domain: "provider.org", Path: "/orders"}},
+		{testName: "Credentials problem.", url: "/credentials/clientB", clientIdParam: "clientB", status: 404, reason: reasonUnknownClient,
+			expectedProblem: problem{Type: problemTypeBase + "unknown_client", Title: "Unknown client", Status: 404, Detail: "detail", Instance: "/credentials/clientB", Reason: "unknown_client", ClientId: "clientB"}},
+		{testName: "Client from the context takes precedence.", url: "/credentials/clientB", clientIdParam: "clientB", contextClientId: "clientA", status: 409, reason: reasonCredentialsExist,
+			expectedProblem: problem{Type: problemTypeBase + "credentials_exist", Title: "Credentials already exist", Status: 409, Detail: "detail", Instance: "/credentials/clientB", Reason: "credentials_exist", ClientId: "clientA"}},
+		{testName: "Unknown reason.", url: "/", status: 500, reason: "something",
+			expectedProblem: problem{Type: problemTypeBase + "something", Title: "Internal Server Error", Status: 500, Detail: "detail", Instance: "/", Reason: "something"}},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestAbortWithProblem +++++++++++++++++++++ Running test: " + tc.testName)
+
+		recorder := httptest.NewRecorder()
+		ginContext, _ := gin.CreateTestContext(recorder)
+		ginContext.Request, _ = http.NewRequest(http.MethodGet, tc.url, nil)
+		if tc.clientIdParam != "" {
+			ginContext.Params = []gin.Param{{Key: "clientId", Value: tc.clientIdParam}}
+		}
+		if tc.contextClientId != "" {
+			ginContext.Set(problemClientIdKey, tc.contextClientId)
+		}
+
+		abortWithProblem(ginContext, tc.status, tc.reason, "detail")
+
+		if recorder.Code != tc.status {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.status, recorder.Code)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != problemContentType {
+			t.Errorf("%s: Expected content type %s but was %s.", tc.testName, problemContentType, contentType)
+		}
+		var returned problem
+		json.Unmarshal(recorder.Body.Bytes(), &returned)
+		if fmt.Sprint(returned) != fmt.Sprint(tc.expectedProblem) {
+			t.Errorf("%s: Expected problem %v but was %v.", tc.testName, tc.expectedProblem, returned)
+		}
+		if !ginContext.IsAborted() {
+			t.Errorf("%s: The request should have been aborted.", tc.testName)
+		}
+	}
+}
+
+/**
+* Every problem type needs to point to an entry of the error catalogue.
+ */
+func TestProblemCatalogue(t *testing.T) {
+
+	log.Info("TestProblemCatalogue +++++++++++++++++++++")
+
+	catalogue, err := os.ReadFile(path.Join("..", "..", "doc", "ERRORS.md"))
+	if os.IsNotExist(err) {
+		t.Skip("The doc folder is not available.")
+	}
+	for reason := range problemTitles {
+		if !strings.Contains(string(catalogue), "\n### "+reason+"\n") {
+			t.Errorf("The error catalogue does not contain %s.", reason)
+		}
+	}
+}
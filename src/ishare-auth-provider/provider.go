@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errUnknownAuthType = errors.New("unknown_auth_type")
+var errNoProviderConfig = errors.New("no_provider_config")
+
+/**
+* Provider for one type of authentication. It resolves the auth info of an endpoint, creates the headers to authenticate
+* requests to it and reports how long they can be cached. Failures should be returned as authFailure, to be answered with
+* the matching problem.
+ */
+type authProvider interface {
+	resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error)
+	getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error)
+}
+
+/**
+* Context of the original request, as forwarded by the filter for request bound auth types.
+ */
+type requestContext struct {
+	method    string
+	targetUri string
+	headers   http.Header
+	// nonce the endpoint challenged the previous request with, f.e. through DPoP-Nonce
+	nonce string
+}
+
+/**
+* Provider whose headers depend on the original request, e.g. acting on behalf of its user or signing it. The filter forwards the
+* headers of the request together with its method and uri. Such headers are never cached by the filter.
+ */
+type requestBoundProvider interface {
+	authProvider
+	getRequestHeaders(authInfo AuthInfo, domain string, path string, request requestContext) (headers HeadersList, cacheLifetime time.Duration, err error)
+}
+
+/**
+* Provider whose headers depend on stored state, f.e. secrets. The headers are returned together with the version of the state, thus the
+* filter can cache them for long and re-fetch them once the version served at /:authType/version changed.
+ */
+type versionedProvider interface {
+	authProvider
+	getVersion() (version string, err error)
+}
+
+/**
+* Registry of the providers, by their auth type. New types need to be added here, the route /:authType/auth serves all of them.
+ */
+var authProviders = map[string]authProvider{
+	iShareAuthType:         &iShareProvider{},
+	oauth2AuthType:         &oauth2Provider{},
+	staticAuthType:         &staticProvider{},
+	jwtBearerAuthType:      &jwtBearerProvider{},
+	tokenExchangeAuthType:  &tokenExchangeProvider{},
+	httpSignatureAuthType:  &httpSignatureProvider{},
+	sigV4AuthType:          &sigV4Provider{},
+	vcAuthType:             &vcProvider{},
+	serviceAccountAuthType: &serviceAccountProvider{},
+	dpopAuthType:           &dpopProvider{},
+}
+
+/**
+* Get the provider for the auth type. Types are matched case-insensitive, since the filter requests them upper-case while the
+* configuration service uses camel-case.
+ */
+func getAuthProvider(authType string) (provider authProvider, err error) {
+	provider, ok := authProviders[strings.ToUpper(authType)]
+	if !ok {
+		return provider, fmt.Errorf("%w: %s", errUnknownAuthType, authType)
+	}
+	return provider, err
+}
+
+/**
+* Failure of a provider, describing the problem to be returned.
+ */
+type authFailure struct {
+	status   int
+	reason   string
+	detail   string
+	clientId string
+	err      error
+}
+
+func (af *authFailure) Error() string {
+	if af.err == nil {
+		return af.reason + ": " + af.detail
+	}
+	return af.reason + ": " + af.detail + ": " + af.err.Error()
+}
+
+func (af *authFailure) Unwrap() error {
+	return af.err
+}
+
+/**
+* Resolve the auth info of the endpoint through the configured sources and check that it is meant for the auth type.
+* Used by all providers, the type-specific validation is up to them.
+ */
+func getEndpointAuthInfo(authType string, domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = authGetter.getAuthInfo(domain, path)
+	if errors.Is(err, errUnknownEndpoint) {
+		logger.Infof("No auth-info configured for %s - %s.", domain, path)
+		return authInfo, &authFailure{status: http.StatusNotFound, reason: reasonUnknownEndpoint, detail: "No auth info exists for the requested endpoint.", err: err}
+	}
+	if err != nil {
+		logger.Warn("Was not able to retrieve auth-info. ", err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonConfigServiceUnavailable, detail: "Was not able to retrieve auth info from the config-service.", err: err}
+	}
+	if authInfo.AuthType != "" && !strings.EqualFold(authInfo.AuthType, authType) {
+		logger.Warnf("Received auth-info of type %s for %s - %s, but %s was requested.", authInfo.AuthType, domain, path, authType)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "The endpoint is configured for auth type " + authInfo.AuthType + "."}
+	}
+	return authInfo, err
+}
+
+/**
+* Decode the type-specific configuration of the auth info into the given struct.
+ */
+func decodeProviderConfig(authInfo AuthInfo, config interface{}) (err error) {
+	if len(authInfo.Config) == 0 {
+		return errNoProviderConfig
+	}
+	return json.Unmarshal(authInfo.Config, config)
+}
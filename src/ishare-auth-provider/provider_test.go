@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type mockProvider struct {
+	mockAuthInfo      AuthInfo
+	resolveError      error
+	mockHeaders       HeadersList
+	mockCacheLifetime time.Duration
+	headersError      error
+}
+
+func (mp mockProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	return mp.mockAuthInfo, mp.resolveError
+}
+
+func (mp mockProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	return mp.mockHeaders, mp.mockCacheLifetime, mp.headersError
+}
+
+type mockVersionedProvider struct {
+	mockProvider
+	mockVersion  string
+	versionError error
+}
+
+func (mp mockVersionedProvider) getVersion() (version string, err error) {
+	return mp.mockVersion, mp.versionError
+}
+
+func TestGetAuthProvider(t *testing.T) {
+
+	type test struct {
+		testName      string
+		authType      string
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Registered type.", authType: "ISHARE"},
+		{testName: "Type as used by the configuration service.", authType: "iShare"},
+		{testName: "Unknown type.", authType: "UNKNOWN", expectedError: errUnknownAuthType},
+		{testName: "Empty type.", authType: "", expectedError: errUnknownAuthType},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetAuthProvider +++++++++++++++++++++ Running test: " + tc.testName)
+
+		provider, err := getAuthProvider(tc.authType)
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if tc.expectedError == nil && provider == nil {
+			t.Errorf("%s: Expected a provider.", tc.testName)
+		}
+	}
+}
+
+func TestGetAuthWithProvider(t *testing.T) {
+
+	type test struct {
+		testName             string
+		authType             string
+		provider             authProvider
+		expectedCode         int
+		expectedCacheControl string
+		expectedETag         string
+		expectedReason       string
+		expectedClientId     string
+	}
+
+	headers := HeadersList{{Name: "Authorization", Value: "Bearer token"}}
+	tests := []test{
+		{testName: "Headers with cache lifetime.", authType: "MOCK", provider: mockProvider{mockHeaders: headers, mockCacheLifetime: 10 * time.Second}, expectedCode: 200, expectedCacheControl: "max-age=10"},
+		{testName: "Auth type is case-insensitive.", authType: "mock", provider: mockProvider{mockHeaders: headers, mockCacheLifetime: 10 * time.Second}, expectedCode: 200, expectedCacheControl: "max-age=10"},
+		{testName: "Headers without cache lifetime.", authType: "MOCK", provider: mockProvider{mockHeaders: headers}, expectedCode: 200, expectedCacheControl: "no-store"},
+		{testName: "Versioned headers.", authType: "MOCK", provider: mockVersionedProvider{mockProvider: mockProvider{mockHeaders: headers, mockCacheLifetime: time.Hour}, mockVersion: "v1"}, expectedCode: 200, expectedCacheControl: "max-age=3600", expectedETag: `"v1"`},
+		{testName: "Versioned headers without readable version.", authType: "MOCK", provider: mockVersionedProvider{mockProvider: mockProvider{mockHeaders: headers, mockCacheLifetime: time.Hour}, versionError: errors.New("unreadable")}, expectedCode: 200, expectedCacheControl: "max-age=3600"},
+		{testName: "Unknown auth type.", authType: "OTHER", provider: mockProvider{mockHeaders: headers}, expectedCode: 404, expectedReason: reasonUnknownAuthType},
+		{testName: "Failure while resolving.", authType: "MOCK", provider: mockProvider{resolveError: &authFailure{status: 404, reason: reasonUnknownEndpoint, detail: "unknown"}}, expectedCode: 404, expectedReason: reasonUnknownEndpoint},
+		{testName: "Failure of the client.", authType: "MOCK", provider: mockProvider{headersError: &authFailure{status: 403, reason: reasonIdpRejectedClient, detail: "rejected", clientId: "clientId"}}, expectedCode: 403, expectedReason: reasonIdpRejectedClient, expectedClientId: "clientId"},
+		{testName: "Wrapped failure.", authType: "MOCK", provider: mockProvider{headersError: fmt.Errorf("wrapped: %w", &authFailure{status: 503, reason: reasonIdpUnavailable})}, expectedCode: 503, expectedReason: reasonIdpUnavailable},
+		{testName: "Unexpected error.", authType: "MOCK", provider: mockProvider{headersError: errors.New("unexpected")}, expectedCode: 500, expectedReason: reasonInternalError},
+	}
+
+	defer delete(authProviders, "MOCK")
+
+	for _, tc := range tests {
+		log.Info("TestGetAuthWithProvider +++++++++++++++++++++ Running test: " + tc.testName)
+		authProviders["MOCK"] = tc.provider
+
+		router := gin.New()
+		router.GET("/:authType/auth", getAuth)
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/"+tc.authType+"/auth?domain=test.domain&path=/", nil)
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedCode {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.expectedCode, recorder.Code)
+			continue
+		}
+		if tc.expectedCacheControl != "" && recorder.Header().Get("Cache-Control") != tc.expectedCacheControl {
+			t.Errorf("%s: Expected cache control %s but was %s.", tc.testName, tc.expectedCacheControl, recorder.Header().Get("Cache-Control"))
+		}
+		if recorder.Header().Get("ETag") != tc.expectedETag {
+			t.Errorf("%s: Expected the etag %s but was %s.", tc.testName, tc.expectedETag, recorder.Header().Get("ETag"))
+		}
+		if tc.expectedReason == "" {
+			continue
+		}
+		var returned problem
+		json.Unmarshal(recorder.Body.Bytes(), &returned)
+		if returned.Reason != tc.expectedReason || returned.ClientId != tc.expectedClientId {
+			t.Errorf("%s: Expected a %s problem for %s, but was %v.", tc.testName, tc.expectedReason, tc.expectedClientId, returned)
+		}
+	}
+}
+
+func TestGetAuthVersion(t *testing.T) {
+
+	type test struct {
+		testName       string
+		authType       string
+		provider       authProvider
+		expectedCode   int
+		expectedETag   string
+		expectedReason string
+	}
+
+	tests := []test{
+		{testName: "Versioned provider.", authType: "MOCK", provider: mockVersionedProvider{mockVersion: "v1"}, expectedCode: 204, expectedETag: `"v1"`},
+		{testName: "Versioned provider without state.", authType: "MOCK", provider: mockVersionedProvider{}, expectedCode: 204},
+		{testName: "Unreadable version.", authType: "MOCK", provider: mockVersionedProvider{versionError: errors.New("unreadable")}, expectedCode: 500, expectedReason: reasonStorageFailure},
+		{testName: "Provider without version.", authType: "MOCK", provider: mockProvider{}, expectedCode: 404, expectedReason: reasonUnknownAuthType},
+		{testName: "Unknown auth type.", authType: "OTHER", provider: mockVersionedProvider{mockVersion: "v1"}, expectedCode: 404, expectedReason: reasonUnknownAuthType},
+	}
+
+	defer delete(authProviders, "MOCK")
+
+	for _, tc := range tests {
+		log.Info("TestGetAuthVersion +++++++++++++++++++++ Running test: " + tc.testName)
+		authProviders["MOCK"] = tc.provider
+
+		router := gin.New()
+		router.GET("/:authType/version", getAuthVersion)
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/"+tc.authType+"/version", nil)
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedCode {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.expectedCode, recorder.Code)
+			continue
+		}
+		if recorder.Header().Get("ETag") != tc.expectedETag {
+			t.Errorf("%s: Expected the etag %s but was %s.", tc.testName, tc.expectedETag, recorder.Header().Get("ETag"))
+		}
+		if tc.expectedReason == "" {
+			continue
+		}
+		var returned problem
+		json.Unmarshal(recorder.Body.Bytes(), &returned)
+		if returned.Reason != tc.expectedReason {
+			t.Errorf("%s: Expected a %s problem, but was %v.", tc.testName, tc.expectedReason, returned)
+		}
+	}
+}
+
+func TestGetEndpointAuthInfo(t *testing.T) {
+
+	type test struct {
+		testName          string
+		mockAuthInfo      AuthInfo
+		mockAuthInfoError error
+		expectedReason    string
+	}
+
+	tests := []test{
+		{testName: "Matching type.", mockAuthInfo: AuthInfo{AuthType: "iShare", IShareClientID: "clientId"}},
+		{testName: "No type in the auth info.", mockAuthInfo: AuthInfo{IShareClientID: "clientId"}},
+		{testName: "Other type.", mockAuthInfo: AuthInfo{AuthType: "OAUTH2"}, expectedReason: reasonInvalidAuthInfo},
+		{testName: "Unknown endpoint.", mockAuthInfoError: errUnknownEndpoint, expectedReason: reasonUnknownEndpoint},
+		{testName: "Config service error.", mockAuthInfoError: errors.New("service_error"), expectedReason: reasonConfigServiceUnavailable},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetEndpointAuthInfo +++++++++++++++++++++ Running test: " + tc.testName)
+		authGetter = &mockAuthGetter{mockAuthInfo: tc.mockAuthInfo, infoGetError: tc.mockAuthInfoError}
+
+		_, err := getEndpointAuthInfo(iShareAuthType, "test.domain", "/")
+
+		var failure *authFailure
+		if tc.expectedReason == "" && err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+		}
+		if tc.expectedReason != "" && (!errors.As(err, &failure) || failure.reason != tc.expectedReason) {
+			t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+		}
+	}
+}
+
+func TestDecodeProviderConfig(t *testing.T) {
+
+	log.Info("TestDecodeProviderConfig +++++++++++++++++++++")
+
+	endpoints, err := parseAuthInfoMapping([]byte(`
+endpoints:
+  - domain: test.domain
+    path: /
+    authType: MOCK
+    config:
+      tokenUrl: https://idp.org/token
+      scopes:
+        - read
+`))
+	if err != nil {
+		t.Fatalf("Was not able to parse the mapping. %v", err)
+	}
+
+	var config struct {
+		TokenUrl string   `json:"tokenUrl"`
+		Scopes   []string `json:"scopes"`
+	}
+	err = decodeProviderConfig(endpoints["test.domain"]["/*"], &config)
+	if err != nil || config.TokenUrl != "https://idp.org/token" || len(config.Scopes) != 1 {
+		t.Errorf("Expected the configuration of the mapping, but was %v. Err: %v", config, err)
+	}
+	if err = decodeProviderConfig(AuthInfo{}, &config); !errors.Is(err, errNoProviderConfig) {
+		t.Errorf("Expected %v for auth info without config, but was %v.", errNoProviderConfig, err)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/**
+* Adherence status of parties that are allowed to be called.
+ */
+const activePartyStatus = "Active"
+
+var errNoPartyToken = errors.New("no_party_token")
+var errInvalidPartyToken = errors.New("invalid_party_token")
+var errPartyMismatch = errors.New("party_mismatch")
+var errPartyNotActive = errors.New("party_not_active")
+var errPartyCertificateMismatch = errors.New("party_certificate_mismatch")
+
+/**
+* Party information as returned by the iShare satellite.
+ */
+type PartyInfo struct {
+	PartyID      string             `json:"party_id"`
+	PartyName    string             `json:"party_name"`
+	Adherence    PartyAdherence     `json:"adherence"`
+	Certificates []PartyCertificate `json:"certificates"`
+}
+
+type PartyAdherence struct {
+	Status    string `json:"status"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+type PartyCertificate struct {
+	SubjectName     string `json:"subject_name"`
+	CertificateType string `json:"certificate_type"`
+	EnabledFrom     string `json:"enabled_from"`
+	X5c             string `json:"x5c"`
+	X5tS256         string `json:"x5t#s256"`
+}
+
+/**
+* Claims of the party token returned by the satellite.
+ */
+type partyTokenClaims struct {
+	jwt.StandardClaims
+	PartyInfo PartyInfo `json:"party_info"`
+}
+
+/**
+* Cache of the already verified parties, indexed by the party id.
+ */
+type partyStatusCache struct {
+	mutex   sync.RWMutex
+	parties map[string]cachedParty
+}
+
+type cachedParty struct {
+	party  PartyInfo
+	expiry time.Time
+}
+
+var partyCache = partyStatusCache{parties: map[string]cachedParty{}}
+
+/**
+* Get the party information of the idp from the satellite and check that the idp is allowed to be called, which requires it to be
+* active and to have certificates registered. Results are cached
+* for the configured time, but never longer than the party token is valid.
+ */
+func getTrustedParty(authInfo AuthInfo, credentialsFolderPath string) (party PartyInfo, err error) {
+
+	party, cached := partyCache.get(authInfo.IShareIdpID)
+	if !cached {
+		var expiry time.Time
+		party, expiry, err = requestParty(authInfo, credentialsFolderPath)
+		if err != nil {
+			return party, err
+		}
+		partyCache.put(party, expiry)
+	}
+
+	err = verifyPartyStatus(party)
+	if err == nil && len(party.Certificates) == 0 {
+		// tokens of the idp could not be matched
+		err = fmt.Errorf("%w: no certificates are registered for %s", errPartyCertificateMismatch, party.PartyID)
+	}
+	return party, err
+}
+
+/**
+* Retrieve the party information for the idp from the satellite, using a satellite token of the client.
+ */
+func requestParty(authInfo AuthInfo, credentialsFolderPath string) (party PartyInfo, expiry time.Time, err error) {
+
+	signedToken, err := createClientAssertion(authInfo.IShareClientID, satelliteId, credentialsFolderPath, nil)
+	if err != nil {
+		logger.Warn("Was not able to create the client assertion for the satellite.", err)
+		return party, expiry, err
+	}
+
+	tokenResponse, err := requestToken(satelliteUrl+"/connect/token", satelliteId, url.Values{
+		"grant_type":            {"client_credentials"},
+		"scope":                 {defaultScope},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {signedToken},
+		"client_id":             {authInfo.IShareClientID},
+	})
+	if err != nil {
+		logger.Warn("Was not able to get a token from the satellite.", err)
+		return party, expiry, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, satelliteUrl+"/parties/"+url.PathEscape(authInfo.IShareIdpID), nil)
+	if err != nil {
+		logger.Warn("Was not able to build the party request. Invalid satellite url.", err)
+		return party, expiry, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenResponse["access_token"].(string))
+
+	resp, err := globalHttpClient.Do(req)
+	if err != nil {
+		logger.Warn("Was not able to get the party from the satellite.", err)
+		return party, expiry, err
+	}
+	if resp.Body == nil {
+		logger.Warn("Did not receive a party response body.")
+		return party, expiry, errNoResponseBody
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warnf("The satellite responded to the party request with status %d.", resp.StatusCode)
+		return party, expiry, fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	}
+
+	var partyResponse map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&partyResponse)
+	if err != nil {
+		logger.Warn("Was not able to decode the party response.", err)
+		return party, expiry, err
+	}
+
+	partyToken, ok := partyResponse["party_token"].(string)
+	if !ok {
+		logger.Warnf("Did not receive a party token. Resp: %v", partyResponse)
+		return party, expiry, errNoPartyToken
+	}
+
+	return parsePartyToken(partyToken, authInfo)
+}
+
+/**
+* Verify the party token. It has to be signed by a certificate trusted for the satellite, be issued by the satellite for our client and
+* contain the information of the requested idp.
+ */
+func parsePartyToken(partyToken string, authInfo AuthInfo) (party PartyInfo, expiry time.Time, err error) {
+
+	claims := &partyTokenClaims{}
+	parser := jwt.Parser{ValidMethods: validTokenMethods}
+	_, err = parser.ParseWithClaims(partyToken, claims, func(token *jwt.Token) (key interface{}, err error) {
+		leaf, err := getTrustedX5cCertificate(token, satelliteTrustPool)
+		if err != nil {
+			return key, err
+		}
+		return leaf.PublicKey, err
+	})
+	if err != nil {
+		return party, expiry, fmt.Errorf("%w: %v", errInvalidPartyToken, err)
+	}
+
+	if !claims.VerifyIssuer(satelliteId, true) {
+		return party, expiry, fmt.Errorf("%w: not issued by the satellite", errInvalidPartyToken)
+	}
+	if !claims.VerifyAudience(authInfo.IShareClientID, true) {
+		return party, expiry, fmt.Errorf("%w: not issued for %s", errInvalidPartyToken, authInfo.IShareClientID)
+	}
+	if claims.PartyInfo.PartyID != authInfo.IShareIdpID {
+		return party, expiry, fmt.Errorf("%w: expected %s but was %s", errPartyMismatch, authInfo.IShareIdpID, claims.PartyInfo.PartyID)
+	}
+
+	expiry = time.Now().Add(satelliteCacheTtl)
+	if claims.ExpiresAt > 0 && time.Unix(claims.ExpiresAt, 0).Before(expiry) {
+		expiry = time.Unix(claims.ExpiresAt, 0)
+	}
+	return claims.PartyInfo, expiry, err
+}
+
+/**
+* Check that the party is active and its adherence is currently valid.
+ */
+func verifyPartyStatus(party PartyInfo) (err error) {
+
+	if party.Adherence.Status != activePartyStatus {
+		return fmt.Errorf("%w: status is %s", errPartyNotActive, party.Adherence.Status)
+	}
+
+	now := time.Now()
+	if startDate, err := time.Parse(time.RFC3339, party.Adherence.StartDate); err == nil && now.Before(startDate) {
+		return fmt.Errorf("%w: adherence starts at %s", errPartyNotActive, party.Adherence.StartDate)
+	}
+	if endDate, err := time.Parse(time.RFC3339, party.Adherence.EndDate); err == nil && now.After(endDate) {
+		return fmt.Errorf("%w: adherence ended at %s", errPartyNotActive, party.Adherence.EndDate)
+	}
+	return err
+}
+
+/**
+* Check that the access token was signed with one of the certificates registered for the party. The certificate is taken from the
+* x5c header and the signature verified with its key, tokens that cannot be matched are rejected.
+ */
+func verifyPartyCertificate(accessToken string, party PartyInfo) (err error) {
+
+	if strings.Count(accessToken, ".") != 2 {
+		return fmt.Errorf("%w: the access token is not a jwt", errPartyCertificateMismatch)
+	}
+
+	parser := jwt.Parser{ValidMethods: validTokenMethods, SkipClaimsValidation: true}
+	_, err = parser.Parse(accessToken, func(token *jwt.Token) (key interface{}, err error) {
+		leaf, err := getX5cLeaf(token)
+		if err != nil {
+			return key, err
+		}
+		for _, partyCertificate := range party.Certificates {
+			if partyCertificate.matches(leaf) {
+				return leaf.PublicKey, err
+			}
+		}
+		return key, fmt.Errorf("%w: %s", errPartyCertificateMismatch, leaf.Subject.String())
+	})
+	if err != nil && !errors.Is(err, errPartyCertificateMismatch) {
+		return fmt.Errorf("%w: %v", errPartyCertificateMismatch, err)
+	}
+	return err
+}
+
+/**
+* Get the leaf certificate from the x5c-header of the token, without verifying its chain.
+ */
+func getX5cLeaf(token *jwt.Token) (leaf *x509.Certificate, err error) {
+
+	x5c, ok := token.Header["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return leaf, fmt.Errorf("%w: the access token does not contain a certificate", errPartyCertificateMismatch)
+	}
+	encodedLeaf, _ := x5c[0].(string)
+	der, err := base64.StdEncoding.DecodeString(encodedLeaf)
+	if err != nil {
+		return leaf, fmt.Errorf("%w: %v", errPartyCertificateMismatch, err)
+	}
+	leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		return leaf, fmt.Errorf("%w: %v", errPartyCertificateMismatch, err)
+	}
+	return leaf, err
+}
+
+/**
+* Check if the certificate is the one registered for the party, either through the thumbprint or the encoded certificate.
+ */
+func (pc PartyCertificate) matches(cert *x509.Certificate) bool {
+	thumbprint := sha256.Sum256(cert.Raw)
+	if pc.X5tS256 != "" {
+		if strings.EqualFold(pc.X5tS256, hex.EncodeToString(thumbprint[:])) || pc.X5tS256 == base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+			return true
+		}
+	}
+	return pc.X5c != "" && pc.X5c == base64.StdEncoding.EncodeToString(cert.Raw)
+}
+
+func (pc *partyStatusCache) get(partyId string) (party PartyInfo, ok bool) {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	entry, ok := pc.parties[partyId]
+	if !ok || time.Now().After(entry.expiry) {
+		return party, false
+	}
+	return entry.party, true
+}
+
+func (pc *partyStatusCache) put(party PartyInfo, expiry time.Time) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.parties[party.PartyID] = cachedParty{party, expiry}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+type standInSatellite struct {
+	party        PartyInfo
+	signer       testCertificate
+	ca           testCertificate
+	issuer       string
+	audience     string
+	partyRequest int
+	partyStatus  int
+}
+
+/**
+* Minimal satellite, providing the token and the parties endpoint.
+ */
+func (sis *standInSatellite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/connect/token":
+		r.ParseForm()
+		if r.PostForm.Get("client_assertion") == "" || r.PostForm.Get("client_id") != sis.audience {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"satelliteToken","token_type":"Bearer","expires_in":3600}`))
+	case strings.HasPrefix(r.URL.Path, "/parties/"):
+		if r.Header.Get("Authorization") != "Bearer satelliteToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sis.partyRequest++
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":        sis.issuer,
+			"sub":        sis.issuer,
+			"aud":        sis.audience,
+			"exp":        time.Now().Unix() + 30,
+			"party_info": sis.party,
+		})
+		token.Header["x5c"] = []string{base64.StdEncoding.EncodeToString(sis.signer.cert.Raw), base64.StdEncoding.EncodeToString(sis.ca.cert.Raw)}
+		signedToken, _ := token.SignedString(sis.signer.key)
+		if sis.partyStatus != 0 {
+			w.WriteHeader(sis.partyStatus)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"party_token": signedToken})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGetTrustedParty(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	satellite := getTestCertificate(&ca, "satellite")
+	untrustedCa := getTestCertificate(nil, "untrusted")
+	untrustedSatellite := getTestCertificate(&untrustedCa, "satellite")
+	idp := getTestCertificate(&ca, "idp")
+
+	validKey, _ := getValidKey()
+	authInfo := AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId"}
+
+	activeParty := PartyInfo{PartyID: "idpId", PartyName: "IdP", Adherence: PartyAdherence{Status: "Active", StartDate: time.Now().Add(-time.Hour).Format(time.RFC3339), EndDate: time.Now().Add(time.Hour).Format(time.RFC3339)},
+		Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}
+	inactiveParty := PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Revoked"}}
+	endedParty := PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Active", EndDate: time.Now().Add(-time.Hour).Format(time.RFC3339)}}
+	notStartedParty := PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Active", StartDate: time.Now().Add(time.Hour).Format(time.RFC3339)}}
+	otherParty := PartyInfo{PartyID: "otherId", Adherence: PartyAdherence{Status: "Active"}}
+	noCertificatesParty := PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Active"}}
+
+	type test struct {
+		testName      string
+		satellite     standInSatellite
+		expectedParty string
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Active party.", satellite: standInSatellite{party: activeParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedParty: "idpId"},
+		{testName: "Inactive party.", satellite: standInSatellite{party: inactiveParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedError: errPartyNotActive},
+		{testName: "Adherence ended.", satellite: standInSatellite{party: endedParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedError: errPartyNotActive},
+		{testName: "Adherence not started.", satellite: standInSatellite{party: notStartedParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedError: errPartyNotActive},
+		{testName: "No certificates registered.", satellite: standInSatellite{party: noCertificatesParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedError: errPartyCertificateMismatch},
+		{testName: "Other party returned.", satellite: standInSatellite{party: otherParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId"}, expectedError: errPartyMismatch},
+		{testName: "Untrusted satellite.", satellite: standInSatellite{party: activeParty, signer: untrustedSatellite, ca: untrustedCa, issuer: "satelliteId", audience: "clientId"}, expectedError: errInvalidPartyToken},
+		{testName: "Wrong issuer.", satellite: standInSatellite{party: activeParty, signer: satellite, ca: ca, issuer: "otherSatellite", audience: "clientId"}, expectedError: errInvalidPartyToken},
+		{testName: "Error response of the satellite.", satellite: standInSatellite{party: activeParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "clientId", partyStatus: http.StatusInternalServerError}, expectedError: errUnexpectedStatus},
+		{testName: "Not allowed to request satellite.", satellite: standInSatellite{party: activeParty, signer: satellite, ca: ca, issuer: "satelliteId", audience: "otherClient"}, expectedError: errIdpRejectedClient},
+	}
+
+	satelliteId = "satelliteId"
+	satelliteTrustPool = x509.NewCertPool()
+	satelliteTrustPool.AddCert(ca.cert)
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert"}
+	globalHttpClient = &http.Client{}
+	defer func() { satelliteUrl = ""; satelliteTrustPool = nil }()
+
+	for _, tc := range tests {
+		log.Info("TestGetTrustedParty +++++++++++++++++++++ Running test: " + tc.testName)
+
+		partyCache = partyStatusCache{parties: map[string]cachedParty{}}
+		server := httptest.NewServer(&tc.satellite)
+		satelliteUrl = server.URL
+
+		party, err := getTrustedParty(authInfo, "folder/")
+		server.Close()
+
+		if tc.expectedError == nil && err != nil {
+			t.Errorf(tc.testName + ": No error expected, but was: " + fmt.Sprint(err))
+		}
+		if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if tc.expectedError == nil && party.PartyID != tc.expectedParty {
+			t.Errorf(tc.testName + ": Expected party: " + tc.expectedParty + " but got: " + party.PartyID)
+		}
+	}
+}
+
+func TestPartyCaching(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	validKey, _ := getValidKey()
+	log.Info("TestPartyCaching +++++++++++++++++++++")
+
+	satellite := standInSatellite{party: PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Active"}, Certificates: []PartyCertificate{{X5tS256: "thumbprint"}}}, signer: getTestCertificate(&ca, "satellite"), ca: ca, issuer: "satelliteId", audience: "clientId"}
+	server := httptest.NewServer(&satellite)
+	defer server.Close()
+
+	satelliteUrl = server.URL
+	satelliteId = "satelliteId"
+	satelliteTrustPool = x509.NewCertPool()
+	satelliteTrustPool.AddCert(ca.cert)
+	partyCache = partyStatusCache{parties: map[string]cachedParty{}}
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert"}
+	globalHttpClient = &http.Client{}
+	defer func() { satelliteUrl = ""; satelliteTrustPool = nil }()
+
+	for i := 0; i < 3; i++ {
+		_, err := getTrustedParty(AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId"}, "folder/")
+		if err != nil {
+			t.Errorf("Party should be trusted, but was: " + fmt.Sprint(err))
+		}
+	}
+	if satellite.partyRequest != 1 {
+		t.Errorf("Party should have been requested once, but was requested " + fmt.Sprint(satellite.partyRequest) + " times.")
+	}
+
+	// once the cached status expired, a revocation at the satellite takes effect.
+	satellite.party.Adherence.Status = "Revoked"
+	partyCache.put(PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "Active"}}, time.Now().Add(-time.Second))
+	_, err := getTrustedParty(AuthInfo{IShareClientID: "clientId", IShareIdpID: "idpId"}, "folder/")
+	if !errors.Is(err, errPartyNotActive) {
+		t.Errorf("Expired party should have been requested again and be rejected, but was: " + fmt.Sprint(err))
+	}
+}
+
+func TestVerifyPartyCertificate(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	idp := getTestCertificate(&ca, "idp")
+	otherIdp := getTestCertificate(&ca, "other")
+
+	thumbprint := sha256.Sum256(idp.cert.Raw)
+	claims := jwt.MapClaims{"iss": "idpId"}
+
+	type test struct {
+		testName      string
+		accessToken   string
+		party         PartyInfo
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Matching x5c.", accessToken: signWithX5c(claims, idp, ca), party: PartyInfo{Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}},
+		{testName: "Matching hex thumbprint.", accessToken: signWithX5c(claims, idp, ca), party: PartyInfo{Certificates: []PartyCertificate{{X5tS256: strings.ToUpper(hex.EncodeToString(thumbprint[:]))}}}},
+		{testName: "Matching base64url thumbprint.", accessToken: signWithX5c(claims, idp, ca), party: PartyInfo{Certificates: []PartyCertificate{{X5tS256: base64.RawURLEncoding.EncodeToString(thumbprint[:])}}}},
+		{testName: "Opaque token.", accessToken: "opaque", party: PartyInfo{Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}, expectedError: errPartyCertificateMismatch},
+		{testName: "No x5c in token.", accessToken: signWithKid(claims, idp.key, "kid"), party: PartyInfo{Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}, expectedError: errPartyCertificateMismatch},
+		{testName: "Copied certificate of the party.", accessToken: signWithX5c(claims, testCertificate{cert: idp.cert, key: otherIdp.key}, ca), party: PartyInfo{Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}, expectedError: errPartyCertificateMismatch},
+		{testName: "Other certificate.", accessToken: signWithX5c(claims, otherIdp, ca), party: PartyInfo{Certificates: []PartyCertificate{{X5c: base64.StdEncoding.EncodeToString(idp.cert.Raw)}}}, expectedError: errPartyCertificateMismatch},
+		{testName: "No party certificates.", accessToken: signWithX5c(claims, idp, ca), party: PartyInfo{}, expectedError: errPartyCertificateMismatch},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestVerifyPartyCertificate +++++++++++++++++++++ Running test: " + tc.testName)
+
+		err := verifyPartyCertificate(tc.accessToken, tc.party)
+
+		if tc.expectedError == nil && err != nil {
+			t.Errorf(tc.testName + ": No error expected, but was: " + fmt.Sprint(err))
+		}
+		if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+	}
+}
+
+func TestGetAuthRouteWithSatellite(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	validKey, _ := getValidKey()
+	log.Info("TestGetAuthRouteWithSatellite +++++++++++++++++++++")
+
+	satellite := standInSatellite{party: PartyInfo{PartyID: "idpId", Adherence: PartyAdherence{Status: "NotActive"}}, signer: getTestCertificate(&ca, "satellite"), ca: ca, issuer: "satelliteId", audience: "clientId"}
+	server := httptest.NewServer(&satellite)
+	defer server.Close()
+
+	idpCalled := false
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idpCalled = true
+		w.Write([]byte(`{"access_token":"myToken","token_type":"Bearer"}`))
+	}))
+	defer idp.Close()
+
+	satelliteUrl = server.URL
+	satelliteId = "satelliteId"
+	satelliteTrustPool = x509.NewCertPool()
+	satelliteTrustPool.AddCert(ca.cert)
+	partyCache = partyStatusCache{parties: map[string]cachedParty{}}
+	globalHttpClient = &http.Client{}
+	authGetter = &mockAuthGetter{mockKey: validKey, mockCert: "cert", mockAuthInfo: AuthInfo{IShareIdpAddress: idp.URL, IShareClientID: "clientId", IShareIdpID: "idpId", RequestGrantType: "client_credentials"}}
+	defer func() { satelliteUrl = ""; satelliteTrustPool = nil }()
+
+	recorder := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(recorder)
+	ginContext.Request, _ = http.NewRequest(http.MethodGet, "http://auth.domain/ISHARE/auth?domain=test.domain&path=/", nil)
+	ginContext.Params = gin.Params{{Key: "authType", Value: "ISHARE"}}
+
+	getAuth(ginContext)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("Inactive idp should be rejected with 502, but was: " + fmt.Sprint(recorder.Code))
+	}
+	if idpCalled {
+		t.Errorf("Inactive idp should not be called.")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+/**
+* Auth type of the Kubernetes service account provider, as used in its route /SERVICE_ACCOUNT_TOKEN/auth.
+ */
+const serviceAccountAuthType = "SERVICE_ACCOUNT_TOKEN"
+
+/**
+* Lifetime requested for the tokens. Kubernetes does not issue tokens valid for less than 10 minutes.
+ */
+const (
+	defaultServiceAccountTokenExpiration = 3600
+	minServiceAccountTokenExpiration     = 600
+)
+
+/**
+* Timeout for the TokenRequest call to the api server.
+ */
+const kubernetesRequestTimeout = 10 * time.Second
+
+/**
+* Namespace of the pod, as mounted into every container with a service account.
+ */
+const podNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var errInvalidServiceAccountConfig = errors.New("invalid_service_account_config")
+
+/**
+* Client for the Kubernetes api, nil if the provider does not run in a cluster and no kubeconfig is configured.
+ */
+var kubernetesClient kubernetes.Interface
+
+/**
+* Namespace of the service accounts, if the auth info does not contain one. Defaults to the namespace of the pod.
+ */
+var kubernetesNamespace string
+
+/**
+* Configuration of an endpoint using projected service account tokens, read from the config of its auth info.
+ */
+type serviceAccountConfig struct {
+	ServiceAccount    string `json:"serviceAccount"`
+	Namespace         string `json:"namespace,omitempty"`
+	Audience          string `json:"audience"`
+	ExpirationSeconds int64  `json:"expirationSeconds,omitempty"`
+}
+
+/**
+* Tokens of the service account provider, indexed by service account, audience and lifetime.
+ */
+var serviceAccountTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Provider requesting audience bound tokens of a service account through the TokenRequest api of Kubernetes, to be used with
+* services trusting the workload identity of the cluster. Tokens are cached until shortly before their expiry.
+ */
+type serviceAccountProvider struct{}
+
+func (serviceAccountProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(serviceAccountAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getServiceAccountConfig(authInfo); err != nil {
+		logger.Warnf("Received invalid service account auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid service account auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+func (serviceAccountProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getServiceAccountConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+	if kubernetesClient == nil {
+		logger.Warn("Received a request for a service account token, but no kubernetes api is configured.")
+		return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonInternalError, detail: "No kubernetes api is configured."}
+	}
+
+	serviceAccountId := config.Namespace + "/" + config.ServiceAccount
+	cacheKey := fmt.Sprintf("%s/%s/%d", serviceAccountId, config.Audience, config.ExpirationSeconds)
+	token, expiry, cached := serviceAccountTokenCache.get(cacheKey)
+	if !cached {
+		token, expiry, err = requestServiceAccountToken(config)
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(serviceAccountId, err)
+		}
+		serviceAccountTokenCache.put(cacheKey, serviceAccountId, token, expiry)
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + token}}
+	return headers, time.Until(expiry), err
+}
+
+/**
+* Decode and validate the service account configuration of the auth info. Names have to be valid Kubernetes names.
+ */
+func getServiceAccountConfig(authInfo AuthInfo) (config serviceAccountConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidServiceAccountConfig, err)
+	}
+	if config.Namespace == "" {
+		config.Namespace = kubernetesNamespace
+	}
+	if config.ExpirationSeconds == 0 {
+		config.ExpirationSeconds = defaultServiceAccountTokenExpiration
+	}
+	if config.Audience == "" {
+		return config, fmt.Errorf("%w: an audience is required", errInvalidServiceAccountConfig)
+	}
+	if errs := validation.IsDNS1123Subdomain(config.ServiceAccount); len(errs) > 0 {
+		return config, fmt.Errorf("%w: invalid service account %s: %s", errInvalidServiceAccountConfig, config.ServiceAccount, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Label(config.Namespace); len(errs) > 0 {
+		return config, fmt.Errorf("%w: invalid namespace %s: %s", errInvalidServiceAccountConfig, config.Namespace, strings.Join(errs, ", "))
+	}
+	if config.ExpirationSeconds < minServiceAccountTokenExpiration {
+		return config, fmt.Errorf("%w: the expiration has to be at least %d seconds", errInvalidServiceAccountConfig, minServiceAccountTokenExpiration)
+	}
+	return config, err
+}
+
+/**
+* Request a token for the service account. The api server may shorten the lifetime, thus the expiry is taken from its response.
+ */
+func requestServiceAccountToken(config serviceAccountConfig) (token string, expiry time.Time, err error) {
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{config.Audience},
+			ExpirationSeconds: &config.ExpirationSeconds,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesRequestTimeout)
+	defer cancel()
+	response, err := kubernetesClient.CoreV1().ServiceAccounts(config.Namespace).CreateToken(ctx, config.ServiceAccount, tokenRequest, metav1.CreateOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return token, expiry, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) || apierrors.IsBadRequest(err) || apierrors.IsInvalid(err):
+		return token, expiry, fmt.Errorf("%w: %v", errIdpRejectedClient, err)
+	case err != nil:
+		return token, expiry, fmt.Errorf("%w: %v", errIdpUnavailable, err)
+	}
+
+	if response.Status.Token == "" {
+		return token, expiry, errors.New("no token in the token request response")
+	}
+	return response.Status.Token, response.Status.ExpirationTimestamp.Add(-oauth2ExpiryMargin), err
+}
+
+/**
+* Create the client for the Kubernetes api, from the kubeconfig if one is given, otherwise from the in-cluster configuration.
+ */
+func newKubernetesClient(kubeconfig string) (client kubernetes.Interface, err error) {
+	var restConfig *rest.Config
+	if kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return client, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return client, err
+	}
+	return clientset, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetServiceAccountConfig(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedConfig serviceAccountConfig
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Defaults.", config: `{"serviceAccount":"orders","audience":"https://orders.provider.org"}`,
+			expectedConfig: serviceAccountConfig{ServiceAccount: "orders", Namespace: "provider", Audience: "https://orders.provider.org", ExpirationSeconds: defaultServiceAccountTokenExpiration}},
+		{testName: "Configured token.", config: `{"serviceAccount":"orders","namespace":"other","audience":"vault","expirationSeconds":600}`,
+			expectedConfig: serviceAccountConfig{ServiceAccount: "orders", Namespace: "other", Audience: "vault", ExpirationSeconds: 600}},
+		{testName: "No audience.", config: `{"serviceAccount":"orders"}`, expectedError: errInvalidServiceAccountConfig},
+		{testName: "Invalid service account.", config: `{"serviceAccount":"Orders/Admin","audience":"vault"}`, expectedError: errInvalidServiceAccountConfig},
+		{testName: "Invalid namespace.", config: `{"serviceAccount":"orders","namespace":"my.namespace","audience":"vault"}`, expectedError: errInvalidServiceAccountConfig},
+		{testName: "Expiration too short.", config: `{"serviceAccount":"orders","audience":"vault","expirationSeconds":60}`, expectedError: errInvalidServiceAccountConfig},
+		{testName: "No config.", expectedError: errInvalidServiceAccountConfig},
+	}
+
+	kubernetesNamespace = "provider"
+	defer func() { kubernetesNamespace = "" }()
+
+	for _, tc := range tests {
+		log.Info("TestGetServiceAccountConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getServiceAccountConfig(AuthInfo{AuthType: serviceAccountAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && fmt.Sprint(config) != fmt.Sprint(tc.expectedConfig) {
+			t.Errorf("%s: Expected config %v but was %v.", tc.testName, tc.expectedConfig, config)
+		}
+	}
+}
+
+func TestServiceAccountGetHeaders(t *testing.T) {
+
+	type test struct {
+		testName       string
+		serviceAccount string
+		noClient       bool
+		apiError       error
+		tokenLifetime  time.Duration
+		expectedReason string
+	}
+
+	serviceAccounts := schema.GroupResource{Resource: "serviceaccounts"}
+	tests := []test{
+		{testName: "Issue token.", serviceAccount: "orders", tokenLifetime: time.Hour},
+		{testName: "Lifetime shortened by the api server.", serviceAccount: "orders", tokenLifetime: 10 * time.Minute},
+		{testName: "Unknown service account.", serviceAccount: "unknown", expectedReason: reasonMissingCredentials},
+		{testName: "Token request not allowed.", serviceAccount: "orders", apiError: apierrors.NewForbidden(serviceAccounts, "orders", errors.New("no rbac")), expectedReason: reasonIdpRejectedClient},
+		{testName: "Api server unavailable.", serviceAccount: "orders", apiError: apierrors.NewServiceUnavailable("overloaded"), expectedReason: reasonIdpUnavailable},
+		{testName: "Empty token.", serviceAccount: "orders", expectedReason: reasonIdpInvalidResponse},
+		{testName: "No kubernetes api.", serviceAccount: "orders", noClient: true, expectedReason: reasonInternalError},
+	}
+
+	kubernetesNamespace = "provider"
+	defer func() {
+		kubernetesNamespace = ""
+		kubernetesClient = nil
+	}()
+
+	for _, tc := range tests {
+		log.Info("TestServiceAccountGetHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		serviceAccountTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+		// fake api server, issuing tokens for the existing service account
+		clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "provider"}})
+		var tokenRequests []*authenticationv1.TokenRequest
+		clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			createAction := action.(k8stesting.CreateActionImpl)
+			if createAction.GetSubresource() != "token" {
+				return false, nil, nil
+			}
+			if tc.apiError != nil {
+				return true, nil, tc.apiError
+			}
+			if _, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("serviceaccounts"), action.GetNamespace(), createAction.Name); err != nil {
+				return true, nil, err
+			}
+			tokenRequest := createAction.GetObject().(*authenticationv1.TokenRequest)
+			tokenRequests = append(tokenRequests, tokenRequest)
+			response := tokenRequest.DeepCopy()
+			if tc.tokenLifetime > 0 {
+				response.Status = authenticationv1.TokenRequestStatus{Token: "myToken", ExpirationTimestamp: metav1.NewTime(time.Now().Add(tc.tokenLifetime))}
+			}
+			return true, response, nil
+		})
+		kubernetesClient = clientset
+		if tc.noClient {
+			kubernetesClient = nil
+		}
+
+		authInfo := AuthInfo{AuthType: serviceAccountAuthType, Config: json.RawMessage(`{"serviceAccount":"` + tc.serviceAccount + `","audience":"vault"}`)}
+		headers, cacheLifetime, err := serviceAccountProvider{}.getHeaders(authInfo, "vault.provider.org", "/")
+		// a second request is served from the cache
+		serviceAccountProvider{}.getHeaders(authInfo, "vault.provider.org", "/")
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if len(headers) != 1 || headers[0].Value != "Bearer myToken" {
+			t.Errorf("%s: Expected the bearer token, but was %v.", tc.testName, headers)
+		}
+		expectedLifetime := tc.tokenLifetime - oauth2ExpiryMargin
+		if cacheLifetime > expectedLifetime || cacheLifetime < expectedLifetime-2*time.Second {
+			t.Errorf("%s: Expected a cache lifetime of %v, but was %v.", tc.testName, expectedLifetime, cacheLifetime)
+		}
+		if len(tokenRequests) != 1 {
+			t.Errorf("%s: Expected the token to be cached, but %d tokens were requested.", tc.testName, len(tokenRequests))
+			continue
+		}
+		if spec := tokenRequests[0].Spec; fmt.Sprint(spec.Audiences) != "[vault]" || *spec.ExpirationSeconds != defaultServiceAccountTokenExpiration {
+			t.Errorf("%s: Expected a token for the audience, but was %v.", tc.testName, spec)
+		}
+	}
+}
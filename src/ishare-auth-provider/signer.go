@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"ishare-auth-provider/model"
+)
+
+/**
+* Name of the file referencing a key inside of a PKCS#11 token. If present, it is used instead of the key file.
+ */
+const pkcs11File = "pkcs11.json"
+
+/**
+* Name of the environment variable containing the user pin, if nothing else is configured.
+ */
+const defaultPkcs11PinVariable = "PKCS11_PIN"
+
+/**
+* Prefix of the environment variables a reference may read its pin from, so that no other variable, f.e. the admin token, can be used.
+ */
+const pkcs11PinVariablePrefix = "PKCS11_PIN"
+
+/**
+* PKCS#11 modules that references may load, configured through PKCS11_MODULES. Modules run inside the provider, thus no other path is accepted.
+ */
+var pkcs11Modules = map[string]bool{}
+
+var errInvalidPkcs11Config = errors.New("invalid_pkcs11_config")
+var errPkcs11Unsupported = errors.New("pkcs11_unsupported")
+var errPkcs11KeyNotFound = errors.New("pkcs11_key_not_found")
+
+/**
+* Reference to a signing key inside a PKCS#11 token, shared with the admin cli.
+ */
+type Pkcs11Config = model.Pkcs11Config
+
+/**
+* Signing method for RS256 with keys that are only available as crypto.Signer, f.e. inside an HSM. The jwt library only signs
+* with *rsa.PrivateKey.
+ */
+type signerSigningMethod struct {
+	*jwt.SigningMethodRSA
+}
+
+var signingMethodSignerRS256 = &signerSigningMethod{jwt.SigningMethodRS256}
+
+func (sm *signerSigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return "", fmt.Errorf("%w: only rsa keys are supported", errInvalidSigningKey)
+	}
+
+	hasher := sm.Hash.New()
+	hasher.Write([]byte(signingString))
+	signature, err := signer.Sign(rand.Reader, hasher.Sum(nil), sm.Hash)
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(signature), nil
+}
+
+/**
+* Get the signer for the client, either from its PKCS#11 reference or from the key file.
+ */
+func getSigner(credentialsFolderPath string) (signer crypto.Signer, err error) {
+	configFile, err := globalFileAccessor.read(credentialsFolderPath + pkcs11File)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := getSigningKey(credentialsFolderPath)
+		if key == nil {
+			return nil, err
+		}
+		return key, err
+	}
+	if err != nil {
+		logger.Warn("Was not able to read the pkcs11 config. ", err)
+		return signer, err
+	}
+
+	config, err := parsePkcs11Config(configFile)
+	if err != nil {
+		logger.Warn("Was not able to parse the pkcs11 config. ", err)
+		return signer, err
+	}
+	return getPkcs11Signer(config)
+}
+
+/**
+* Close the PKCS#11 context of the reference inside the folder, if there is one. Called before the reference is changed or removed.
+ */
+func releasePkcs11Reference(credentialsFolderPath string) {
+	configFile, err := globalFileAccessor.read(credentialsFolderPath + pkcs11File)
+	if err != nil {
+		return
+	}
+	if config, err := parsePkcs11Config(configFile); err == nil {
+		closePkcs11Context(config)
+	}
+}
+
+func parsePkcs11Config(content []byte) (config Pkcs11Config, err error) {
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidPkcs11Config, err)
+	}
+	return config, validatePkcs11Config(config)
+}
+
+/**
+* A module and a key label are required, the token has to be identified by exactly one of slot and label. The module needs to be allowed
+* and the pin can only be read from variables with the pin prefix.
+ */
+func validatePkcs11Config(config Pkcs11Config) error {
+	if config.Module == "" {
+		return fmt.Errorf("%w: no module configured", errInvalidPkcs11Config)
+	}
+	if !pkcs11Modules[config.Module] {
+		return fmt.Errorf("%w: module %s is not allowed", errInvalidPkcs11Config, config.Module)
+	}
+	if config.PinVariable != "" && !strings.HasPrefix(config.PinVariable, pkcs11PinVariablePrefix) {
+		return fmt.Errorf("%w: pin variable has to start with %s", errInvalidPkcs11Config, pkcs11PinVariablePrefix)
+	}
+	if config.KeyLabel == "" {
+		return fmt.Errorf("%w: no key label configured", errInvalidPkcs11Config)
+	}
+	if (config.Slot == nil) == (config.TokenLabel == "") {
+		return fmt.Errorf("%w: either slot or token label have to be configured", errInvalidPkcs11Config)
+	}
+	return nil
+}
+
+/**
+* User pin of the token, read from the configured environment variable.
+ */
+func getPkcs11Pin(config Pkcs11Config) string {
+	if config.PinVariable != "" {
+		return os.Getenv(config.PinVariable)
+	}
+	return os.Getenv(defaultPkcs11PinVariable)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+func mock_read_existing(filename string) (content []byte, err error) {
+	content, ok := contentMock[filename]
+	if !ok {
+		return content, fs.ErrNotExist
+	}
+	return content, err
+}
+
+func TestSignerSigningMethod(t *testing.T) {
+
+	rsaKey, _ := getValidKey()
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	type test struct {
+		testName      string
+		key           interface{}
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Sign with rsa key.", key: rsaKey},
+		{testName: "Sign with opaque signer.", key: opaqueSigner{rsaKey}},
+		{testName: "Ec keys are not supported.", key: ecKey, expectedError: errInvalidSigningKey},
+		{testName: "No signer.", key: "key", expectedError: jwt.ErrInvalidKeyType},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestSignerSigningMethod +++++++++++++++++++++ Running test: " + tc.testName)
+
+		signedToken, err := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{"iss": "clientId"}).SignedString(tc.key)
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+			continue
+		}
+		if tc.expectedError != nil {
+			continue
+		}
+		token, err := jwt.Parse(signedToken, func(token *jwt.Token) (interface{}, error) { return &rsaKey.PublicKey, nil })
+		if err != nil || !token.Valid {
+			t.Errorf(tc.testName + ": The token should be verifiable with the public key. Err: " + fmt.Sprint(err))
+		}
+		if token.Header["alg"] != "RS256" {
+			t.Errorf(tc.testName + ": The token should be signed with RS256, but was " + fmt.Sprint(token.Header["alg"]))
+		}
+	}
+}
+
+func TestParsePkcs11Config(t *testing.T) {
+
+	type test struct {
+		testName      string
+		config        string
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Reference by slot.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","slot":0,"keyLabel":"clientKey"}`},
+		{testName: "Reference by token label.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","tokenLabel":"ishare","keyLabel":"clientKey","pinVariable":"PKCS11_PIN_HSM"}`},
+		{testName: "Module not allowed.", config: `{"module":"/tmp/credentials/client/key.pem","slot":0,"keyLabel":"clientKey"}`, expectedError: errInvalidPkcs11Config},
+		{testName: "Pin variable without prefix.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","slot":0,"keyLabel":"clientKey","pinVariable":"ADMIN_TOKEN"}`, expectedError: errInvalidPkcs11Config},
+		{testName: "Invalid json.", config: `{"module":`, expectedError: errInvalidPkcs11Config},
+		{testName: "No module.", config: `{"slot":0,"keyLabel":"clientKey"}`, expectedError: errInvalidPkcs11Config},
+		{testName: "No key label.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","slot":0}`, expectedError: errInvalidPkcs11Config},
+		{testName: "No token.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","keyLabel":"clientKey"}`, expectedError: errInvalidPkcs11Config},
+		{testName: "Slot and token label.", config: `{"module":"/usr/lib/softhsm/libsofthsm2.so","slot":0,"tokenLabel":"ishare","keyLabel":"clientKey"}`, expectedError: errInvalidPkcs11Config},
+	}
+
+	pkcs11Modules = map[string]bool{"/usr/lib/softhsm/libsofthsm2.so": true}
+	defer func() { pkcs11Modules = map[string]bool{} }()
+
+	for _, tc := range tests {
+		log.Info("TestParsePkcs11Config +++++++++++++++++++++ Running test: " + tc.testName)
+
+		_, err := parsePkcs11Config([]byte(tc.config))
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+	}
+}
+
+func TestGetSigner(t *testing.T) {
+
+	type test struct {
+		testName      string
+		files         map[string][]byte
+		expectSigner  bool
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Use the key file.", files: map[string][]byte{"folder/key.pem": getValidKeyBytes()}, expectSigner: true},
+		{testName: "No credentials.", files: map[string][]byte{}, expectedError: fs.ErrNotExist},
+		{testName: "Invalid pkcs11 reference.", files: map[string][]byte{"folder/key.pem": getValidKeyBytes(), "folder/pkcs11.json": []byte(`{}`)}, expectedError: errInvalidPkcs11Config},
+	}
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_existing}
+	defer func() { globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content} }()
+
+	for _, tc := range tests {
+		log.Info("TestGetSigner +++++++++++++++++++++ Running test: " + tc.testName)
+		contentMock = tc.files
+
+		signer, err := getSigner("folder/")
+
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if (signer != nil) != tc.expectSigner {
+			t.Errorf(tc.testName + ": Expected a signer: " + fmt.Sprint(tc.expectSigner) + " but was " + fmt.Sprint(signer))
+		}
+	}
+}
+
+/**
+* Signer that does not expose the private key, like keys inside an hsm.
+ */
+type opaqueSigner struct {
+	key *rsa.PrivateKey
+}
+
+func (os opaqueSigner) Public() crypto.PublicKey { return &os.key.PublicKey }
+func (os opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return os.key.Sign(rand, digest, opts)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+* Auth type of the AWS Signature Version 4 provider, as used in its route /AWS_SIGV4/auth.
+ */
+const sigV4AuthType = "AWS_SIGV4"
+
+/**
+* Algorithm, time format and payload hashes of Signature Version 4.
+ */
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat    = "20060102T150405Z"
+	sigV4DateFormat    = "20060102"
+	sigV4Terminator    = "aws4_request"
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	defaultSigV4Region = "us-east-1"
+	s3Service          = "s3"
+)
+
+/**
+* Headers set by the provider. The content hash is calculated by the filter, since the provider never sees the body.
+ */
+const (
+	amzDateHeader          = "x-amz-date"
+	amzContentSha256Header = "x-amz-content-sha256"
+	amzSecurityTokenHeader = "x-amz-security-token"
+)
+
+/**
+* Files of the sigv4 credentials, stored in one folder per access key id.
+ */
+const (
+	secretAccessKeyFile = "secretAccessKey"
+	sessionTokenFile    = "sessionToken"
+)
+
+var errInvalidSigV4Config = errors.New("invalid_sigv4_config")
+var errNoSecretAccessKey = errors.New("no_secret_access_key")
+
+/**
+* Folder to store the access keys for Signature Version 4 in, one folder per access key id.
+ */
+var sigV4CredentialsFolder string
+
+/**
+* Configuration of an endpoint using Signature Version 4, read from the config of its auth info. The secret of the access key is
+* stored through the sigv4 credentials api.
+ */
+type sigV4Config struct {
+	AccessKeyId     string   `json:"accessKeyId"`
+	Region          string   `json:"region,omitempty"`
+	Service         string   `json:"service,omitempty"`
+	SignedHeaders   []string `json:"signedHeaders,omitempty"`
+	UnsignedPayload bool     `json:"unsignedPayload,omitempty"`
+}
+
+/**
+* Secret of an access key, together with the session token of temporary credentials.
+ */
+type sigV4Credentials struct {
+	secretAccessKey string
+	sessionToken    string
+}
+
+/**
+* Provider signing the original request with Signature Version 4, f.e. for S3-compatible object stores. The signature covers the
+* method, uri, query and headers of the request, thus it is created for every request and never cached.
+ */
+type sigV4Provider struct{}
+
+func (sigV4Provider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(sigV4AuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getSigV4Config(authInfo); err != nil {
+		logger.Warnf("Received invalid sigv4 auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid sigv4 auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+/**
+* Without the original request, there is nothing to sign.
+ */
+func (sigV4Provider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "The auth type " + sigV4AuthType + " requires the method and uri of the original request."}
+}
+
+/**
+* Sign the original request with the stored access key. The headers are only valid for this request and must not be cached.
+ */
+func (sigV4Provider) getRequestHeaders(authInfo AuthInfo, domain string, path string, request requestContext) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getSigV4Config(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	credentials, err := getSigV4Credentials(config.AccessKeyId)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNoSecretAccessKey) {
+		logger.Warnf("No credentials exist for %s.", config.AccessKeyId)
+		return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonMissingCredentials, detail: "No credentials exist for access key " + config.AccessKeyId + ".", clientId: config.AccessKeyId, err: err}
+	}
+	if err != nil {
+		logger.Warnf("Was not able to read the credentials of %s. Err: %v", config.AccessKeyId, err)
+		return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonInvalidCredentials, detail: "Was not able to read the credentials of access key " + config.AccessKeyId + ".", clientId: config.AccessKeyId, err: err}
+	}
+
+	headers, err = signSigV4(config, credentials, request, time.Now())
+	if err != nil {
+		logger.Infof("Was not able to sign the request to %s - %s. Err: %v", domain, path, err)
+		return headers, cacheLifetime, &authFailure{status: http.StatusBadRequest, reason: reasonInvalidRequest, detail: "Was not able to sign the request: " + err.Error(), err: err}
+	}
+	return headers, 0, err
+}
+
+/**
+* Decode and validate the sigv4 configuration of the auth info. The region defaults to us-east-1, as used by most S3-compatible
+* stores, the service to s3.
+ */
+func getSigV4Config(authInfo AuthInfo) (config sigV4Config, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidSigV4Config, err)
+	}
+	if !isValidClientId(config.AccessKeyId) {
+		return config, fmt.Errorf("%w: invalid access key id %s", errInvalidSigV4Config, config.AccessKeyId)
+	}
+	if config.Region == "" {
+		config.Region = defaultSigV4Region
+	}
+	if config.Service == "" {
+		config.Service = s3Service
+	}
+	if strings.Contains(config.Region+config.Service, "/") {
+		return config, fmt.Errorf("%w: region and service must not contain a /", errInvalidSigV4Config)
+	}
+	for i, header := range config.SignedHeaders {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header == "" || header == "authorization" || strings.ContainsAny(header, " :;") {
+			return config, fmt.Errorf("%w: header %s cannot be signed", errInvalidSigV4Config, header)
+		}
+		config.SignedHeaders[i] = header
+	}
+	return config, err
+}
+
+/**
+* Read the credentials of the access key. The session token is optional.
+ */
+func getSigV4Credentials(accessKeyId string) (credentials sigV4Credentials, err error) {
+	folderPath := buildSigV4CredentialsFolderPath(accessKeyId)
+	secret, err := globalFileAccessor.read(folderPath + secretAccessKeyFile)
+	if err != nil {
+		return credentials, err
+	}
+	credentials.secretAccessKey = strings.TrimSpace(string(secret))
+	if credentials.secretAccessKey == "" {
+		return credentials, errNoSecretAccessKey
+	}
+	sessionToken, err := globalFileAccessor.read(folderPath + sessionTokenFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return credentials, nil
+	}
+	credentials.sessionToken = strings.TrimSpace(string(sessionToken))
+	return credentials, err
+}
+
+/**
+* Sign the request as defined by the AWS Signature Version 4 process. The payload hash is taken from the x-amz-content-sha256 header,
+* as set by the filter for requests with a body. It is signed for S3 and whenever present, since other services do not require it.
+* With an unsigned payload, the body is not covered at all.
+ */
+func signSigV4(config sigV4Config, credentials sigV4Credentials, request requestContext, signingTime time.Time) (headers HeadersList, err error) {
+	if request.method == "" || request.targetUri == "" {
+		return headers, fmt.Errorf("%w: method and uri of the request are required", errMissingComponent)
+	}
+	targetUri, err := url.Parse(request.targetUri)
+	if err != nil || !targetUri.IsAbs() {
+		return headers, fmt.Errorf("%w: invalid target uri %s", errMissingComponent, request.targetUri)
+	}
+
+	payloadHash := request.headers.Get(amzContentSha256Header)
+	signPayloadHash := payloadHash != "" || config.Service == s3Service || config.UnsignedPayload
+	if config.UnsignedPayload {
+		payloadHash = unsignedPayload
+	} else if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+
+	amzDate := signingTime.UTC().Format(sigV4TimeFormat)
+	canonicalHeaders := map[string]string{"host": targetUri.Host, amzDateHeader: amzDate}
+	if signPayloadHash {
+		canonicalHeaders[amzContentSha256Header] = payloadHash
+	}
+	if credentials.sessionToken != "" {
+		canonicalHeaders[amzSecurityTokenHeader] = credentials.sessionToken
+	}
+	for _, header := range config.SignedHeaders {
+		if _, ok := canonicalHeaders[header]; ok {
+			continue
+		}
+		values := request.headers.Values(header)
+		if len(values) == 0 {
+			return headers, fmt.Errorf("%w: the request has no header %s", errMissingComponent, header)
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		canonicalHeaders[header] = strings.Join(trimmed, ",")
+	}
+
+	headerNames := make([]string, 0, len(canonicalHeaders))
+	for name := range canonicalHeaders {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaderLines strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaderLines.WriteString(name + ":" + canonicalHeaders[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		strings.ToUpper(request.method),
+		getCanonicalUri(targetUri, config.Service),
+		getCanonicalQuery(targetUri),
+		canonicalHeaderLines.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := signingTime.UTC().Format(sigV4DateFormat) + "/" + config.Region + "/" + config.Service + "/" + sigV4Terminator
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSha256([]byte("AWS4"+credentials.secretAccessKey), signingTime.UTC().Format(sigV4DateFormat))
+	for _, part := range []string{config.Region, config.Service, sigV4Terminator} {
+		signingKey = hmacSha256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	headers = HeadersList{
+		Header{Name: "Authorization", Value: sigV4Algorithm + " Credential=" + config.AccessKeyId + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature},
+		Header{Name: amzDateHeader, Value: amzDate},
+	}
+	if signPayloadHash {
+		headers = append(headers, Header{Name: amzContentSha256Header, Value: payloadHash})
+	}
+	if credentials.sessionToken != "" {
+		headers = append(headers, Header{Name: amzSecurityTokenHeader, Value: credentials.sessionToken})
+	}
+	return headers, err
+}
+
+/**
+* The path is uri-encoded per segment. S3 expects it to be encoded once, all other services expect the encoded path to be encoded again.
+ */
+func getCanonicalUri(targetUri *url.URL, service string) string {
+	path := targetUri.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			decoded = segment
+		}
+		segments[i] = sigV4Escape(decoded)
+		if service != s3Service {
+			segments[i] = sigV4Escape(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+/**
+* Query parameters are uri-encoded and sorted by name and value.
+ */
+func getCanonicalQuery(targetUri *url.URL) string {
+	var parameters [][2]string
+	for _, parameter := range strings.Split(targetUri.RawQuery, "&") {
+		if parameter == "" {
+			continue
+		}
+		nameAndValue := strings.SplitN(parameter, "=", 2)
+		name, _ := url.QueryUnescape(nameAndValue[0])
+		value := ""
+		if len(nameAndValue) == 2 {
+			value, _ = url.QueryUnescape(nameAndValue[1])
+		}
+		parameters = append(parameters, [2]string{sigV4Escape(name), sigV4Escape(value)})
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		if parameters[i][0] == parameters[j][0] {
+			return parameters[i][1] < parameters[j][1]
+		}
+		return parameters[i][0] < parameters[j][0]
+	})
+	encoded := make([]string, len(parameters))
+	for i, parameter := range parameters {
+		encoded[i] = parameter[0] + "=" + parameter[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+/**
+* Uri-encode everything except the unreserved characters of RFC 3986.
+ */
+func sigV4Escape(value string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			escaped.WriteByte(b)
+		} else {
+			escaped.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return escaped.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/**
+* Build the path to the folder of the access key. It will include the trailing /
+ */
+func buildSigV4CredentialsFolderPath(accessKeyId string) string {
+	return strings.TrimSuffix(sigV4CredentialsFolder, "/") + "/" + accessKeyId + "/"
+}
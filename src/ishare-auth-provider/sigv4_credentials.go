@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* Credentials of an access key for Signature Version 4. The session token is only required for temporary credentials. They are never
+* returned by the api.
+ */
+type SigV4Credentials struct {
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+}
+
+/**
+* The sigv4 credentials api is only available if a folder to store them is configured. Access key ids are used as folder names,
+* thus cannot point outside of it.
+ */
+func requireSigV4CredentialsFolder(c *gin.Context) {
+	if sigV4CredentialsFolder == "" {
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "No folder for sigv4 credentials is configured.")
+		return
+	}
+	if accessKeyId, ok := c.Params.Get("accessKeyId"); ok && !isValidClientId(accessKeyId) {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The access key id "+accessKeyId+" is not allowed.")
+	}
+}
+
+func getSigV4CredentialsList(c *gin.Context) {
+
+	audit := startSecretAudit(auditListSigV4Credentials, "")
+	defer audit.log(c)
+
+	folders, err := globalFolderAccessor.get(sigV4CredentialsFolder)
+	if err != nil {
+		logger.Warn("Was not able to read the sigv4 credentials folder.", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the sigv4 credentials folder.")
+		return
+	}
+
+	accessKeyIds := []string{}
+	for _, folder := range folders {
+		if folder.IsDir() {
+			accessKeyIds = append(accessKeyIds, folder.Name())
+		}
+	}
+	c.JSON(http.StatusOK, accessKeyIds)
+}
+
+/**
+* Create or replace the credentials of an access key. A session token of replaced credentials is removed, if the new ones do not
+* contain one.
+ */
+func putSigV4Credentials(c *gin.Context) {
+	accessKeyId := c.Param("accessKeyId")
+
+	audit := startSecretAudit(auditUpdateSigV4Credentials, accessKeyId)
+	defer audit.log(c)
+
+	var credentials SigV4Credentials
+	err := c.ShouldBindJSON(&credentials)
+	if err != nil {
+		logger.Warn("Was not able to read sigv4 credentials to json.")
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the credentials: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(credentials.SecretAccessKey) == "" {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "A secret access key is required.")
+		return
+	}
+
+	credentialsFolderPath := buildSigV4CredentialsFolderPath(accessKeyId)
+	err = diskFs.MkdirAll(credentialsFolderPath, 0700)
+	if err == nil {
+		err = globalFileAccessor.write(credentialsFolderPath+secretAccessKeyFile, []byte(credentials.SecretAccessKey), 0600)
+	}
+	if err == nil && credentials.SessionToken != "" {
+		err = globalFileAccessor.write(credentialsFolderPath+sessionTokenFile, []byte(credentials.SessionToken), 0600)
+	} else if err == nil {
+		err = diskFs.RemoveAll(credentialsFolderPath + sessionTokenFile)
+	}
+	if err != nil {
+		logger.Warn("Was not able to store the sigv4 credentials for: "+accessKeyId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the sigv4 credentials of "+accessKeyId+".")
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func deleteSigV4Credentials(c *gin.Context) {
+	accessKeyId := c.Param("accessKeyId")
+	credentialsFolderPath := buildSigV4CredentialsFolderPath(accessKeyId)
+
+	audit := startSecretAudit(auditDeleteSigV4Credentials, accessKeyId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(credentialsFolderPath); errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No sigv4 credentials for "+accessKeyId+" exist.", err)
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No sigv4 credentials exist for access key "+accessKeyId+".")
+		return
+	}
+
+	err := diskFs.RemoveAll(credentialsFolderPath)
+	if err != nil {
+		logger.Warn("Was not able to delete the sigv4 credentials for: "+accessKeyId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the sigv4 credentials of "+accessKeyId+".")
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSigV4Credentials(t *testing.T) {
+
+	type test struct {
+		testName        string
+		method          string
+		url             string
+		body            string
+		expectedStatus  int
+		expectedFiles   map[string]string
+		expectedMissing []string
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Create with session token.", method: http.MethodPut, url: "/sigv4/credentials/AKIDEXAMPLE", body: `{"secretAccessKey":"secret","sessionToken":"session"}`, expectedStatus: 204,
+			expectedFiles: map[string]string{"AKIDEXAMPLE/" + secretAccessKeyFile: "secret", "AKIDEXAMPLE/" + sessionTokenFile: "session"}},
+		{testName: "Replace without session token.", method: http.MethodPut, url: "/sigv4/credentials/AKIDEXAMPLE", body: `{"secretAccessKey":"rotated"}`, expectedStatus: 204,
+			expectedFiles: map[string]string{"AKIDEXAMPLE/" + secretAccessKeyFile: "rotated"}, expectedMissing: []string{"AKIDEXAMPLE/" + sessionTokenFile}},
+		{testName: "Create without secret.", method: http.MethodPut, url: "/sigv4/credentials/other", body: `{"sessionToken":"session"}`, expectedStatus: 400,
+			expectedMissing: []string{"other"}},
+		{testName: "Create outside of the folder.", method: http.MethodPut, url: "/sigv4/credentials/..", body: `{"secretAccessKey":"secret"}`, expectedStatus: 400},
+		{testName: "List.", method: http.MethodGet, url: "/sigv4/credentials", expectedStatus: 200},
+		{testName: "Delete.", method: http.MethodDelete, url: "/sigv4/credentials/AKIDEXAMPLE", expectedStatus: 204,
+			expectedMissing: []string{"AKIDEXAMPLE"}},
+		{testName: "Delete unknown.", method: http.MethodDelete, url: "/sigv4/credentials/AKIDEXAMPLE", expectedStatus: 404},
+	}
+
+	sigV4CredentialsFolder = t.TempDir()
+	diskFs = &osFS{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	defer func() {
+		sigV4CredentialsFolder = ""
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+
+	router := gin.New()
+	sigV4 := router.Group("/sigv4/credentials", requireSigV4CredentialsFolder)
+	sigV4.GET("", getSigV4CredentialsList)
+	sigV4.PUT("/:accessKeyId", putSigV4Credentials)
+	sigV4.DELETE("/:accessKeyId", deleteSigV4Credentials)
+
+	for _, tc := range tests {
+		log.Info("TestSigV4Credentials +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		for file, expectedContent := range tc.expectedFiles {
+			content, err := os.ReadFile(filepath.Join(sigV4CredentialsFolder, file))
+			if err != nil || string(content) != expectedContent {
+				t.Errorf("%s: Expected %s to contain %s, but was %s.", tc.testName, file, expectedContent, string(content))
+			}
+		}
+		for _, file := range tc.expectedMissing {
+			if _, err := os.Stat(filepath.Join(sigV4CredentialsFolder, file)); !os.IsNotExist(err) {
+				t.Errorf("%s: Expected %s to not exist.", tc.testName, file)
+			}
+		}
+		if tc.method == http.MethodGet && recorder.Body.String() != `["AKIDEXAMPLE"]` {
+			t.Errorf("%s: Expected the access key to be listed, but was %s.", tc.testName, recorder.Body.String())
+		}
+	}
+}
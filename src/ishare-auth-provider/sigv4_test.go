@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetSigV4Config(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedConfig sigV4Config
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Defaults.", config: `{"accessKeyId":"AKIDEXAMPLE"}`,
+			expectedConfig: sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: defaultSigV4Region, Service: s3Service}},
+		{testName: "Configured signature.", config: `{"accessKeyId":"AKIDEXAMPLE","region":"eu-central-1","service":"execute-api","signedHeaders":["Content-Type"," X-Tenant "]}`,
+			expectedConfig: sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: "eu-central-1", Service: "execute-api", SignedHeaders: []string{"content-type", "x-tenant"}}},
+		{testName: "Signed authorization.", config: `{"accessKeyId":"AKIDEXAMPLE","signedHeaders":["Authorization"]}`, expectedError: errInvalidSigV4Config},
+		{testName: "Invalid region.", config: `{"accessKeyId":"AKIDEXAMPLE","region":"eu/central"}`, expectedError: errInvalidSigV4Config},
+		{testName: "Access key outside of the folder.", config: `{"accessKeyId":".."}`, expectedError: errInvalidSigV4Config},
+		{testName: "No config.", expectedError: errInvalidSigV4Config},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetSigV4Config +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getSigV4Config(AuthInfo{AuthType: sigV4AuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && fmt.Sprint(config) != fmt.Sprint(tc.expectedConfig) {
+			t.Errorf("%s: Expected config %v but was %v.", tc.testName, tc.expectedConfig, config)
+		}
+	}
+}
+
+func TestSignSigV4(t *testing.T) {
+
+	type test struct {
+		testName        string
+		config          sigV4Config
+		credentials     sigV4Credentials
+		request         requestContext
+		expectedHeaders map[string]string
+		expectedError   error
+	}
+
+	// test vectors of the aws signature version 4 test suite
+	vectorConfig := sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: "us-east-1", Service: "service"}
+	vectorCredentials := sigV4Credentials{secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	s3Config := sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: "us-east-1", Service: s3Service}
+
+	tests := []test{
+		{testName: "Get vanilla.", config: vectorConfig, credentials: vectorCredentials, request: requestContext{method: "GET", targetUri: "https://example.amazonaws.com/"},
+			expectedHeaders: map[string]string{
+				"Authorization": "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+				"x-amz-date":    "20150830T123600Z"}},
+		{testName: "Get vanilla with an empty query.", config: vectorConfig, credentials: vectorCredentials, request: requestContext{method: "get", targetUri: "https://example.amazonaws.com/?"},
+			expectedHeaders: map[string]string{
+				"Authorization": "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"}},
+		{testName: "S3 signs the payload hash and session token.", config: s3Config, credentials: sigV4Credentials{secretAccessKey: "secret", sessionToken: "session"},
+			request:         requestContext{method: "PUT", targetUri: "https://minio.local/bucket/my%20object", headers: http.Header{"X-Amz-Content-Sha256": {"abc"}}},
+			expectedHeaders: map[string]string{"x-amz-content-sha256": "abc", "x-amz-security-token": "session", "x-amz-date": "20150830T123600Z"}},
+		{testName: "S3 without body.", config: s3Config, credentials: vectorCredentials, request: requestContext{method: "GET", targetUri: "https://minio.local/bucket"},
+			expectedHeaders: map[string]string{"x-amz-content-sha256": emptyPayloadHash}},
+		{testName: "Unsigned payload.", config: sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: "us-east-1", Service: s3Service, UnsignedPayload: true}, credentials: vectorCredentials,
+			request:         requestContext{method: "PUT", targetUri: "https://minio.local/bucket/object", headers: http.Header{"X-Amz-Content-Sha256": {"abc"}}},
+			expectedHeaders: map[string]string{"x-amz-content-sha256": unsignedPayload}},
+		{testName: "Missing signed header.", config: sigV4Config{AccessKeyId: "AKIDEXAMPLE", Region: "us-east-1", Service: s3Service, SignedHeaders: []string{"content-type"}}, credentials: vectorCredentials,
+			request: requestContext{method: "GET", targetUri: "https://minio.local/bucket"}, expectedError: errMissingComponent},
+		{testName: "No request context.", config: vectorConfig, credentials: vectorCredentials, expectedError: errMissingComponent},
+	}
+
+	signingTime, _ := time.Parse(sigV4TimeFormat, "20150830T123600Z")
+
+	for _, tc := range tests {
+		log.Info("TestSignSigV4 +++++++++++++++++++++ Running test: " + tc.testName)
+
+		headers, err := signSigV4(tc.config, tc.credentials, tc.request, signingTime)
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+			continue
+		}
+		returned := map[string]string{}
+		for _, header := range headers {
+			returned[header.Name] = header.Value
+		}
+		for name, value := range tc.expectedHeaders {
+			if returned[name] != value {
+				t.Errorf("%s: Expected header %s=%s, but was %s.", tc.testName, name, value, returned[name])
+			}
+		}
+		if tc.config.Service == s3Service && err == nil && !strings.Contains(returned["Authorization"], "SignedHeaders=host;x-amz-content-sha256;x-amz-date") {
+			t.Errorf("%s: Expected the payload hash to be signed, but was %s.", tc.testName, returned["Authorization"])
+		}
+	}
+}
+
+func TestCanonicalRequestParts(t *testing.T) {
+
+	type test struct {
+		testName      string
+		targetUri     string
+		service       string
+		expectedUri   string
+		expectedQuery string
+	}
+
+	tests := []test{
+		{testName: "Sorted and encoded query.", targetUri: "https://example.com/?b=2&a=1&a-b=3&c&d=x+y", service: s3Service, expectedUri: "/", expectedQuery: "a=1&a-b=3&b=2&c=&d=x%20y"},
+		{testName: "S3 encodes the path once.", targetUri: "https://example.com/bucket/my%20object~1", service: s3Service, expectedUri: "/bucket/my%20object~1"},
+		{testName: "Other services encode the path twice.", targetUri: "https://example.com/path/my%20object", service: "service", expectedUri: "/path/my%2520object"},
+		{testName: "Empty path.", targetUri: "https://example.com", service: s3Service, expectedUri: "/"},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestCanonicalRequestParts +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(http.MethodGet, tc.targetUri, nil)
+		if uri := getCanonicalUri(request.URL, tc.service); uri != tc.expectedUri {
+			t.Errorf("%s: Expected canonical uri %s but was %s.", tc.testName, tc.expectedUri, uri)
+		}
+		if query := getCanonicalQuery(request.URL); query != tc.expectedQuery {
+			t.Errorf("%s: Expected canonical query %s but was %s.", tc.testName, tc.expectedQuery, query)
+		}
+	}
+}
+
+func TestSigV4GetRequestHeaders(t *testing.T) {
+
+	type test struct {
+		testName       string
+		accessKeyId    string
+		request        requestContext
+		expectedReason string
+	}
+
+	request := requestContext{method: "GET", targetUri: "https://minio.local/bucket"}
+	tests := []test{
+		{testName: "Sign with stored credentials.", accessKeyId: "AKIDEXAMPLE", request: request},
+		{testName: "Unknown access key.", accessKeyId: "unknown", request: request, expectedReason: reasonMissingCredentials},
+		{testName: "Empty secret.", accessKeyId: "empty", request: request, expectedReason: reasonMissingCredentials},
+		{testName: "No request context.", accessKeyId: "AKIDEXAMPLE", expectedReason: reasonInvalidRequest},
+	}
+
+	sigV4CredentialsFolder = t.TempDir()
+	os.MkdirAll(filepath.Join(sigV4CredentialsFolder, "AKIDEXAMPLE"), 0700)
+	os.WriteFile(filepath.Join(sigV4CredentialsFolder, "AKIDEXAMPLE", secretAccessKeyFile), []byte("secret\n"), 0600)
+	os.MkdirAll(filepath.Join(sigV4CredentialsFolder, "empty"), 0700)
+	os.WriteFile(filepath.Join(sigV4CredentialsFolder, "empty", secretAccessKeyFile), []byte(" "), 0600)
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		sigV4CredentialsFolder = ""
+	}()
+
+	for _, tc := range tests {
+		log.Info("TestSigV4GetRequestHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		authInfo := AuthInfo{AuthType: sigV4AuthType, Config: json.RawMessage(`{"accessKeyId":"` + tc.accessKeyId + `"}`)}
+		headers, cacheLifetime, err := sigV4Provider{}.getRequestHeaders(authInfo, "minio.local", "/", tc.request)
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if cacheLifetime != 0 {
+			t.Errorf("%s: Expected the signature to not be cached, but the lifetime was %v.", tc.testName, cacheLifetime)
+		}
+		if len(headers) != 3 || !strings.HasPrefix(headers[0].Value, sigV4Algorithm+" Credential=AKIDEXAMPLE/") {
+			t.Errorf("%s: Expected the signature headers, but was %v.", tc.testName, headers)
+		}
+	}
+}
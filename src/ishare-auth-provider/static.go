@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/**
+* Auth type of the static header provider, as used in its route /STATIC/auth.
+ */
+const staticAuthType = "STATIC"
+
+/**
+* Static headers do not expire by themselves, they are cached for an hour if nothing else is configured. The filter re-fetches them
+* earlier, once the version of the secrets changed.
+ */
+const defaultStaticCacheLifetime = time.Hour
+
+var errInvalidStaticConfig = errors.New("invalid_static_config")
+var errEmptySecret = errors.New("empty_secret")
+
+/**
+* Folder to store the secrets of static headers in, one folder per secret set, one file per secret.
+ */
+var staticSecretsFolder string
+
+/**
+* Configuration of an endpoint using static headers, read from the config of its auth info. Secret values are only referenced, they
+* are stored through the secrets api.
+ */
+type staticConfig struct {
+	SecretId      string         `json:"secretId,omitempty"`
+	Headers       []staticHeader `json:"headers"`
+	CacheLifetime int            `json:"cacheLifetime,omitempty"`
+}
+
+/**
+* Header with either a literal value, f.e. for tenants, or the value of a secret. With a basic user, the secret is used as password
+* of a Basic authorization.
+ */
+type staticHeader struct {
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	BasicUser string `json:"basicUser,omitempty"`
+}
+
+/**
+* Provider returning configured headers, f.e. api keys, Basic authorization or tenant headers. The secrets are read on every
+* request and versioned, thus changes are picked up as soon as the filter sees the new version.
+ */
+type staticProvider struct{}
+
+func (staticProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(staticAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getStaticConfig(authInfo); err != nil {
+		logger.Warnf("Received invalid static auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid static auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+func (staticProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getStaticConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	for _, header := range config.Headers {
+		value := header.Value
+		if header.Secret != "" {
+			value, err = getStaticSecret(config.SecretId, header.Secret)
+			if err != nil {
+				logger.Warnf("Was not able to read the secret %s of %s. Err: %v", header.Secret, config.SecretId, err)
+				reason := reasonInvalidCredentials
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errEmptySecret) {
+					reason = reasonMissingCredentials
+				}
+				return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reason, detail: "No value exists for the secret " + header.Secret + " of " + config.SecretId + ".", clientId: config.SecretId, err: err}
+			}
+		}
+		if header.BasicUser != "" {
+			value = "Basic " + base64.StdEncoding.EncodeToString([]byte(header.BasicUser+":"+value))
+		}
+		headers = append(headers, Header{Name: header.Name, Value: value})
+	}
+
+	cacheLifetime = defaultStaticCacheLifetime
+	if config.CacheLifetime > 0 {
+		cacheLifetime = time.Duration(config.CacheLifetime) * time.Second
+	}
+	return headers, cacheLifetime, err
+}
+
+/**
+* Version of the stored secrets, it changes whenever a secret is created, replaced or deleted. Derived from names, sizes and modification
+* times of the files, thus it is the same for all providers sharing the folder and does not reveal the secrets.
+ */
+func (staticProvider) getVersion() (version string, err error) {
+	if staticSecretsFolder == "" {
+		return version, err
+	}
+	// the folder is only created with the first secret
+	secretSets, err := globalFolderAccessor.get(staticSecretsFolder)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return version, err
+	}
+	hash := sha256.New()
+	for _, secretSet := range secretSets {
+		fmt.Fprintf(hash, "%s|%d|%d\n", secretSet.Name(), secretSet.Size(), secretSet.ModTime().UnixNano())
+		if !secretSet.IsDir() {
+			continue
+		}
+		secrets, err := globalFolderAccessor.get(buildStaticSecretsFolderPath(secretSet.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return version, err
+		}
+		for _, secret := range secrets {
+			fmt.Fprintf(hash, "%s/%s|%d|%d\n", secretSet.Name(), secret.Name(), secret.Size(), secret.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+/**
+* Decode and validate the static configuration of the auth info. Every header needs either a value or a secret, secrets require
+* the secret set to be configured.
+ */
+func getStaticConfig(authInfo AuthInfo) (config staticConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidStaticConfig, err)
+	}
+	if len(config.Headers) == 0 {
+		return config, fmt.Errorf("%w: no headers configured", errInvalidStaticConfig)
+	}
+	for _, header := range config.Headers {
+		if header.Name == "" {
+			return config, fmt.Errorf("%w: header without name", errInvalidStaticConfig)
+		}
+		if (header.Value == "") == (header.Secret == "") {
+			return config, fmt.Errorf("%w: header %s needs either a value or a secret", errInvalidStaticConfig, header.Name)
+		}
+		if header.Secret != "" && (!isValidClientId(config.SecretId) || !isValidClientId(header.Secret)) {
+			return config, fmt.Errorf("%w: header %s references an invalid secret", errInvalidStaticConfig, header.Name)
+		}
+	}
+	if config.CacheLifetime < 0 {
+		return config, fmt.Errorf("%w: negative cache lifetime", errInvalidStaticConfig)
+	}
+	return config, err
+}
+
+func getStaticSecret(secretId string, name string) (secret string, err error) {
+	content, err := globalFileAccessor.read(buildStaticSecretsFolderPath(secretId) + name)
+	if err != nil {
+		return secret, err
+	}
+	secret = strings.TrimSpace(string(content))
+	if secret == "" {
+		return secret, errEmptySecret
+	}
+	return secret, err
+}
+
+/**
+* Build the path to the folder of the secret set. It will include the trailing /
+ */
+func buildStaticSecretsFolderPath(secretId string) string {
+	return strings.TrimSuffix(staticSecretsFolder, "/") + "/" + secretId + "/"
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* The secrets api is only available if a folder to store them is configured. Secret set and secret names are used as file names,
+* thus cannot point outside of it.
+ */
+func requireStaticSecretsFolder(c *gin.Context) {
+	if staticSecretsFolder == "" {
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "No folder for static secrets is configured.")
+		return
+	}
+	for _, param := range c.Params {
+		if !isValidClientId(param.Value) {
+			abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The name "+param.Value+" is not allowed.")
+			return
+		}
+	}
+}
+
+/**
+* List the secret sets.
+ */
+func getStaticSecretsList(c *gin.Context) {
+
+	audit := startSecretAudit(auditListStaticSecrets, "")
+	defer audit.log(c)
+
+	folders, err := globalFolderAccessor.get(staticSecretsFolder)
+	if err != nil {
+		logger.Warn("Was not able to read the static secrets folder.", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the static secrets folder.")
+		return
+	}
+
+	secretIds := []string{}
+	for _, folder := range folders {
+		if folder.IsDir() {
+			secretIds = append(secretIds, folder.Name())
+		}
+	}
+	c.JSON(http.StatusOK, secretIds)
+}
+
+/**
+* List the names of the secrets in a set. Their values are never returned.
+ */
+func getStaticSecretNames(c *gin.Context) {
+	secretId := c.Param("secretId")
+
+	audit := startSecretAudit(auditShowStaticSecrets, secretId)
+	defer audit.log(c)
+
+	files, err := globalFolderAccessor.get(buildStaticSecretsFolderPath(secretId))
+	if errors.Is(err, os.ErrNotExist) {
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No secrets exist for "+secretId+".")
+		return
+	}
+	if err != nil {
+		logger.Warn("Was not able to read the secrets of "+secretId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the secrets of "+secretId+".")
+		return
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+/**
+* Create or replace a secret, the set is created if it does not exist yet.
+ */
+func putStaticSecret(c *gin.Context) {
+	secretId := c.Param("secretId")
+	name := c.Param("name")
+
+	audit := startSecretAudit(auditUpdateStaticSecret, secretId)
+	defer audit.log(c)
+
+	secret, err := io.ReadAll(c.Request.Body)
+	if err != nil || len(secret) == 0 {
+		logger.Warn("Was not able to read the request body.", err)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the body.")
+		return
+	}
+
+	secretsFolderPath := buildStaticSecretsFolderPath(secretId)
+	err = diskFs.MkdirAll(secretsFolderPath, 0700)
+	if err == nil {
+		err = globalFileAccessor.write(secretsFolderPath+name, secret, 0600)
+	}
+	if err != nil {
+		logger.Warn("Was not able to store the secret "+name+" of "+secretId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the secret "+name+" of "+secretId+".")
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func deleteStaticSecret(c *gin.Context) {
+	secretId := c.Param("secretId")
+	removeStaticSecrets(c, secretId, buildStaticSecretsFolderPath(secretId)+c.Param("name"))
+}
+
+func deleteStaticSecrets(c *gin.Context) {
+	secretId := c.Param("secretId")
+	removeStaticSecrets(c, secretId, buildStaticSecretsFolderPath(secretId))
+}
+
+func removeStaticSecrets(c *gin.Context, secretId string, path string) {
+	audit := startSecretAudit(auditDeleteStaticSecrets, secretId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(path); errors.Is(err, os.ErrNotExist) {
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No such secret exists for "+secretId+".")
+		return
+	}
+	err := diskFs.RemoveAll(path)
+	if err != nil {
+		logger.Warn("Was not able to delete the secrets of "+secretId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the secrets of "+secretId+".")
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
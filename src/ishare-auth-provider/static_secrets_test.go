@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestStaticSecrets(t *testing.T) {
+
+	type test struct {
+		testName       string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		expectedBody   string
+		expectedFiles  map[string]string
+		missingFiles   []string
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Create secret.", method: http.MethodPut, url: "/static/secrets/partner/apiKey", body: "key", expectedStatus: 204,
+			expectedFiles: map[string]string{"partner/apiKey": "key"}},
+		{testName: "Replace secret.", method: http.MethodPut, url: "/static/secrets/partner/apiKey", body: "rotated", expectedStatus: 204,
+			expectedFiles: map[string]string{"partner/apiKey": "rotated"}},
+		{testName: "Add secret.", method: http.MethodPut, url: "/static/secrets/partner/password", body: "pass", expectedStatus: 204,
+			expectedFiles: map[string]string{"partner/password": "pass"}},
+		{testName: "Empty secret.", method: http.MethodPut, url: "/static/secrets/partner/apiKey", body: "", expectedStatus: 400,
+			expectedFiles: map[string]string{"partner/apiKey": "rotated"}},
+		{testName: "Secret outside of the folder.", method: http.MethodPut, url: "/static/secrets/../apiKey", body: "key", expectedStatus: 400},
+		{testName: "List sets.", method: http.MethodGet, url: "/static/secrets", expectedStatus: 200, expectedBody: `["partner"]`},
+		{testName: "List names.", method: http.MethodGet, url: "/static/secrets/partner", expectedStatus: 200, expectedBody: `["apiKey","password"]`},
+		{testName: "List names of unknown set.", method: http.MethodGet, url: "/static/secrets/unknown", expectedStatus: 404},
+		{testName: "Delete secret.", method: http.MethodDelete, url: "/static/secrets/partner/password", expectedStatus: 204,
+			missingFiles: []string{"partner/password"}},
+		{testName: "Delete unknown secret.", method: http.MethodDelete, url: "/static/secrets/partner/password", expectedStatus: 404},
+		{testName: "Delete set.", method: http.MethodDelete, url: "/static/secrets/partner", expectedStatus: 204,
+			missingFiles: []string{"partner"}},
+		{testName: "Delete unknown set.", method: http.MethodDelete, url: "/static/secrets/partner", expectedStatus: 404},
+	}
+
+	staticSecretsFolder = t.TempDir()
+	diskFs = &osFS{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	defer func() {
+		staticSecretsFolder = ""
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+
+	router := gin.New()
+	static := router.Group("/static/secrets", requireStaticSecretsFolder)
+	static.GET("", getStaticSecretsList)
+	static.GET("/:secretId", getStaticSecretNames)
+	static.DELETE("/:secretId", deleteStaticSecrets)
+	static.PUT("/:secretId/:name", putStaticSecret)
+	static.DELETE("/:secretId/:name", deleteStaticSecret)
+
+	for _, tc := range tests {
+		log.Info("TestStaticSecrets +++++++++++++++++++++ Running test: " + tc.testName)
+
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if tc.expectedBody != "" && recorder.Body.String() != tc.expectedBody {
+			t.Errorf("%s: Expected body %s but was %s.", tc.testName, tc.expectedBody, recorder.Body.String())
+		}
+		for file, expectedContent := range tc.expectedFiles {
+			content, err := os.ReadFile(filepath.Join(staticSecretsFolder, file))
+			if err != nil || string(content) != expectedContent {
+				t.Errorf("%s: Expected %s to contain %s, but was %s.", tc.testName, file, expectedContent, string(content))
+			}
+		}
+		for _, file := range tc.missingFiles {
+			if _, err := os.Stat(filepath.Join(staticSecretsFolder, file)); !os.IsNotExist(err) {
+				t.Errorf("%s: Expected %s to be removed.", tc.testName, file)
+			}
+		}
+	}
+}
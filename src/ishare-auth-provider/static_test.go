@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestStaticGetHeaders(t *testing.T) {
+
+	type test struct {
+		testName         string
+		config           string
+		secrets          map[string][]byte
+		expectedHeaders  HeadersList
+		expectedLifetime time.Duration
+		expectedReason   string
+	}
+
+	tests := []test{
+		{testName: "Api key and tenant.", config: `{"secretId":"partner","headers":[{"name":"X-API-Key","secret":"apiKey"},{"name":"NGSILD-Tenant","value":"tenantA"}]}`,
+			secrets:          map[string][]byte{"/secrets/partner/apiKey": []byte("myKey\n")},
+			expectedHeaders:  HeadersList{{Name: "X-API-Key", Value: "myKey"}, {Name: "NGSILD-Tenant", Value: "tenantA"}},
+			expectedLifetime: time.Hour},
+		{testName: "Basic auth with configured lifetime.", config: `{"secretId":"partner","headers":[{"name":"Authorization","secret":"password","basicUser":"user"}],"cacheLifetime":300}`,
+			secrets:          map[string][]byte{"/secrets/partner/password": []byte("pass")},
+			expectedHeaders:  HeadersList{{Name: "Authorization", Value: "Basic dXNlcjpwYXNz"}},
+			expectedLifetime: 5 * time.Minute},
+		{testName: "Only literal values.", config: `{"headers":[{"name":"Fiware-Service","value":"service"}]}`,
+			expectedHeaders:  HeadersList{{Name: "Fiware-Service", Value: "service"}},
+			expectedLifetime: time.Hour},
+		{testName: "Missing secret.", config: `{"secretId":"partner","headers":[{"name":"X-API-Key","secret":"apiKey"}]}`, expectedReason: reasonMissingCredentials},
+		{testName: "Empty secret.", config: `{"secretId":"partner","headers":[{"name":"X-API-Key","secret":"apiKey"}]}`,
+			secrets: map[string][]byte{"/secrets/partner/apiKey": []byte("\n")}, expectedReason: reasonMissingCredentials},
+	}
+
+	staticSecretsFolder = "/secrets"
+	defer func() {
+		staticSecretsFolder = ""
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_existing}
+
+	for _, tc := range tests {
+		log.Info("TestStaticGetHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+		contentMock = tc.secrets
+
+		headers, cacheLifetime, err := staticProvider{}.getHeaders(AuthInfo{AuthType: staticAuthType, Config: json.RawMessage(tc.config)}, "test.domain", "/")
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if fmt.Sprint(headers) != fmt.Sprint(tc.expectedHeaders) {
+			t.Errorf("%s: Expected headers %v, but were %v.", tc.testName, tc.expectedHeaders, headers)
+		}
+		if cacheLifetime != tc.expectedLifetime {
+			t.Errorf("%s: Expected a cache lifetime of %v, but was %v.", tc.testName, tc.expectedLifetime, cacheLifetime)
+		}
+	}
+}
+
+func TestGetStaticConfig(t *testing.T) {
+
+	type test struct {
+		testName      string
+		config        string
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Valid config.", config: `{"secretId":"partner","headers":[{"name":"X-API-Key","secret":"apiKey"}]}`},
+		{testName: "No headers.", config: `{"secretId":"partner","headers":[]}`, expectedError: errInvalidStaticConfig},
+		{testName: "Header without name.", config: `{"headers":[{"value":"value"}]}`, expectedError: errInvalidStaticConfig},
+		{testName: "Header with value and secret.", config: `{"secretId":"partner","headers":[{"name":"X-API-Key","value":"key","secret":"apiKey"}]}`, expectedError: errInvalidStaticConfig},
+		{testName: "Secret without secret set.", config: `{"headers":[{"name":"X-API-Key","secret":"apiKey"}]}`, expectedError: errInvalidStaticConfig},
+		{testName: "Secret outside of the folder.", config: `{"secretId":"..","headers":[{"name":"X-API-Key","secret":"apiKey"}]}`, expectedError: errInvalidStaticConfig},
+		{testName: "Negative cache lifetime.", config: `{"headers":[{"name":"Fiware-Service","value":"service"}],"cacheLifetime":-1}`, expectedError: errInvalidStaticConfig},
+		{testName: "No config.", expectedError: errInvalidStaticConfig},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetStaticConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		_, err := getStaticConfig(AuthInfo{AuthType: staticAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+	}
+}
+
+func TestStaticVersion(t *testing.T) {
+
+	type test struct {
+		testName      string
+		change        func(folder string)
+		expectChanged bool
+	}
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Unchanged secrets.", change: func(folder string) {}},
+		{testName: "Secret created.", change: func(folder string) { os.WriteFile(filepath.Join(folder, "partner", "password"), []byte("pass"), 0600) }, expectChanged: true},
+		{testName: "Secret rotated.", change: func(folder string) { os.WriteFile(filepath.Join(folder, "partner", "apiKey"), []byte("rotated"), 0600) }, expectChanged: true},
+		{testName: "Secret deleted.", change: func(folder string) { os.Remove(filepath.Join(folder, "partner", "password")) }, expectChanged: true},
+		{testName: "Set deleted.", change: func(folder string) { os.RemoveAll(filepath.Join(folder, "partner")) }, expectChanged: true},
+	}
+
+	staticSecretsFolder = t.TempDir()
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	defer func() { staticSecretsFolder = "" }()
+	os.MkdirAll(filepath.Join(staticSecretsFolder, "partner"), 0700)
+	os.WriteFile(filepath.Join(staticSecretsFolder, "partner", "apiKey"), []byte("key"), 0600)
+
+	version, err := staticProvider{}.getVersion()
+	if err != nil || version == "" {
+		t.Fatalf("Expected a version, but was %s. %v", version, err)
+	}
+	for _, tc := range tests {
+		log.Info("TestStaticVersion +++++++++++++++++++++ Running test: " + tc.testName)
+
+		// modification times of the file system are not necessarily more precise
+		time.Sleep(10 * time.Millisecond)
+		tc.change(staticSecretsFolder)
+
+		changedVersion, err := staticProvider{}.getVersion()
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if (changedVersion != version) != tc.expectChanged {
+			t.Errorf("%s: Expected the version to change: %v, but was %s before and %s after.", tc.testName, tc.expectChanged, version, changedVersion)
+		}
+		version = changedVersion
+	}
+
+	staticSecretsFolder = filepath.Join(staticSecretsFolder, "not-created")
+	if version, err := (staticProvider{}).getVersion(); err != nil || version == "" {
+		t.Errorf("Without any stored secret, a version should be returned, but was %s. %v", version, err)
+	}
+
+	staticSecretsFolder = ""
+	if version, err := (staticProvider{}).getVersion(); err != nil || version != "" {
+		t.Errorf("Without a secrets folder, no version should be returned, but was %s. %v", version, err)
+	}
+}
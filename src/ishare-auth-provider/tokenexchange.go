@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/**
+* Auth type of the token exchange provider, as used in its route /TOKEN_EXCHANGE/auth.
+ */
+const tokenExchangeAuthType = "TOKEN_EXCHANGE"
+
+/**
+* Grant type and token types of RFC 8693.
+ */
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var errInvalidTokenExchangeConfig = errors.New("invalid_token_exchange_config")
+
+/**
+* Configuration of an endpoint using token exchange, read from the config of its auth info. The client authenticates at the sts with
+* its oauth2 credentials.
+ */
+type tokenExchangeConfig struct {
+	oauth2Config
+	Resource           string `json:"resource,omitempty"`
+	RequestedTokenType string `json:"requestedTokenType,omitempty"`
+	SubjectTokenType   string `json:"subjectTokenType,omitempty"`
+}
+
+/**
+* Exchanged tokens, indexed by a hash over the subject token and the requested audience.
+ */
+var tokenExchangeCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Provider exchanging the token of the user of the original request for a token of the endpoints audience, as defined by RFC 8693.
+ */
+type tokenExchangeProvider struct{}
+
+func (tokenExchangeProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(tokenExchangeAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getTokenExchangeConfig(authInfo); err != nil {
+		logger.Warnf("Received invalid token exchange auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid token exchange auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+/**
+* Without the token of the user, there is nothing to exchange.
+ */
+func (tokenExchangeProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	return headers, cacheLifetime, &authFailure{status: http.StatusUnauthorized, reason: reasonMissingSubjectToken, detail: "No token to exchange was forwarded."}
+}
+
+/**
+* Exchange the token of the forwarded authorization at the sts. The headers are specific to the user, thus they must not be cached
+* by the filter, the exchanged tokens are cached by the provider instead.
+ */
+func (tokenExchangeProvider) getRequestHeaders(authInfo AuthInfo, domain string, path string, request requestContext) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	subjectToken := getBearerToken(request.headers.Get("Authorization"))
+	if subjectToken == "" {
+		logger.Info("No subject token was forwarded.")
+		return headers, cacheLifetime, &authFailure{status: http.StatusUnauthorized, reason: reasonMissingSubjectToken, detail: "The auth type " + tokenExchangeAuthType + " requires the token of the original request."}
+	}
+	config, err := getTokenExchangeConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	cacheKey := getTokenExchangeCacheKey(config, subjectToken)
+	token, _, cached := tokenExchangeCache.get(cacheKey)
+	if !cached {
+		var expiry time.Time
+		token, expiry, err = requestTokenExchange(config, subjectToken)
+		var oauthErr *oauthError
+		if errors.As(err, &oauthErr) && oauthErr.Code == "invalid_grant" {
+			logger.Infof("The sts rejected the subject token for %s. Err: %v", config.ClientId, err)
+			return headers, cacheLifetime, &authFailure{status: http.StatusForbidden, reason: reasonSubjectTokenRejected, detail: "The sts rejected the token to exchange: " + err.Error(), err: err}
+		}
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(config.ClientId, err)
+		}
+		tokenExchangeCache.put(cacheKey, config.ClientId, token, expiry)
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + token}}
+	return headers, 0, err
+}
+
+/**
+* Decode and validate the token exchange configuration of the auth info. The subject is expected to be an access token.
+ */
+func getTokenExchangeConfig(authInfo AuthInfo) (config tokenExchangeConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidTokenExchangeConfig, err)
+	}
+	if err = validateOAuth2Client(&config.oauth2Config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidTokenExchangeConfig, err)
+	}
+	if config.Audience == "" && config.Resource == "" {
+		return config, fmt.Errorf("%w: an audience or a resource is required", errInvalidTokenExchangeConfig)
+	}
+	if config.SubjectTokenType == "" {
+		config.SubjectTokenType = accessTokenType
+	}
+	return config, err
+}
+
+func requestTokenExchange(config tokenExchangeConfig, subjectToken string) (token string, expiry time.Time, err error) {
+	data := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {config.SubjectTokenType},
+	}
+	if config.Audience != "" {
+		data.Set("audience", config.Audience)
+	}
+	if config.Resource != "" {
+		data.Set("resource", config.Resource)
+	}
+	if config.RequestedTokenType != "" {
+		data.Set("requested_token_type", config.RequestedTokenType)
+	}
+	if len(config.Scopes) > 0 {
+		data.Set("scope", strings.Join(config.Scopes, " "))
+	}
+	return postOAuth2TokenRequest(config.oauth2Config, data)
+}
+
+/**
+* The key is a hash, thus the subject tokens are not kept in memory.
+ */
+func getTokenExchangeCacheKey(config tokenExchangeConfig, subjectToken string) string {
+	hash := sha256.Sum256([]byte(config.TokenEndpoint + "|" + config.ClientId + "|" + config.Audience + "|" + config.Resource + "|" + config.RequestedTokenType + "|" + strings.Join(config.Scopes, " ") + "|" + subjectToken))
+	return hex.EncodeToString(hash[:])
+}
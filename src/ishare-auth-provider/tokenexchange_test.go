@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetTokenExchangeConfig(t *testing.T) {
+
+	type test struct {
+		testName                 string
+		config                   string
+		expectedSubjectTokenType string
+		expectedError            error
+	}
+
+	tests := []test{
+		{testName: "Default subject token type.", config: `{"tokenEndpoint":"https://sts.org/token","clientId":"client","audience":"https://api.org"}`, expectedSubjectTokenType: accessTokenType},
+		{testName: "Resource instead of audience.", config: `{"tokenEndpoint":"https://sts.org/token","clientId":"client","resource":"https://api.org/orders","subjectTokenType":"urn:ietf:params:oauth:token-type:jwt"}`, expectedSubjectTokenType: "urn:ietf:params:oauth:token-type:jwt"},
+		{testName: "No audience and resource.", config: `{"tokenEndpoint":"https://sts.org/token","clientId":"client"}`, expectedError: errInvalidTokenExchangeConfig},
+		{testName: "Unsupported auth method.", config: `{"tokenEndpoint":"https://sts.org/token","clientId":"client","audience":"https://api.org","clientAuthMethod":"tls_client_auth"}`, expectedError: errInvalidTokenExchangeConfig},
+		{testName: "No client.", config: `{"tokenEndpoint":"https://sts.org/token","audience":"https://api.org"}`, expectedError: errInvalidTokenExchangeConfig},
+		{testName: "No config.", expectedError: errInvalidTokenExchangeConfig},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetTokenExchangeConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getTokenExchangeConfig(AuthInfo{AuthType: tokenExchangeAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && config.SubjectTokenType != tc.expectedSubjectTokenType {
+			t.Errorf("%s: Expected subject token type %s but was %s.", tc.testName, tc.expectedSubjectTokenType, config.SubjectTokenType)
+		}
+	}
+}
+
+func TestTokenExchangeGetAuth(t *testing.T) {
+
+	type test struct {
+		testName             string
+		authorization        string
+		status               int
+		response             string
+		expectedCode         int
+		expectedReason       string
+		expectedRequests     int
+		expectedSubjectToken string
+	}
+
+	tokenResponse := `{"access_token":"exchangedToken","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":60}`
+	// the steps build on each other
+	tests := []test{
+		{testName: "Exchange the token.", authorization: "Bearer userA", response: tokenResponse, expectedCode: 200, expectedRequests: 1, expectedSubjectToken: "userA"},
+		{testName: "Exchanged token is cached.", authorization: "Bearer userA", response: tokenResponse, expectedCode: 200, expectedRequests: 1, expectedSubjectToken: "userA"},
+		{testName: "Exchange per subject.", authorization: "bearer userB", response: tokenResponse, expectedCode: 200, expectedRequests: 2, expectedSubjectToken: "userB"},
+		{testName: "No authorization.", expectedCode: 401, expectedReason: reasonMissingSubjectToken, expectedRequests: 2},
+		{testName: "No bearer authorization.", authorization: "Basic dXNlcjpwYXNz", expectedCode: 401, expectedReason: reasonMissingSubjectToken, expectedRequests: 2},
+		{testName: "Rejected subject token.", authorization: "Bearer expired", status: 400, response: `{"error":"invalid_grant"}`, expectedCode: 403, expectedReason: reasonSubjectTokenRejected, expectedRequests: 3},
+		{testName: "Rejected client.", authorization: "Bearer userC", status: 401, response: `{"error":"invalid_client"}`, expectedCode: 403, expectedReason: reasonIdpRejectedClient, expectedRequests: 4},
+	}
+
+	endpoint := &standInTokenEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	oauth2CredentialsFolder = t.TempDir()
+	os.MkdirAll(filepath.Join(oauth2CredentialsFolder, "client"), 0700)
+	os.WriteFile(filepath.Join(oauth2CredentialsFolder, "client", clientSecretFile), []byte("secret"), 0600)
+	globalHttpClient = &http.Client{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		oauth2CredentialsFolder = ""
+	}()
+
+	config := `{"tokenEndpoint":"` + server.URL + `","clientId":"client","audience":"https://api.org"}`
+	authGetter = &mockAuthGetter{mockAuthInfo: AuthInfo{AuthType: tokenExchangeAuthType, Config: json.RawMessage(config)}}
+	tokenExchangeCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+	router := gin.New()
+	router.GET("/:authType/auth", getAuth)
+
+	for _, tc := range tests {
+		log.Info("TestTokenExchangeGetAuth +++++++++++++++++++++ Running test: " + tc.testName)
+		endpoint.status = tc.status
+		endpoint.response = tc.response
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/TOKEN_EXCHANGE/auth?domain=test.domain&path=/", nil)
+		if tc.authorization != "" {
+			request.Header.Set("Authorization", tc.authorization)
+		}
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedCode {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedCode, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if endpoint.requests != tc.expectedRequests {
+			t.Errorf("%s: Expected %d requests to the sts, but were %d.", tc.testName, tc.expectedRequests, endpoint.requests)
+		}
+		if tc.expectedReason != "" {
+			var returned problem
+			json.Unmarshal(recorder.Body.Bytes(), &returned)
+			if returned.Reason != tc.expectedReason {
+				t.Errorf("%s: Expected a %s problem, but was %v.", tc.testName, tc.expectedReason, returned)
+			}
+			continue
+		}
+
+		// headers of the user must never be cached by the filter
+		if recorder.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: Expected the headers to not be stored, but cache control was %s.", tc.testName, recorder.Header().Get("Cache-Control"))
+		}
+		var headers HeadersList
+		json.Unmarshal(recorder.Body.Bytes(), &headers)
+		if len(headers) != 1 || headers[0].Value != "Bearer exchangedToken" {
+			t.Errorf("%s: Expected the exchanged token, but was %v.", tc.testName, headers)
+		}
+		if endpoint.form["subject_token"] != tc.expectedSubjectToken || endpoint.form["grant_type"] != tokenExchangeGrantType ||
+			endpoint.form["subject_token_type"] != accessTokenType || endpoint.form["audience"] != "https://api.org" {
+			t.Errorf("%s: Expected the exchange of %s, but the request was %v.", tc.testName, tc.expectedSubjectToken, endpoint.form)
+		}
+		if endpoint.basicUser != "client" || endpoint.basicSecret != "secret" {
+			t.Errorf("%s: Expected the client to authenticate with its secret, but was %s.", tc.testName, endpoint.basicUser)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/scrypt"
+)
+
+/**
+* Conflict policies for imported clients that already exist.
+ */
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+)
+
+/**
+* Name of the manifest inside the archive.
+ */
+const manifestFile = "manifest.json"
+
+/**
+* Header to provide the passphrase for encrypting and decrypting archives.
+ */
+const passphraseHeader = "X-Archive-Passphrase"
+
+/**
+* Prefix of encrypted archives, followed by the scrypt salt, the gcm nonce and the encrypted tar.gz.
+ */
+var encryptedArchiveMagic = []byte("EASCRED1")
+
+/**
+* Maximum size of an archive to be imported. Also limits the decompressed size of its content.
+ */
+const maxArchiveSize = 32 << 20
+
+/**
+* Maximum number of entries inside an archive to be imported.
+ */
+const maxArchiveEntries = 4096
+
+/**
+* Prefix of the folders imported clients are staged in, before they replace the actual folder of the client.
+ */
+const importStagingPrefix = ".import-"
+
+var errInvalidArchive = errors.New("invalid_archive")
+var errChecksumMismatch = errors.New("checksum_mismatch")
+var errPassphraseRequired = errors.New("passphrase_required")
+var errDecryptionFailed = errors.New("decryption_failed")
+
+/**
+* Files of a client folder that are transferred.
+ */
+var transferableFiles = map[string]bool{keyfile: true, certChainFile: true, pkcs11File: true}
+
+/**
+* Token required for the admin endpoints. They are disabled if empty.
+ */
+var adminToken string
+
+type archiveManifest struct {
+	Version int              `json:"version"`
+	Created time.Time        `json:"created"`
+	Clients []archivedClient `json:"clients"`
+}
+
+type archivedClient struct {
+	ClientID string         `json:"clientId"`
+	Files    []archivedFile `json:"files"`
+}
+
+type archivedFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+/**
+* Result of an import, per client.
+ */
+type ImportResult struct {
+	DryRun  bool                 `json:"dryRun"`
+	Clients []ClientImportResult `json:"clients"`
+}
+
+type ClientImportResult struct {
+	ClientID string `json:"clientId"`
+	Action   string `json:"action"`
+}
+
+/**
+* Only allow requests bearing the admin token.
+ */
+func requireAdminToken(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		logger.Warn("Received an admin request without a valid token.")
+		abortWithProblem(c, http.StatusUnauthorized, reasonUnauthorized, "A valid admin token is required.")
+		return
+	}
+	c.Next()
+}
+
+/**
+* Export all or the requested(query parameter clientId) credentials as tar.gz, encrypted if a passphrase is provided.
+ */
+func exportCredentials(c *gin.Context) {
+
+	audit := startAudit(auditExportCredentials, "")
+	defer audit.log(c)
+
+	clientIds := c.QueryArray("clientId")
+	if len(clientIds) == 0 {
+		folders, err := globalFolderAccessor.get(credentialsBaseFolder)
+		if err != nil {
+			logger.Warn("Was not able to read credentials folder.", err)
+			audit.err = err
+			abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the credentials folder.")
+			return
+		}
+		for _, folder := range folders {
+			if folder.IsDir() && !isStagingFolder(folder.Name()) {
+				clientIds = append(clientIds, folder.Name())
+			}
+		}
+	}
+
+	archive, err := buildArchive(clientIds)
+	if errors.Is(err, os.ErrNotExist) {
+		audit.err = err
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Warn("Was not able to build the archive. ", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to build the archive.")
+		return
+	}
+
+	fileName := "credentials.tar.gz"
+	if passphrase := c.GetHeader(passphraseHeader); passphrase != "" {
+		archive, err = encryptArchive(archive, passphrase)
+		if err != nil {
+			logger.Warn("Was not able to encrypt the archive. ", err)
+			audit.err = err
+			abortWithProblem(c, http.StatusInternalServerError, reasonInternalError, "Was not able to encrypt the archive.")
+			return
+		}
+		fileName += ".enc"
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(http.StatusOK, "application/octet-stream", archive)
+}
+
+/**
+* Import an archive created by the export. Supports the query parameters dryRun and conflictPolicy(skip, overwrite, fail).
+ */
+func importCredentials(c *gin.Context) {
+
+	audit := startAudit(auditImportCredentials, "")
+	defer audit.log(c)
+
+	conflictPolicy := c.DefaultQuery("conflictPolicy", conflictFail)
+	if conflictPolicy != conflictSkip && conflictPolicy != conflictOverwrite && conflictPolicy != conflictFail {
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Unknown conflict policy "+conflictPolicy+".")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+
+	archive, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxArchiveSize))
+	if err != nil {
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidArchive, "Was not able to read the archive.")
+		return
+	}
+
+	if bytes.HasPrefix(archive, encryptedArchiveMagic) {
+		archive, err = decryptArchive(archive, c.GetHeader(passphraseHeader))
+		if err != nil {
+			logger.Warn("Was not able to decrypt the archive. ", err)
+			audit.err = err
+			abortWithProblem(c, http.StatusBadRequest, reasonInvalidArchive, err.Error())
+			return
+		}
+	}
+
+	manifest, files, err := readArchive(archive)
+	if err != nil {
+		logger.Warn("Received an invalid archive. ", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidArchive, err.Error())
+		return
+	}
+
+	result := ImportResult{DryRun: dryRun, Clients: []ClientImportResult{}}
+	for _, client := range manifest.Clients {
+		action := "created"
+		if _, err := diskFs.Stat(buildCredentialsFolderPath(client.ClientID)); err == nil {
+			switch conflictPolicy {
+			case conflictFail:
+				audit.err = fmt.Errorf("credentials for %s already exist", client.ClientID)
+				c.Set(problemClientIdKey, client.ClientID)
+				abortWithProblem(c, http.StatusConflict, reasonCredentialsExist, "Credentials for "+client.ClientID+" already exist.")
+				return
+			case conflictSkip:
+				action = "skipped"
+			case conflictOverwrite:
+				action = "overwritten"
+			}
+		}
+		result.Clients = append(result.Clients, ClientImportResult{client.ClientID, action})
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	for i, client := range manifest.Clients {
+		if result.Clients[i].Action == "skipped" {
+			continue
+		}
+		clientAudit := startAudit(auditImportCredentials, client.ClientID)
+		err = storeImportedClient(client, files, result.Clients[i].Action == "overwritten")
+		clientAudit.err = err
+		if err != nil {
+			logger.Warn("Was not able to import the credentials for "+client.ClientID, err)
+			audit.err = err
+			c.Set(problemClientIdKey, client.ClientID)
+			abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to import the credentials for "+client.ClientID+".")
+			clientAudit.log(c)
+			return
+		}
+		clientAudit.log(c)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+/**
+* Write all files of the client to a staging folder, that replaces the folder of the client once complete. Existing credentials
+* are replaced as a whole, to not keep stale files, and stay untouched if the files cannot be written.
+ */
+func storeImportedClient(client archivedClient, files map[string][]byte, overwrite bool) (err error) {
+	credentialsFolderPath := strings.TrimSuffix(buildCredentialsFolderPath(client.ClientID), "/")
+	stagingFolderPath := strings.TrimSuffix(buildCredentialsFolderPath(importStagingPrefix+client.ClientID), "/")
+	replacedFolderPath := stagingFolderPath + ".replaced"
+
+	// remove leftovers of an interrupted import
+	if err = diskFs.RemoveAll(stagingFolderPath); err != nil {
+		return err
+	}
+	if err = diskFs.MkdirAll(stagingFolderPath, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range client.Files {
+		err = globalFileAccessor.write(stagingFolderPath+"/"+file.Name, files[client.ClientID+"/"+file.Name], 0666)
+		if err != nil {
+			diskFs.RemoveAll(stagingFolderPath)
+			return err
+		}
+	}
+
+	if !overwrite {
+		if err = diskFs.Rename(stagingFolderPath, credentialsFolderPath); err != nil {
+			diskFs.RemoveAll(stagingFolderPath)
+		}
+		return err
+	}
+
+	if err = diskFs.RemoveAll(replacedFolderPath); err != nil {
+		diskFs.RemoveAll(stagingFolderPath)
+		return err
+	}
+	releasePkcs11Reference(credentialsFolderPath + "/")
+	if err = diskFs.Rename(credentialsFolderPath, replacedFolderPath); err != nil {
+		diskFs.RemoveAll(stagingFolderPath)
+		return err
+	}
+	if err = diskFs.Rename(stagingFolderPath, credentialsFolderPath); err != nil {
+		// restore the previous credentials
+		diskFs.Rename(replacedFolderPath, credentialsFolderPath)
+		diskFs.RemoveAll(stagingFolderPath)
+		return err
+	}
+	jwtBearerCache.invalidate(client.ClientID)
+	parsedCredentials.invalidate(client.ClientID)
+	if err = diskFs.RemoveAll(replacedFolderPath); err != nil {
+		logger.Warnf("Was not able to remove the replaced credentials of %s. %v", client.ClientID, err)
+	}
+	return nil
+}
+
+/**
+* Create a tar.gz containing the manifest and the files of all clients.
+ */
+func buildArchive(clientIds []string) (archive []byte, err error) {
+	manifest := archiveManifest{Version: 1, Created: time.Now().UTC(), Clients: []archivedClient{}}
+	files := map[string][]byte{}
+
+	for _, clientId := range clientIds {
+		if !isValidClientId(clientId) {
+			return archive, fmt.Errorf("%w: invalid clientId %s", os.ErrNotExist, clientId)
+		}
+		credentialsFolderPath := buildCredentialsFolderPath(clientId)
+		folderContent, err := globalFolderAccessor.get(credentialsFolderPath)
+		if err != nil {
+			return archive, fmt.Errorf("no credentials for %s: %w", clientId, err)
+		}
+		client := archivedClient{ClientID: clientId, Files: []archivedFile{}}
+		for _, fileInfo := range folderContent {
+			if fileInfo.IsDir() || !transferableFiles[fileInfo.Name()] {
+				continue
+			}
+			content, err := globalFileAccessor.read(credentialsFolderPath + fileInfo.Name())
+			if err != nil {
+				return archive, err
+			}
+			checksum := sha256.Sum256(content)
+			client.Files = append(client.Files, archivedFile{fileInfo.Name(), int64(len(content)), hex.EncodeToString(checksum[:])})
+			files[clientId+"/"+fileInfo.Name()] = content
+		}
+		manifest.Clients = append(manifest.Clients, client)
+	}
+
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return archive, err
+	}
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = writeTarEntry(tarWriter, manifestFile, manifestContent)
+	for _, client := range manifest.Clients {
+		for _, file := range client.Files {
+			if err == nil {
+				name := client.ClientID + "/" + file.Name
+				err = writeTarEntry(tarWriter, name, files[name])
+			}
+		}
+	}
+	if err != nil {
+		return archive, err
+	}
+	if err = tarWriter.Close(); err != nil {
+		return archive, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return archive, err
+	}
+	return buffer.Bytes(), err
+}
+
+func writeTarEntry(tarWriter *tar.Writer, name string, content []byte) error {
+	err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(content)
+	return err
+}
+
+/**
+* Read the archive and verify its content against the manifest. Every file listed needs to be contained with a matching checksum,
+* no other files are accepted.
+ */
+func readArchive(archive []byte) (manifest archiveManifest, files map[string][]byte, err error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return manifest, files, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	files = map[string][]byte{}
+	var manifestContent []byte
+	var entries int
+	var totalSize int64
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, files, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		entries++
+		if entries > maxArchiveEntries {
+			return manifest, files, fmt.Errorf("%w: more than %d entries", errInvalidArchive, maxArchiveEntries)
+		}
+		// read one byte more than allowed, to detect content exceeding the limit
+		content, err := io.ReadAll(io.LimitReader(tarReader, maxArchiveSize-totalSize+1))
+		if err != nil {
+			return manifest, files, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		totalSize += int64(len(content))
+		if totalSize > maxArchiveSize {
+			return manifest, files, fmt.Errorf("%w: content exceeds %d bytes", errInvalidArchive, maxArchiveSize)
+		}
+		if header.Name == manifestFile {
+			manifestContent = content
+			continue
+		}
+		files[header.Name] = content
+	}
+
+	if manifestContent == nil {
+		return manifest, files, fmt.Errorf("%w: no manifest", errInvalidArchive)
+	}
+	if err = json.Unmarshal(manifestContent, &manifest); err != nil {
+		return manifest, files, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+
+	listedFiles := 0
+	for _, client := range manifest.Clients {
+		if !isValidClientId(client.ClientID) {
+			return manifest, files, fmt.Errorf("%w: invalid clientId %s", errInvalidArchive, client.ClientID)
+		}
+		for _, file := range client.Files {
+			if !transferableFiles[file.Name] {
+				return manifest, files, fmt.Errorf("%w: unexpected file %s", errInvalidArchive, file.Name)
+			}
+			content, ok := files[client.ClientID+"/"+file.Name]
+			if !ok {
+				return manifest, files, fmt.Errorf("%w: missing %s/%s", errInvalidArchive, client.ClientID, file.Name)
+			}
+			checksum := sha256.Sum256(content)
+			if hex.EncodeToString(checksum[:]) != file.Sha256 || int64(len(content)) != file.Size {
+				return manifest, files, fmt.Errorf("%w: %s/%s", errChecksumMismatch, client.ClientID, file.Name)
+			}
+			// references are validated like on creation, they must not load other modules
+			if file.Name == pkcs11File {
+				if _, err := parsePkcs11Config(content); err != nil {
+					return manifest, files, fmt.Errorf("%w: %s/%s %v", errInvalidArchive, client.ClientID, file.Name, err)
+				}
+			}
+			listedFiles++
+		}
+	}
+	if listedFiles != len(files) {
+		return manifest, files, fmt.Errorf("%w: files not listed in the manifest", errInvalidArchive)
+	}
+	return manifest, files, err
+}
+
+/**
+* Staging folders of running or interrupted imports are not clients.
+ */
+func isStagingFolder(name string) bool {
+	return strings.HasPrefix(name, importStagingPrefix)
+}
+
+/**
+* ClientIds are used as folder names, thus they must not navigate through the file system. Folders starting with a dot are reserved,
+* f.e. for staging imports and the data folders of mounted secrets.
+ */
+func isValidClientId(clientId string) bool {
+	return clientId != "" && !strings.HasPrefix(clientId, ".") && !strings.ContainsAny(clientId, "/\\")
+}
+
+/**
+* Encrypt the archive with AES-256-GCM, using a key derived from the passphrase via scrypt.
+ */
+func encryptArchive(archive []byte, passphrase string) (encrypted []byte, err error) {
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return encrypted, err
+	}
+	gcm, err := getArchiveCipher(passphrase, salt)
+	if err != nil {
+		return encrypted, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return encrypted, err
+	}
+
+	encrypted = append(encrypted, encryptedArchiveMagic...)
+	encrypted = append(encrypted, salt...)
+	encrypted = append(encrypted, nonce...)
+	return gcm.Seal(encrypted, nonce, archive, encryptedArchiveMagic), err
+}
+
+func decryptArchive(encrypted []byte, passphrase string) (archive []byte, err error) {
+	if passphrase == "" {
+		return archive, errPassphraseRequired
+	}
+	encrypted = encrypted[len(encryptedArchiveMagic):]
+	if len(encrypted) < 16 {
+		return archive, errInvalidArchive
+	}
+	salt, encrypted := encrypted[:16], encrypted[16:]
+	gcm, err := getArchiveCipher(passphrase, salt)
+	if err != nil {
+		return archive, err
+	}
+	if len(encrypted) < gcm.NonceSize() {
+		return archive, errInvalidArchive
+	}
+	archive, err = gcm.Open(nil, encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():], encryptedArchiveMagic)
+	if err != nil {
+		return archive, errDecryptionFailed
+	}
+	return archive, err
+}
+
+func getArchiveCipher(passphrase string, salt []byte) (gcm cipher.AEAD, err error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return gcm, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return gcm, err
+	}
+	return cipher.NewGCM(block)
+}
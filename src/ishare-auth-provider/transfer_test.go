@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestExportImportCredentials(t *testing.T) {
+
+	type test struct {
+		testName           string
+		exportPassphrase   string
+		importPassphrase   string
+		exportClients      []string
+		existingClients    []string
+		conflictPolicy     string
+		dryRun             bool
+		manipulate         func(archive []byte) []byte
+		failingWrite       string
+		expectedStatus     int
+		expectedDetail     string
+		expectedActions    map[string]string
+		expectedImported   []string
+		expectedUntouched  []string
+		expectedExportCode int
+	}
+
+	tests := []test{
+		{testName: "Import all clients.", expectedStatus: 200, expectedActions: map[string]string{"clientA": "created", "clientB": "created"}, expectedImported: []string{"clientA", "clientB"}},
+		{testName: "Import selected clients.", exportClients: []string{"clientB"}, expectedStatus: 200, expectedActions: map[string]string{"clientB": "created"}, expectedImported: []string{"clientB"}},
+		{testName: "Import encrypted archive.", exportPassphrase: "secret", importPassphrase: "secret", expectedStatus: 200, expectedActions: map[string]string{"clientA": "created", "clientB": "created"}, expectedImported: []string{"clientA", "clientB"}},
+		{testName: "Import encrypted archive without passphrase.", exportPassphrase: "secret", expectedStatus: 400},
+		{testName: "Import encrypted archive with wrong passphrase.", exportPassphrase: "secret", importPassphrase: "wrong", expectedStatus: 400},
+		{testName: "Skip existing clients.", existingClients: []string{"clientA"}, conflictPolicy: conflictSkip, expectedStatus: 200, expectedActions: map[string]string{"clientA": "skipped", "clientB": "created"}, expectedImported: []string{"clientB"}, expectedUntouched: []string{"clientA"}},
+		{testName: "Overwrite existing clients.", existingClients: []string{"clientA"}, conflictPolicy: conflictOverwrite, expectedStatus: 200, expectedActions: map[string]string{"clientA": "overwritten", "clientB": "created"}, expectedImported: []string{"clientA", "clientB"}},
+		{testName: "Fail on existing clients.", existingClients: []string{"clientA"}, conflictPolicy: conflictFail, expectedStatus: 409, expectedUntouched: []string{"clientA"}},
+		{testName: "Dry run does not write.", existingClients: []string{"clientA"}, conflictPolicy: conflictOverwrite, dryRun: true, expectedStatus: 200, expectedActions: map[string]string{"clientA": "overwritten", "clientB": "created"}, expectedUntouched: []string{"clientA"}},
+		{testName: "Reject unknown conflict policy.", conflictPolicy: "merge", expectedStatus: 400},
+		{testName: "Reject manipulated content.", manipulate: replaceEntry("clientA/key.pem", []byte("manipulated")), expectedStatus: 400},
+		{testName: "Reject path traversal.", manipulate: addEntry("../clientC/key.pem", []byte("key")), expectedStatus: 400},
+		{testName: "Reject no archive.", manipulate: func(archive []byte) []byte { return []byte("no-archive") }, expectedStatus: 400},
+		{testName: "Reject too many entries.", manipulate: addEntries(maxArchiveEntries), expectedStatus: 400, expectedDetail: "more than 4096 entries"},
+		{testName: "Reject oversized content.", manipulate: addEntry("clientC/key.pem", make([]byte, maxArchiveSize)), expectedStatus: 400, expectedDetail: "content exceeds"},
+		{testName: "Keep existing clients if the import fails.", existingClients: []string{"clientA"}, conflictPolicy: conflictOverwrite, failingWrite: "clientA/" + certChainFile, expectedStatus: 500, expectedUntouched: []string{"clientA"}},
+		{testName: "Export unknown client.", exportClients: []string{"unknown"}, expectedExportCode: 404},
+	}
+
+	gin.SetMode(gin.TestMode)
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		globalFolderAccessor = folderAccessor{mock_get_folder}
+		diskFs = &mockFS{}
+	}()
+
+	adminToken = "adminToken"
+	router := gin.New()
+	admin := router.Group("/admin", requireAdminToken)
+	admin.GET("/credentials/export", exportCredentials)
+	admin.POST("/credentials/import", importCredentials)
+
+	for _, tc := range tests {
+		log.Info("TestExportImportCredentials +++++++++++++++++++++ Running test: " + tc.testName)
+
+		globalFileAccessor = fileAccessor{writeFile, readFile}
+		globalFolderAccessor = folderAccessor{getFolderContent}
+		diskFs = &osFS{}
+
+		credentialsBaseFolder = t.TempDir()
+		storeTestClient("clientA", "keyA", "certA")
+		storeTestClient("clientB", "keyB", "certB")
+
+		exportUrl := "/admin/credentials/export"
+		for i, clientId := range tc.exportClients {
+			if i == 0 {
+				exportUrl += "?clientId=" + clientId
+			} else {
+				exportUrl += "&clientId=" + clientId
+			}
+		}
+		exportRequest, _ := http.NewRequest(http.MethodGet, exportUrl, nil)
+		exportRequest.Header.Set("Authorization", "Bearer adminToken")
+		if tc.exportPassphrase != "" {
+			exportRequest.Header.Set(passphraseHeader, tc.exportPassphrase)
+		}
+		exportRecorder := httptest.NewRecorder()
+		router.ServeHTTP(exportRecorder, exportRequest)
+
+		expectedExportCode := tc.expectedExportCode
+		if expectedExportCode == 0 {
+			expectedExportCode = 200
+		}
+		if exportRecorder.Code != expectedExportCode {
+			t.Errorf("%s: Expected export status %v but was %v.", tc.testName, expectedExportCode, exportRecorder.Code)
+		}
+		if expectedExportCode != 200 {
+			continue
+		}
+		archive := exportRecorder.Body.Bytes()
+		if tc.manipulate != nil {
+			archive = tc.manipulate(archive)
+		}
+
+		// import into a fresh store, only containing the existing clients
+		credentialsBaseFolder = t.TempDir()
+		for _, clientId := range tc.existingClients {
+			storeTestClient(clientId, "existingKey", "existingCert")
+		}
+
+		importUrl := "/admin/credentials/import?dryRun=" + strconv.FormatBool(tc.dryRun)
+		if tc.conflictPolicy != "" {
+			importUrl += "&conflictPolicy=" + tc.conflictPolicy
+		}
+		importRequest, _ := http.NewRequest(http.MethodPost, importUrl, bytes.NewReader(archive))
+		importRequest.Header.Set("Authorization", "Bearer adminToken")
+		if tc.importPassphrase != "" {
+			importRequest.Header.Set(passphraseHeader, tc.importPassphrase)
+		}
+		if tc.failingWrite != "" {
+			globalFileAccessor = fileAccessor{func(path string, content []byte, fileMode fs.FileMode) error {
+				if strings.HasSuffix(path, tc.failingWrite) {
+					return errors.New("not_writable")
+				}
+				return writeFile(path, content, fileMode)
+			}, readFile}
+		}
+		importRecorder := httptest.NewRecorder()
+		router.ServeHTTP(importRecorder, importRequest)
+
+		folders, _ := os.ReadDir(credentialsBaseFolder)
+		for _, folder := range folders {
+			if isStagingFolder(folder.Name()) {
+				t.Errorf("%s: The staging folder %s should have been removed.", tc.testName, folder.Name())
+			}
+		}
+
+		if importRecorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected import status %v but was %v. %s", tc.testName, tc.expectedStatus, importRecorder.Code, importRecorder.Body.String())
+			continue
+		}
+		if !strings.Contains(importRecorder.Body.String(), tc.expectedDetail) {
+			t.Errorf("%s: Expected the detail %s, but was %s.", tc.testName, tc.expectedDetail, importRecorder.Body.String())
+		}
+		if tc.expectedActions != nil {
+			var result ImportResult
+			json.Unmarshal(importRecorder.Body.Bytes(), &result)
+			if result.DryRun != tc.dryRun || len(result.Clients) != len(tc.expectedActions) {
+				t.Errorf("%s: Unexpected import result %s.", tc.testName, importRecorder.Body.String())
+			}
+			for _, client := range result.Clients {
+				if tc.expectedActions[client.ClientID] != client.Action {
+					t.Errorf("%s: Expected %s for %s but was %s.", tc.testName, tc.expectedActions[client.ClientID], client.ClientID, client.Action)
+				}
+			}
+		}
+		for _, clientId := range tc.expectedImported {
+			key, _ := os.ReadFile(filepath.Join(credentialsBaseFolder, clientId, keyfile))
+			if string(key) != "key"+clientId[len(clientId)-1:] {
+				t.Errorf("%s: Expected %s to be imported, but the key was %s.", tc.testName, clientId, key)
+			}
+		}
+		for _, clientId := range tc.expectedUntouched {
+			key, _ := os.ReadFile(filepath.Join(credentialsBaseFolder, clientId, keyfile))
+			if string(key) != "existingKey" {
+				t.Errorf("%s: Expected %s to be untouched, but the key was %s.", tc.testName, clientId, key)
+			}
+		}
+		if tc.expectedImported == nil {
+			if _, err := os.Stat(filepath.Join(credentialsBaseFolder, "clientB")); err == nil {
+				t.Errorf("%s: No client should have been imported.", tc.testName)
+			}
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+
+	type test struct {
+		testName       string
+		adminToken     string
+		authorization  string
+		expectedStatus int
+	}
+
+	tests := []test{
+		{testName: "Valid token.", adminToken: "adminToken", authorization: "Bearer adminToken", expectedStatus: 200},
+		{testName: "Invalid token.", adminToken: "adminToken", authorization: "Bearer otherToken", expectedStatus: 401},
+		{testName: "No token.", adminToken: "adminToken", expectedStatus: 401},
+		{testName: "Admin api disabled.", adminToken: "", authorization: "Bearer ", expectedStatus: 401},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestAdminToken +++++++++++++++++++++ Running test: " + tc.testName)
+
+		adminToken = tc.adminToken
+		router := gin.New()
+		router.GET("/admin", requireAdminToken, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+		request.Header.Set("Authorization", tc.authorization)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v.", tc.testName, tc.expectedStatus, recorder.Code)
+		}
+	}
+}
+
+func storeTestClient(clientId string, key string, cert string) {
+	os.MkdirAll(filepath.Join(credentialsBaseFolder, clientId), os.ModePerm)
+	os.WriteFile(filepath.Join(credentialsBaseFolder, clientId, keyfile), []byte(key), 0600)
+	os.WriteFile(filepath.Join(credentialsBaseFolder, clientId, certChainFile), []byte(cert), 0600)
+}
+
+/**
+* Rewrite the archive, applying the given function to all entries.
+ */
+func rewriteArchive(archive []byte, rewrite func(entries map[string][]byte, names []string) []string) []byte {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return archive
+	}
+	tarReader := tar.NewReader(gzipReader)
+	entries := map[string][]byte{}
+	names := []string{}
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		var content bytes.Buffer
+		content.ReadFrom(tarReader)
+		entries[header.Name] = content.Bytes()
+		names = append(names, header.Name)
+	}
+	names = rewrite(entries, names)
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		writeTarEntry(tarWriter, name, entries[name])
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buffer.Bytes()
+}
+
+func replaceEntry(name string, content []byte) func(archive []byte) []byte {
+	return func(archive []byte) []byte {
+		return rewriteArchive(archive, func(entries map[string][]byte, names []string) []string {
+			entries[name] = content
+			return names
+		})
+	}
+}
+
+func addEntries(count int) func(archive []byte) []byte {
+	return func(archive []byte) []byte {
+		return rewriteArchive(archive, func(entries map[string][]byte, names []string) []string {
+			for i := 0; i < count; i++ {
+				name := "clientC/" + strconv.Itoa(i)
+				entries[name] = []byte{}
+				names = append(names, name)
+			}
+			return names
+		})
+	}
+}
+
+func addEntry(name string, content []byte) func(archive []byte) []byte {
+	return func(archive []byte) []byte {
+		return rewriteArchive(archive, func(entries map[string][]byte, names []string) []string {
+			entries[name] = content
+			return append(names, name)
+		})
+	}
+}
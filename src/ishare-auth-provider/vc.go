@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+/**
+* Auth type of the verifiable credentials provider, as used in its route /VERIFIABLE_CREDENTIALS/auth.
+ */
+const vcAuthType = "VERIFIABLE_CREDENTIALS"
+
+/**
+* Grant type of the token endpoint of OID4VP verifiers, exchanging a verifiable presentation for an access token.
+ */
+const vpTokenGrantType = "vp_token"
+
+/**
+* Formats of the presentation exchange, as used in the presentation submission.
+ */
+const (
+	jwtVpFormat = "jwt_vp"
+	jwtVcFormat = "jwt_vc"
+	ldpVcFormat = "ldp_vc"
+)
+
+/**
+* Lifetime of the presentation. It is only used once, for the token request.
+ */
+const vpLifetime = 30 * time.Second
+
+/**
+* Holder key and credentials are stored in the folder of the holder, the credentials in a sub folder with one file per credential.
+ */
+const vcCredentialsFolder = "credentials/"
+
+var errInvalidVcConfig = errors.New("invalid_vc_config")
+var errInvalidVerifiableCredential = errors.New("invalid_verifiable_credential")
+var errNoMatchingCredential = errors.New("no_matching_credential")
+
+/**
+* Folder to store the holder keys and verifiable credentials in, one folder per holder.
+ */
+var vcHoldersFolder string
+
+/**
+* Configuration of an endpoint using verifiable credentials, read from the config of its auth info. Key and credentials are
+* taken from the stored holder.
+ */
+type vcConfig struct {
+	TokenEndpoint   string   `json:"tokenEndpoint"`
+	ClientId        string   `json:"clientId"`
+	HolderId        string   `json:"holderId"`
+	HolderDid       string   `json:"holderDid"`
+	KeyId           string   `json:"keyId,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	CredentialTypes []string `json:"credentialTypes,omitempty"`
+}
+
+/**
+* Stored credential, either a jwt or a json-ld credential.
+ */
+type verifiableCredential struct {
+	name       string
+	format     string
+	types      []string
+	credential interface{}
+}
+
+/**
+* Tokens of the verifiable credentials provider, invalidated when the key or credentials of their holder change.
+ */
+var vcTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+/**
+* Provider presenting the stored credentials of the holder to an OID4VP verifier. The presentation is signed with the holder key and
+* exchanged for an access token at the token endpoint of the verifier. Tokens are cached based on their expires_in.
+ */
+type vcProvider struct{}
+
+func (vcProvider) resolveAuthInfo(domain string, path string) (authInfo AuthInfo, err error) {
+	authInfo, err = getEndpointAuthInfo(vcAuthType, domain, path)
+	if err != nil {
+		return authInfo, err
+	}
+	if _, err = getVcConfig(authInfo); err != nil {
+		logger.Warnf("Received invalid verifiable credentials auth info for %s - %s. Err: %v", domain, path, err)
+		return authInfo, &authFailure{status: http.StatusBadGateway, reason: reasonInvalidAuthInfo, detail: "Received invalid verifiable credentials auth info: " + err.Error(), err: err}
+	}
+	return authInfo, err
+}
+
+func (vcProvider) getHeaders(authInfo AuthInfo, domain string, path string) (headers HeadersList, cacheLifetime time.Duration, err error) {
+	config, err := getVcConfig(authInfo)
+	if err != nil {
+		return headers, cacheLifetime, err
+	}
+
+	cacheKey := getVcCacheKey(config)
+	token, expiry, cached := vcTokenCache.get(cacheKey)
+	if !cached {
+		token, expiry, err = requestVpToken(config)
+		if errors.Is(err, errNoMatchingCredential) {
+			logger.Warnf("The holder %s has no credential for %s. Err: %v", config.HolderId, config.Scope, err)
+			return headers, cacheLifetime, &authFailure{status: http.StatusInternalServerError, reason: reasonMissingCredentials, detail: "No matching credential exists for holder " + config.HolderId + ".", clientId: config.HolderId, err: err}
+		}
+		if err != nil {
+			return headers, cacheLifetime, getTokenRequestFailure(config.HolderId, err)
+		}
+		vcTokenCache.put(cacheKey, config.HolderId, token, expiry)
+	}
+
+	headers = HeadersList{Header{Name: "Authorization", Value: "Bearer " + token}}
+	return headers, time.Until(expiry), err
+}
+
+/**
+* Decode and validate the verifiable credentials configuration of the auth info. The key id defaults to the did of the holder.
+ */
+func getVcConfig(authInfo AuthInfo) (config vcConfig, err error) {
+	if err = decodeProviderConfig(authInfo, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", errInvalidVcConfig, err)
+	}
+	if config.TokenEndpoint == "" || config.ClientId == "" || config.HolderDid == "" {
+		return config, fmt.Errorf("%w: token endpoint, client id and holder did are required", errInvalidVcConfig)
+	}
+	if !isValidClientId(config.HolderId) {
+		return config, fmt.Errorf("%w: invalid holder id %s", errInvalidVcConfig, config.HolderId)
+	}
+	if config.KeyId == "" {
+		config.KeyId = config.HolderDid
+	}
+	return config, err
+}
+
+/**
+* Present the matching credentials of the holder at the token endpoint of the verifier.
+ */
+func requestVpToken(config vcConfig) (token string, expiry time.Time, err error) {
+	credentials, err := getMatchingCredentials(config.HolderId, config.CredentialTypes)
+	if err != nil {
+		return token, expiry, err
+	}
+	vpToken, err := createVerifiablePresentation(config, credentials)
+	if errors.Is(err, fs.ErrNotExist) {
+		return token, expiry, err
+	}
+	if err != nil {
+		return token, expiry, fmt.Errorf("%w: %v", errInvalidClientCredentials, err)
+	}
+	submission, err := getPresentationSubmission(config, credentials)
+	if err != nil {
+		return token, expiry, err
+	}
+	encodedSubmission, err := json.Marshal(submission)
+	if err != nil {
+		return token, expiry, err
+	}
+
+	data := url.Values{
+		"grant_type":              {vpTokenGrantType},
+		"vp_token":                {vpToken},
+		"presentation_submission": {string(encodedSubmission)},
+		"client_id":               {config.ClientId},
+	}
+	if config.Scope != "" {
+		data.Set("scope", config.Scope)
+	}
+
+	requestStart := time.Now()
+	res, err := requestToken(config.TokenEndpoint, config.ClientId, data)
+	if err != nil {
+		return token, expiry, err
+	}
+
+	token = res["access_token"].(string)
+	expiry = requestStart
+	if expiresIn, ok := res["expires_in"].(float64); ok {
+		expiry = requestStart.Add(time.Duration(expiresIn)*time.Second - oauth2ExpiryMargin)
+	}
+	return token, expiry, err
+}
+
+/**
+* Create the presentation as jwt, signed with the key of the holder. The verifier is its audience.
+ */
+func createVerifiablePresentation(config vcConfig, credentials []verifiableCredential) (vpToken string, err error) {
+	randomUuid, err := uuid.NewRandom()
+	if err != nil {
+		return vpToken, err
+	}
+	presented := make([]interface{}, len(credentials))
+	for i, credential := range credentials {
+		presented[i] = credential.credential
+	}
+
+	now := time.Now()
+	jwtToken := jwt.NewWithClaims(signingMethodSignerRS256, jwt.MapClaims{
+		"jti":   "urn:uuid:" + randomUuid.String(),
+		"iss":   config.HolderDid,
+		"sub":   config.HolderDid,
+		"aud":   config.ClientId,
+		"nonce": randomUuid.String(),
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(vpLifetime).Unix(),
+		"vp": map[string]interface{}{
+			"@context":             []string{"https://www.w3.org/2018/credentials/v1"},
+			"type":                 []string{"VerifiablePresentation"},
+			"holder":               config.HolderDid,
+			"verifiableCredential": presented,
+		},
+	})
+	jwtToken.Header["kid"] = config.KeyId
+
+	key, err := authGetter.getSigningKey(buildVcHolderFolderPath(config.HolderId))
+	if err != nil {
+		logger.Warn("Was not able to read the holder key.")
+		return vpToken, err
+	}
+	if key == nil {
+		return vpToken, errInvalidSigningKey
+	}
+	return jwtToken.SignedString(key)
+}
+
+/**
+* Describe where the verifier finds the presented credentials, as defined by DIF presentation exchange.
+ */
+func getPresentationSubmission(config vcConfig, credentials []verifiableCredential) (submission map[string]interface{}, err error) {
+	submissionId, err := uuid.NewRandom()
+	if err != nil {
+		return submission, err
+	}
+	descriptors := make([]interface{}, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = map[string]interface{}{
+			"id":     credential.types[len(credential.types)-1],
+			"format": jwtVpFormat,
+			"path":   "$",
+			"path_nested": map[string]interface{}{
+				"id":     credential.types[len(credential.types)-1],
+				"format": credential.format,
+				"path":   "$.vp.verifiableCredential[" + strconv.Itoa(i) + "]",
+			},
+		}
+	}
+	definitionId := config.Scope
+	if definitionId == "" {
+		definitionId = vcAuthType
+	}
+	submission = map[string]interface{}{
+		"id":             submissionId.String(),
+		"definition_id":  definitionId,
+		"descriptor_map": descriptors,
+	}
+	return submission, err
+}
+
+/**
+* Read the credentials of the holder, that are of one of the requested types. Without types, all credentials are presented.
+ */
+func getMatchingCredentials(holderId string, credentialTypes []string) (credentials []verifiableCredential, err error) {
+	folderPath := buildVcHolderFolderPath(holderId) + vcCredentialsFolder
+	files, err := globalFolderAccessor.get(folderPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return credentials, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		content, err := globalFileAccessor.read(folderPath + file.Name())
+		if err != nil {
+			return credentials, err
+		}
+		credential, err := parseVerifiableCredential(file.Name(), content)
+		if err != nil {
+			logger.Warnf("Skip the invalid credential %s of %s. Err: %v", file.Name(), holderId, err)
+			continue
+		}
+		if len(credentialTypes) == 0 || containsAny(credential.types, credentialTypes) {
+			credentials = append(credentials, credential)
+		}
+	}
+	if len(credentials) == 0 {
+		return credentials, fmt.Errorf("%w: types %v", errNoMatchingCredential, credentialTypes)
+	}
+	return credentials, nil
+}
+
+/**
+* Parse a stored credential. Credentials in jwt format are presented as they are, json-ld credentials as embedded objects. The
+* signature of the credential is not checked, that is up to the verifier.
+ */
+func parseVerifiableCredential(name string, content []byte) (credential verifiableCredential, err error) {
+	credential.name = name
+	trimmed := strings.TrimSpace(string(content))
+
+	var types interface{}
+	if strings.HasPrefix(trimmed, "{") {
+		var ldpCredential map[string]interface{}
+		if err = json.Unmarshal([]byte(trimmed), &ldpCredential); err != nil {
+			return credential, fmt.Errorf("%w: %v", errInvalidVerifiableCredential, err)
+		}
+		credential.format = ldpVcFormat
+		credential.credential = json.RawMessage(trimmed)
+		types = ldpCredential["type"]
+	} else {
+		claims := jwt.MapClaims{}
+		if _, _, err = new(jwt.Parser).ParseUnverified(trimmed, claims); err != nil {
+			return credential, fmt.Errorf("%w: %v", errInvalidVerifiableCredential, err)
+		}
+		credential.format = jwtVcFormat
+		credential.credential = trimmed
+		types = claims["type"]
+		if vc, ok := claims["vc"].(map[string]interface{}); ok {
+			types = vc["type"]
+		}
+	}
+
+	switch typed := types.(type) {
+	case string:
+		credential.types = []string{typed}
+	case []interface{}:
+		for _, credentialType := range typed {
+			if credentialType, ok := credentialType.(string); ok {
+				credential.types = append(credential.types, credentialType)
+			}
+		}
+	}
+	if len(credential.types) == 0 {
+		return credential, fmt.Errorf("%w: the credential has no type", errInvalidVerifiableCredential)
+	}
+	return credential, nil
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getVcCacheKey(config vcConfig) string {
+	hash := sha256.Sum256([]byte(config.TokenEndpoint + "|" + config.ClientId + "|" + config.HolderId + "|" + config.HolderDid + "|" + config.KeyId + "|" + config.Scope + "|" + strings.Join(config.CredentialTypes, " ")))
+	return hex.EncodeToString(hash[:])
+}
+
+/**
+* Build the path to the folder of the holder. It will include the trailing /
+ */
+func buildVcHolderFolderPath(holderId string) string {
+	return strings.TrimSuffix(vcHoldersFolder, "/") + "/" + holderId + "/"
+}
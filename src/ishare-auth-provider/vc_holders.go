@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/**
+* The holder api is only available if a folder to store them is configured. Holder ids and credential names are used as file names,
+* thus cannot point outside of it.
+ */
+func requireVcHoldersFolder(c *gin.Context) {
+	if vcHoldersFolder == "" {
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "No folder for verifiable credentials is configured.")
+		return
+	}
+	for _, param := range c.Params {
+		if !isValidClientId(param.Value) {
+			abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The name "+param.Value+" is not allowed.")
+			return
+		}
+	}
+}
+
+func getVcHoldersList(c *gin.Context) {
+
+	audit := startSecretAudit(auditListVcHolders, "")
+	defer audit.log(c)
+
+	folders, err := globalFolderAccessor.get(vcHoldersFolder)
+	if err != nil {
+		logger.Warn("Was not able to read the verifiable credentials folder.", err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the verifiable credentials folder.")
+		return
+	}
+
+	holderIds := []string{}
+	for _, folder := range folders {
+		if folder.IsDir() {
+			holderIds = append(holderIds, folder.Name())
+		}
+	}
+	c.JSON(http.StatusOK, holderIds)
+}
+
+/**
+* List the names of the credentials of the holder. The credentials themselves are never returned.
+ */
+func getVcHolderCredentials(c *gin.Context) {
+	holderId := c.Param("holderId")
+
+	audit := startSecretAudit(auditShowVcHolder, holderId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(buildVcHolderFolderPath(holderId)); errors.Is(err, os.ErrNotExist) {
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No holder "+holderId+" exists.")
+		return
+	}
+	files, err := globalFolderAccessor.get(buildVcHolderFolderPath(holderId) + vcCredentialsFolder)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Was not able to read the credentials of "+holderId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to read the credentials of "+holderId+".")
+		return
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+/**
+* Create or replace the key of the holder, the holder is created if it does not exist yet. Cached tokens of the holder are dropped.
+ */
+func putVcHolderKey(c *gin.Context) {
+	holderId := c.Param("holderId")
+
+	audit := startSecretAudit(auditUpdateVcHolderKey, holderId)
+	defer audit.log(c)
+
+	key, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		_, err = jwt.ParseRSAPrivateKeyFromPEM(key)
+	}
+	if err != nil {
+		logger.Warn("Did not receive a valid holder key.", err)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "The holder key has to be a PEM encoded RSA key.")
+		return
+	}
+	storeVcHolderFile(c, audit, holderId, keyfile, key)
+}
+
+/**
+* Create or replace a credential of the holder. Credentials are accepted as jwt or json-ld, they need to contain a type.
+ */
+func putVerifiableCredential(c *gin.Context) {
+	holderId := c.Param("holderId")
+	name := c.Param("name")
+
+	audit := startSecretAudit(auditUpdateVerifiableCredential, holderId)
+	defer audit.log(c)
+
+	credential, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		_, err = parseVerifiableCredential(name, credential)
+	}
+	if err != nil {
+		logger.Warn("Did not receive a valid credential.", err)
+		abortWithProblem(c, http.StatusBadRequest, reasonInvalidRequest, "Was not able to read the credential: "+err.Error())
+		return
+	}
+	storeVcHolderFile(c, audit, holderId, vcCredentialsFolder+name, credential)
+}
+
+func storeVcHolderFile(c *gin.Context, audit *auditEntry, holderId string, fileName string, content []byte) {
+	holderFolderPath := buildVcHolderFolderPath(holderId)
+	err := diskFs.MkdirAll(holderFolderPath+vcCredentialsFolder, 0700)
+	if err == nil {
+		err = globalFileAccessor.write(holderFolderPath+fileName, content, 0600)
+	}
+	if err != nil {
+		logger.Warn("Was not able to store "+fileName+" of "+holderId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to store the credentials of "+holderId+".")
+		return
+	}
+	vcTokenCache.invalidate(holderId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func deleteVcHolder(c *gin.Context) {
+	holderId := c.Param("holderId")
+	removeVcHolderFiles(c, holderId, buildVcHolderFolderPath(holderId))
+}
+
+func deleteVerifiableCredential(c *gin.Context) {
+	holderId := c.Param("holderId")
+	removeVcHolderFiles(c, holderId, buildVcHolderFolderPath(holderId)+vcCredentialsFolder+c.Param("name"))
+}
+
+func removeVcHolderFiles(c *gin.Context, holderId string, path string) {
+	audit := startSecretAudit(auditDeleteVcHolder, holderId)
+	defer audit.log(c)
+
+	if _, err := diskFs.Stat(path); errors.Is(err, os.ErrNotExist) {
+		abortWithProblem(c, http.StatusNotFound, reasonUnknownClient, "No such credential exists for "+holderId+".")
+		return
+	}
+	err := diskFs.RemoveAll(path)
+	if err != nil {
+		logger.Warn("Was not able to delete the credentials of "+holderId, err)
+		audit.err = err
+		abortWithProblem(c, http.StatusInternalServerError, reasonStorageFailure, "Was not able to delete the credentials of "+holderId+".")
+		return
+	}
+	vcTokenCache.invalidate(holderId)
+	c.AbortWithStatus(http.StatusNoContent)
+}
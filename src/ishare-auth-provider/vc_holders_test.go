@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestVcHolders(t *testing.T) {
+
+	type test struct {
+		testName        string
+		method          string
+		url             string
+		body            string
+		expectedStatus  int
+		expectedBody    string
+		expectedFiles   []string
+		expectedMissing []string
+	}
+
+	validKey, _ := getValidKey()
+	holderKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(validKey)}))
+
+	// the steps build on each other
+	tests := []test{
+		{testName: "Create with key.", method: http.MethodPut, url: "/vc/holders/org/holderKey", body: holderKey, expectedStatus: 204,
+			expectedFiles: []string{"org/" + keyfile}},
+		{testName: "Invalid key.", method: http.MethodPut, url: "/vc/holders/other/holderKey", body: "key", expectedStatus: 400,
+			expectedMissing: []string{"other"}},
+		{testName: "Add credential.", method: http.MethodPut, url: "/vc/holders/org/credentials/employee", body: `{"type":["VerifiableCredential","UserCredential"]}`, expectedStatus: 204,
+			expectedFiles: []string{"org/" + vcCredentialsFolder + "employee"}},
+		{testName: "Add credential without type.", method: http.MethodPut, url: "/vc/holders/org/credentials/untyped", body: `{"issuer":"did:web:issuer.eu"}`, expectedStatus: 400,
+			expectedMissing: []string{"org/" + vcCredentialsFolder + "untyped"}},
+		{testName: "Add credential outside of the folder.", method: http.MethodPut, url: "/vc/holders/org/credentials/..", body: `{"type":"UserCredential"}`, expectedStatus: 400},
+		{testName: "List holders.", method: http.MethodGet, url: "/vc/holders", expectedStatus: 200, expectedBody: `["org"]`},
+		{testName: "List credentials.", method: http.MethodGet, url: "/vc/holders/org", expectedStatus: 200, expectedBody: `["employee"]`},
+		{testName: "List credentials of unknown holder.", method: http.MethodGet, url: "/vc/holders/other", expectedStatus: 404},
+		{testName: "Delete credential.", method: http.MethodDelete, url: "/vc/holders/org/credentials/employee", expectedStatus: 204,
+			expectedFiles: []string{"org/" + keyfile}, expectedMissing: []string{"org/" + vcCredentialsFolder + "employee"}},
+		{testName: "Delete unknown credential.", method: http.MethodDelete, url: "/vc/holders/org/credentials/employee", expectedStatus: 404},
+		{testName: "Delete holder.", method: http.MethodDelete, url: "/vc/holders/org", expectedStatus: 204,
+			expectedMissing: []string{"org"}},
+		{testName: "Delete unknown holder.", method: http.MethodDelete, url: "/vc/holders/org", expectedStatus: 404},
+	}
+
+	vcHoldersFolder = t.TempDir()
+	diskFs = &osFS{}
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	defer func() {
+		vcHoldersFolder = ""
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+	}()
+
+	router := gin.New()
+	vcHolders := router.Group("/vc/holders", requireVcHoldersFolder)
+	vcHolders.GET("", getVcHoldersList)
+	vcHolders.GET("/:holderId", getVcHolderCredentials)
+	vcHolders.DELETE("/:holderId", deleteVcHolder)
+	vcHolders.PUT("/:holderId/holderKey", putVcHolderKey)
+	vcHolders.PUT("/:holderId/credentials/:name", putVerifiableCredential)
+	vcHolders.DELETE("/:holderId/credentials/:name", deleteVerifiableCredential)
+
+	for _, tc := range tests {
+		log.Info("TestVcHolders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		vcTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+		vcTokenCache.put("presentation", "org", "myToken", time.Now().Add(time.Minute))
+
+		request, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("%s: Expected status %v but was %v. %s", tc.testName, tc.expectedStatus, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if tc.expectedBody != "" && recorder.Body.String() != tc.expectedBody {
+			t.Errorf("%s: Expected body %s but was %s.", tc.testName, tc.expectedBody, recorder.Body.String())
+		}
+		for _, file := range tc.expectedFiles {
+			if _, err := os.Stat(filepath.Join(vcHoldersFolder, file)); err != nil {
+				t.Errorf("%s: Expected %s to exist.", tc.testName, file)
+			}
+		}
+		for _, file := range tc.expectedMissing {
+			if _, err := os.Stat(filepath.Join(vcHoldersFolder, file)); !os.IsNotExist(err) {
+				t.Errorf("%s: Expected %s to not exist.", tc.testName, file)
+			}
+		}
+		_, _, cached := vcTokenCache.get("presentation")
+		if cached == (tc.expectedStatus == 204) {
+			t.Errorf("%s: Expected cached tokens of the holder to only be dropped on changes.", tc.testName)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetVcConfig(t *testing.T) {
+
+	type test struct {
+		testName       string
+		config         string
+		expectedConfig vcConfig
+		expectedError  error
+	}
+
+	tests := []test{
+		{testName: "Defaults.", config: `{"tokenEndpoint":"https://verifier.org/token","clientId":"data-service","holderId":"org","holderDid":"did:web:org.eu"}`,
+			expectedConfig: vcConfig{TokenEndpoint: "https://verifier.org/token", ClientId: "data-service", HolderId: "org", HolderDid: "did:web:org.eu", KeyId: "did:web:org.eu"}},
+		{testName: "Configured presentation.", config: `{"tokenEndpoint":"https://verifier.org/token","clientId":"data-service","holderId":"org","holderDid":"did:web:org.eu","keyId":"did:web:org.eu#key-1","scope":"operator","credentialTypes":["OperatorCredential"]}`,
+			expectedConfig: vcConfig{TokenEndpoint: "https://verifier.org/token", ClientId: "data-service", HolderId: "org", HolderDid: "did:web:org.eu", KeyId: "did:web:org.eu#key-1", Scope: "operator", CredentialTypes: []string{"OperatorCredential"}}},
+		{testName: "No holder did.", config: `{"tokenEndpoint":"https://verifier.org/token","clientId":"data-service","holderId":"org"}`, expectedError: errInvalidVcConfig},
+		{testName: "No token endpoint.", config: `{"clientId":"data-service","holderId":"org","holderDid":"did:web:org.eu"}`, expectedError: errInvalidVcConfig},
+		{testName: "Holder outside of the folder.", config: `{"tokenEndpoint":"https://verifier.org/token","clientId":"data-service","holderId":"..","holderDid":"did:web:org.eu"}`, expectedError: errInvalidVcConfig},
+		{testName: "No config.", expectedError: errInvalidVcConfig},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestGetVcConfig +++++++++++++++++++++ Running test: " + tc.testName)
+
+		config, err := getVcConfig(AuthInfo{AuthType: vcAuthType, Config: json.RawMessage(tc.config)})
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && fmt.Sprint(config) != fmt.Sprint(tc.expectedConfig) {
+			t.Errorf("%s: Expected config %v but was %v.", tc.testName, tc.expectedConfig, config)
+		}
+	}
+}
+
+func TestParseVerifiableCredential(t *testing.T) {
+
+	type test struct {
+		testName       string
+		credential     string
+		expectedFormat string
+		expectedTypes  []string
+		expectedError  error
+	}
+
+	jwtCredential, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"vc": map[string]interface{}{"type": []string{"VerifiableCredential", "OperatorCredential"}}}).SignedString([]byte("issuer"))
+	untypedCredential, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "did:web:issuer.eu"}).SignedString([]byte("issuer"))
+
+	tests := []test{
+		{testName: "Jwt credential.", credential: jwtCredential + "\n", expectedFormat: jwtVcFormat, expectedTypes: []string{"VerifiableCredential", "OperatorCredential"}},
+		{testName: "Json-ld credential.", credential: `{"@context":["https://www.w3.org/2018/credentials/v1"],"type":["VerifiableCredential","UserCredential"],"proof":{}}`, expectedFormat: ldpVcFormat, expectedTypes: []string{"VerifiableCredential", "UserCredential"}},
+		{testName: "Single type.", credential: `{"type":"UserCredential"}`, expectedFormat: ldpVcFormat, expectedTypes: []string{"UserCredential"}},
+		{testName: "No type.", credential: untypedCredential, expectedError: errInvalidVerifiableCredential},
+		{testName: "Invalid json.", credential: `{"type":`, expectedError: errInvalidVerifiableCredential},
+		{testName: "No credential.", credential: "credential", expectedError: errInvalidVerifiableCredential},
+	}
+
+	for _, tc := range tests {
+		log.Info("TestParseVerifiableCredential +++++++++++++++++++++ Running test: " + tc.testName)
+
+		credential, err := parseVerifiableCredential("credential", []byte(tc.credential))
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("%s: Expected error %v but was %v.", tc.testName, tc.expectedError, err)
+		}
+		if err == nil && (credential.format != tc.expectedFormat || fmt.Sprint(credential.types) != fmt.Sprint(tc.expectedTypes)) {
+			t.Errorf("%s: Expected a %s credential of %v, but was %s of %v.", tc.testName, tc.expectedFormat, tc.expectedTypes, credential.format, credential.types)
+		}
+	}
+}
+
+func TestVcGetHeaders(t *testing.T) {
+
+	type test struct {
+		testName            string
+		credentialTypes     []string
+		mockKeyReadError    error
+		status              int
+		response            string
+		expectedReason      string
+		expectedCredentials int
+		expectedFormats     []string
+	}
+
+	tokenResponse := `{"access_token":"myToken","token_type":"Bearer","expires_in":60}`
+	tests := []test{
+		{testName: "Present all credentials.", response: tokenResponse, expectedCredentials: 2, expectedFormats: []string{ldpVcFormat, jwtVcFormat}},
+		{testName: "Present the credential of the scope.", credentialTypes: []string{"OperatorCredential"}, response: tokenResponse, expectedCredentials: 1, expectedFormats: []string{jwtVcFormat}},
+		{testName: "No matching credential.", credentialTypes: []string{"LegalPersonCredential"}, response: tokenResponse, expectedReason: reasonMissingCredentials},
+		{testName: "Missing holder key.", mockKeyReadError: fs.ErrNotExist, response: tokenResponse, expectedReason: reasonMissingCredentials},
+		{testName: "Invalid holder key.", mockKeyReadError: errors.New("parse_error"), response: tokenResponse, expectedReason: reasonInvalidCredentials},
+		{testName: "Rejected presentation.", status: 400, response: `{"error":"invalid_grant"}`, expectedReason: reasonIdpRejectedClient},
+		{testName: "Unavailable verifier.", status: 503, response: ``, expectedReason: reasonIdpUnavailable},
+	}
+
+	operatorCredential, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"vc": map[string]interface{}{"type": []string{"VerifiableCredential", "OperatorCredential"}}}).SignedString([]byte("issuer"))
+	vcHoldersFolder = t.TempDir()
+	credentialsFolder := filepath.Join(vcHoldersFolder, "org", "credentials")
+	os.MkdirAll(credentialsFolder, 0700)
+	os.WriteFile(filepath.Join(credentialsFolder, "operator"), []byte(operatorCredential), 0600)
+	os.WriteFile(filepath.Join(credentialsFolder, "invalid"), []byte("invalid"), 0600)
+	os.WriteFile(filepath.Join(credentialsFolder, "employee"), []byte(`{"type":["VerifiableCredential","UserCredential"]}`), 0600)
+	globalFileAccessor = fileAccessor{writeFile, readFile}
+	globalFolderAccessor = folderAccessor{getFolderContent}
+	globalHttpClient = &http.Client{}
+	defer func() {
+		globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+		vcHoldersFolder = ""
+	}()
+
+	validKey, _ := getValidKey()
+
+	for _, tc := range tests {
+		log.Info("TestVcGetHeaders +++++++++++++++++++++ Running test: " + tc.testName)
+
+		authGetter = &mockAuthGetter{mockKey: validKey, keyGetError: tc.mockKeyReadError}
+		vcTokenCache = oauth2TokenCache{tokens: map[string]cachedOAuth2Token{}}
+
+		// stand-in for the token endpoint of the verifier
+		verifier := &standInTokenEndpoint{status: tc.status, response: tc.response}
+		server := httptest.NewServer(verifier)
+		config, _ := json.Marshal(vcConfig{TokenEndpoint: server.URL, ClientId: "data-service", HolderId: "org", HolderDid: "did:web:org.eu", Scope: "operator", CredentialTypes: tc.credentialTypes})
+		authInfo := AuthInfo{AuthType: vcAuthType, Config: config}
+
+		headers, cacheLifetime, err := vcProvider{}.getHeaders(authInfo, "test.domain", "/")
+		// a second request is served from the cache
+		vcProvider{}.getHeaders(authInfo, "test.domain", "/")
+		server.Close()
+
+		if tc.expectedReason != "" {
+			var failure *authFailure
+			if !errors.As(err, &failure) || failure.reason != tc.expectedReason || failure.clientId != "org" {
+				t.Errorf("%s: Expected a %s failure, but was %v.", tc.testName, tc.expectedReason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: No error expected, but was %v.", tc.testName, err)
+			continue
+		}
+		if len(headers) != 1 || headers[0].Value != "Bearer myToken" {
+			t.Errorf("%s: Expected the bearer token, but was %v.", tc.testName, headers)
+		}
+		if cacheLifetime > 55*time.Second || cacheLifetime < 53*time.Second {
+			t.Errorf("%s: Expected a cache lifetime of 55s, but was %v.", tc.testName, cacheLifetime)
+		}
+		if verifier.requests != 1 {
+			t.Errorf("%s: Expected the token to be cached, but %d requests were sent.", tc.testName, verifier.requests)
+		}
+		if verifier.form["grant_type"] != vpTokenGrantType || verifier.form["scope"] != "operator" || verifier.form["client_id"] != "data-service" {
+			t.Errorf("%s: Expected a vp_token request for the scope, but was %v.", tc.testName, verifier.form)
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(verifier.form["vp_token"], claims, func(token *jwt.Token) (interface{}, error) { return &validKey.PublicKey, nil })
+		if err != nil {
+			t.Errorf("%s: Expected a presentation signed by the holder, but was %v. Err: %v", tc.testName, verifier.form["vp_token"], err)
+			continue
+		}
+		if claims["iss"] != "did:web:org.eu" || claims["aud"] != "data-service" || token.Header["kid"] != "did:web:org.eu" {
+			t.Errorf("%s: Expected a presentation of the holder for the verifier, but was %v.", tc.testName, claims)
+		}
+		presented := claims["vp"].(map[string]interface{})["verifiableCredential"].([]interface{})
+		if len(presented) != tc.expectedCredentials {
+			t.Errorf("%s: Expected %d credentials to be presented, but were %v.", tc.testName, tc.expectedCredentials, presented)
+		}
+
+		var submission struct {
+			DefinitionId  string `json:"definition_id"`
+			DescriptorMap []struct {
+				PathNested struct {
+					Format string `json:"format"`
+					Path   string `json:"path"`
+				} `json:"path_nested"`
+			} `json:"descriptor_map"`
+		}
+		json.Unmarshal([]byte(verifier.form["presentation_submission"]), &submission)
+		if submission.DefinitionId != "operator" || len(submission.DescriptorMap) != len(tc.expectedFormats) {
+			t.Errorf("%s: Expected a submission for the scope, but was %s.", tc.testName, verifier.form["presentation_submission"])
+			continue
+		}
+		for i, format := range tc.expectedFormats {
+			if submission.DescriptorMap[i].PathNested.Format != format || submission.DescriptorMap[i].PathNested.Path != fmt.Sprintf("$.vp.verifiableCredential[%d]", i) {
+				t.Errorf("%s: Expected credential %d in format %s, but was %v.", tc.testName, i, format, submission.DescriptorMap[i])
+			}
+		}
+	}
+}
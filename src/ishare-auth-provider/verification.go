@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var errInvalidTokenType = errors.New("invalid_token_type")
+var errTokenSignature = errors.New("invalid_token_signature")
+var errTokenIssuer = errors.New("invalid_token_issuer")
+var errTokenAudience = errors.New("invalid_token_audience")
+var errTokenExpired = errors.New("token_expired")
+var errNoVerificationKey = errors.New("no_verification_key")
+var errUntrustedCertificate = errors.New("untrusted_certificate")
+var errEmptyTrustStore = errors.New("empty_trust_store")
+
+/**
+* Signing algorithms accepted for tokens issued by the idp.
+ */
+var validTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+/**
+* Minimum time between two refreshes of the key set. Tokens with unknown kids would otherwise trigger a request to the idp each.
+ */
+const minJwksRefreshInterval = 30 * time.Second
+
+/**
+* Key set retrieved from the configured jwks-endpoint, indexed by the kid.
+ */
+type jwksCache struct {
+	mutex       sync.RWMutex
+	keys        map[string]interface{}
+	lastRefresh time.Time
+}
+
+var idpJwks = jwksCache{keys: map[string]interface{}{}}
+
+/**
+* Json representation of a single key inside a jwks.
+ */
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+/**
+* Verify the token response of the idp. The token type has to be "Bearer". If the access token is a JWT, its signature is
+* checked against the configured jwks or the trust store and issuer(idp), audience(client) and expiry have to match.
+ */
+func verifyAccessToken(tokenResponse map[string]interface{}, authInfo AuthInfo) (err error) {
+
+	tokenType, _ := tokenResponse["token_type"].(string)
+	if !strings.EqualFold(tokenType, "Bearer") {
+		return fmt.Errorf("%w: expected Bearer but was %q", errInvalidTokenType, tokenType)
+	}
+
+	accessToken, _ := tokenResponse["access_token"].(string)
+	if strings.Count(accessToken, ".") != 2 {
+		logger.Debug("Access token is not a jwt, nothing more to verify.")
+		return err
+	}
+
+	parser := jwt.Parser{ValidMethods: validTokenMethods, SkipClaimsValidation: true}
+	token, err := parser.Parse(accessToken, func(token *jwt.Token) (key interface{}, err error) {
+		return getTokenVerificationKey(token, authInfo.IShareIdpID)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTokenSignature, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("%w: unreadable claims", errTokenSignature)
+	}
+	if !claims.VerifyIssuer(authInfo.IShareIdpID, true) {
+		return fmt.Errorf("%w: expected %s", errTokenIssuer, authInfo.IShareIdpID)
+	}
+	if !claims.VerifyAudience(authInfo.IShareClientID, true) {
+		return fmt.Errorf("%w: expected %s", errTokenAudience, authInfo.IShareClientID)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return errTokenExpired
+	}
+	return err
+}
+
+/**
+* Keyfunc to be used for the idp tokens. Keys from the jwks take precedence, if the token references one of them. Otherwise
+* the x5c-chain of the token has to be trusted by the configured trust store and the leaf has to be issued to the idp, since the
+* trust store usually contains the CAs of all participants.
+ */
+func getTokenVerificationKey(token *jwt.Token, idpId string) (key interface{}, err error) {
+
+	if kid, ok := token.Header["kid"].(string); ok && idpJwksUrl != "" {
+		key, err = idpJwks.get(kid)
+		if err == nil {
+			return key, err
+		}
+		logger.Debugf("Kid %s not resolvable through the jwks. %v", kid, err)
+	}
+
+	leaf, err := getTrustedX5cCertificate(token, idpTrustPool)
+	if err != nil {
+		return key, err
+	}
+	if leaf.Subject.SerialNumber != idpId {
+		return key, fmt.Errorf("%w: issued to %s instead of %s", errUntrustedCertificate, leaf.Subject.SerialNumber, idpId)
+	}
+	return leaf.PublicKey, err
+}
+
+/**
+* Get the leaf certificate from the x5c-header of the token. The chain has to be trusted by the given pool.
+ */
+func getTrustedX5cCertificate(token *jwt.Token, trustPool *x509.CertPool) (leaf *x509.Certificate, err error) {
+
+	x5c, ok := token.Header["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return leaf, errNoVerificationKey
+	}
+
+	chain := []string{}
+	for _, entry := range x5c {
+		encodedCert, ok := entry.(string)
+		if !ok {
+			return leaf, errCertDecode
+		}
+		chain = append(chain, encodedCert)
+	}
+
+	return verifyCertificateChain(chain, trustPool)
+}
+
+/**
+* Verify the given (base64 encoded der) chain against the trust pool and return the leaf certificate.
+ */
+func verifyCertificateChain(encodedChain []string, trustPool *x509.CertPool) (leaf *x509.Certificate, err error) {
+
+	if trustPool == nil {
+		return leaf, fmt.Errorf("%w: no trust store configured", errUntrustedCertificate)
+	}
+
+	intermediates := x509.NewCertPool()
+	for i, encodedCert := range encodedChain {
+		der, err := base64.StdEncoding.DecodeString(encodedCert)
+		if err != nil {
+			return leaf, errCertDecode
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return leaf, errCertDecode
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: trustPool, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return leaf, fmt.Errorf("%w: %v", errUntrustedCertificate, err)
+	}
+	return leaf, err
+}
+
+/**
+* Load the pem-encoded certificates to be trusted for verifying tokens.
+ */
+func loadTrustStore(trustStorePath string) (pool *x509.CertPool, err error) {
+
+	content, err := globalFileAccessor.read(trustStorePath)
+	if err != nil {
+		return pool, err
+	}
+
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errEmptyTrustStore
+	}
+	return pool, err
+}
+
+/**
+* Get the key for the given kid. The key set is refreshed once, in case the kid is unknown and the last refresh is at least
+* minJwksRefreshInterval ago.
+ */
+func (jc *jwksCache) get(kid string) (key interface{}, err error) {
+	jc.mutex.Lock()
+	key, ok := jc.keys[kid]
+	refreshAllowed := time.Since(jc.lastRefresh) >= minJwksRefreshInterval
+	if !ok && refreshAllowed {
+		// failed refreshes count as well, to not flood an unavailable endpoint
+		jc.lastRefresh = time.Now()
+	}
+	jc.mutex.Unlock()
+	if ok {
+		return key, err
+	}
+	if !refreshAllowed {
+		return key, errNoVerificationKey
+	}
+
+	err = jc.refresh()
+	if err != nil {
+		return key, err
+	}
+
+	jc.mutex.RLock()
+	defer jc.mutex.RUnlock()
+	key, ok = jc.keys[kid]
+	if !ok {
+		return key, errNoVerificationKey
+	}
+	return key, err
+}
+
+/**
+* Retrieve the key set from the configured jwks endpoint. The known keys are kept, if the endpoint does not respond successfully.
+ */
+func (jc *jwksCache) refresh() (err error) {
+	resp, err := globalHttpClient.Get(idpJwksUrl)
+	if err != nil {
+		logger.Warn("Was not able to get the jwks. Err: ", err)
+		return err
+	}
+	if resp.Body == nil {
+		logger.Warn("Did not receive a jwks body.")
+		return errNoResponseBody
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warnf("The jwks endpoint responded with status %d.", resp.StatusCode)
+		return fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	}
+
+	var keySet jsonWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&keySet)
+	if err != nil {
+		logger.Warn("Was not able to decode the jwks.", err)
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range keySet.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warnf("Ignore unusable key %s from jwks. %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	jc.mutex.Lock()
+	jc.keys = keys
+	jc.mutex.Unlock()
+	return err
+}
+
+/**
+* Convert the jwk into a public key usable for signature verification. Keys providing an x5c-chain need to be trusted.
+ */
+func (jwk jsonWebKey) publicKey() (key interface{}, err error) {
+
+	if len(jwk.X5c) > 0 {
+		leaf, err := verifyCertificateChain(jwk.X5c, idpTrustPool)
+		if err != nil {
+			return key, err
+		}
+		return leaf.PublicKey, err
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return key, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return key, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, err
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return key, fmt.Errorf("%w: unsupported curve %s", errNoVerificationKey, jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return key, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return key, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, err
+	default:
+		return key, fmt.Errorf("%w: unsupported key type %s", errNoVerificationKey, jwk.Kty)
+	}
+}
+
+func decodeBigInt(encoded string) (value *big.Int, err error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return value, err
+	}
+	return new(big.Int).SetBytes(decoded), err
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	idp := getTestCertificate(&ca, "idp")
+	untrustedCa := getTestCertificate(nil, "untrusted")
+	untrustedIdp := getTestCertificate(&untrustedCa, "idp")
+
+	otherParticipant := getTestCertificate(&ca, "other")
+
+	authInfo := AuthInfo{IShareClientID: "clientId", IShareIdpID: "EU.EORI.idp"}
+	now := time.Now().Unix()
+	validClaims := jwt.MapClaims{"iss": "EU.EORI.idp", "aud": "clientId", "exp": now + 30}
+
+	type test struct {
+		testName      string
+		tokenResponse map[string]interface{}
+		jwksResponse  string
+		expectedError error
+	}
+
+	tests := []test{
+		{testName: "Valid jwt.", tokenResponse: tokenResponse(signWithX5c(validClaims, idp, ca), "Bearer")},
+		{testName: "Valid opaque token.", tokenResponse: tokenResponse("opaque", "Bearer")},
+		{testName: "Valid jwt, case insensitive token type.", tokenResponse: tokenResponse(signWithX5c(validClaims, idp, ca), "bearer")},
+		{testName: "Valid jwt with audience list.", tokenResponse: tokenResponse(signWithX5c(jwt.MapClaims{"iss": "EU.EORI.idp", "aud": []string{"other", "clientId"}, "exp": now + 30}, idp, ca), "Bearer")},
+		{testName: "Valid jwt from jwks.", tokenResponse: tokenResponse(signWithKid(validClaims, idp.key, "myKid"), "Bearer"), jwksResponse: getJwks(idp.key, "myKid")},
+		{testName: "Missing token type.", tokenResponse: map[string]interface{}{"access_token": "opaque"}, expectedError: errInvalidTokenType},
+		{testName: "Invalid token type.", tokenResponse: tokenResponse("opaque", "mac"), expectedError: errInvalidTokenType},
+		{testName: "Wrong issuer.", tokenResponse: tokenResponse(signWithX5c(jwt.MapClaims{"iss": "otherIdp", "aud": "clientId", "exp": now + 30}, idp, ca), "Bearer"), expectedError: errTokenIssuer},
+		{testName: "Wrong audience.", tokenResponse: tokenResponse(signWithX5c(jwt.MapClaims{"iss": "EU.EORI.idp", "aud": "otherClient", "exp": now + 30}, idp, ca), "Bearer"), expectedError: errTokenAudience},
+		{testName: "Expired token.", tokenResponse: tokenResponse(signWithX5c(jwt.MapClaims{"iss": "EU.EORI.idp", "aud": "clientId", "exp": now - 30}, idp, ca), "Bearer"), expectedError: errTokenExpired},
+		{testName: "Missing expiry.", tokenResponse: tokenResponse(signWithX5c(jwt.MapClaims{"iss": "EU.EORI.idp", "aud": "clientId"}, idp, ca), "Bearer"), expectedError: errTokenExpired},
+		{testName: "Untrusted certificate.", tokenResponse: tokenResponse(signWithX5c(validClaims, untrustedIdp, untrustedCa), "Bearer"), expectedError: errTokenSignature},
+		{testName: "Certificate of another participant.", tokenResponse: tokenResponse(signWithX5c(validClaims, otherParticipant, ca), "Bearer"), expectedError: errTokenSignature},
+		{testName: "No verification key.", tokenResponse: tokenResponse(signWithKid(validClaims, idp.key, "myKid"), "Bearer"), expectedError: errTokenSignature},
+		{testName: "Unknown kid in jwks.", tokenResponse: tokenResponse(signWithKid(validClaims, idp.key, "otherKid"), "Bearer"), jwksResponse: getJwks(idp.key, "myKid"), expectedError: errTokenSignature},
+		{testName: "Invalid signature.", tokenResponse: tokenResponse(signWithKid(validClaims, untrustedIdp.key, "myKid"), "Bearer"), jwksResponse: getJwks(idp.key, "myKid"), expectedError: errTokenSignature},
+	}
+
+	idpTrustPool = x509.NewCertPool()
+	idpTrustPool.AddCert(ca.cert)
+	defer func() { idpTrustPool = nil; idpJwksUrl = "" }()
+
+	for _, tc := range tests {
+		log.Info("TestVerifyAccessToken +++++++++++++++++++++ Running test: " + tc.testName)
+
+		idpJwks = jwksCache{keys: map[string]interface{}{}}
+		idpJwksUrl = ""
+		if tc.jwksResponse != "" {
+			idpJwksUrl = "http://idp/jwks"
+			globalHttpClient = &mockHttpClient{mockGetResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(tc.jwksResponse))}}
+		}
+
+		err := verifyAccessToken(tc.tokenResponse, authInfo)
+
+		if tc.expectedError == nil && err != nil {
+			t.Errorf(tc.testName + ": No error expected, but was: " + fmt.Sprint(err))
+		}
+		if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+	}
+}
+
+func TestJwksRefresh(t *testing.T) {
+
+	idpKey, _ := getValidKey()
+	knownKey, _ := getValidKey()
+
+	type test struct {
+		testName      string
+		lastRefresh   time.Duration
+		jwksResponse  *http.Response
+		expectedError error
+		expectKnown   bool
+	}
+
+	tests := []test{
+		{testName: "Refresh on unknown kid.", lastRefresh: -time.Hour, jwksResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(getJwks(idpKey, "myKid")))}},
+		{testName: "Do not refresh within the interval.", lastRefresh: -time.Second, jwksResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(getJwks(idpKey, "myKid")))},
+			expectedError: errNoVerificationKey, expectKnown: true},
+		{testName: "Keep the keys on error responses.", lastRefresh: -time.Hour, jwksResponse: &http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader(`{"keys":[]}`))},
+			expectedError: errUnexpectedStatus, expectKnown: true},
+	}
+
+	idpJwksUrl = "http://idp/jwks"
+	defer func() { idpJwksUrl = "" }()
+
+	for _, tc := range tests {
+		log.Info("TestJwksRefresh +++++++++++++++++++++ Running test: " + tc.testName)
+
+		idpJwks = jwksCache{keys: map[string]interface{}{"knownKid": &knownKey.PublicKey}, lastRefresh: time.Now().Add(tc.lastRefresh)}
+		globalHttpClient = &mockHttpClient{mockGetResponse: tc.jwksResponse}
+
+		_, err := idpJwks.get("myKid")
+		if tc.expectedError == nil && err != nil {
+			t.Errorf(tc.testName + ": No error expected, but was: " + fmt.Sprint(err))
+		}
+		if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectedError) + " but got: " + fmt.Sprint(err))
+		}
+		if _, err := idpJwks.get("knownKid"); (err == nil) != tc.expectKnown {
+			t.Errorf(tc.testName + ": Expected the known key to be kept: " + fmt.Sprint(tc.expectKnown))
+		}
+	}
+}
+
+func TestLoadTrustStore(t *testing.T) {
+
+	ca := getTestCertificate(nil, "ca")
+	readError := errors.New("not_readable")
+
+	type test struct {
+		testName    string
+		content     []byte
+		mockError   error
+		expectError error
+	}
+
+	tests := []test{
+		{testName: "Load trust store.", content: getPemEncoded(string(ca.cert.Raw))},
+		{testName: "Empty trust store.", content: []byte("no-certs"), expectError: errEmptyTrustStore},
+		{testName: "Unreadable trust store.", mockError: readError, expectError: readError},
+	}
+
+	globalFileAccessor = fileAccessor{mock_noop_write, mock_read_content}
+
+	for _, tc := range tests {
+		log.Info("TestLoadTrustStore +++++++++++++++++++++ Running test: " + tc.testName)
+		contentMock = map[string][]byte{"trust.pem": tc.content}
+		mockReadErr = tc.mockError
+
+		pool, err := loadTrustStore("trust.pem")
+
+		if !errors.Is(err, tc.expectError) {
+			t.Errorf(tc.testName + ": Expected error: " + fmt.Sprint(tc.expectError) + " but got: " + fmt.Sprint(err))
+		}
+		if tc.expectError == nil && pool == nil {
+			t.Errorf(tc.testName + ": Expected a cert pool.")
+		}
+	}
+	mockReadErr = nil
+}
+
+func tokenResponse(accessToken string, tokenType string) map[string]interface{} {
+	return map[string]interface{}{"access_token": accessToken, "token_type": tokenType}
+}
+
+func signWithX5c(claims jwt.MapClaims, leaf testCertificate, ca testCertificate) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["x5c"] = []string{base64.StdEncoding.EncodeToString(leaf.cert.Raw), base64.StdEncoding.EncodeToString(ca.cert.Raw)}
+	signedToken, _ := token.SignedString(leaf.key)
+	return signedToken
+}
+
+func signWithKid(claims jwt.MapClaims, key *rsa.PrivateKey, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signedToken, _ := token.SignedString(key)
+	return signedToken
+}
+
+func getJwks(key *rsa.PrivateKey, kid string) string {
+	n := base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes())
+	return `{"keys":[{"kty":"RSA","kid":"` + kid + `","n":"` + n + `","e":"` + e + `"}]}`
+}
+
+/**
+* Create a certificate for the given name. Will be self-signed if no issuer is provided.
+ */
+func getTestCertificate(issuer *testCertificate, name string) testCertificate {
+	key, _ := getValidKey()
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, SerialNumber: "EU.EORI." + name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  issuer == nil,
+	}
+	parent := template
+	signingKey := key
+	if issuer != nil {
+		parent = issuer.cert
+		signingKey = issuer.key
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signingKey)
+	cert, _ := x509.ParseCertificate(der)
+	return testCertificate{cert, key}
+}